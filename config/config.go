@@ -5,10 +5,11 @@ import "time"
 type DatabaseType string
 
 const (
-	DBTypeMongodb DatabaseType = "mongodb"
-	DBTypeSql     DatabaseType = "sql"
-	DBTypeMysql   DatabaseType = "mysql"
-	DBTypeLocal   DatabaseType = "local"
+	DBTypeMongodb  DatabaseType = "mongodb"
+	DBTypeSql      DatabaseType = "sql"
+	DBTypeMysql    DatabaseType = "mysql"
+	DBTypePostgres DatabaseType = "postgres"
+	DBTypeLocal    DatabaseType = "local"
 )
 
 type GatewayProvider string
@@ -78,10 +79,16 @@ type YekongaConfig struct {
 	PdfInstances            int           `json:"pdfInstances"`            // Number of PDF instances
 	AccessTokenExpireTime   time.Duration `json:"accessTokenExpireTime"`   // Access token expiration time in minutes
 	RefreshTokenExpireTime  time.Duration `json:"refreshTokenExpireTime"`  // Refresh token expiration time in minutes
+	PasswordResetExpireTime time.Duration `json:"passwordResetExpireTime"` // Password reset token expiration time in minutes
 	SecureOnly              bool          `json:"secureOnly"`              // Enforce secure connections only
 	Debug                   bool          `json:"debug"`                   // Enable or disable debug mode
 	Cors                    bool          `json:"cors"`                    // Enable or disable CORS
 	ResetOTP                bool          `json:"resetOTP"`                // Enable or disable OTP reset
+	OtpLength               int           `json:"otpLength"`               // Number of digits in an OTP code
+	OtpExpireTime           time.Duration `json:"otpExpireTime"`           // OTP expiration time in minutes
+	OtpMaxAttempts          int           `json:"otpMaxAttempts"`          // Failed OTP attempts allowed per identifier and IP before lockout
+	OtpLockoutTime          time.Duration `json:"otpLockoutTime"`          // OTP lockout window in minutes
+	OtpResendCooldown       time.Duration `json:"otpResendCooldown"`       // Minimum time between OTP resends in seconds
 	Environment             string        `json:"environment"`             // Application environment (e.g., development, production)
 	HasTenant               bool          `json:"hasTenant"`               // Enable multi-tenancy
 	TenantOnly              bool          `json:"tenantOnly"`              // Restrict access to tenants only
@@ -136,7 +143,7 @@ type YekongaConfig struct {
 		}
 	}
 	Database struct { // Database configuration
-		Kind             DatabaseType `json:"kind"`             // Type of database (e.g., mongodb, sql, mysql, local)
+		Kind             DatabaseType `json:"kind"`             // Type of database (e.g., mongodb, sql, mysql, postgres, local)
 		Srv              bool         `json:"srv"`              // Enable SRV record lookup for MongoDB
 		Host             string       `json:"host"`             // Database host address
 		Port             string       `json:"port"`             // Database port
@@ -146,13 +153,35 @@ type YekongaConfig struct {
 		Prefix           string       `json:"prefix"`           // Table/collection name prefix
 		GenerateID       bool         `json:"generateID"`       // Automatically generate IDs for new records
 		GenerateIDLength int          `json:"generateIDLength"` // Length of generated IDs
+		SSL              bool         `json:"ssl"`              // Enable TLS for SQL connections
+		SSLCA            string       `json:"sslCA"`            // Path to the CA certificate used to verify the server
+		SSLCert          string       `json:"sslCert"`          // Path to the client certificate
+		SSLKey           string       `json:"sslKey"`           // Path to the client certificate key
+		SSLSkipVerify    bool         `json:"sslSkipVerify"`    // Skip server certificate verification
+		MaxOpenConns     int          `json:"maxOpenConns"`     // Maximum number of open SQL connections
+		MaxIdleConns     int          `json:"maxIdleConns"`     // Maximum number of idle SQL connections
+		ConnMaxLifetime  int          `json:"connMaxLifetime"`  // Maximum lifetime of an SQL connection in minutes
+		AutoMigrate      *bool        `json:"autoMigrate"`      // Create or alter SQL tables from the database structure on startup (default true)
+		MigrationsPath   string       `json:"migrationsPath"`   // Directory of the JSON migration files (default migrations next to the executable)
+		LocalSync        string       `json:"localSync"`        // When the local database flushes its write-ahead log: always, interval or never (default always)
+		LocalCheckpoint  int          `json:"localCheckpoint"`  // Minutes between checkpoints of the local database (default 5)
+		ExpiryInterval   int          `json:"expiryInterval"`   // Minutes between runs of the IndexExpiry cronjob, negative to not run it on this instance (default 1)
+	}
+	Backup struct { // Scheduled database backups
+		Enabled   bool   `json:"enabled"`   // Run the backup cronjob
+		Path      string `json:"path"`      // Directory of the backup archives (default backups in the app home directory)
+		Interval  int    `json:"interval"`  // Hours between backups (default 24)
+		Retention int    `json:"retention"` // Number of archives to keep (default 7)
 	}
 	Authentication struct { // Authentication configuration
-		SaltRound   int    `json:"saltRound"`   // Number of salt rounds for password hashing
-		Algorithm   string `json:"algorithm"`   // Hashing algorithm for passwords
-		SecretToken string `json:"secretToken"` // Secret key for JWT or session tokens
-		CryptoJsKey string `json:"cryptoJsKey"` // Cryptographic key for client-side encryption
-		CryptoJsIv  string `json:"cryptoJsIv"`  // Initialization vector for client-side encryption
+		SaltRound      int           `json:"saltRound"`      // Number of salt rounds for password hashing
+		Algorithm      string        `json:"algorithm"`      // Hashing algorithm for passwords
+		SecretToken    string        `json:"secretToken"`    // Secret key for JWT or session tokens
+		JwtAlgorithm   string        `json:"jwtAlgorithm"`   // Access token signing algorithm (HS256, RS256, ES256 or EdDSA)
+		JwtKeysPath    string        `json:"jwtKeysPath"`    // Directory of the asymmetric signing keys
+		JwtKeyRotation time.Duration `json:"jwtKeyRotation"` // Signing key rotation interval in days
+		CryptoJsKey    string        `json:"cryptoJsKey"`    // Cryptographic key for client-side encryption
+		CryptoJsIv     string        `json:"cryptoJsIv"`     // Initialization vector for client-side encryption
 	}
 	Ports struct { // Port configuration
 		Secure    bool `json:"secure"`    // Enable secure ports (HTTPS/SSL)
@@ -3,7 +3,10 @@ package helper
 import (
 	"bytes"
 	"cmp"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -13,6 +16,7 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"math/big"
 	"math/rand"
 	"net"
 	"net/http"
@@ -531,11 +535,9 @@ func SortMap[T interface{}](options map[string]T) map[string]T {
 		return cmp.Compare(ToString(options[a]), ToString(options[b]))
 	})
 
-	// 3. Print the results in order
-	fmt.Println("Sorted by Value:", keys)
+	// 3. Copy the results in order
 	newOptions := make(map[string]T)
 	for _, k := range keys {
-		fmt.Printf("%s: %s\n", k, options[k])
 		newOptions[k] = options[k]
 	}
 
@@ -1003,7 +1005,7 @@ func ConvertCalculatedValue(value interface{}) interface{} {
 			vi, _ := strconv.ParseFloat(v, 64)
 			return vi
 		} else {
-			return v
+			return value
 		}
 	}
 
@@ -2107,10 +2109,74 @@ func GetHexString(length int) string {
 }
 
 func HashRefreshToken(token string) string {
+	return HashToken(token)
+}
+
+// HashToken returns the hex encoded SHA-256 of a token so only the hash
+// needs to be stored.
+func HashToken(token string) string {
 	hash := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(hash[:])
 }
 
+// GetSecureToken returns a random token of uppercase letters and digits read
+// from crypto/rand, leaving out characters that are easy to confuse.
+func GetSecureToken(length int) string {
+	return secureRandomString(length, "ABCDEFGHJKLMNPQRSTUVWXYZ23456789")
+}
+
+// GetSecureNumber returns a random string of digits read from crypto/rand.
+func GetSecureNumber(length int) string {
+	return secureRandomString(length, "0123456789")
+}
+
+// secureRandomString panics when crypto/rand fails, the tokens and codes it
+// makes must never come from a predictable source.
+func secureRandomString(length int, chars string) string {
+	result := make([]byte, length)
+	max := big.NewInt(int64(len(chars)))
+
+	for i := range result {
+		n, err := cryptorand.Int(cryptorand.Reader, max)
+		if err != nil {
+			panic("crypto/rand failed: " + err.Error())
+		}
+
+		result[i] = chars[n.Int64()]
+	}
+
+	return string(result)
+}
+
+// HashOTP returns a salted HMAC-SHA256 of a one time code in the form
+// `salt:hash`, keyed with the given secret.
+func HashOTP(code string, secret string) string {
+	salt := make([]byte, 16)
+	if _, err := cryptorand.Read(salt); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+
+	return hashOTP(code, secret, hex.EncodeToString(salt))
+}
+
+// CompareOTP reports whether the code matches a hash made by HashOTP. The
+// comparison runs in constant time.
+func CompareOTP(hashed string, code string, secret string) bool {
+	salt, _, found := strings.Cut(hashed, ":")
+	if !found || IsEmpty(code) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashOTP(code, secret, salt)), []byte(hashed)) == 1
+}
+
+func hashOTP(code string, secret string, salt string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(salt + ":" + code))
+
+	return salt + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
 // ExtractDomain extracts the domain from a URL string
 func ExtractDomain(input string) string {
 	// Add scheme if missing
@@ -0,0 +1,34 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConvertCalculatedValue(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{5, 5},
+		{int64(-7), int64(-7)},
+		{2.5, 2.5},
+		{"42", 42.0},
+		{"-1.5", -1.5},
+	}
+
+	for _, c := range cases {
+		if got := ConvertCalculatedValue(c.value); got != c.want {
+			t.Errorf("ConvertCalculatedValue(%#v) = %#v, want %#v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestConvertCalculatedValueDate(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	got, ok := ConvertCalculatedValue(want).(time.Time)
+	if !ok || !got.Equal(want) {
+		t.Errorf("ConvertCalculatedValue(%v) = %v, want %v", want, got, want)
+	}
+}
@@ -1,8 +1,6 @@
 package jwt
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"strings"
@@ -15,28 +13,62 @@ type Token struct {
 	Signature string
 }
 
+// EncodeJWT signs the claims with HS256 using the secret key. The `exp` claim
+// defaults to 24 hours when the caller does not set it.
 func EncodeJWT(claims map[string]interface{}, secretKey string) (string, error) {
+	return Encode(claims, NewSecretKey(secretKey))
+}
+
+// DecodeJWT verifies an HS256 token signed with the secret key and returns
+// its claims.
+func DecodeJWT(tokenString string, secretKey string) (bool, map[string]interface{}) {
+	key := NewSecretKey(secretKey)
+
+	return Decode(tokenString, func(kid string) *Key {
+		return key
+	})
+}
+
+// Encode signs the claims with the key, adding its id as the `kid` header.
+func Encode(claims map[string]interface{}, key *Key) (string, error) {
 	// Header
 	header := map[string]interface{}{
-		"alg": "HS256",
+		"alg": key.Algorithm,
 		"typ": "JWT",
 	}
+	if key.ID != "" {
+		header["kid"] = key.ID
+	}
 	headerJSON, _ := json.Marshal(header)
-	headerBase64 := base64.URLEncoding.EncodeToString(headerJSON)
+	headerBase64 := base64.RawURLEncoding.EncodeToString(headerJSON)
 
 	// Payload
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
-	payloadJSON, _ := json.Marshal(claims)
-	payloadBase64 := base64.URLEncoding.EncodeToString(payloadJSON)
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = time.Now().Unix()
+	}
+	payloadJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payloadBase64 := base64.RawURLEncoding.EncodeToString(payloadJSON)
 
 	// Signature
 	signatureInput := headerBase64 + "." + payloadBase64
-	signature := generateSignature(signatureInput, secretKey)
+	signature, err := key.Sign([]byte(signatureInput))
+	if err != nil {
+		return "", err
+	}
 
-	return signatureInput + "." + signature, nil
+	return signatureInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
 }
 
-func DecodeJWT(tokenString string, secretKey string) (bool, map[string]interface{}) {
+// Decode verifies the token with the key returned by lookup for its `kid`
+// header and returns the claims. The key algorithm must match the `alg`
+// header so a token can not pick a weaker algorithm than the key.
+func Decode(tokenString string, lookup func(kid string) *Key) (bool, map[string]interface{}) {
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
 		return false, nil
@@ -46,15 +78,37 @@ func DecodeJWT(tokenString string, secretKey string) (bool, map[string]interface
 	payloadBase64 := parts[1]
 	signatureProvided := parts[2]
 
+	headerJSON, err := decodeSegment(headerBase64)
+	if err != nil {
+		return false, nil
+	}
+
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return false, nil
+	}
+
+	alg, _ := header["alg"].(string)
+	kid, _ := header["kid"].(string)
+
+	key := lookup(kid)
+	if key == nil || key.Algorithm != alg {
+		return false, nil
+	}
+
 	// Verify signature
+	signature, err := decodeSegment(signatureProvided)
+	if err != nil {
+		return false, nil
+	}
+
 	signatureInput := headerBase64 + "." + payloadBase64
-	expectedSignature := generateSignature(signatureInput, secretKey)
-	if signatureProvided != expectedSignature {
+	if !key.Verify([]byte(signatureInput), signature) {
 		return false, nil
 	}
 
 	// Decode payload
-	payloadJSON, err := base64.URLEncoding.DecodeString(payloadBase64)
+	payloadJSON, err := decodeSegment(payloadBase64)
 	if err != nil {
 		return false, nil
 	}
@@ -72,8 +126,8 @@ func DecodeJWT(tokenString string, secretKey string) (bool, map[string]interface
 	return true, payload
 }
 
-func generateSignature(input string, secretKey string) string {
-	mac := hmac.New(sha256.New, []byte(secretKey))
-	mac.Write([]byte(input))
-	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+// decodeSegment accepts both padded and unpadded base64url, older tokens
+// were issued with padding.
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
 }
@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a signing key. HS256 keys hold a shared secret, the other
+// algorithms hold a private key and publish the public part as a JWK.
+type Key struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// NewSecretKey returns an HS256 key without an id for the shared secret.
+func NewSecretKey(secret string) *Key {
+	return &Key{
+		Algorithm: HS256,
+		Secret:    []byte(secret),
+	}
+}
+
+// GenerateKey creates a new key with a random id for the algorithm.
+func GenerateKey(algorithm string) (*Key, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("jwt: can not generate keys for algorithm %q", algorithm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        hex.EncodeToString(id),
+		Algorithm: algorithm,
+		Private:   private,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// ParseKey reads a PKCS #8 PEM private key and works out its algorithm.
+func ParseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = RS256
+		key.Private = private
+	case *ecdsa.PrivateKey:
+		key.Algorithm = ES256
+		key.Private = private
+	case ed25519.PrivateKey:
+		key.Algorithm = EdDSA
+		key.Private = private
+	default:
+		return nil, errors.New("jwt: unsupported private key type")
+	}
+
+	return key, nil
+}
+
+// MarshalPEM encodes the private key as PKCS #8 PEM.
+func (k *Key) MarshalPEM() ([]byte, error) {
+	if k.Private == nil {
+		return nil, errors.New("jwt: key has no private part")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (k *Key) Sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		private, ok := k.Private.(*rsa.PrivateKey)
+		if !ok {
+			break
+		}
+		hash := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, hash[:])
+	case ES256:
+		private, ok := k.Private.(*ecdsa.PrivateKey)
+		if !ok {
+			break
+		}
+		hash := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, private, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed size r || s form rather than ASN.1.
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case EdDSA:
+		private, ok := k.Private.(ed25519.PrivateKey)
+		if !ok {
+			break
+		}
+		return ed25519.Sign(private, input), nil
+	}
+
+	return nil, fmt.Errorf("jwt: key %q can not sign with %s", k.ID, k.Algorithm)
+}
+
+func (k *Key) Verify(input []byte, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		expected, _ := k.Sign(input)
+		return hmac.Equal(signature, expected)
+	case RS256:
+		public, ok := k.Public().(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		hash := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], signature) == nil
+	case ES256:
+		public, ok := k.Public().(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, hash[:], r, s)
+	case EdDSA:
+		public, ok := k.Public().(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(public, input, signature)
+	}
+
+	return false
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	if k.Private == nil {
+		return nil
+	}
+
+	return k.Private.Public()
+}
+
+// JWK returns the public key in JSON Web Key form, nil for HS256 keys.
+func (k *Key) JWK() map[string]interface{} {
+	jwk := map[string]interface{}{
+		"kid": k.ID,
+		"alg": k.Algorithm,
+		"use": "sig",
+	}
+
+	switch public := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encodeBytes(public.N.Bytes())
+		jwk["e"] = encodeBytes(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		public.X.FillBytes(x)
+		public.Y.FillBytes(y)
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["x"] = encodeBytes(x)
+		jwk["y"] = encodeBytes(y)
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = encodeBytes(public)
+	default:
+		return nil
+	}
+
+	return jwk
+}
+
+// IsAsymmetric reports whether the algorithm signs with a private key.
+func IsAsymmetric(algorithm string) bool {
+	switch algorithm {
+	case RS256, ES256, EdDSA:
+		return true
+	}
+
+	return false
+}
+
+func encodeBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,304 @@
+package jwt
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySet holds the signing keys of a server. With HS256 it wraps the shared
+// secret. With an asymmetric algorithm the newest key signs new tokens and
+// is replaced once it is older than Rotation, while retired keys are still
+// accepted for verification during Retention so the tokens they signed can
+// expire normally.
+type KeySet struct {
+	Algorithm string
+	Path      string        // Directory the private keys are stored in, empty keeps them in memory
+	Rotation  time.Duration // Age of the signing key before a new one is generated
+	Retention time.Duration // Time a retired key is still accepted for verification
+
+	secret   *Key
+	keys     []*Key // newest first
+	loadedAt time.Time
+	mut      sync.Mutex
+}
+
+// reloadInterval limits how often an unknown kid makes the set read the key
+// directory again, another instance sharing it may have rotated.
+const reloadInterval = time.Minute
+
+func NewKeySet(algorithm string, secret string, path string, rotation time.Duration, retention time.Duration) (*KeySet, error) {
+	if algorithm == "" {
+		algorithm = HS256
+	}
+
+	set := &KeySet{
+		Algorithm: algorithm,
+		Path:      path,
+		Rotation:  rotation,
+		Retention: retention,
+		secret:    NewSecretKey(secret),
+	}
+
+	if !IsAsymmetric(algorithm) {
+		if algorithm != HS256 {
+			return nil, &UnsupportedAlgorithmError{Algorithm: algorithm}
+		}
+
+		return set, nil
+	}
+
+	set.mut.Lock()
+	defer set.mut.Unlock()
+
+	if err := set.load(); err != nil {
+		return nil, err
+	}
+
+	if _, err := set.current(); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+type UnsupportedAlgorithmError struct {
+	Algorithm string
+}
+
+func (e *UnsupportedAlgorithmError) Error() string {
+	return "jwt: unsupported algorithm " + e.Algorithm
+}
+
+// Current returns the key new tokens are signed with, rotating it when due.
+func (s *KeySet) Current() (*Key, error) {
+	if !IsAsymmetric(s.Algorithm) {
+		return s.secret, nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.current()
+}
+
+// Rotate replaces the signing key right away. The previous key stays valid
+// for verification during the retention time.
+func (s *KeySet) Rotate() (*Key, error) {
+	if !IsAsymmetric(s.Algorithm) {
+		return s.secret, nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.generate()
+}
+
+// Lookup returns the verification key for a kid.
+func (s *KeySet) Lookup(kid string) *Key {
+	if !IsAsymmetric(s.Algorithm) {
+		if kid == "" {
+			return s.secret
+		}
+
+		return nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if key := s.find(kid); key != nil {
+		return key
+	}
+
+	if s.Path != "" && time.Since(s.loadedAt) > reloadInterval {
+		s.load()
+		return s.find(kid)
+	}
+
+	return nil
+}
+
+// Encode signs the claims with the current key.
+func (s *KeySet) Encode(claims map[string]interface{}) (string, error) {
+	key, err := s.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return Encode(claims, key)
+}
+
+// Decode verifies a token against the key named by its kid.
+func (s *KeySet) Decode(token string) (bool, map[string]interface{}) {
+	return Decode(token, s.Lookup)
+}
+
+// JWKS returns the public keys of the set as a JSON Web Key Set. It is empty
+// for HS256 as the secret can not be published.
+func (s *KeySet) JWKS() map[string]interface{} {
+	keys := make([]map[string]interface{}, 0)
+
+	if IsAsymmetric(s.Algorithm) {
+		s.mut.Lock()
+		s.prune()
+		for _, key := range s.keys {
+			if jwk := key.JWK(); jwk != nil {
+				keys = append(keys, jwk)
+			}
+		}
+		s.mut.Unlock()
+	}
+
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
+
+func (s *KeySet) current() (*Key, error) {
+	if !s.due() {
+		return s.keys[0], nil
+	}
+
+	// Another instance sharing the directory may have rotated already.
+	if s.Path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+
+		if !s.due() {
+			return s.keys[0], nil
+		}
+	}
+
+	return s.generate()
+}
+
+func (s *KeySet) due() bool {
+	if len(s.keys) == 0 {
+		return true
+	}
+
+	return s.Rotation > 0 && time.Since(s.keys[0].CreatedAt) > s.Rotation
+}
+
+func (s *KeySet) generate() (*Key, error) {
+	key, err := GenerateKey(s.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Path != "" {
+		data, err := key.MarshalPEM()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(s.Path, 0700); err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(filepath.Join(s.Path, key.ID+".pem"), data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	s.keys = append([]*Key{key}, s.keys...)
+	s.prune()
+
+	return key, nil
+}
+
+func (s *KeySet) find(kid string) *Key {
+	s.prune()
+
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// load reads the keys of the configured algorithm from the key directory.
+func (s *KeySet) load() error {
+	s.loadedAt = time.Now()
+
+	if s.Path == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	keys := make([]*Key, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".pem" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.Path, name))
+		if err != nil {
+			continue
+		}
+
+		key, err := ParseKey(strings.TrimSuffix(name, ".pem"), data)
+		if err != nil || key.Algorithm != s.Algorithm {
+			continue
+		}
+
+		if info, err := entry.Info(); err == nil {
+			key.CreatedAt = info.ModTime()
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	s.keys = keys
+	s.prune()
+
+	return nil
+}
+
+// prune drops retired keys whose retention has passed. A key is retired
+// when the next one is created.
+func (s *KeySet) prune() {
+	if len(s.keys) < 2 {
+		return
+	}
+
+	kept := s.keys[:1]
+
+	for i := 1; i < len(s.keys); i++ {
+		retiredAt := s.keys[i-1].CreatedAt
+
+		if time.Since(retiredAt) > s.Retention {
+			if s.Path != "" {
+				os.Remove(filepath.Join(s.Path, s.keys[i].ID+".pem"))
+			}
+
+			continue
+		}
+
+		kept = append(kept, s.keys[i])
+	}
+
+	s.keys = kept
+}
@@ -73,7 +73,6 @@ func OpenDataFile(path string, growth int) (file *DataFile, err error) {
 			mid = mid + (high-mid)/2
 		}
 	}
-	return
 }
 
 // Fill up portion of a file with 0s.
@@ -116,6 +115,14 @@ func (file *DataFile) EnsureSize(more int) (err error) {
 	return file.EnsureSize(more)
 }
 
+// Write the changes in the file buffer to disk and wait until they are written.
+func (file *DataFile) Sync() (err error) {
+	if err = file.Buf.Flush(); err != nil {
+		return
+	}
+	return file.Fh.Sync()
+}
+
 // Un-map the file buffer and close the file handle.
 func (file *DataFile) Close() (err error) {
 	if err = file.Buf.Unmap(); err != nil {
@@ -157,6 +157,39 @@ func (part *Partition) ApproxDocCount() int {
 	}
 }
 
+// Remove the ID lookup entries that point to no document, to a document the check function rejects, or to a second
+// copy of a document. Return the number of removed entries.
+func (part *Partition) Verify(check func(id int, doc []byte) bool) (removed int) {
+	ids, physIDs := part.lookup.GetPartition(0, 1)
+	seen := make(map[int]struct{}, len(ids))
+	for i, id := range ids {
+		data := part.col.Read(physIDs[i])
+		if _, dup := seen[id]; !dup && data != nil && check(id, data) {
+			seen[id] = struct{}{}
+			continue
+		}
+		part.lookup.Remove(id, physIDs[i])
+		removed++
+	}
+	return
+}
+
+// Write data file and lookup hash table to disk.
+func (part *Partition) Sync() error {
+
+	var err error
+
+	if e := part.col.Sync(); e != nil {
+		tdlog.CritNoRepeat("Failed to sync %s: %v", part.col.Path, e)
+		err = dberr.New(dberr.ErrorIO)
+	}
+	if e := part.lookup.Sync(); e != nil {
+		tdlog.CritNoRepeat("Failed to sync %s: %v", part.lookup.Path, e)
+		err = dberr.New(dberr.ErrorIO)
+	}
+	return err
+}
+
 // Clear data file and lookup hash table.
 func (part *Partition) Clear() error {
 
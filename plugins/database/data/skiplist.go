@@ -0,0 +1,298 @@
+// Skip list file keeps sorted index entries.
+//
+// An entry is a binary key and a document ID, entries are ordered by key and
+// then by ID, and the combination of key and ID is unique. The entries live in
+// a skip list in memory, which allows range scans in both directions. The file
+// is a snapshot of the entries written on Sync, and is read back on open: a
+// magic header, the number of entries, every entry as key length, key and ID,
+// and the CRC-32 checksum of all of it. A snapshot is replaced atomically, so
+// the file is either the old or the new snapshot.
+
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/plugins/database/tdlog"
+)
+
+const (
+	SkipListMagic    = "TDSL1" // SkipListMagic is the header of a skip list file.
+	SkipListMaxLevel = 24      // SkipListMaxLevel is the number of levels of the skip list, enough for 4^24 entries.
+	SkipListBranch   = 4       // SkipListBranch is the inverse probability of an entry being promoted a level.
+)
+
+type skipNode struct {
+	key  []byte
+	id   int
+	next []*skipNode
+	prev *skipNode
+}
+
+// Return the order of the node relative to the key and ID.
+func (node *skipNode) compare(key []byte, id int) int {
+	if c := bytes.Compare(node.key, key); c != 0 {
+		return c
+	}
+	switch {
+	case node.id < id:
+		return -1
+	case node.id > id:
+		return 1
+	}
+	return 0
+}
+
+// Skip list of sorted index entries, with its snapshot file.
+type SkipList struct {
+	Path    string
+	Lock    *sync.RWMutex
+	Rebuild bool // Rebuild is set when the file could not be read, and the entries have to be put back.
+	head    *skipNode
+	tail    *skipNode
+	level   int
+	length  int
+	dirty   bool // Entries changed since the last snapshot
+	rand    *rand.Rand
+}
+
+// Open a skip list file and read its entries.
+func OpenSkipList(path string) (sl *SkipList, err error) {
+	sl = &SkipList{Path: path, Lock: new(sync.RWMutex), rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	sl.reset()
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		sl.dirty = true
+		return sl, sl.Sync()
+	} else if err != nil {
+		return
+	}
+	if err := sl.load(content); err != nil {
+		tdlog.CritNoRepeat("Bad skip list %s - %v, the index has to be rebuilt", path, err)
+		sl.reset()
+		sl.Rebuild = true
+	}
+	return sl, nil
+}
+
+// Remove all entries from memory.
+func (sl *SkipList) reset() {
+	sl.head = &skipNode{next: make([]*skipNode, SkipListMaxLevel)}
+	sl.tail = nil
+	sl.level = 1
+	sl.length = 0
+}
+
+// Read the entries of a snapshot.
+func (sl *SkipList) load(content []byte) error {
+	if len(content) < len(SkipListMagic)+4 || string(content[:len(SkipListMagic)]) != SkipListMagic {
+		return errors.New("missing header")
+	}
+	body := content[:len(content)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(content[len(content)-4:]) {
+		return errors.New("checksum mismatch")
+	}
+	reader := bytes.NewReader(body[len(SkipListMagic):])
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		keyLen, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return err
+		} else if keyLen > uint64(reader.Len()) {
+			return errors.New("bad key length")
+		}
+		key := make([]byte, keyLen)
+		if _, err = io.ReadFull(reader, key); err != nil {
+			return err
+		}
+		id, err := binary.ReadVarint(reader)
+		if err != nil {
+			return err
+		}
+		sl.Put(key, int(id))
+	}
+	return nil
+}
+
+// Return a random level for a new node.
+func (sl *SkipList) randomLevel() int {
+	level := 1
+	for level < SkipListMaxLevel && sl.rand.Intn(SkipListBranch) == 0 {
+		level++
+	}
+	return level
+}
+
+// Return the last node before the key and ID on every level.
+func (sl *SkipList) predecessors(key []byte, id int) []*skipNode {
+	update := make([]*skipNode, SkipListMaxLevel)
+	node := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].compare(key, id) < 0 {
+			node = node.next[level]
+		}
+		update[level] = node
+	}
+	return update
+}
+
+// Store the entry. An existing entry is left as it is.
+func (sl *SkipList) Put(key []byte, id int) {
+	update := sl.predecessors(key, id)
+	if next := update[0].next[0]; next != nil && next.compare(key, id) == 0 {
+		return
+	}
+	level := sl.randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+		}
+		sl.level = level
+	}
+	node := &skipNode{key: append([]byte(nil), key...), id: id, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	if update[0] != sl.head {
+		node.prev = update[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	} else {
+		sl.tail = node
+	}
+	sl.length++
+	sl.dirty = true
+}
+
+// Remove the entry.
+func (sl *SkipList) Remove(key []byte, id int) {
+	update := sl.predecessors(key, id)
+	node := update[0].next[0]
+	if node == nil || node.compare(key, id) != 0 {
+		return
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	} else {
+		sl.tail = node.prev
+	}
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.length--
+	sl.dirty = true
+}
+
+// Return the number of entries.
+func (sl *SkipList) Len() int {
+	return sl.length
+}
+
+// Run the function on the entries with a key from "from" (inclusive) to "to" (exclusive) in key order, or in reverse
+// key order; stop when the function returns false. A nil bound is open.
+func (sl *SkipList) Scan(from, to []byte, reverse bool, fun func(key []byte, id int) bool) {
+	if reverse {
+		var node *skipNode
+		if to == nil {
+			node = sl.tail
+		} else if last := sl.predecessors(to, -1<<63)[0]; last != sl.head {
+			node = last
+		}
+		for ; node != nil && (from == nil || bytes.Compare(node.key, from) >= 0); node = node.prev {
+			if !fun(node.key, node.id) {
+				return
+			}
+		}
+		return
+	}
+	node := sl.head.next[0]
+	if from != nil {
+		node = sl.predecessors(from, -1<<63)[0].next[0]
+	}
+	for ; node != nil && (to == nil || bytes.Compare(node.key, to) < 0); node = node.next[0] {
+		if !fun(node.key, node.id) {
+			return
+		}
+	}
+}
+
+// Return the number of entries with a key from "from" (inclusive) to "to" (exclusive), counting up to the limit
+// (0 for no limit).
+func (sl *SkipList) Count(from, to []byte, limit int) (count int) {
+	sl.Scan(from, to, false, func(_ []byte, _ int) bool {
+		count++
+		return limit <= 0 || count < limit
+	})
+	return
+}
+
+// Write the entries into the file, if they changed since the last snapshot.
+func (sl *SkipList) Sync() (err error) {
+	if !sl.dirty {
+		return
+	}
+	tmpPath := sl.Path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	checksum := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(file, checksum))
+	buf := make([]byte, binary.MaxVarintLen64)
+	out.WriteString(SkipListMagic)
+	out.Write(buf[:binary.PutUvarint(buf, uint64(sl.length))])
+	for node := sl.head.next[0]; node != nil; node = node.next[0] {
+		out.Write(buf[:binary.PutUvarint(buf, uint64(len(node.key)))])
+		out.Write(node.key)
+		out.Write(buf[:binary.PutVarint(buf, int64(node.id))])
+	}
+	if err = out.Flush(); err != nil {
+		file.Close()
+		return
+	}
+	binary.LittleEndian.PutUint32(buf, checksum.Sum32())
+	if _, err = file.Write(buf[:4]); err != nil {
+		file.Close()
+		return
+	} else if err = file.Sync(); err != nil {
+		file.Close()
+		return
+	} else if err = file.Close(); err != nil {
+		return
+	} else if err = os.Rename(tmpPath, sl.Path); err != nil {
+		return
+	}
+	sl.dirty = false
+	return
+}
+
+// Remove all entries.
+func (sl *SkipList) Clear() error {
+	sl.reset()
+	sl.dirty = true
+	sl.Rebuild = false
+	return sl.Sync()
+}
+
+// Write the entries into the file.
+func (sl *SkipList) Close() error {
+	return sl.Sync()
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+type skipEntry struct {
+	key string
+	id  int
+}
+
+func openTestSkipList(t *testing.T, file string) *SkipList {
+	t.Helper()
+	sl, err := OpenSkipList(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sl
+}
+
+// Return the entries from "from" to "to" in the scan order.
+func scanEntries(sl *SkipList, from, to []byte, reverse bool) (entries []skipEntry) {
+	sl.Scan(from, to, reverse, func(key []byte, id int) bool {
+		entries = append(entries, skipEntry{string(key), id})
+		return true
+	})
+	return
+}
+
+func TestSkipListOrder(t *testing.T) {
+	sl := openTestSkipList(t, path.Join(t.TempDir(), "sorted"))
+	sl.Put([]byte("b"), 2)
+	sl.Put([]byte("a"), 3)
+	sl.Put([]byte("c"), 1)
+	sl.Put([]byte("b"), 1)
+	sl.Put([]byte("b"), 1) // The key and ID are already there
+	if sl.Len() != 4 {
+		t.Fatalf("%d entries, want 4", sl.Len())
+	}
+	want := []skipEntry{{"a", 3}, {"b", 1}, {"b", 2}, {"c", 1}}
+	if got := scanEntries(sl, nil, nil, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan %v, want %v", got, want)
+	}
+	reversed := []skipEntry{{"c", 1}, {"b", 2}, {"b", 1}, {"a", 3}}
+	if got := scanEntries(sl, nil, nil, true); !reflect.DeepEqual(got, reversed) {
+		t.Fatalf("reverse scan %v, want %v", got, reversed)
+	}
+
+	sl.Remove([]byte("b"), 1)
+	sl.Remove([]byte("x"), 1) // Not there
+	want = []skipEntry{{"a", 3}, {"b", 2}, {"c", 1}}
+	if got := scanEntries(sl, nil, nil, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan after remove %v, want %v", got, want)
+	}
+}
+
+func TestSkipListRange(t *testing.T) {
+	sl := openTestSkipList(t, path.Join(t.TempDir(), "sorted"))
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		sl.Put([]byte(key), i)
+	}
+	want := []skipEntry{{"b", 1}, {"c", 2}}
+	if got := scanEntries(sl, []byte("b"), []byte("d"), false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan from b to d %v, want %v", got, want)
+	}
+	want = []skipEntry{{"c", 2}, {"b", 1}}
+	if got := scanEntries(sl, []byte("b"), []byte("d"), true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("reverse scan from b to d %v, want %v", got, want)
+	}
+	want = []skipEntry{{"e", 4}, {"d", 3}}
+	if got := scanEntries(sl, []byte("cc"), nil, true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("reverse scan from cc %v, want %v", got, want)
+	}
+	if got := scanEntries(sl, nil, []byte("a"), true); len(got) != 0 {
+		t.Fatalf("reverse scan below a %v, want none", got)
+	}
+	if count := sl.Count([]byte("b"), nil, 0); count != 4 {
+		t.Fatalf("count from b %d, want 4", count)
+	}
+	if count := sl.Count(nil, nil, 2); count != 2 {
+		t.Fatalf("count with limit 2 is %d", count)
+	}
+
+	// The function stops the scan
+	var visited []skipEntry
+	sl.Scan(nil, nil, false, func(key []byte, id int) bool {
+		visited = append(visited, skipEntry{string(key), id})
+		return len(visited) < 2
+	})
+	if want := []skipEntry{{"a", 0}, {"b", 1}}; !reflect.DeepEqual(visited, want) {
+		t.Fatalf("stopped scan %v, want %v", visited, want)
+	}
+}
+
+func TestSkipListSnapshot(t *testing.T) {
+	file := path.Join(t.TempDir(), "sorted")
+	sl := openTestSkipList(t, file)
+	for i := 0; i < 1000; i++ {
+		sl.Put([]byte{byte(i % 7), byte(i)}, i)
+	}
+	sl.Remove([]byte{0, 0}, 0)
+	want := scanEntries(sl, nil, nil, false)
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sl = openTestSkipList(t, file)
+	if sl.Rebuild {
+		t.Fatal("a good snapshot asks for a rebuild")
+	}
+	if got := scanEntries(sl, nil, nil, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded %d entries, want the %d saved", len(got), len(want))
+	}
+	if got := scanEntries(sl, nil, nil, true); len(got) != len(want) || got[0] != want[len(want)-1] {
+		t.Fatal("the loaded entries are not linked backwards")
+	}
+
+	if err := sl.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	sl = openTestSkipList(t, file)
+	if sl.Len() != 0 {
+		t.Fatalf("%d entries after clear", sl.Len())
+	}
+}
+
+func TestSkipListBadSnapshot(t *testing.T) {
+	file := path.Join(t.TempDir(), "sorted")
+	sl := openTestSkipList(t, file)
+	sl.Put([]byte("a"), 1)
+	sl.Put([]byte("b"), 2)
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped byte no longer matches the checksum
+	corrupted := append([]byte(nil), content...)
+	corrupted[len(SkipListMagic)+2] ^= 0xff
+	if err := ioutil.WriteFile(file, corrupted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sl = openTestSkipList(t, file)
+	if !sl.Rebuild || sl.Len() != 0 {
+		t.Fatalf("bad checksum loaded %d entries, rebuild %v", sl.Len(), sl.Rebuild)
+	}
+
+	// A file cut short has no checksum to match
+	if err := ioutil.WriteFile(file, content[:len(SkipListMagic)+1], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if sl = openTestSkipList(t, file); !sl.Rebuild {
+		t.Fatal("truncated file does not ask for a rebuild")
+	}
+
+	// The entries put back are saved, and the next open reads them
+	sl.Put([]byte("a"), 1)
+	if err := sl.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	sl.Put([]byte("a"), 1)
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sl = openTestSkipList(t, file)
+	if sl.Rebuild || sl.Len() != 1 {
+		t.Fatalf("rebuilt file loaded %d entries, rebuild %v", sl.Len(), sl.Rebuild)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("the snapshot left its temporary file behind")
+	}
+}
@@ -26,6 +26,7 @@ type Col struct {
 	name       string
 	parts      []*data.Partition            // Collection partitions
 	hts        []map[string]*data.HashTable // Index partitions
+	sorted     map[string]*data.SkipList    // Sorted indexes
 	indexPaths map[string][]string          // Index names and paths
 }
 
@@ -45,6 +46,7 @@ func (col *Col) load() error {
 	for i := 0; i < col.db.numParts; i++ {
 		col.hts[i] = make(map[string]*data.HashTable)
 	}
+	col.sorted = make(map[string]*data.SkipList)
 	col.indexPaths = make(map[string][]string)
 	// Open collection document partitions
 	for i := 0; i < col.db.numParts; i++ {
@@ -68,6 +70,12 @@ func (col *Col) load() error {
 		idxName := htDir.Name()
 		idxPath := strings.Split(idxName, INDEX_PATH_SEP)
 		col.indexPaths[idxName] = idxPath
+		if isSortedIndex(idxPath) {
+			if col.sorted[idxName], err = col.openSortedIndex(idxName); err != nil {
+				return err
+			}
+			continue
+		}
 		for i := 0; i < col.db.numParts; i++ {
 			if col.hts[i][idxName], err = col.db.Config.OpenHashTable(
 				path.Join(col.db.path, col.name, idxName, strconv.Itoa(i))); err != nil {
@@ -75,6 +83,12 @@ func (col *Col) load() error {
 			}
 		}
 	}
+	// Put the documents back on the sorted indexes whose file was unreadable
+	for idxName, sl := range col.sorted {
+		if sl.Rebuild {
+			col.fillSortedIndex(idxName)
+		}
+	}
 	return nil
 }
 
@@ -93,6 +107,37 @@ func (col *Col) close() error {
 		}
 		col.parts[i].DataLock.Unlock()
 	}
+	for _, sl := range col.sorted {
+		if err := sl.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", errs)
+}
+
+// Write all collection files to disk.
+func (col *Col) sync() error {
+	errs := make([]error, 0, 0)
+	for i := 0; i < col.db.numParts; i++ {
+		if err := col.parts[i].Sync(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, ht := range col.hts[i] {
+			if err := ht.Sync(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, sl := range col.sorted {
+		sl.Lock.Lock()
+		if err := sl.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+		sl.Lock.Unlock()
+	}
 	if len(errs) == 0 {
 		return nil
 	}
@@ -140,6 +185,13 @@ func (col *Col) Index(idxPath []string) (err error) {
 	if err = os.MkdirAll(idxDir, 0700); err != nil {
 		return err
 	}
+	if isSortedIndex(idxPath) {
+		if col.sorted[idxName], err = col.openSortedIndex(idxName); err != nil {
+			return err
+		}
+		col.fillSortedIndex(idxName)
+		return col.db.checkpoint()
+	}
 	for i := 0; i < col.db.numParts; i++ {
 		if col.hts[i][idxName], err = col.db.Config.OpenHashTable(path.Join(idxDir, strconv.Itoa(i))); err != nil {
 			return err
@@ -152,15 +204,13 @@ func (col *Col) Index(idxPath []string) (err error) {
 			// Skip corrupted document
 			return true
 		}
-		for _, idxVal := range GetIn(docObj, idxPath) {
-			if idxVal != nil {
-				hashKey := StrHash(fmt.Sprint(idxVal))
-				col.hts[hashKey%col.db.numParts][idxName].Put(hashKey, id)
-			}
+		for _, hashKey := range indexKeys(docObj, idxPath) {
+			col.hts[hashKey%col.db.numParts][idxName].Put(hashKey, id)
 		}
 		return true
 	}, false)
-	return
+	// The new index entries are not logged
+	return col.db.checkpoint()
 }
 
 // Return all indexed paths.
@@ -187,9 +237,15 @@ func (col *Col) Unindex(idxPath []string) error {
 		return fmt.Errorf("Path %v is not indexed", idxPath)
 	}
 	delete(col.indexPaths, idxName)
+	if sl, sorted := col.sorted[idxName]; sorted {
+		sl.Close()
+		delete(col.sorted, idxName)
+	}
 	for i := 0; i < col.db.numParts; i++ {
-		col.hts[i][idxName].Close()
-		delete(col.hts[i], idxName)
+		if ht, exists := col.hts[i][idxName]; exists {
+			ht.Close()
+			delete(col.hts[i], idxName)
+		}
 	}
 	if err := os.RemoveAll(path.Join(col.db.path, col.name, idxName)); err != nil {
 		return err
@@ -27,23 +27,36 @@ const (
 
 // Database structures.
 type DB struct {
-	Config     *data.Config
-	path       string          // Root path of database directory
-	numParts   int             // Total number of partitions
-	cols       map[string]*Col // All collections
-	schemaLock *sync.RWMutex   // Control access to collection instances.
+	Config        *data.Config
+	path          string          // Root path of database directory
+	numParts      int             // Total number of partitions
+	cols          map[string]*Col // All collections
+	schemaLock    *sync.RWMutex   // Control access to collection instances.
+	options       Options         // Durability settings
+	wal           *wal            // Write-ahead log of document writes
+	checkpointNow chan struct{}   // Ask the background goroutine for a checkpoint
+	stop          chan struct{}   // Stop the background goroutine
+	background    *sync.WaitGroup // Wait for the background goroutine to stop
 }
 
-// Open database and load all collections & indexes.
+// Open database and load all collections & indexes, with the default durability options.
 func OpenDB(dbPath string) (*DB, error) {
+	return OpenDBWithOptions(dbPath, DefaultOptions())
+}
+
+// Open database and load all collections & indexes. The writes a crash left in the write-ahead log are replayed.
+func OpenDBWithOptions(dbPath string, options Options) (*DB, error) {
 	rand.Seed(time.Now().UnixNano()) // document ID generation relies on this RNG
 	d, err := data.CreateOrReadConfig(dbPath)
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{Config: d, path: dbPath, schemaLock: new(sync.RWMutex)}
+	db := &DB{Config: d, path: dbPath, options: options, schemaLock: new(sync.RWMutex)}
 	db.Config.CalculateConfigConstants()
-	return db, db.load()
+	if err := db.load(); err != nil {
+		return db, err
+	}
+	return db, db.openWAL()
 }
 
 // Load all collection schema.
@@ -91,14 +104,27 @@ func (db *DB) load() error {
 
 // Close all database files. Do not use the DB afterwards!
 func (db *DB) Close() error {
+	if db.stop != nil {
+		close(db.stop)
+		db.background.Wait()
+	}
 	db.schemaLock.Lock()
 	defer db.schemaLock.Unlock()
 	errs := make([]error, 0, 0)
+	// A clean close leaves an empty write-ahead log
+	if err := db.checkpoint(); err != nil {
+		errs = append(errs, err)
+	}
 	for _, col := range db.cols {
 		if err := col.close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
+	if db.wal != nil {
+		if err := db.wal.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if len(errs) == 0 {
 		return nil
 	}
@@ -153,6 +179,8 @@ func (db *DB) Rename(oldName, newName string) error {
 		return fmt.Errorf("Collection %s does not exist", oldName)
 	} else if _, exists := db.cols[newName]; exists {
 		return fmt.Errorf("Collection %s already exists", newName)
+	} else if err := db.checkpoint(); err != nil {
+		return err
 	} else if err := db.cols[oldName].close(); err != nil {
 		return err
 	} else if err := os.Rename(path.Join(db.path, oldName), path.Join(db.path, newName)); err != nil {
@@ -171,6 +199,10 @@ func (db *DB) Truncate(name string) error {
 	if _, exists := db.cols[name]; !exists {
 		return fmt.Errorf("Collection %s does not exist", name)
 	}
+	// The logged writes of the collection must not be replayed after it is emptied
+	if err := db.checkpoint(); err != nil {
+		return err
+	}
 	col := db.cols[name]
 	for i := 0; i < db.numParts; i++ {
 		if err := col.parts[i].Clear(); err != nil {
@@ -182,6 +214,11 @@ func (db *DB) Truncate(name string) error {
 			}
 		}
 	}
+	for _, sl := range col.sorted {
+		if err := sl.Clear(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -192,6 +229,9 @@ func (db *DB) Scrub(name string) error {
 	if _, exists := db.cols[name]; !exists {
 		return fmt.Errorf("Collection %s does not exist", name)
 	}
+	if err := db.checkpoint(); err != nil {
+		return err
+	}
 	// Prepare a temporary collection in file system
 	tmpColName := fmt.Sprintf("scrub-%s-%d", name, time.Now().UnixNano())
 	tmpColDir := path.Join(db.path, tmpColName)
@@ -220,6 +260,10 @@ func (db *DB) Scrub(name string) error {
 		}
 		return true
 	}, false)
+	// The scrubbed documents are not logged, they have to be on disk before the original is removed
+	if err := tmpCol.sync(); err != nil {
+		return err
+	}
 	if err := tmpCol.close(); err != nil {
 		return err
 	}
@@ -243,6 +287,8 @@ func (db *DB) Drop(name string) error {
 	defer db.schemaLock.Unlock()
 	if _, exists := db.cols[name]; !exists {
 		return fmt.Errorf("Collection %s does not exist", name)
+	} else if err := db.checkpoint(); err != nil {
+		return err
 	} else if err := db.cols[name].close(); err != nil {
 		return err
 	} else if err := os.RemoveAll(path.Join(db.path, name)); err != nil {
@@ -256,6 +302,10 @@ func (db *DB) Drop(name string) error {
 func (db *DB) Dump(dest string) error {
 	db.schemaLock.Lock()
 	defer db.schemaLock.Unlock()
+	// The copy holds every write and an empty write-ahead log
+	if err := db.checkpoint(); err != nil {
+		return err
+	}
 	cpFun := func(currPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
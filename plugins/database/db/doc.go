@@ -50,18 +50,31 @@ func StrHash(str string) int {
 	return hash
 }
 
+// Return the document as it is stored. Values such as object IDs are indexed in the JSON form queries compare them in.
+func storedDoc(docJS []byte) (doc map[string]interface{}) {
+	if err := json.Unmarshal(docJS, &doc); err != nil {
+		tdlog.Noticef("Will not index a document that is not valid JSON: %v", err)
+	}
+	return
+}
+
 // Put a document on all user-created indexes.
 func (col *Col) indexDoc(id int, doc map[string]interface{}) {
 	for idxName, idxPath := range col.indexPaths {
-		for _, idxVal := range GetIn(doc, idxPath) {
-			if idxVal != nil {
-				hashKey := StrHash(fmt.Sprint(idxVal))
-				partNum := hashKey % col.db.numParts
-				ht := col.hts[partNum][idxName]
-				ht.Lock.Lock()
-				ht.Put(hashKey, id)
-				ht.Lock.Unlock()
+		if sl, sorted := col.sorted[idxName]; sorted {
+			sl.Lock.Lock()
+			for _, key := range sortedIndexKeys(doc, idxPath) {
+				sl.Put(key, id)
 			}
+			sl.Lock.Unlock()
+			continue
+		}
+		for _, hashKey := range indexKeys(doc, idxPath) {
+			partNum := hashKey % col.db.numParts
+			ht := col.hts[partNum][idxName]
+			ht.Lock.Lock()
+			ht.Put(hashKey, id)
+			ht.Lock.Unlock()
 		}
 	}
 }
@@ -69,15 +82,20 @@ func (col *Col) indexDoc(id int, doc map[string]interface{}) {
 // Remove a document from all user-created indexes.
 func (col *Col) unindexDoc(id int, doc map[string]interface{}) {
 	for idxName, idxPath := range col.indexPaths {
-		for _, idxVal := range GetIn(doc, idxPath) {
-			if idxVal != nil {
-				hashKey := StrHash(fmt.Sprint(idxVal))
-				partNum := hashKey % col.db.numParts
-				ht := col.hts[partNum][idxName]
-				ht.Lock.Lock()
-				ht.Remove(hashKey, id)
-				ht.Lock.Unlock()
+		if sl, sorted := col.sorted[idxName]; sorted {
+			sl.Lock.Lock()
+			for _, key := range sortedIndexKeys(doc, idxPath) {
+				sl.Remove(key, id)
 			}
+			sl.Lock.Unlock()
+			continue
+		}
+		for _, hashKey := range indexKeys(doc, idxPath) {
+			partNum := hashKey % col.db.numParts
+			ht := col.hts[partNum][idxName]
+			ht.Lock.Lock()
+			ht.Remove(hashKey, id)
+			ht.Lock.Unlock()
 		}
 	}
 }
@@ -95,7 +113,39 @@ func (col *Col) InsertRecovery(id int, doc map[string]interface{}) (err error) {
 		return
 	}
 	// Index the document
-	col.indexDoc(id, doc)
+	col.indexDoc(id, storedDoc(docJS))
+	return
+}
+
+// Restore puts a deleted document back under its former ID (incl. index).
+func (col *Col) Restore(id int, doc map[string]interface{}) (err error) {
+	docJS, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	partNum := id % col.db.numParts
+	col.db.schemaLock.RLock()
+	part := col.parts[partNum]
+	if err = col.logWrite(WAL_INSERT, id, docJS); err != nil {
+		col.db.schemaLock.RUnlock()
+		return
+	}
+
+	// Put document data into collection
+	part.DataLock.Lock()
+	_, err = part.Insert(id, []byte(docJS))
+	part.DataLock.Unlock()
+	if err != nil {
+		col.db.schemaLock.RUnlock()
+		return
+	}
+
+	part.LockUpdate(id)
+	// Index the document
+	col.indexDoc(id, storedDoc(docJS))
+	part.UnlockUpdate(id)
+
+	col.db.schemaLock.RUnlock()
 	return
 }
 
@@ -109,6 +159,10 @@ func (col *Col) Insert(doc map[string]interface{}) (id int, err error) {
 	partNum := id % col.db.numParts
 	col.db.schemaLock.RLock()
 	part := col.parts[partNum]
+	if err = col.logWrite(WAL_INSERT, id, docJS); err != nil {
+		col.db.schemaLock.RUnlock()
+		return
+	}
 
 	// Put document data into collection
 	part.DataLock.Lock()
@@ -121,7 +175,7 @@ func (col *Col) Insert(doc map[string]interface{}) (id int, err error) {
 
 	part.LockUpdate(id)
 	// Index the document
-	col.indexDoc(id, doc)
+	col.indexDoc(id, storedDoc(docJS))
 	part.UnlockUpdate(id)
 
 	col.db.schemaLock.RUnlock()
@@ -176,6 +230,11 @@ func (col *Col) Update(id int, doc map[string]interface{}) error {
 		col.db.schemaLock.RUnlock()
 		return err
 	}
+	if err = col.logWrite(WAL_UPDATE, id, docJS); err != nil {
+		part.DataLock.Unlock()
+		col.db.schemaLock.RUnlock()
+		return err
+	}
 	err = part.Update(id, []byte(docJS))
 	part.DataLock.Unlock()
 	if err != nil {
@@ -195,7 +254,7 @@ func (col *Col) Update(id int, doc map[string]interface{}) error {
 	} else {
 		tdlog.Noticef("Will not attempt to unindex document %d during update", id)
 	}
-	col.indexDoc(id, doc)
+	col.indexDoc(id, storedDoc(docJS))
 	// Done with the index
 	part.UnlockUpdate(id)
 
@@ -239,6 +298,11 @@ func (col *Col) UpdateBytesFunc(id int, update func(origDoc []byte) (newDoc []by
 		col.db.schemaLock.RUnlock()
 		return err
 	}
+	if err = col.logWrite(WAL_UPDATE, id, docB); err != nil {
+		part.DataLock.Unlock()
+		col.db.schemaLock.RUnlock()
+		return err
+	}
 	err = part.Update(id, docB)
 	part.DataLock.Unlock()
 	if err != nil {
@@ -296,6 +360,11 @@ func (col *Col) UpdateFunc(id int, update func(origDoc map[string]interface{}) (
 		col.db.schemaLock.RUnlock()
 		return err
 	}
+	if err = col.logWrite(WAL_UPDATE, id, docJS); err != nil {
+		part.DataLock.Unlock()
+		col.db.schemaLock.RUnlock()
+		return err
+	}
 	err = part.Update(id, []byte(docJS))
 	part.DataLock.Unlock()
 	if err != nil {
@@ -306,7 +375,7 @@ func (col *Col) UpdateFunc(id int, update func(origDoc map[string]interface{}) (
 	// Done with the collection data, next is to maintain indexed values
 	part.LockUpdate(id)
 	col.unindexDoc(id, original)
-	col.indexDoc(id, doc)
+	col.indexDoc(id, storedDoc(docJS))
 	// Done with the document
 	part.UnlockUpdate(id)
 
@@ -327,6 +396,11 @@ func (col *Col) Delete(id int) error {
 		col.db.schemaLock.RUnlock()
 		return err
 	}
+	if err = col.logWrite(WAL_DELETE, id, nil); err != nil {
+		part.DataLock.Unlock()
+		col.db.schemaLock.RUnlock()
+		return err
+	}
 	err = part.Delete(id)
 	part.DataLock.Unlock()
 	if err != nil {
@@ -0,0 +1,160 @@
+// Geospatial (geohash grid) index on top of the hash indexes.
+
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	GEO_INDEX_PREFIX = "$geo" // First segment of the path of a geo index.
+	GEO_PRECISION    = 6      // Longest geohash put on a geo index, cells of about 1.2 by 0.6 km.
+	GEO_MAX_CELLS    = 64     // Most cells a scan looks up before it falls back to larger cells.
+)
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Return true if the index path belongs to a geo index.
+func isGeoIndex(idxPath []string) bool {
+	return len(idxPath) == 2 && idxPath[0] == GEO_INDEX_PREFIX
+}
+
+// Encode a point as a geohash of the given length.
+func Geohash(lat, lng float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	bit, ch, even := 0, 0, true
+	for len(hash) < precision {
+		if even {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if lng >= mid {
+				ch |= 1 << (4 - bit)
+				lngRange[0] = mid
+			} else {
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// Return the size in degrees of the geohash cells of the given length.
+func geohashCellSize(precision int) (latSize, lngSize float64) {
+	bits := precision * 5
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / float64(uint64(1)<<latBits), 360 / float64(uint64(1)<<lngBits)
+}
+
+// Return the geohashes of the given length of the cells that cover the box.
+func geohashCover(minLat, minLng, maxLat, maxLng float64, precision int) []string {
+	latSize, lngSize := geohashCellSize(precision)
+	seen := make(map[string]struct{})
+	ret := make([]string, 0)
+	for lat := minLat; ; lat += latSize {
+		if lat > maxLat {
+			lat = maxLat
+		}
+		for lng := minLng; ; lng += lngSize {
+			if lng > maxLng {
+				lng = maxLng
+			}
+			hash := Geohash(lat, lng, precision)
+			if _, exists := seen[hash]; !exists {
+				seen[hash] = struct{}{}
+				ret = append(ret, hash)
+			}
+			if lng == maxLng {
+				break
+			}
+		}
+		if lat == maxLat {
+			break
+		}
+	}
+	return ret
+}
+
+// Return the latitude and longitude of a GeoJSON point.
+func GeoPoint(val interface{}) (lat, lng float64, ok bool) {
+	point, isMap := val.(map[string]interface{})
+	if !isMap {
+		return
+	}
+	coordinates, isArray := point["coordinates"].([]interface{})
+	if !isArray || len(coordinates) != 2 {
+		return
+	}
+	lng, okLng := coordinates[0].(float64)
+	lat, okLat := coordinates[1].(float64)
+	return lat, lng, okLng && okLat
+}
+
+// Return the geohashes of every length under which a document is put on a geo index.
+func geoIndexWords(doc map[string]interface{}, idxPath []string) (words []string) {
+	for _, val := range GetIn(doc, strings.Split(idxPath[1], ".")) {
+		if lat, lng, ok := GeoPoint(val); ok {
+			hash := Geohash(lat, lng, GEO_PRECISION)
+			for i := 1; i <= GEO_PRECISION; i++ {
+				words = append(words, hash[:i])
+			}
+		}
+	}
+	return
+}
+
+// Create a geo index on the field, which holds GeoJSON points.
+func (col *Col) GeoIndex(field string) error {
+	return col.Index([]string{GEO_INDEX_PREFIX, field})
+}
+
+// Return true if the field has a geo index.
+func (col *Col) HasGeoIndex(field string) bool {
+	col.db.schemaLock.RLock()
+	defer col.db.schemaLock.RUnlock()
+	_, exists := col.indexPaths[strings.Join([]string{GEO_INDEX_PREFIX, field}, INDEX_PATH_SEP)]
+	return exists
+}
+
+// Look up the documents whose point on the field may lie inside the box.
+// The cells of the index are larger than the box, so the caller verifies the points.
+func (col *Col) GeoScan(field string, minLat, minLng, maxLat, maxLng float64) (ids map[int]struct{}, err error) {
+	col.db.schemaLock.RLock()
+	defer col.db.schemaLock.RUnlock()
+	idxName := strings.Join([]string{GEO_INDEX_PREFIX, field}, INDEX_PATH_SEP)
+	if _, exists := col.indexPaths[idxName]; !exists {
+		return nil, fmt.Errorf("Field %s has no geo index", field)
+	}
+	// Use the smallest cells that cover the box with few lookups
+	cells := []string{""}
+	for precision := GEO_PRECISION; precision > 0; precision-- {
+		if cover := geohashCover(minLat, minLng, maxLat, maxLng, precision); len(cover) <= GEO_MAX_CELLS || precision == 1 {
+			cells = cover
+			break
+		}
+	}
+	ids = make(map[int]struct{})
+	for _, cell := range cells {
+		for _, id := range col.hashScan(idxName, StrHash(cell), 0) {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, nil
+}
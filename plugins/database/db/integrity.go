@@ -0,0 +1,130 @@
+// Integrity check of the ID lookups and indexes against the documents.
+
+package db
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/robertkonga/yekonga-server-go/plugins/database/tdlog"
+)
+
+// IntegrityReport is the outcome of the integrity check of a collection.
+type IntegrityReport struct {
+	Col        string   // Col is the collection name.
+	Docs       int      // Docs is the number of valid documents.
+	BadLookups int      // BadLookups is the number of ID lookup entries removed for pointing to no valid document.
+	Rebuilt    []string // Rebuilt are the indexes rebuilt from the documents.
+}
+
+// Return true if the check had to repair the collection.
+func (report IntegrityReport) Repaired() bool {
+	return report.BadLookups > 0 || len(report.Rebuilt) > 0
+}
+
+// Check the ID lookups and indexes of all collections against the documents, and rebuild the indexes that disagree
+// with the documents. The repaired files are written to disk before returning.
+func (db *DB) CheckIntegrity() ([]IntegrityReport, error) {
+	db.schemaLock.Lock()
+	defer db.schemaLock.Unlock()
+	names := make([]string, 0, len(db.cols))
+	for name := range db.cols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reports := make([]IntegrityReport, 0, len(names))
+	for _, name := range names {
+		reports = append(reports, db.cols[name].checkIntegrity())
+	}
+	return reports, db.checkpoint()
+}
+
+// Remove the ID lookup entries that point to no valid document, and rebuild the indexes that disagree with the
+// documents. Does not place a schema lock.
+func (col *Col) checkIntegrity() (report IntegrityReport) {
+	report.Col = col.name
+	// Work out the index entries from the documents
+	expected := make(map[string]map[[2]int]struct{}, len(col.indexPaths))
+	sortedExpected := make(map[string]map[string]struct{}, len(col.sorted))
+	for idxName := range col.indexPaths {
+		if _, sorted := col.sorted[idxName]; sorted {
+			sortedExpected[idxName] = make(map[string]struct{})
+		} else {
+			expected[idxName] = make(map[[2]int]struct{})
+		}
+	}
+	for partNum, part := range col.parts {
+		part.DataLock.Lock()
+		report.BadLookups += part.Verify(func(id int, doc []byte) bool {
+			var docObj map[string]interface{}
+			if id%col.db.numParts != partNum || json.Unmarshal(doc, &docObj) != nil {
+				return false
+			}
+			report.Docs++
+			for idxName, idxPath := range col.indexPaths {
+				if entries, sorted := sortedExpected[idxName]; sorted {
+					for _, key := range sortedIndexKeys(docObj, idxPath) {
+						entries[sortedEntry(key, id)] = struct{}{}
+					}
+					continue
+				}
+				for _, hashKey := range indexKeys(docObj, idxPath) {
+					expected[idxName][[2]int{hashKey, id}] = struct{}{}
+				}
+			}
+			return true
+		})
+		part.DataLock.Unlock()
+	}
+	// Compare them with the index entries on disk
+	for idxName := range col.indexPaths {
+		var err error
+		if sl, sorted := col.sorted[idxName]; sorted {
+			if sortedIndexMatches(sl, sortedExpected[idxName]) {
+				continue
+			}
+			err = rebuildSortedIndex(sl, sortedExpected[idxName])
+		} else {
+			if col.indexMatches(idxName, expected[idxName]) {
+				continue
+			}
+			err = col.rebuildIndex(idxName, expected[idxName])
+		}
+		if err != nil {
+			tdlog.CritNoRepeat("Failed to rebuild index %s of %s: %v", idxName, col.name, err)
+			continue
+		}
+		report.Rebuilt = append(report.Rebuilt, idxName)
+	}
+	sort.Strings(report.Rebuilt)
+	return
+}
+
+// Return true if the index holds exactly the expected entries.
+func (col *Col) indexMatches(idxName string, expected map[[2]int]struct{}) bool {
+	actual := make(map[[2]int]struct{}, len(expected))
+	for partNum := range col.hts {
+		keys, vals := col.hts[partNum][idxName].GetPartition(0, 1)
+		for i, key := range keys {
+			entry := [2]int{key, vals[i]}
+			if _, exists := expected[entry]; !exists || key%col.db.numParts != partNum {
+				return false
+			}
+			actual[entry] = struct{}{}
+		}
+	}
+	return len(actual) == len(expected)
+}
+
+// Clear the index and put the entries on it.
+func (col *Col) rebuildIndex(idxName string, entries map[[2]int]struct{}) error {
+	for partNum := range col.hts {
+		if err := col.hts[partNum][idxName].Clear(); err != nil {
+			return err
+		}
+	}
+	for entry := range entries {
+		col.hts[entry[0]%col.db.numParts][idxName].Put(entry[0], entry[1])
+	}
+	return nil
+}
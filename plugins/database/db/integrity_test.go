@@ -0,0 +1,63 @@
+package db
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestCheckIntegrityRebuildsCorruptedIndex(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db := openTestDB(t, dir)
+	defer db.Close()
+	if err := db.Create("Posts"); err != nil {
+		t.Fatal(err)
+	}
+	col := db.Use("Posts")
+	if err := col.Index([]string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int)
+	for _, title := range []string{"a", "b", "c"} {
+		id, err := col.Insert(map[string]interface{}{"title": title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[title] = id
+	}
+
+	// Lose the entries of the index and point it at a document that does not exist
+	for partNum := range col.hts {
+		if err := col.hts[partNum]["title"].Clear(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ghost := StrHash("ghost")
+	col.hts[ghost%db.numParts]["title"].Put(ghost, 12345)
+	if result := lookupTitle(t, col, "a"); len(result) != 0 {
+		t.Fatalf("the cleared index still finds %v", result)
+	}
+
+	reports, err := db.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Docs != 3 || !reflect.DeepEqual(reports[0].Rebuilt, []string{"title"}) {
+		t.Fatalf("report %+v, want the title index of 3 documents rebuilt", reports)
+	}
+	for title, id := range ids {
+		assertTitle(t, col, id, title)
+	}
+	if col.indexMatches("title", map[[2]int]struct{}{}) {
+		t.Fatal("the rebuilt index is empty")
+	}
+
+	// The repaired collection passes the next check
+	reports, err = db.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[0].Repaired() {
+		t.Fatalf("second check repaired %+v", reports[0])
+	}
+}
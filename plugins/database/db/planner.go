@@ -0,0 +1,367 @@
+// Query planner of "and" queries, and the comparison operators evaluated on the documents.
+//
+// The sub-queries of an "and" query are compiled into conditions. Each
+// condition that an index can answer estimates the number of documents it
+// matches, counting no further than the best estimate so far, and the planner
+// collects the candidates from the most selective one. The other conditions are
+// checked on the candidate documents. When no index can answer a condition,
+// every document is checked.
+
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/plugins/database/dberr"
+)
+
+// Comparison operators of a range condition.
+var rangeOps = []string{"gt", "gte", "lt", "lte", "prefix"}
+
+// A compiled sub-query.
+type condition struct {
+	op     string   // eq, range, in, nin, all, regex, not or ids
+	path   []string // Path of the attribute
+	value  interface{}
+	values []interface{}
+	from   []byte // Inclusive lower bound of a range, nil for none
+	to     []byte // Exclusive upper bound of a range, nil for none
+	regex  *regexp.Regexp
+	sub    *condition             // Negated condition
+	ids    map[int]struct{}       // Matching documents of a sub-query evaluated up front
+	expr   map[string]interface{} // The sub-query
+}
+
+// Return true if the query is evaluated by the planner.
+func isPlannedQuery(expr map[string]interface{}) bool {
+	for _, op := range append([]string{"and", "not", "$in", "$nin", "all", "regex"}, rangeOps...) {
+		if _, exists := expr[op]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the lookup path of a query - JSON array "in".
+func queryPath(expr map[string]interface{}) ([]string, error) {
+	path, hasPath := expr["in"]
+	if !hasPath {
+		return nil, errors.New("Missing lookup path `in`")
+	}
+	vecPathInterface, ok := path.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expecting vector lookup path `in`, but %v given", path)
+	}
+	vecPath := make([]string, 0, len(vecPathInterface))
+	for _, v := range vecPathInterface {
+		vecPath = append(vecPath, fmt.Sprint(v))
+	}
+	return vecPath, nil
+}
+
+// Return the result number limit of a query, 0 for no limit.
+func queryLimit(expr map[string]interface{}) (int, error) {
+	limit, hasLimit := expr["limit"]
+	if !hasLimit {
+		return 0, nil
+	}
+	if floatLimit, ok := limit.(float64); ok {
+		return int(floatLimit), nil
+	} else if intLimit, ok := limit.(int); ok {
+		return intLimit, nil
+	}
+	return 0, dberr.New(dberr.ErrorExpectingInt, "limit", limit)
+}
+
+// Compile a sub-query. The sub-queries the planner does not know are evaluated up front.
+func compileCondition(q interface{}, src *Col) (cond *condition, err error) {
+	expr, isMap := q.(map[string]interface{})
+	_, isLookup := expr["eq"]
+	if !isMap || !isLookup && !isPlannedQuery(expr) || expr["and"] != nil {
+		cond = &condition{op: "ids", ids: make(map[int]struct{})}
+		err = evalQuery(q, src, &cond.ids, false)
+		return
+	}
+	cond = &condition{expr: expr}
+	if sub, negated := expr["not"]; negated {
+		cond.op = "not"
+		cond.sub, err = compileCondition(sub, src)
+		return
+	}
+	if cond.path, err = queryPath(expr); err != nil {
+		return
+	}
+	if value, exists := expr["eq"]; exists {
+		key := SortKey(value)
+		cond.op, cond.value, cond.from, cond.to = "eq", value, key, keySuccessor(key)
+	} else if values, exists := expr["$in"]; exists {
+		cond.op = "in"
+		cond.values, err = queryValues(values)
+	} else if values, exists := expr["$nin"]; exists {
+		cond.op = "nin"
+		cond.values, err = queryValues(values)
+	} else if values, exists := expr["all"]; exists {
+		cond.op = "all"
+		cond.values, err = queryValues(values)
+	} else if pattern, exists := expr["regex"]; exists {
+		cond.op = "regex"
+		cond.regex, err = regexp.Compile(fmt.Sprint(pattern))
+	} else {
+		cond.op = "range"
+		err = cond.setRange(expr)
+	}
+	return
+}
+
+// Return the list of values of an operator.
+func queryValues(values interface{}) ([]interface{}, error) {
+	if list, ok := values.([]interface{}); ok {
+		return list, nil
+	}
+	return nil, fmt.Errorf("Expecting a list of values, but %v given", values)
+}
+
+// Work out the bounds of a range condition. A bound only matches values of its own type.
+func (cond *condition) setRange(expr map[string]interface{}) error {
+	for _, op := range rangeOps {
+		val, exists := expr[op]
+		if !exists {
+			continue
+		}
+		var from, to []byte
+		switch op {
+		case "gt":
+			from, to = keySuccessor(SortKey(val)), []byte{SortKey(val)[0] + 1}
+		case "gte":
+			from, to = SortKey(val), []byte{SortKey(val)[0] + 1}
+		case "lt":
+			from, to = []byte{SortKey(val)[0]}, SortKey(val)
+		case "lte":
+			from, to = []byte{SortKey(val)[0]}, keySuccessor(SortKey(val))
+		case "prefix":
+			from = prefixSortKey(fmt.Sprint(val))
+			to = prefixEnd(from)
+		}
+		cond.narrow(from, to)
+	}
+	if cond.from == nil && cond.to == nil {
+		return errors.New(fmt.Sprintf("Query %v does not contain any operation (lookup/union/etc)", expr))
+	}
+	return nil
+}
+
+// Narrow the range of the condition down to its intersection with another range.
+func (cond *condition) narrow(from, to []byte) {
+	if cond.from == nil || from != nil && bytes.Compare(from, cond.from) > 0 {
+		cond.from = from
+	}
+	if cond.to == nil || to != nil && bytes.Compare(to, cond.to) < 0 {
+		cond.to = to
+	}
+}
+
+// Return true if the key lies in the range of the condition.
+func (cond *condition) inRange(key []byte) bool {
+	return (cond.from == nil || bytes.Compare(key, cond.from) >= 0) && (cond.to == nil || bytes.Compare(key, cond.to) < 0)
+}
+
+// Return true if two values are equal, as printed or by sort order.
+func valuesEqual(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b) || bytes.Equal(SortKey(a), SortKey(b))
+}
+
+// Return true if the document matches the condition.
+func (cond *condition) match(id int, doc map[string]interface{}) bool {
+	switch cond.op {
+	case "ids":
+		_, exists := cond.ids[id]
+		return exists
+	case "not":
+		return !cond.sub.match(id, doc)
+	}
+	vals := GetIn(doc, cond.path)
+	switch cond.op {
+	case "eq":
+		for _, v := range vals {
+			if valuesEqual(v, cond.value) {
+				return true
+			}
+		}
+	case "in", "nin":
+		for _, v := range vals {
+			for _, value := range cond.values {
+				if valuesEqual(v, value) {
+					return cond.op == "in"
+				}
+			}
+		}
+		return cond.op == "nin"
+	case "all":
+		for _, value := range cond.values {
+			found := false
+			for _, v := range vals {
+				if found = valuesEqual(v, value); found {
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case "regex":
+		for _, v := range vals {
+			if str, ok := v.(string); ok && cond.regex.MatchString(str) {
+				return true
+			}
+		}
+	case "range":
+		for _, v := range vals {
+			if cond.inRange(SortKey(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the number of documents an index finds for the condition, counting no further than the limit. Return false
+// if no index can answer the condition.
+func (cond *condition) estimate(src *Col, limit int) (count int, indexed bool) {
+	switch cond.op {
+	case "ids":
+		return len(cond.ids), true
+	case "eq", "range":
+		if sl := src.sortedIndexOf(cond.path); sl != nil {
+			sl.Lock.RLock()
+			defer sl.Lock.RUnlock()
+			return sl.Count(cond.from, cond.to, limit), true
+		}
+		if _, hashed := src.hts[0][strings.Join(cond.path, INDEX_PATH_SEP)]; cond.op == "eq" && hashed {
+			return len(src.hashScan(strings.Join(cond.path, INDEX_PATH_SEP), StrHash(fmt.Sprint(cond.value)), limit)), true
+		}
+	case "in":
+		for _, value := range cond.values {
+			valueCount, valueIndexed := cond.valueCondition(value).estimate(src, limit-count)
+			if !valueIndexed {
+				return 0, false
+			}
+			if count += valueCount; limit > 0 && count >= limit {
+				return
+			}
+		}
+		return count, true
+	}
+	return 0, false
+}
+
+// Return the equality condition of a value on the path of the condition.
+func (cond *condition) valueCondition(value interface{}) *condition {
+	key := SortKey(value)
+	return &condition{op: "eq", path: cond.path, value: value, from: key, to: keySuccessor(key),
+		expr: map[string]interface{}{"eq": value, "in": cond.expr["in"]}}
+}
+
+// Put the documents an index finds for the condition into result.
+func (cond *condition) collect(src *Col, result map[int]struct{}) (err error) {
+	switch cond.op {
+	case "ids":
+		for id := range cond.ids {
+			result[id] = struct{}{}
+		}
+	case "eq", "range":
+		if sl := src.sortedIndexOf(cond.path); sl != nil {
+			sl.Lock.RLock()
+			sl.Scan(cond.from, cond.to, false, func(_ []byte, id int) bool {
+				result[id] = struct{}{}
+				return true
+			})
+			sl.Lock.RUnlock()
+			return
+		}
+		return Lookup(cond.value, cond.expr, src, &result)
+	case "in":
+		for _, value := range cond.values {
+			if err = cond.valueCondition(value).collect(src, result); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Calculate intersection of sub-query results, collecting the candidates from the most selective index.
+func EvalAnd(subExprs interface{}, expr map[string]interface{}, src *Col, result *map[int]struct{}) (err error) {
+	subExprVecs, ok := subExprs.([]interface{})
+	if !ok {
+		return dberr.New(dberr.ErrorExpectingSubQuery, subExprs)
+	}
+	limit, err := queryLimit(expr)
+	if err != nil {
+		return
+	}
+	conds := make([]*condition, 0, len(subExprVecs))
+	ranges := make(map[string]*condition)
+	for _, subExpr := range subExprVecs {
+		cond, err := compileCondition(subExpr, src)
+		if err != nil {
+			return err
+		}
+		// Ranges on the same path are merged, so that an index scan covers just their intersection
+		if cond.op == "range" {
+			if merged, exists := ranges[strings.Join(cond.path, INDEX_PATH_SEP)]; exists {
+				merged.narrow(cond.from, cond.to)
+				continue
+			}
+			ranges[strings.Join(cond.path, INDEX_PATH_SEP)] = cond
+		}
+		conds = append(conds, cond)
+	}
+	// Pick the most selective index. The document count is only approximate, so an index is taken over the full scan
+	// even when its estimate reaches it.
+	var best *condition
+	bestCount := src.approxDocCount(false) + 1
+	for _, cond := range conds {
+		if count, indexed := cond.estimate(src, bestCount); indexed && (best == nil || count < bestCount) {
+			best, bestCount = cond, count
+		}
+	}
+	// Check the other conditions on the candidates
+	counter := 0
+	check := func(id int, doc map[string]interface{}) bool {
+		for _, cond := range conds {
+			if cond != best && !cond.match(id, doc) {
+				return true
+			}
+		}
+		(*result)[id] = struct{}{}
+		counter++
+		return limit <= 0 || counter < limit
+	}
+	if best == nil {
+		src.forEachDoc(func(id int, docB []byte) bool {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(docB, &doc); err != nil {
+				// Skip corrupted document
+				return true
+			}
+			return check(id, doc)
+		}, false)
+		return
+	}
+	candidates := make(map[int]struct{}, bestCount)
+	if err = best.collect(src, candidates); err != nil {
+		return
+	}
+	for id := range candidates {
+		if doc, err := src.read(id, false); err == nil && !check(id, doc) {
+			break
+		}
+	}
+	return
+}
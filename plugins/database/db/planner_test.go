@@ -0,0 +1,107 @@
+package db
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Return the sorted "i" of the documents the query finds.
+func queryNumbers(t *testing.T, col *Col, query interface{}) []int {
+	t.Helper()
+	result := make(map[int]struct{})
+	if err := EvalQuery(query, col, &result); err != nil {
+		t.Fatal(err)
+	}
+	numbers := make([]int, 0, len(result))
+	for id := range result {
+		doc, err := col.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, int(doc["i"].(float64)))
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func TestPlannerMatchesFullScan(t *testing.T) {
+	db := openTestDB(t, path.Join(t.TempDir(), "db"))
+	defer db.Close()
+	// The same documents with indexes on category and n, and without any index
+	for _, name := range []string{"Indexed", "Plain"} {
+		if err := db.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexed, plain := db.Use("Indexed"), db.Use("Plain")
+	if err := indexed.Index([]string{"category"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexed.SortedIndex("n"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		doc := map[string]interface{}{
+			"i":        i,
+			"category": fmt.Sprintf("c%d", i%5),
+			"tag":      fmt.Sprintf("t%d", i%3),
+		}
+		if i%11 != 0 {
+			doc["n"] = i%50 - 10
+		}
+		for _, col := range []*Col{indexed, plain} {
+			if _, err := col.Insert(doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	eq := func(field string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"eq": value, "in": []interface{}{field}}
+	}
+	cmp := func(field, op string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{op: value, "in": []interface{}{field}}
+	}
+	and := func(subs ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"and": subs}
+	}
+	queries := []map[string]interface{}{
+		// Hash index, sorted index and no index
+		and(eq("category", "c1"), cmp("n", "gte", 5), eq("tag", "t1")),
+		// Two ranges on the same path are merged
+		and(cmp("n", "gt", 0), cmp("n", "lt", 20), cmp("tag", "$in", []interface{}{"t0", "t2"})),
+		and(map[string]interface{}{"not": eq("category", "c2")}, cmp("n", "lte", 3)),
+		and(cmp("category", "$in", []interface{}{"c1", "c3"}), cmp("tag", "regex", "^t[01]$")),
+		and(cmp("category", "prefix", "c"), cmp("tag", "$nin", []interface{}{"t1"})),
+		and(eq("tag", "t2"), cmp("n", "gte", 100)),
+		and(eq("n", 7), eq("category", "c2")),
+		and(cmp("tag", "all", []interface{}{"t0"}), []interface{}{and(eq("category", "c0")), and(eq("category", "c4"))}),
+	}
+	for _, query := range queries {
+		want := queryNumbers(t, plain, query)
+		got := queryNumbers(t, indexed, query)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v found %v with indexes, %v with a full scan", query, got, want)
+		}
+	}
+
+	// A limit finds that many of the matches
+	query := and(eq("category", "c1"), cmp("n", "gte", 5))
+	all := make(map[int]bool)
+	for _, i := range queryNumbers(t, plain, query) {
+		all[i] = true
+	}
+	query["limit"] = 3
+	limited := queryNumbers(t, indexed, query)
+	if len(limited) != 3 {
+		t.Fatalf("limit 3 found %v", limited)
+	}
+	for _, i := range limited {
+		if !all[i] {
+			t.Fatalf("limit 3 found %d, which does not match", i)
+		}
+	}
+}
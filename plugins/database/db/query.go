@@ -315,7 +315,11 @@ func evalQuery(q interface{}, src *Col, result *map[int]struct{}, placeSchemaLoc
 		}
 		(*result)[int(docID)] = struct{}{}
 	case map[string]interface{}:
-		if lookupValue, lookup := expr["eq"]; lookup { // eq - lookup
+		if subExprs, and := expr["and"]; and { // and - intersection chosen by the query planner
+			return EvalAnd(subExprs, expr, src, result)
+		} else if isPlannedQuery(expr) { // not, $in, $nin, all, regex, gt, gte, lt, lte, prefix - a single condition
+			return EvalAnd([]interface{}{expr}, expr, src, result)
+		} else if lookupValue, lookup := expr["eq"]; lookup { // eq - lookup
 			return Lookup(lookupValue, expr, src, result)
 		} else if hasPath, exist := expr["has"]; exist { // has - path existence test
 			return PathExistence(hasPath, expr, src, result)
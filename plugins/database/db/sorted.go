@@ -0,0 +1,274 @@
+// Sorted (ordered) index on top of the skip list files.
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/plugins/database/data"
+)
+
+const (
+	SORTED_INDEX_PREFIX = "$sorted" // First segment of the path of a sorted index.
+	SORTED_INDEX_FILE   = "sorted"  // Name of the skip list file in the directory of a sorted index.
+)
+
+// Type tags of the sort keys, the values of different types sort by their tag.
+const (
+	sortNull byte = iota + 1
+	sortBool
+	sortNumber
+	sortTime
+	sortString
+	sortOther
+)
+
+// Return true if the index path belongs to a sorted index.
+func isSortedIndex(idxPath []string) bool {
+	return len(idxPath) > 1 && idxPath[0] == SORTED_INDEX_PREFIX
+}
+
+// Return the name of the sorted index of the field.
+func sortedIndexName(field string) string {
+	return SORTED_INDEX_PREFIX + INDEX_PATH_SEP + strings.Join(strings.Split(field, "."), INDEX_PATH_SEP)
+}
+
+// Encode a value into a key whose byte order is the order of the values. Numbers sort by value, dates (time values
+// and RFC 3339 strings) by time, and strings byte by byte. Values of different types sort by type: null, booleans,
+// numbers, dates, strings and then everything else.
+func SortKey(val interface{}) []byte {
+	switch v := val.(type) {
+	case nil:
+		return []byte{sortNull}
+	case bool:
+		if v {
+			return []byte{sortBool, 1}
+		}
+		return []byte{sortBool, 0}
+	case string:
+		if t, ok := parseSortTime(v); ok {
+			return timeSortKey(t)
+		}
+		return append([]byte{sortString}, v...)
+	case time.Time:
+		return timeSortKey(v)
+	case *time.Time:
+		if v == nil {
+			return []byte{sortNull}
+		}
+		return timeSortKey(*v)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return numberSortKey(f)
+		}
+		return append([]byte{sortString}, v...)
+	}
+	if f, ok := sortNumberValue(val); ok {
+		return numberSortKey(f)
+	}
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		encoded = []byte(fmt.Sprint(val))
+	}
+	return append([]byte{sortOther}, encoded...)
+}
+
+// Return the sort key of the strings that start with the prefix.
+func prefixSortKey(prefix string) []byte {
+	return append([]byte{sortString}, prefix...)
+}
+
+// Return the number of a numeric value.
+func sortNumberValue(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Return the time of a string that holds an RFC 3339 date.
+func parseSortTime(str string) (t time.Time, ok bool) {
+	if len(str) < 20 || str[4] != '-' || str[10] != 'T' {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	return t, err == nil
+}
+
+func numberSortKey(f float64) []byte {
+	if f == 0 {
+		f = 0 // Negative zero sorts as zero
+	}
+	bits := math.Float64bits(f)
+	if math.Signbit(f) {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	key := make([]byte, 9)
+	key[0] = sortNumber
+	binary.BigEndian.PutUint64(key[1:], bits)
+	return key
+}
+
+func timeSortKey(t time.Time) []byte {
+	key := make([]byte, 9)
+	key[0] = sortTime
+	binary.BigEndian.PutUint64(key[1:], uint64(t.UnixNano())^(1<<63))
+	return key
+}
+
+// Return the smallest key that sorts after the key and all keys it is a prefix of.
+func prefixEnd(key []byte) []byte {
+	end := append([]byte(nil), key...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Return the smallest key that sorts after the key.
+func keySuccessor(key []byte) []byte {
+	return append(append([]byte(nil), key...), 0)
+}
+
+// Return the keys under which a document is put on a sorted index. A document without the field is put under the
+// null key, so that iterating the index visits every document.
+func sortedIndexKeys(doc map[string]interface{}, idxPath []string) (keys [][]byte) {
+	seen := make(map[string]struct{})
+	for _, val := range GetIn(doc, idxPath[1:]) {
+		key := SortKey(val)
+		if _, exists := seen[string(key)]; !exists {
+			seen[string(key)] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		keys = append(keys, SortKey(nil))
+	}
+	return
+}
+
+// Open the skip list file of a sorted index.
+func (col *Col) openSortedIndex(idxName string) (sl *data.SkipList, err error) {
+	return data.OpenSkipList(path.Join(col.db.path, col.name, idxName, SORTED_INDEX_FILE))
+}
+
+// Put every document on a sorted index. Does not place a schema lock.
+func (col *Col) fillSortedIndex(idxName string) {
+	sl, idxPath := col.sorted[idxName], col.indexPaths[idxName]
+	sl.Lock.Lock()
+	defer sl.Lock.Unlock()
+	col.forEachDoc(func(id int, doc []byte) (moveOn bool) {
+		var docObj map[string]interface{}
+		if err := json.Unmarshal(doc, &docObj); err != nil {
+			// Skip corrupted document
+			return true
+		}
+		for _, key := range sortedIndexKeys(docObj, idxPath) {
+			sl.Put(key, id)
+		}
+		return true
+	}, false)
+	sl.Rebuild = false
+}
+
+// Create a sorted index on the field, for range queries, prefix matches and sorted iteration.
+func (col *Col) SortedIndex(field string) error {
+	return col.Index(append([]string{SORTED_INDEX_PREFIX}, strings.Split(field, ".")...))
+}
+
+// Return true if the field has a sorted index.
+func (col *Col) HasSortedIndex(field string) bool {
+	col.db.schemaLock.RLock()
+	defer col.db.schemaLock.RUnlock()
+	_, exists := col.sorted[sortedIndexName(field)]
+	return exists
+}
+
+// Run the function on the documents in the order of the field, from the lowest value or from the highest; stop when
+// the function returns false. A document with several values on the field (an array) is visited once for each
+// value. The key is the sort key of the value. The function must not call into the collection.
+func (col *Col) SortedScan(field string, descending bool, fun func(key []byte, id int) bool) error {
+	col.db.schemaLock.RLock()
+	defer col.db.schemaLock.RUnlock()
+	sl, exists := col.sorted[sortedIndexName(field)]
+	if !exists {
+		return fmt.Errorf("Field %s has no sorted index", field)
+	}
+	sl.Lock.RLock()
+	defer sl.Lock.RUnlock()
+	sl.Scan(nil, nil, descending, fun)
+	return nil
+}
+
+// Return the sorted index of the path, if there is one.
+func (col *Col) sortedIndexOf(vecPath []string) *data.SkipList {
+	return col.sorted[SORTED_INDEX_PREFIX+INDEX_PATH_SEP+strings.Join(vecPath, INDEX_PATH_SEP)]
+}
+
+// Return true if the index holds exactly the expected entries.
+func sortedIndexMatches(sl *data.SkipList, expected map[string]struct{}) bool {
+	if sl.Rebuild || sl.Len() != len(expected) {
+		return false
+	}
+	matches := true
+	sl.Scan(nil, nil, false, func(key []byte, id int) bool {
+		_, matches = expected[sortedEntry(key, id)]
+		return matches
+	})
+	return matches
+}
+
+// Return an index entry as a map key.
+func sortedEntry(key []byte, id int) string {
+	var buf bytes.Buffer
+	buf.Write(key)
+	binary.Write(&buf, binary.BigEndian, int64(id))
+	return buf.String()
+}
+
+// Clear the sorted index and put the entries on it.
+func rebuildSortedIndex(sl *data.SkipList, entries map[string]struct{}) error {
+	if err := sl.Clear(); err != nil {
+		return err
+	}
+	for entry := range entries {
+		key := []byte(entry[:len(entry)-8])
+		sl.Put(key, int(int64(binary.BigEndian.Uint64([]byte(entry[len(entry)-8:])))))
+	}
+	return nil
+}
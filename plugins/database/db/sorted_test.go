@@ -0,0 +1,295 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSortKeyOrder(t *testing.T) {
+	// In ascending order
+	values := []interface{}{
+		nil,
+		false,
+		true,
+		-1e9,
+		-10,
+		-2.5,
+		int64(-1),
+		0,
+		0.5,
+		uint8(1),
+		2,
+		json.Number("10"),
+		1e9,
+		"2020-01-01T00:00:00+02:00", // 2019-12-31T22:00:00Z
+		"2019-12-31T23:00:00Z",
+		time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"2024-03-01T12:00:00.5Z",
+		"",
+		"2024-03-01", // Not an RFC 3339 date
+		"a",
+		"ab",
+		"b",
+		[]interface{}{1},
+		map[string]interface{}{"a": 1},
+	}
+	for i := 1; i < len(values); i++ {
+		if bytes.Compare(SortKey(values[i-1]), SortKey(values[i])) >= 0 {
+			t.Errorf("%#v does not sort before %#v", values[i-1], values[i])
+		}
+	}
+}
+
+func TestSortKeyEqual(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	equal := [][]interface{}{
+		{2, int64(2), 2.0, float32(2), json.Number("2")},
+		{0, math.Copysign(0, -1)},
+		{at, &at, "2024-03-01T12:00:00Z", "2024-03-01T14:00:00+02:00"},
+		{nil, (*time.Time)(nil)},
+	}
+	for _, values := range equal {
+		for _, v := range values[1:] {
+			if !bytes.Equal(SortKey(values[0]), SortKey(v)) {
+				t.Errorf("%#v and %#v have different keys", values[0], v)
+			}
+		}
+	}
+}
+
+// Insert the documents and return their IDs by name.
+func insertNamed(t *testing.T, col *Col, docs []map[string]interface{}) map[string]int {
+	t.Helper()
+	ids := make(map[string]int, len(docs))
+	for _, doc := range docs {
+		id, err := col.Insert(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[doc["name"].(string)] = id
+	}
+	return ids
+}
+
+// Return the sorted names of the documents the query finds.
+func queryNames(t *testing.T, col *Col, query map[string]interface{}) []string {
+	t.Helper()
+	result := make(map[int]struct{})
+	if err := EvalQuery(query, col, &result); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(result))
+	for id := range result {
+		doc, err := col.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, doc["name"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedTestDocs() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"name": "apple", "n": -20, "at": "2023-06-01T00:00:00Z"},
+		{"name": "apricot", "n": -1.5, "at": "2024-01-01T00:00:00Z"},
+		{"name": "banana", "n": 0, "at": "2024-01-01T01:00:00+02:00"},
+		{"name": "blueberry", "n": 3, "at": "2024-02-15T08:30:00Z"},
+		{"name": "cherry", "n": 10},
+		{"name": "date", "n": "10"},
+		{"name": "elder", "n": []interface{}{-5, 30}},
+	}
+}
+
+func openSortedTestCol(t *testing.T, dir string) (*DB, *Col) {
+	t.Helper()
+	db := openTestDB(t, dir)
+	if err := db.Create("Fruits"); err != nil {
+		t.Fatal(err)
+	}
+	col := db.Use("Fruits")
+	for _, field := range []string{"name", "n", "at"} {
+		if err := col.SortedIndex(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, col
+}
+
+func TestSortedIndexRange(t *testing.T) {
+	db, col := openSortedTestCol(t, path.Join(t.TempDir(), "db"))
+	defer db.Close()
+	insertNamed(t, col, sortedTestDocs())
+
+	in := func(field string) []interface{} { return []interface{}{field} }
+	cases := []struct {
+		query map[string]interface{}
+		want  []string
+	}{
+		{map[string]interface{}{"gt": 0, "in": in("n")}, []string{"blueberry", "cherry", "elder"}},
+		{map[string]interface{}{"gte": 0, "in": in("n")}, []string{"banana", "blueberry", "cherry", "elder"}},
+		{map[string]interface{}{"lt": -1, "in": in("n")}, []string{"apple", "apricot", "elder"}},
+		{map[string]interface{}{"lte": -1.5, "in": in("n")}, []string{"apple", "apricot", "elder"}},
+		{map[string]interface{}{"gte": -2, "lt": 10, "in": in("n")}, []string{"apricot", "banana", "blueberry"}},
+		{map[string]interface{}{"gte": "10", "in": in("n")}, []string{"date"}},
+		{map[string]interface{}{"and": []interface{}{map[string]interface{}{"eq": 10, "in": in("n")}}}, []string{"cherry"}},
+		{map[string]interface{}{"prefix": "ap", "in": in("name")}, []string{"apple", "apricot"}},
+		{map[string]interface{}{"prefix": "b", "in": in("name")}, []string{"banana", "blueberry"}},
+		{map[string]interface{}{"prefix": "z", "in": in("name")}, []string{}},
+		// 2024-01-01T01:00:00+02:00 is before midnight UTC
+		{map[string]interface{}{"gte": "2024-01-01T00:00:00Z", "in": in("at")}, []string{"apricot", "blueberry"}},
+		{map[string]interface{}{"lt": "2024-01-01T00:00:00Z", "in": in("at")}, []string{"apple", "banana"}},
+	}
+	for _, c := range cases {
+		if got := queryNames(t, col, c.query); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v found %v, want %v", c.query, got, c.want)
+		}
+	}
+
+	// The limit stops the scan, every document found matches
+	limited := queryNames(t, col, map[string]interface{}{"gte": 0, "in": in("n"), "limit": 2})
+	if len(limited) != 2 {
+		t.Fatalf("limit 2 found %v", limited)
+	}
+	for _, name := range limited {
+		if name == "apple" || name == "apricot" || name == "date" {
+			t.Fatalf("limit 2 found %s out of the range", name)
+		}
+	}
+}
+
+// Return the names of a page of the documents in the order of the field, the way a sorted page is read.
+func sortedPage(t *testing.T, col *Col, field string, descending bool, skip, limit int) []string {
+	t.Helper()
+	var ids []int
+	err := col.SortedScan(field, descending, func(key []byte, id int) bool {
+		ids = append(ids, id)
+		return limit <= 0 || len(ids) < skip+limit
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skip > len(ids) {
+		skip = len(ids)
+	}
+	names := make([]string, 0, len(ids)-skip)
+	for _, id := range ids[skip:] {
+		doc, err := col.Read(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, doc["name"].(string))
+	}
+	return names
+}
+
+func TestSortedScan(t *testing.T) {
+	db, col := openSortedTestCol(t, path.Join(t.TempDir(), "db"))
+	defer db.Close()
+	insertNamed(t, col, sortedTestDocs())
+	insertNamed(t, col, []map[string]interface{}{{"name": "fig"}}) // No n, sorts as null
+
+	// elder is visited for each of its values
+	ascending := []string{"fig", "apple", "elder", "apricot", "banana", "blueberry", "cherry", "elder", "date"}
+	if got := sortedPage(t, col, "n", false, 0, 0); !reflect.DeepEqual(got, ascending) {
+		t.Fatalf("ascending %v, want %v", got, ascending)
+	}
+	descending := make([]string, len(ascending))
+	for i, name := range ascending {
+		descending[len(ascending)-1-i] = name
+	}
+	if got := sortedPage(t, col, "n", true, 0, 0); !reflect.DeepEqual(got, descending) {
+		t.Fatalf("descending %v, want %v", got, descending)
+	}
+
+	pages := []struct {
+		descending  bool
+		skip, limit int
+		want        []string
+	}{
+		{false, 0, 3, []string{"fig", "apple", "elder"}},
+		{false, 3, 3, []string{"apricot", "banana", "blueberry"}},
+		{false, 8, 3, []string{"date"}},
+		{false, 20, 3, []string{}},
+		{true, 1, 2, []string{"elder", "cherry"}},
+	}
+	for _, p := range pages {
+		if got := sortedPage(t, col, "n", p.descending, p.skip, p.limit); !reflect.DeepEqual(got, p.want) {
+			t.Errorf("page skip %d limit %d descending %v is %v, want %v", p.skip, p.limit, p.descending, got, p.want)
+		}
+	}
+
+	names := []string{"apple", "apricot", "banana", "blueberry", "cherry", "date", "elder", "fig"}
+	if got := sortedPage(t, col, "name", false, 0, 0); !reflect.DeepEqual(got, names) {
+		t.Fatalf("by name %v, want %v", got, names)
+	}
+	if err := col.SortedScan("color", false, func([]byte, int) bool { return true }); err == nil {
+		t.Fatal("scan of a field without sorted index")
+	}
+}
+
+func TestSortedIndexRebuildsBadFile(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db, col := openSortedTestCol(t, dir)
+	insertNamed(t, col, sortedTestDocs())
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(dir, "Fruits", sortedIndexName("n"), SORTED_INDEX_FILE)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 0xff
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	col = db.Use("Fruits")
+	want := []string{"blueberry", "cherry", "elder"}
+	if got := queryNames(t, col, map[string]interface{}{"gt": 0, "in": []interface{}{"n"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rebuilt index found %v, want %v", got, want)
+	}
+	if report := col.checkIntegrity(); report.Repaired() {
+		t.Fatalf("the rebuilt index needed repair %+v", report)
+	}
+}
+
+func TestIndexesStoredValues(t *testing.T) {
+	db := openTestDB(t, path.Join(t.TempDir(), "db"))
+	defer db.Close()
+	if err := db.Create("Events"); err != nil {
+		t.Fatal(err)
+	}
+	col := db.Use("Events")
+	if err := col.Index([]string{"at"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.SortedIndex("at"); err != nil {
+		t.Fatal(err)
+	}
+	// A value whose JSON form differs from its Go form is found by the JSON form
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	id := insertNamed(t, col, []map[string]interface{}{{"name": "launch", "at": at}})["launch"]
+	want := []string{"launch"}
+	if got := queryNames(t, col, map[string]interface{}{"eq": "2024-01-02T03:04:05Z", "in": []interface{}{"at"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("inserted value found %v, want %v", got, want)
+	}
+	if err := col.Update(id, map[string]interface{}{"name": "launch", "at": at.AddDate(0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryNames(t, col, map[string]interface{}{"eq": "2024-01-03T03:04:05Z", "in": []interface{}{"at"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("updated value found %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+// Full-text (inverted) index on top of the hash indexes.
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const TEXT_INDEX_PREFIX = "$text" // First segment of the path of a text index.
+
+// Return true if the index path belongs to a text index.
+func isTextIndex(idxPath []string) bool {
+	return len(idxPath) > 1 && idxPath[0] == TEXT_INDEX_PREFIX
+}
+
+// Split text into lower case words of at least two letters or digits.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	ret := make([]string, 0, len(words))
+	for _, word := range words {
+		if len([]rune(word)) > 1 {
+			ret = append(ret, word)
+		}
+	}
+	return ret
+}
+
+// Return the words of the values of a text index, with their frequency.
+func textIndexWords(doc map[string]interface{}, idxPath []string) map[string]int {
+	words := make(map[string]int)
+	for _, field := range idxPath[1:] {
+		for _, val := range GetIn(doc, strings.Split(field, ".")) {
+			if val == nil {
+				continue
+			}
+			for _, word := range Tokenize(fmt.Sprint(val)) {
+				words[word]++
+			}
+		}
+	}
+	return words
+}
+
+// Return the hash keys under which a document is put on an index.
+func indexKeys(doc map[string]interface{}, idxPath []string) (keys []int) {
+	if isTextIndex(idxPath) {
+		for word := range textIndexWords(doc, idxPath) {
+			keys = append(keys, StrHash(word))
+		}
+		return
+	}
+	if isGeoIndex(idxPath) {
+		for _, word := range geoIndexWords(doc, idxPath) {
+			keys = append(keys, StrHash(word))
+		}
+		return
+	}
+	for _, idxVal := range GetIn(doc, idxPath) {
+		if idxVal != nil {
+			keys = append(keys, StrHash(fmt.Sprint(idxVal)))
+		}
+	}
+	return
+}
+
+// Create a text index on the fields, an inverted index of the words of their values.
+func (col *Col) TextIndex(fields []string) error {
+	return col.Index(append([]string{TEXT_INDEX_PREFIX}, fields...))
+}
+
+// Return the fields of the text indexes.
+func (col *Col) TextIndexes() (ret [][]string) {
+	for _, idxPath := range col.AllIndexes() {
+		if isTextIndex(idxPath) {
+			ret = append(ret, idxPath[1:])
+		}
+	}
+	return
+}
+
+// Look up the documents that contain words of the text in the text index of the fields.
+// The score of a document is the number of times the words occur in it.
+func (col *Col) TextSearch(fields []string, text string) (scores map[int]float64, err error) {
+	col.db.schemaLock.RLock()
+	defer col.db.schemaLock.RUnlock()
+	idxPath := append([]string{TEXT_INDEX_PREFIX}, fields...)
+	idxName := strings.Join(idxPath, INDEX_PATH_SEP)
+	if _, exists := col.indexPaths[idxName]; !exists {
+		return nil, fmt.Errorf("Fields %v have no text index", fields)
+	}
+	words := Tokenize(text)
+	sort.Strings(words)
+	candidates := make(map[int]struct{})
+	for _, word := range words {
+		for _, id := range col.hashScan(idxName, StrHash(word), 0) {
+			candidates[id] = struct{}{}
+		}
+	}
+	// Count the words on the documents, which also drops hash collisions
+	scores = make(map[int]float64)
+	for id := range candidates {
+		doc, err := col.read(id, false)
+		if err != nil {
+			continue
+		}
+		docWords := textIndexWords(doc, idxPath)
+		score := 0
+		for _, word := range words {
+			score += docWords[word]
+		}
+		if score > 0 {
+			scores[id] = float64(score)
+		}
+	}
+	return scores, nil
+}
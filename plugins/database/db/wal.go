@@ -0,0 +1,338 @@
+// Write-ahead log of document writes.
+//
+// Every document insert, update and delete is appended to the log before the
+// data and index files are touched. The data and index files are memory
+// mapped and reach the disk in no particular order, so after a crash the log
+// is replayed over them. A checkpoint writes the data and index files to disk
+// and empties the log.
+//
+// A log record is the length and CRC-32 checksum of its payload followed by
+// the payload, a JSON object. Replay stops at the first incomplete or
+// corrupted record, the remainder of the log is a write that never finished.
+
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/plugins/database/tdlog"
+)
+
+const (
+	WAL_FILE   = "wal" // Write-ahead log file name.
+	WAL_HEADER = 8     // Size of record header: payload length and checksum.
+
+	WAL_INSERT = "insert" // Log record of an insert, the document is stored under the ID.
+	WAL_UPDATE = "update" // Log record of an update, the document replaces the one under the ID.
+	WAL_DELETE = "delete" // Log record of a delete.
+)
+
+// SyncPolicy decides when the write-ahead log is flushed to disk.
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // Flush the log before every write is applied, no acknowledged write is lost.
+	SyncInterval SyncPolicy = "interval" // Flush the log periodically, a crash loses the writes of the last interval.
+	SyncNever    SyncPolicy = "never"    // Leave flushing to the OS, a crash loses the writes the OS did not flush.
+)
+
+// Options are the durability settings of a database, they are not stored with the database.
+type Options struct {
+	Sync               SyncPolicy    // Sync is when the log is flushed to disk.
+	SyncInterval       time.Duration // SyncInterval is the time between flushes of the SyncInterval policy.
+	CheckpointInterval time.Duration // CheckpointInterval is the time between checkpoints, 0 disables timed checkpoints.
+	CheckpointSize     int64         // CheckpointSize is the log size (in bytes) that triggers a checkpoint, 0 disables it.
+}
+
+// DefaultOptions flush every write and checkpoint every 5 minutes or every 64MB of log.
+func DefaultOptions() Options {
+	return Options{
+		Sync:               SyncAlways,
+		SyncInterval:       time.Second,
+		CheckpointInterval: 5 * time.Minute,
+		CheckpointSize:     64 * 1048576,
+	}
+}
+
+// A single document write in the log.
+type walRecord struct {
+	Op  string          `json:"op"`
+	Col string          `json:"col"`
+	ID  int             `json:"id"`
+	Doc json.RawMessage `json:"doc,omitempty"`
+}
+
+// Write-ahead log file.
+type wal struct {
+	path  string
+	sync  SyncPolicy
+	fh    *os.File
+	size  int64 // Size of the valid records
+	dirty bool  // Records were appended since the last flush
+	lock  *sync.Mutex
+}
+
+// Open the log file and read its valid records, the corrupted tail is cut off.
+func openWAL(path string, policy SyncPolicy) (w *wal, records []walRecord, err error) {
+	w = &wal{path: path, sync: policy, lock: new(sync.Mutex)}
+	if w.fh, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return
+	}
+	if records, err = w.read(); err != nil {
+		return
+	}
+	if err = w.fh.Truncate(w.size); err != nil {
+		return
+	}
+	_, err = w.fh.Seek(w.size, io.SeekStart)
+	return
+}
+
+// Read the records from the beginning of the log up to the first incomplete or corrupted one.
+func (w *wal) read() (records []walRecord, err error) {
+	info, err := w.fh.Stat()
+	if err != nil {
+		return
+	}
+	if _, err = w.fh.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	header := make([]byte, WAL_HEADER)
+	for {
+		if _, err = io.ReadFull(w.fh, header); err != nil {
+			break
+		}
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		if w.size+WAL_HEADER+length > info.Size() {
+			// Incomplete record, or a corrupted length
+			return records, nil
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(w.fh, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return records, nil
+		}
+		var record walRecord
+		if json.Unmarshal(payload, &record) != nil {
+			return records, nil
+		}
+		records = append(records, record)
+		w.size += WAL_HEADER + length
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return
+}
+
+// Append a record, and flush the log when every write is to be flushed. Return the size of the log.
+func (w *wal) append(record walRecord) (size int64, err error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	buf := make([]byte, WAL_HEADER+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[WAL_HEADER:], payload)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err = w.fh.Write(buf); err != nil {
+		// Cut off the partial record so that the next record is readable
+		w.fh.Truncate(w.size)
+		w.fh.Seek(w.size, io.SeekStart)
+		return
+	}
+	w.size += int64(len(buf))
+	w.dirty = true
+	if w.sync == SyncAlways {
+		if err = w.fh.Sync(); err != nil {
+			return
+		}
+		w.dirty = false
+	}
+	return w.size, nil
+}
+
+// Flush the appended records to disk.
+func (w *wal) flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	if err := w.fh.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// Empty the log.
+func (w *wal) reset() (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err = w.fh.Truncate(0); err != nil {
+		return
+	} else if _, err = w.fh.Seek(0, io.SeekStart); err != nil {
+		return
+	} else if err = w.fh.Sync(); err != nil {
+		return
+	}
+	w.size, w.dirty = 0, false
+	return
+}
+
+// Close the log file.
+func (w *wal) close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.fh.Close()
+}
+
+// Open the write-ahead log, replay the writes a crash left in it and start the background flushes and checkpoints.
+func (db *DB) openWAL() (err error) {
+	var records []walRecord
+	if db.wal, records, err = openWAL(path.Join(db.path, WAL_FILE), db.options.Sync); err != nil {
+		return
+	}
+	if len(records) > 0 {
+		tdlog.Noticef("Replaying %d writes from the write-ahead log of %s", len(records), db.path)
+		db.replay(records)
+		for name, col := range db.cols {
+			if report := col.checkIntegrity(); report.Repaired() {
+				tdlog.Noticef("Repaired %s: removed %d bad ID lookups, rebuilt indexes %v", name, report.BadLookups, report.Rebuilt)
+			}
+		}
+		if err = db.checkpoint(); err != nil {
+			return
+		}
+	}
+	db.stop = make(chan struct{})
+	db.checkpointNow = make(chan struct{}, 1)
+	db.background = new(sync.WaitGroup)
+	db.background.Add(1)
+	go db.runBackground()
+	return
+}
+
+// Apply the logged writes to the data files once more. The writes are applied whether or not they reached the data
+// files before, the indexes are repaired afterwards by the integrity check. Does not place a schema lock.
+func (db *DB) replay(records []walRecord) {
+	for _, record := range records {
+		col, exists := db.cols[record.Col]
+		if !exists {
+			tdlog.Noticef("Replay: collection %s no longer exists", record.Col)
+			continue
+		}
+		part := col.parts[record.ID%db.numParts]
+		_, readErr := part.Read(record.ID)
+		var err error
+		switch record.Op {
+		case WAL_INSERT, WAL_UPDATE:
+			if readErr == nil {
+				err = part.Update(record.ID, record.Doc)
+			} else {
+				_, err = part.Insert(record.ID, record.Doc)
+			}
+		case WAL_DELETE:
+			if readErr == nil {
+				err = part.Delete(record.ID)
+			}
+		}
+		if err != nil {
+			tdlog.Noticef("Replay: failed to %s document %d in %s - %v", record.Op, record.ID, record.Col, err)
+		}
+	}
+}
+
+// Append a document write to the write-ahead log, and ask for a checkpoint when the log has grown too large.
+func (col *Col) logWrite(op string, id int, doc []byte) error {
+	db := col.db
+	if db.wal == nil {
+		return nil
+	}
+	size, err := db.wal.append(walRecord{Op: op, Col: col.name, ID: id, Doc: doc})
+	if err != nil {
+		return err
+	}
+	if db.options.CheckpointSize > 0 && size >= db.options.CheckpointSize {
+		select {
+		case db.checkpointNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush the log and run the checkpoints until the database is closed.
+func (db *DB) runBackground() {
+	defer db.background.Done()
+	var flush, checkpoint <-chan time.Time
+	if db.options.Sync == SyncInterval {
+		interval := db.options.SyncInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+	if db.options.CheckpointInterval > 0 {
+		ticker := time.NewTicker(db.options.CheckpointInterval)
+		defer ticker.Stop()
+		checkpoint = ticker.C
+	}
+	for {
+		select {
+		case <-flush:
+			if err := db.wal.flush(); err != nil {
+				tdlog.CritNoRepeat("Failed to flush %s: %v", db.wal.path, err)
+			}
+		case <-checkpoint:
+			if err := db.Checkpoint(); err != nil {
+				tdlog.CritNoRepeat("Checkpoint of %s failed: %v", db.path, err)
+			}
+		case <-db.checkpointNow:
+			if err := db.Checkpoint(); err != nil {
+				tdlog.CritNoRepeat("Checkpoint of %s failed: %v", db.path, err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Write the data and index files to disk and empty the write-ahead log.
+func (db *DB) Checkpoint() error {
+	db.schemaLock.Lock()
+	defer db.schemaLock.Unlock()
+	return db.checkpoint()
+}
+
+// checkpoint does not place a schema lock.
+func (db *DB) checkpoint() error {
+	if db.wal == nil {
+		return nil
+	}
+	// The log is flushed first, so that it can still be replayed if flushing the data files fails
+	if err := db.wal.flush(); err != nil {
+		return err
+	}
+	for _, col := range db.cols {
+		if err := col.sync(); err != nil {
+			return err
+		}
+	}
+	return db.wal.reset()
+}
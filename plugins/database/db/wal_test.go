@@ -0,0 +1,338 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// Open a database of two partitions without background flushes or checkpoints.
+func openTestDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	numPartsFile := path.Join(dir, PART_NUM_FILE)
+	if _, err := os.Stat(numPartsFile); err != nil {
+		if err := ioutil.WriteFile(numPartsFile, []byte("2"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := DefaultOptions()
+	options.CheckpointInterval = 0
+	options.CheckpointSize = 0
+	db, err := OpenDBWithOptions(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Stop the database the way a crash would: the files are closed without a checkpoint, so the write-ahead log keeps
+// its records.
+func crash(t *testing.T, db *DB) {
+	t.Helper()
+	close(db.stop)
+	db.background.Wait()
+	for _, col := range db.cols {
+		if err := col.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Append records to the write-ahead log of a closed database.
+func appendWAL(t *testing.T, dir string, records ...walRecord) {
+	t.Helper()
+	w, _, err := openWAL(path.Join(dir, WAL_FILE), SyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if _, err := w.append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Append raw bytes to the write-ahead log of a closed database.
+func appendWALBytes(t *testing.T, dir string, b []byte) {
+	t.Helper()
+	fh, err := os.OpenFile(path.Join(dir, WAL_FILE), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if _, err := fh.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(path.Join(dir, WAL_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func docJSON(t *testing.T, doc map[string]interface{}) []byte {
+	t.Helper()
+	js, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// Return the IDs of the documents whose indexed title is the value.
+func lookupTitle(t *testing.T, col *Col, title string) map[int]struct{} {
+	t.Helper()
+	result := make(map[int]struct{})
+	if err := EvalQuery(map[string]interface{}{"eq": title, "in": []interface{}{"title"}}, col, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func countDocs(col *Col) int {
+	count := 0
+	col.ForEachDoc(func(id int, doc []byte) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func assertTitle(t *testing.T, col *Col, id int, title string) {
+	t.Helper()
+	doc, err := col.Read(id)
+	if err != nil {
+		t.Fatalf("document %d: %v", id, err)
+	}
+	if doc["title"] != title {
+		t.Fatalf("document %d has title %v, want %s", id, doc["title"], title)
+	}
+	if _, found := lookupTitle(t, col, title)[id]; !found {
+		t.Fatalf("index lookup of %s misses document %d", title, id)
+	}
+}
+
+func TestWALReplayUnappliedWrites(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db := openTestDB(t, dir)
+	if err := db.Create("Posts"); err != nil {
+		t.Fatal(err)
+	}
+	col := db.Use("Posts")
+	if err := col.Index([]string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := col.Insert(map[string]interface{}{"title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := col.Insert(map[string]interface{}{"title": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("a clean close left %d bytes in the log", size)
+	}
+
+	// The writes were logged but the crash came before they reached the data files
+	third := first + 1
+	appendWAL(t, dir,
+		walRecord{Op: WAL_INSERT, Col: "Posts", ID: third, Doc: docJSON(t, map[string]interface{}{"title": "c"})},
+		walRecord{Op: WAL_UPDATE, Col: "Posts", ID: first, Doc: docJSON(t, map[string]interface{}{"title": "a2"})},
+		walRecord{Op: WAL_DELETE, Col: "Posts", ID: second},
+	)
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	col = db.Use("Posts")
+	assertTitle(t, col, first, "a2")
+	assertTitle(t, col, third, "c")
+	if _, err := col.Read(second); err == nil {
+		t.Fatal("the deleted document is still there")
+	}
+	for _, title := range []string{"a", "b"} {
+		if result := lookupTitle(t, col, title); len(result) != 0 {
+			t.Fatalf("index lookup of %s found %v", title, result)
+		}
+	}
+	if count := countDocs(col); count != 2 {
+		t.Fatalf("%d documents after replay, want 2", count)
+	}
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("the log keeps %d bytes after replay", size)
+	}
+}
+
+func TestWALReplayIsIdempotent(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db := openTestDB(t, dir)
+	if err := db.Create("Posts"); err != nil {
+		t.Fatal(err)
+	}
+	col := db.Use("Posts")
+	if err := col.Index([]string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := col.Insert(map[string]interface{}{"title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := col.Insert(map[string]interface{}{"title": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := col.Insert(map[string]interface{}{"title": "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := col.Update(first, map[string]interface{}{"title": "a2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.Delete(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The writes reached the data files, the log was never checkpointed
+	crash(t, db)
+	w, records, err := openWAL(path.Join(dir, WAL_FILE), SyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.close()
+	if len(records) != 5 {
+		t.Fatalf("%d records in the log, want 5", len(records))
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	col = db.Use("Posts")
+
+	// Replay the same records once more, they change nothing
+	db.replay(records)
+	if report := col.checkIntegrity(); report.Docs != 2 {
+		t.Fatalf("%d documents after the second replay, want 2", report.Docs)
+	}
+
+	assertTitle(t, col, first, "a2")
+	assertTitle(t, col, third, "c")
+	if _, err := col.Read(second); err == nil {
+		t.Fatal("the deleted document is back")
+	}
+	if count := countDocs(col); count != 2 {
+		t.Fatalf("%d documents after replay, want 2", count)
+	}
+	if result := lookupTitle(t, col, "a"); len(result) != 0 {
+		t.Fatalf("index lookup of the old title found %v", result)
+	}
+}
+
+func TestWALTruncatesTornRecord(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db := openTestDB(t, dir)
+	if err := db.Create("Posts"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendWAL(t, dir, walRecord{Op: WAL_INSERT, Col: "Posts", ID: 10, Doc: docJSON(t, map[string]interface{}{"title": "a"})})
+	valid := walSize(t, dir)
+
+	// The header promises more payload than the crash let through
+	torn := make([]byte, WAL_HEADER+10)
+	binary.LittleEndian.PutUint32(torn[0:4], 100)
+	appendWALBytes(t, dir, torn)
+
+	w, records, err := openWAL(path.Join(dir, WAL_FILE), SyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != 10 {
+		t.Fatalf("read %v, want the insert of document 10", records)
+	}
+	if size := walSize(t, dir); size != valid {
+		t.Fatalf("the log is %d bytes, want the %d bytes of the valid record", size, valid)
+	}
+
+	// A record appended after the cut is readable
+	if _, err := w.append(walRecord{Op: WAL_DELETE, Col: "Posts", ID: 10}); err != nil {
+		t.Fatal(err)
+	}
+	w.close()
+	w, records, err = openWAL(path.Join(dir, WAL_FILE), SyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.close()
+	if len(records) != 2 || records[1].Op != WAL_DELETE {
+		t.Fatalf("read %v, want the insert and the delete", records)
+	}
+}
+
+func TestWALTruncatesChecksumMismatch(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	db := openTestDB(t, dir)
+	if err := db.Create("Posts"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendWAL(t, dir, walRecord{Op: WAL_INSERT, Col: "Posts", ID: 10, Doc: docJSON(t, map[string]interface{}{"title": "a"})})
+	valid := walSize(t, dir)
+
+	// A complete record whose payload does not match its checksum
+	payload := docJSON(t, map[string]interface{}{"op": WAL_INSERT, "col": "Posts", "id": 11, "doc": map[string]interface{}{"title": "b"}})
+	corrupted := make([]byte, WAL_HEADER+len(payload))
+	binary.LittleEndian.PutUint32(corrupted[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(corrupted[4:8], crc32.ChecksumIEEE(payload)+1)
+	copy(corrupted[WAL_HEADER:], payload)
+	appendWALBytes(t, dir, corrupted)
+
+	w, records, err := openWAL(path.Join(dir, WAL_FILE), SyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.close()
+	if len(records) != 1 || records[0].ID != 10 {
+		t.Fatalf("read %v, want the insert of document 10", records)
+	}
+	if size := walSize(t, dir); size != valid {
+		t.Fatalf("the log is %d bytes, want the %d bytes of the valid record", size, valid)
+	}
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	col := db.Use("Posts")
+	if doc, err := col.Read(10); err != nil || doc["title"] != "a" {
+		t.Fatalf("document 10 is %v, %v after replay", doc, err)
+	}
+	if _, err := col.Read(11); err == nil {
+		t.Fatal("the corrupted record was replayed")
+	}
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("the log keeps %d bytes after replay", size)
+	}
+}
@@ -57,3 +57,7 @@ func unmap(addr, len uintptr) error {
 
 	return os.NewSyscallError("CloseHandle", syscall.CloseHandle(syscall.Handle(handle)))
 }
+
+func flush(addr, len uintptr) error {
+	return os.NewSyscallError("FlushViewOfFile", syscall.FlushViewOfFile(addr, len))
+}
@@ -0,0 +1,170 @@
+package yekonga
+
+import (
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+)
+
+// DataModelAudit is the audit option of a model, either "audit": true or
+// "audit": {"exclude": ["field"]} to leave fields out of the entries on top
+// of the protected ones.
+type DataModelAudit struct {
+	Enabled bool
+	Exclude []string
+}
+
+func getDataModelAudit(value interface{}) DataModelAudit {
+	var audit DataModelAudit
+
+	switch v := value.(type) {
+	case bool:
+		audit.Enabled = v
+	case map[string]interface{}:
+		audit.Enabled = true
+
+		if enabled, ok := v["enabled"].(bool); ok {
+			audit.Enabled = enabled
+		}
+
+		if exclude, ok := v["exclude"]; ok && helper.IsArray(exclude) {
+			for _, field := range helper.ToList[interface{}](exclude) {
+				audit.Exclude = append(audit.Exclude, helper.ToString(field))
+			}
+		}
+	}
+
+	return audit
+}
+
+// auditValues returns the values of a record without the protected fields and
+// the fields the model excludes from its audit.
+func auditValues(model *DataModel, values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if helper.Contains(model.Protected, k) || helper.Contains(model.Audit.Exclude, k) {
+			continue
+		}
+
+		result[k] = v
+	}
+
+	return result
+}
+
+// auditDiff lists the fields whose value differs between the two records.
+// The values are compared as JSON, so an id and its string are equal.
+func auditDiff(oldValues map[string]interface{}, newValues map[string]interface{}) map[string]AuditTrailDiff {
+	diff := make(map[string]AuditTrailDiff)
+
+	for k, v := range newValues {
+		if old, ok := oldValues[k]; !ok || helper.ToJson(old) != helper.ToJson(v) {
+			diff[k] = AuditTrailDiff{Old: old, New: v}
+		}
+	}
+
+	for k, v := range oldValues {
+		if _, ok := newValues[k]; !ok {
+			diff[k] = AuditTrailDiff{Old: v, New: nil}
+		}
+	}
+
+	return diff
+}
+
+// audit records the writes of an audited model in the transaction of the
+// write, so the entries are rolled back with it. The records before and after
+// the write are paired by their id, a create has no record before it and a
+// delete none after it.
+func (m *DataModelQuery) audit(action string, before []datatype.DataMap, after []datatype.DataMap) {
+	if !m.Model.Audit.Enabled || m.Model.Name == "AuditTrail" || (len(before) == 0 && len(after) == 0) {
+		return
+	}
+
+	trail := NewDataAuditTrail(m.RequestContext)
+	trail.tx = m.tx
+
+	previous := make(map[string]datatype.DataMap, len(before))
+	for _, record := range before {
+		previous[helper.ToJson(auditDocumentId(record))] = record
+	}
+
+	for _, record := range after {
+		id := auditDocumentId(record)
+		key := helper.ToJson(id)
+		trail.Append(m.Model, action, id, previous[key], record)
+		delete(previous, key)
+	}
+
+	for _, record := range before {
+		id := auditDocumentId(record)
+		if _, ok := previous[helper.ToJson(id)]; ok {
+			trail.Append(m.Model, action, id, record, nil)
+		}
+	}
+}
+
+// History lists the audit entries of a record of the model, newest first.
+// The caller must be able to read the record itself: it is loaded through
+// the model with the request context first, so the find triggers of the
+// model run, and nothing is listed when that fails. The find triggers of the
+// AuditTrail model then decide which entries the caller can read.
+func (y *YekongaData) History(model string, id interface{}, limit int, context *RequestContext) []datatype.DataMap {
+	dataModel := y.Model(model)
+	query := y.ModelQuery("AuditTrail")
+	if dataModel == nil || query == nil || helper.IsEmpty(id) {
+		return []datatype.DataMap{}
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	record := dataModel.Query()
+	if context != nil {
+		record.SetRequestContext(context)
+		query.SetRequestContext(context)
+	}
+
+	if helper.IsEmpty(record.WithTrashed().FindOne(datatype.DataMap{"id": id})) {
+		return []datatype.DataMap{}
+	}
+
+	result := query.
+		Where("model", dataModel.Name).
+		Where("documentId", id).
+		OrderBy("createdAt", "desc").
+		Take(limit).
+		Find(nil)
+
+	if result == nil {
+		return []datatype.DataMap{}
+	}
+
+	return *result
+}
+
+// auditBefore picks the record an update changed from the records its filter
+// matched before the write.
+func auditBefore(before []datatype.DataMap, record datatype.DataMap) []datatype.DataMap {
+	key := helper.ToJson(auditDocumentId(record))
+	for _, v := range before {
+		if helper.ToJson(auditDocumentId(v)) == key {
+			return []datatype.DataMap{v}
+		}
+	}
+
+	return nil
+}
+
+// auditDocumentId is the id of a record, _id on the databases that have it.
+func auditDocumentId(record datatype.DataMap) interface{} {
+	if id, ok := record["_id"]; ok && helper.IsNotEmpty(id) {
+		return id
+	}
+
+	return record["id"]
+}
@@ -0,0 +1,586 @@
+package yekonga
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/config"
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// BackupVersion is the version of the archive format.
+const BackupVersion = 1
+
+const backupManifestFile = "manifest.json"
+
+// backupBatchSize is the number of records read or written at once.
+const backupBatchSize = 500
+
+// BackupOptions limits a backup or a restore. TenantId keeps the records of
+// one tenant on the models that have a tenant and leaves the other models
+// out. Models keeps the given models, by name, class or collection.
+type BackupOptions struct {
+	TenantId string
+	Models   []string
+}
+
+// BackupManifest describes a backup archive. Schema is the database
+// structure the backup was taken with.
+type BackupManifest struct {
+	Version   int                    `json:"version"`
+	AppName   string                 `json:"appName"`
+	Database  config.DatabaseType    `json:"database"`
+	TenantId  string                 `json:"tenantId,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Models    []BackupModel          `json:"models"`
+	Schema    *DatabaseStructureType `json:"schema,omitempty"`
+}
+
+// BackupModel is the NDJSON file of a model in a backup archive, with the
+// number of records and the SHA-256 checksum of the file.
+type BackupModel struct {
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	File       string `json:"file"`
+	Count      int64  `json:"count"`
+	Checksum   string `json:"checksum"`
+}
+
+// Backup writes the records of every model into a zip archive, one NDJSON
+// file per model and a manifest. The records are read through the query
+// layer, so an archive restores into any database. Soft deleted records are
+// part of the backup and no triggers run.
+func (y *YekongaData) Backup(file string, options BackupOptions) (*BackupManifest, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+
+	temporary := file + ".tmp"
+	out, err := os.Create(temporary)
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(err error) (*BackupManifest, error) {
+		out.Close()
+		os.Remove(temporary)
+		return nil, err
+	}
+
+	archive := zip.NewWriter(out)
+	manifest := &BackupManifest{
+		Version:   BackupVersion,
+		AppName:   y.Config.AppName,
+		Database:  y.Config.Database.Kind,
+		TenantId:  options.TenantId,
+		CreatedAt: time.Now().UTC(),
+		Models:    make([]BackupModel, 0, len(y.models)),
+		Schema:    y.databaseStructure,
+	}
+
+	for _, model := range y.backupModels(options) {
+		entry, err := backupModel(archive, model, options.TenantId)
+		if err != nil {
+			return fail(fmt.Errorf("backup of %s failed: %w", model.Name, err))
+		}
+
+		manifest.Models = append(manifest.Models, entry)
+	}
+
+	w, err := archive.Create(backupManifestFile)
+	if err != nil {
+		return fail(err)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fail(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fail(err)
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(temporary)
+		return nil, err
+	}
+
+	if err := os.Rename(temporary, file); err != nil {
+		os.Remove(temporary)
+		return nil, err
+	}
+
+	logger.Info("Backup created", file)
+
+	return manifest, nil
+}
+
+// Restore replaces the records of the models in the archive with the ones
+// of the archive. With a tenant only the records of the tenant are replaced.
+// The checksums of the archive are verified before anything is written.
+// Models the database structure no longer has are skipped.
+func (y *YekongaData) Restore(file string, options BackupOptions) (*BackupManifest, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	manifest, err := readBackupManifest(files[backupManifestFile])
+	if err != nil {
+		return nil, err
+	}
+
+	if helper.IsNotEmpty(manifest.TenantId) && helper.IsNotEmpty(options.TenantId) && manifest.TenantId != options.TenantId {
+		return nil, fmt.Errorf("the backup holds tenant %s, not %s", manifest.TenantId, options.TenantId)
+	}
+
+	for _, entry := range manifest.Models {
+		if err := verifyBackupModel(files[entry.File], entry); err != nil {
+			return nil, err
+		}
+	}
+
+	selected := map[*DataModel]bool{}
+	for _, model := range y.backupModels(options) {
+		selected[model] = true
+	}
+
+	for _, entry := range manifest.Models {
+		model := y.findDataModel(entry.Name)
+		if model == nil {
+			logger.Warn("Restore skipped "+entry.Name+", the model does not exist", entry.File)
+			continue
+		}
+
+		if !selected[model] {
+			continue
+		}
+
+		count, err := restoreModel(model, files[entry.File], options.TenantId)
+		if err != nil {
+			return nil, fmt.Errorf("restore of %s failed: %w", model.Name, err)
+		}
+
+		logger.Info("Restored "+model.Name, count)
+	}
+
+	return manifest, nil
+}
+
+// backupModels returns the models a backup or restore covers, by name.
+func (y *YekongaData) backupModels(options BackupOptions) []*DataModel {
+	names := make([]string, 0, len(y.models))
+	for name, model := range y.models {
+		if helper.IsNotEmpty(options.TenantId) && !model.HasTenant {
+			continue
+		}
+
+		if len(options.Models) > 0 &&
+			!helper.Contains(options.Models, model.Name) &&
+			!helper.Contains(options.Models, model.Class) &&
+			!helper.Contains(options.Models, model.Collection) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	models := make([]*DataModel, 0, len(names))
+	for _, name := range names {
+		models = append(models, y.models[name])
+	}
+
+	return models
+}
+
+// backupQuery is a query on every record of the model, trashed ones
+// included, or on the records of the tenant.
+func backupQuery(model *DataModel, tenantId string) *DataModelQuery {
+	query := model.Query().SkipTenant().SkipBeforeCommit().WithTrashed()
+	if helper.IsNotEmpty(tenantId) {
+		query.Where(TenantIDKey, helper.ObjectID(tenantId))
+	}
+
+	return query
+}
+
+// backupModel writes the records of the model into the archive in pages
+// ordered by _id.
+func backupModel(archive *zip.Writer, model *DataModel, tenantId string) (BackupModel, error) {
+	entry := BackupModel{
+		Name:       model.Name,
+		Collection: model.Collection,
+		File:       "data/" + model.Collection + ".ndjson",
+	}
+
+	w, err := archive.Create(entry.File)
+	if err != nil {
+		return entry, err
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	order := []queryOrder{{Field: "_id"}}
+
+	var after []interface{}
+	for {
+		rows := backupQuery(model, tenantId).collection().seek(order, after, backupBatchSize)
+		if rows == nil || len(*rows) == 0 {
+			break
+		}
+
+		for _, row := range *rows {
+			line, err := json.Marshal(backupRecord(row))
+			if err != nil {
+				return entry, err
+			}
+
+			if _, err := out.Write(append(line, '\n')); err != nil {
+				return entry, err
+			}
+
+			entry.Count++
+		}
+
+		if len(*rows) < backupBatchSize {
+			break
+		}
+
+		after = []interface{}{(*rows)[len(*rows)-1]["_id"]}
+	}
+
+	entry.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	return entry, nil
+}
+
+// backupRecord is a record as it is written to the archive, without the
+// fields the backends add to the records they return.
+func backupRecord(row datatype.DataMap) datatype.DataMap {
+	record, _ := revisionValue(row).(datatype.DataMap)
+
+	delete(record, "_collection")
+	delete(record, "_model")
+	delete(record, SearchScoreKey)
+	delete(record, DistanceKey)
+
+	if _, ok := record["_id"]; ok {
+		delete(record, "id")
+	}
+
+	return record
+}
+
+func readBackupManifest(f *zip.File) (*BackupManifest, error) {
+	if f == nil {
+		return nil, errors.New("the backup has no " + backupManifestFile)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", backupManifestFile, err)
+	}
+
+	if manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("the backup has version %d, this server reads up to version %d", manifest.Version, BackupVersion)
+	}
+
+	return &manifest, nil
+}
+
+// verifyBackupModel checks the checksum and the number of records of a file
+// of the archive.
+func verifyBackupModel(f *zip.File, entry BackupModel) error {
+	if f == nil {
+		return errors.New("the backup has no " + entry.File)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	var count int64
+
+	reader := bufio.NewReader(io.TeeReader(r, hash))
+	for {
+		line, err := reader.ReadSlice('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			count++
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != entry.Checksum {
+		return errors.New("checksum mismatch in " + entry.File)
+	}
+
+	if count != entry.Count {
+		return fmt.Errorf("%s has %d records, the manifest lists %d", entry.File, count, entry.Count)
+	}
+
+	return nil
+}
+
+// restoreModel replaces the records of the model, or of the tenant, with the
+// records of the file. The whole file is read once before anything is
+// deleted, so a record that can't be parsed leaves the model as it was. The
+// delete and the inserts then run in one transaction, written in batches
+// without triggers.
+func restoreModel(model *DataModel, f *zip.File, tenantId string) (int, error) {
+	if err := readBackupModel(model, f, tenantId, func(record datatype.DataMap) error {
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	err := model.App.Transaction(context.Background(), func(tx *Tx) error {
+		count = 0
+
+		query := backupQuery(model, tenantId)
+		query.tx = tx
+		if helper.IsEmpty(tenantId) {
+			query.Where("_id", datatype.DataMap{"exists": true})
+		}
+
+		if query.collection().count() > 0 {
+			if _, err := query.collection().delete(); err != nil {
+				return err
+			}
+		}
+
+		insert := model.Query().SkipTenant().SkipBeforeCommit()
+		insert.tx = tx
+		batch := make([]datatype.DataMap, 0, backupBatchSize)
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+
+			written, err := insert.collection().createMany(batch)
+			if err != nil {
+				return err
+			}
+
+			if err := checkRestoredFields(model, batch, written); err != nil {
+				return err
+			}
+
+			count += len(batch)
+			batch = batch[:0]
+
+			return nil
+		}
+
+		if err := readBackupModel(model, f, tenantId, func(record datatype.DataMap) error {
+			input := *insert.formatInputData(record, ImportInputAction)
+			if model.Versioned {
+				if v, ok := record[VersionKey].(int); ok {
+					input[VersionKey] = v
+				}
+			}
+
+			batch = append(batch, input)
+			if len(batch) >= backupBatchSize {
+				return flush()
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		return flush()
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// checkRestoredFields compares the records read back after an insert with
+// those written, field by field. A backend that drops a field, or a record,
+// fails the restore even though the checksums and counts of the file passed.
+func checkRestoredFields(model *DataModel, batch []datatype.DataMap, written *[]datatype.DataMap) error {
+	read := []datatype.DataMap{}
+	if written != nil {
+		read = *written
+	}
+
+	if len(read) != len(batch) {
+		return fmt.Errorf("restore of %s wrote %d records, read back %d", model.Name, len(batch), len(read))
+	}
+
+	want := backupFieldCounts(model, batch)
+	got := backupFieldCounts(model, read)
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if got[k] < want[k] {
+			return fmt.Errorf("restore of %s wrote %s on %d records, read it back on %d", model.Name, k, want[k], got[k])
+		}
+	}
+
+	return nil
+}
+
+// backupFieldCounts counts the records holding a value for each model field.
+func backupFieldCounts(model *DataModel, records []datatype.DataMap) map[string]int {
+	counts := map[string]int{}
+	for _, record := range records {
+		for k, v := range record {
+			if _, ok := model.Fields[k]; ok && v != nil {
+				counts[k]++
+			}
+		}
+	}
+
+	return counts
+}
+
+// readBackupModel passes the records of a file of the archive to fn, only
+// those of the tenant when one is given. A record keeps its id and its
+// integer fields are ints again.
+func readBackupModel(model *DataModel, f *zip.File, tenantId string, fn func(record datatype.DataMap) error) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		var record datatype.DataMap
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s line %d: %w", f.Name, line, err)
+		}
+
+		if helper.IsNotEmpty(tenantId) && helper.ToString(record[TenantIDKey]) != tenantId {
+			continue
+		}
+
+		// The record keeps its id, Create reads it from id.
+		if id, ok := record["_id"]; ok {
+			record["id"] = id
+			delete(record, "_id")
+		}
+
+		// JSON numbers are floats, the integer fields take them as ints.
+		for _, k := range model.NumberFields {
+			if v, ok := record[k].(float64); ok {
+				record[k] = int(v)
+			}
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// setBackup registers the backup cronjob when scheduled backups are enabled.
+func (y *YekongaData) setBackup() {
+	if !y.Config.Backup.Enabled {
+		return
+	}
+
+	interval := y.Config.Backup.Interval
+	if interval <= 0 {
+		interval = 24 // default every day
+	}
+
+	y.RegisterCronjob("DatabaseBackup", time.Duration(interval)*time.Hour, func(app *YekongaData, t time.Time) {
+		app.ScheduledBackup(t)
+	})
+}
+
+// ScheduledBackup writes a backup into the backup directory and removes the
+// oldest archives beyond the retention. It is called by the backup cronjob.
+func (y *YekongaData) ScheduledBackup(t time.Time) {
+	if _, err := y.Backup(y.BackupFile(t), BackupOptions{}); err != nil {
+		logger.Error("DatabaseBackup", err)
+		return
+	}
+
+	retention := y.Config.Backup.Retention
+	if retention <= 0 {
+		retention = 7 // default a week of daily backups
+	}
+
+	files, err := filepath.Glob(filepath.Join(y.backupPath(), "backup-*.zip"))
+	if err != nil || len(files) <= retention {
+		return
+	}
+	sort.Strings(files)
+
+	for _, file := range files[:len(files)-retention] {
+		if err := os.Remove(file); err != nil {
+			logger.Error("DatabaseBackup", err)
+		}
+	}
+}
+
+// BackupFile is the archive of a backup taken at the time, in the backup
+// directory.
+func (y *YekongaData) BackupFile(t time.Time) string {
+	return filepath.Join(y.backupPath(), "backup-"+t.UTC().Format("20060102-150405")+".zip")
+}
+
+func (y *YekongaData) backupPath() string {
+	if helper.IsNotEmpty(y.Config.Backup.Path) {
+		return y.Config.Backup.Path
+	}
+
+	return filepath.Join(y.HomeDirectory(), "backups")
+}
@@ -0,0 +1,35 @@
+package yekonga
+
+import (
+	"testing"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+)
+
+func TestCheckRestoredFieldsFindsDroppedFields(t *testing.T) {
+	model := testSQLModel()
+	model.Name = "Product"
+	batch := []datatype.DataMap{
+		{"_id": "a", "name": "Pen"},
+		{"_id": "b", "name": "Ink", "price": 4},
+	}
+
+	read := []datatype.DataMap{
+		{"_id": "a", "name": "Pen", "price": nil, "tags": nil},
+		{"_id": "b", "name": "Ink", "price": 4, "tags": nil},
+	}
+	if err := checkRestoredFields(model, batch, &read); err != nil {
+		t.Fatal(err)
+	}
+
+	// price of the second record was not written
+	read[1]["price"] = nil
+	if err := checkRestoredFields(model, batch, &read); err == nil {
+		t.Fatal("expected the dropped price to fail the restore")
+	}
+
+	read = read[:1]
+	if err := checkRestoredFields(model, batch, &read); err == nil {
+		t.Fatal("expected the missing record to fail the restore")
+	}
+}
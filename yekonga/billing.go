@@ -0,0 +1,944 @@
+package yekonga
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+)
+
+// Subscription states.
+const (
+	SubscriptionTrial    = "trial"
+	SubscriptionActive   = "active"
+	SubscriptionGrace    = "grace"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionExpired  = "expired"
+	SubscriptionCanceled = "canceled"
+)
+
+// Pricing types of a pricing plan.
+const (
+	PricingFlat    = "flat"
+	PricingPerUser = "per_user"
+	PricingPerUnit = "per_unit"
+	PricingMetered = "metered"
+)
+
+// billingStatusCacheTime is how long BillingMiddleware reuses the status of
+// a tenant module before reading the subscriptions again.
+const billingStatusCacheTime = time.Minute
+
+var (
+	ErrBillingDisabled      = errors.New("Tenant billing is not enabled")
+	ErrSubscriptionNotFound = errors.New("Subscription not found")
+	ErrPaymentNotFound      = errors.New("Payment not found")
+)
+
+// SubscriptionItemInput is a module plan added to a new subscription.
+type SubscriptionItemInput struct {
+	PricingPlanId string
+	Quantity      float64
+}
+
+// Billing moves tenant subscriptions through their states, issues invoices
+// for every billing cycle and allocates payments and credits to them.
+//
+// Invoices are issued at the start of a cycle. Flat, per_user and per_unit
+// items charge the cycle ahead, metered items charge the usage recorded in
+// the cycle that just ended. An unpaid invoice moves the subscription to
+// grace on its due date, to past_due once the grace days of the plan have
+// passed and to expired when the period it paid for is over.
+//
+// The methods that bill run one at a time, so the cronjob and a payment
+// webhook can't allocate a payment or issue an invoice twice. The unique
+// indexes on invoices and payment allocations catch the same race between
+// servers.
+type Billing struct {
+	app    *YekongaData
+	status map[string]billingStatus
+	mut    sync.Mutex
+	run    sync.Mutex
+}
+
+type billingStatus struct {
+	status    string
+	checkedAt time.Time
+}
+
+func NewBilling(app *YekongaData) *Billing {
+	return &Billing{
+		app:    app,
+		status: make(map[string]billingStatus),
+	}
+}
+
+// Billing returns the billing engine of the server.
+func (y *YekongaData) Billing() *Billing {
+	return y.billing
+}
+
+func (y *YekongaData) setBilling() {
+	y.billing = NewBilling(y)
+
+	if !y.billing.Enabled() {
+		return
+	}
+
+	y.RegisterCronjob("TenantBilling", time.Hour, func(app *YekongaData, t time.Time) {
+		app.Billing().Run(t)
+	})
+}
+
+func (b *Billing) Enabled() bool {
+	return b.app.Config.HasTenant && b.app.Config.HasTenantBilling
+}
+
+func (b *Billing) query(name string) *DataModelQuery {
+	return b.app.ModelQuery(name).SkipTenant().SkipBeforeCommit()
+}
+
+// txQuery is query inside the transaction, nil runs it outside of one.
+func (b *Billing) txQuery(tx *Tx, name string) *DataModelQuery {
+	query := b.query(name)
+	query.tx = tx
+
+	return query
+}
+
+// transaction runs fn in a transaction of the server, the writes of fn go
+// through txQuery so they are stored together or not at all.
+func (b *Billing) transaction(fn func(tx *Tx) error) error {
+	return b.app.Transaction(context.Background(), fn)
+}
+
+// billingCreated returns the record a Create stored or why it didn't.
+func billingCreated(result interface{}, rejected string) (*datatype.DataMap, error) {
+	if record, ok := result.(*datatype.DataMap); ok {
+		return record, nil
+	}
+
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+
+	return nil, errors.New(rejected)
+}
+
+// Subscribe starts a subscription for the tenant with the given plans. The
+// subscription starts in trial when any of the plans has trial days. The
+// subscription and its items are stored in one transaction.
+func (b *Billing) Subscribe(tenantId interface{}, items []SubscriptionItemInput) (*datatype.DataMap, error) {
+	if !b.Enabled() {
+		return nil, ErrBillingDisabled
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("A subscription needs at least one pricing plan")
+	}
+
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	plans := make([]datatype.DataMap, 0, len(items))
+	trialDays := 0
+	billingCycle := ""
+	currency := ""
+
+	for i, item := range items {
+		plan := b.query("PricingPlan").FindOne(datatype.DataMap{"id": item.PricingPlanId})
+		if helper.IsEmpty(plan) {
+			return nil, errors.New("Pricing plan not found: " + item.PricingPlanId)
+		}
+
+		cycle := helper.GetValueOfString(plan, "billingCycle")
+		if helper.IsNotEmpty(billingCycle) && cycle != billingCycle {
+			return nil, errors.New("All pricing plans of a subscription must share the billing cycle")
+		}
+
+		if i > 0 && helper.GetValueOfString(plan, "currency") != currency {
+			return nil, errors.New("All pricing plans of a subscription must share the currency")
+		}
+
+		billingCycle = cycle
+		currency = helper.GetValueOfString(plan, "currency")
+		trialDays = max(trialDays, helper.GetValueOfInt(plan, "trialDays"))
+		plans = append(plans, *plan)
+	}
+
+	now := helper.GetTimestamp(nil)
+	body := datatype.DataMap{
+		"tenantId":     tenantId,
+		"billingCycle": billingCycle,
+		"createdAt":    now,
+		"updatedAt":    now,
+	}
+
+	if trialDays > 0 {
+		trialEnd := now.AddDate(0, 0, trialDays)
+
+		body["status"] = SubscriptionTrial
+		body["trialStart"] = now
+		body["trialEnd"] = trialEnd
+		body["currentPeriodStart"] = now
+		body["currentPeriodEnd"] = trialEnd
+		body["nextBillingDate"] = trialEnd
+	} else {
+		body["status"] = SubscriptionActive
+		body["currentPeriodStart"] = now
+		body["currentPeriodEnd"] = now
+		body["nextBillingDate"] = now
+	}
+
+	var subscription *datatype.DataMap
+
+	err := b.transaction(func(tx *Tx) error {
+		var err error
+
+		subscription, err = billingCreated(b.txQuery(tx, "Subscription").Create(body), "Subscription rejected")
+		if err != nil {
+			return err
+		}
+
+		for i, item := range items {
+			_, err := billingCreated(b.txQuery(tx, "SubscriptionItem").Create(datatype.DataMap{
+				"tenantId":       tenantId,
+				"subscriptionId": helper.GetValueOf(subscription, "_id"),
+				"moduleId":       helper.GetValueOf(plans[i], "moduleId"),
+				"pricingPlanId":  item.PricingPlanId,
+				"quantity":       item.Quantity,
+			}), "Subscription item rejected")
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionId := helper.GetValueOf(subscription, "_id")
+
+	b.forget(tenantId)
+
+	if trialDays == 0 {
+		b.process(subscription, now)
+	}
+
+	return b.query("Subscription").FindOne(datatype.DataMap{"id": subscriptionId}), nil
+}
+
+// Cancel stops the subscription from renewing. It stays usable until the
+// end of the current period.
+func (b *Billing) Cancel(subscriptionId interface{}) error {
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	subscription := b.query("Subscription").FindOne(datatype.DataMap{"id": subscriptionId})
+	if helper.IsEmpty(subscription) {
+		return ErrSubscriptionNotFound
+	}
+
+	b.update("Subscription", subscription, datatype.DataMap{
+		"canceledAt": helper.GetTimestamp(nil),
+	})
+	b.forget(helper.GetValueOf(subscription, "tenantId"))
+
+	return nil
+}
+
+// RecordUsage adds a usage record for metered pricing plans of the module.
+func (b *Billing) RecordUsage(tenantId interface{}, moduleId interface{}, quantity float64, at time.Time) interface{} {
+	return b.query("UsageRecord").Create(datatype.DataMap{
+		"tenantId":    tenantId,
+		"moduleId":    moduleId,
+		"quantity":    quantity,
+		"periodStart": at,
+		"periodEnd":   at,
+	})
+}
+
+// Run processes every subscription and allocates the payments that have
+// not been allocated yet. It is called by the billing cronjob.
+func (b *Billing) Run(now time.Time) {
+	if !b.Enabled() {
+		return
+	}
+
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	payments := b.query("Payment").Find(datatype.DataMap{
+		"status":      "succeeded",
+		"allocatedAt": nil,
+	})
+	for _, payment := range *payments {
+		if helper.IsEmpty(payment["allocatedAt"]) {
+			b.allocatePayment(payment, now)
+		}
+	}
+
+	subscriptions := b.query("Subscription").Find(nil)
+	for _, subscription := range *subscriptions {
+		b.process(&subscription, now)
+	}
+}
+
+// Process issues the invoices that are due for the subscription, applies
+// the available credit and updates its status.
+func (b *Billing) Process(subscription *datatype.DataMap, now time.Time) {
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	b.process(subscription, now)
+}
+
+func (b *Billing) process(subscription *datatype.DataMap, now time.Time) {
+	status := helper.GetValueOfString(subscription, "status")
+	tenantId := helper.GetValueOf(subscription, "tenantId")
+
+	if status == SubscriptionCanceled {
+		return
+	}
+
+	changes := datatype.DataMap{}
+
+	if status == SubscriptionTrial {
+		if now.Before(helper.GetValueOfDate(subscription, "trialEnd")) {
+			return
+		}
+
+		status = SubscriptionActive
+		changes["status"] = status
+	}
+
+	// An expired subscription is billed again once its debt is settled.
+	if status == SubscriptionExpired {
+		if len(b.unpaidInvoices(subscription)) > 0 {
+			return
+		}
+
+		(*subscription)["currentPeriodStart"] = now
+		(*subscription)["currentPeriodEnd"] = now
+		(*subscription)["nextBillingDate"] = now
+		changes["currentPeriodStart"] = now
+		changes["currentPeriodEnd"] = now
+		changes["nextBillingDate"] = now
+	}
+
+	canceledAt := helper.GetValueOfDate(subscription, "canceledAt")
+	isCanceled := helper.IsNotEmpty((*subscription)["canceledAt"])
+
+	for {
+		billingDate := helper.GetValueOfDate(subscription, "nextBillingDate")
+		if billingDate.IsZero() || billingDate.After(now) {
+			break
+		}
+
+		if isCanceled && !billingDate.Before(canceledAt) {
+			status = SubscriptionCanceled
+			break
+		}
+
+		periodStart := helper.GetValueOfDate(subscription, "currentPeriodStart")
+		periodEnd := billingPeriodEnd(billingDate, helper.GetValueOfString(subscription, "billingCycle"))
+
+		if _, err := b.issueInvoice(subscription, periodStart, billingDate, periodEnd, now); err != nil {
+			console.Error("billing", err.Error())
+			break
+		}
+
+		(*subscription)["currentPeriodStart"] = billingDate
+		(*subscription)["currentPeriodEnd"] = periodEnd
+		(*subscription)["nextBillingDate"] = periodEnd
+		changes["currentPeriodStart"] = billingDate
+		changes["currentPeriodEnd"] = periodEnd
+		changes["nextBillingDate"] = periodEnd
+	}
+
+	b.applyCredits(tenantId, now)
+
+	if status != SubscriptionCanceled {
+		var gracePeriodEnd interface{}
+		status, gracePeriodEnd = b.paymentStatus(subscription, now)
+		changes["gracePeriodEnd"] = gracePeriodEnd
+	}
+
+	if status != helper.GetValueOfString(subscription, "status") {
+		changes["status"] = status
+	}
+
+	changes["updatedAt"] = now
+	b.update("Subscription", subscription, changes)
+	b.forget(tenantId)
+}
+
+// paymentStatus works out the status of a billed subscription from its
+// oldest unpaid invoice.
+func (b *Billing) paymentStatus(subscription *datatype.DataMap, now time.Time) (string, interface{}) {
+	invoices := b.unpaidInvoices(subscription)
+	if len(invoices) == 0 {
+		return SubscriptionActive, nil
+	}
+
+	invoice := invoices[0]
+	dueDate := helper.GetValueOfDate(invoice, "dueDate")
+	graceEnd := dueDate.AddDate(0, 0, b.graceDays(subscription))
+	expiresAt := helper.GetValueOfDate(invoice, "periodEnd")
+
+	if expiresAt.Before(graceEnd) {
+		expiresAt = graceEnd
+	}
+
+	switch {
+	case now.Before(dueDate):
+		return SubscriptionActive, nil
+	case now.Before(graceEnd):
+		return SubscriptionGrace, graceEnd
+	case now.Before(expiresAt):
+		return SubscriptionPastDue, graceEnd
+	}
+
+	return SubscriptionExpired, graceEnd
+}
+
+// issueInvoice creates the invoice of the cycle starting at billingDate. The
+// cycle before it, from previousStart, is used for metered usage. An invoice
+// that already exists for the cycle is returned as it is.
+func (b *Billing) issueInvoice(subscription *datatype.DataMap, previousStart time.Time, billingDate time.Time, periodEnd time.Time, now time.Time) (*datatype.DataMap, error) {
+	subscriptionId := helper.GetValueOf(subscription, "_id")
+	tenantId := helper.GetValueOf(subscription, "tenantId")
+
+	if invoice := b.cycleInvoice(subscriptionId, billingDate); invoice != nil {
+		return invoice, nil
+	}
+
+	// Usage during the trial is free.
+	trialEnd := helper.GetValueOfDate(subscription, "trialEnd")
+	if previousStart.Before(trialEnd) {
+		previousStart = trialEnd
+	}
+
+	items := b.query("SubscriptionItem").Find(datatype.DataMap{"subscriptionId": subscriptionId})
+	lines := make([]datatype.DataMap, 0, len(*items))
+	currency := ""
+	total := 0.0
+
+	for _, item := range *items {
+		plan := b.query("PricingPlan").FindOne(datatype.DataMap{"id": helper.GetValueOf(item, "pricingPlanId")})
+		if helper.IsEmpty(plan) {
+			continue
+		}
+
+		pricingType := helper.GetValueOfString(plan, "pricingType")
+		unitPrice := helper.GetValueOfFloat(plan, "price")
+		start, end := billingDate, periodEnd
+		quantity := 1.0
+
+		switch pricingType {
+		case PricingPerUser:
+			quantity = float64(b.query("TenantUser").Count(datatype.DataMap{
+				"tenantId": tenantId,
+				"status":   "active",
+			}))
+		case PricingPerUnit:
+			quantity = helper.GetValueOfFloat(item, "quantity")
+		case PricingMetered:
+			start, end = previousStart, billingDate
+			quantity = b.usage(tenantId, helper.GetValueOf(item, "moduleId"), start, end)
+		}
+
+		planCurrency := helper.GetValueOfString(plan, "currency")
+		if len(lines) > 0 && planCurrency != currency {
+			return nil, errors.New("The pricing plans of the subscription don't share the currency")
+		}
+		currency = planCurrency
+
+		totalPrice := roundAmount(quantity * unitPrice)
+		total += totalPrice
+
+		lines = append(lines, datatype.DataMap{
+			"tenantId":           tenantId,
+			"moduleId":           helper.GetValueOf(item, "moduleId"),
+			"subscriptionItemId": helper.GetValueOf(item, "_id"),
+			"description":        billingItemDescription(pricingType),
+			"pricingType":        pricingType,
+			"quantity":           quantity,
+			"unitPrice":          unitPrice,
+			"totalPrice":         totalPrice,
+			"currency":           currency,
+			"startPeriod":        start,
+			"endPeriod":          end,
+			"createdAt":          now,
+		})
+	}
+
+	total = roundAmount(total)
+	status := "pending"
+	var paidDate interface{}
+
+	if total <= 0 {
+		status = "paid"
+		paidDate = now
+	}
+
+	var invoice *datatype.DataMap
+
+	// The invoice is stored with its lines or not at all
+	err := b.transaction(func(tx *Tx) error {
+		var err error
+
+		invoice, err = billingCreated(b.txQuery(tx, "Invoice").Create(datatype.DataMap{
+			"tenantId":        tenantId,
+			"subscriptionId":  subscriptionId,
+			"amountDue":       total,
+			"amountPaid":      0,
+			"amountRemaining": total,
+			"currency":        currency,
+			"status":          status,
+			"periodStart":     billingDate,
+			"periodEnd":       periodEnd,
+			"dueDate":         billingDate,
+			"paidDate":        paidDate,
+			"createdAt":       now,
+			"updatedAt":       now,
+		}), "Invoice rejected")
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			line["invoiceId"] = helper.GetValueOf(invoice, "_id")
+
+			if _, err := billingCreated(b.txQuery(tx, "InvoiceItem").Create(line), "Invoice item rejected"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		// Another server issued the invoice of the cycle first.
+		if invoice := b.cycleInvoice(subscriptionId, billingDate); invoice != nil {
+			return invoice, nil
+		}
+
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// cycleInvoice returns the invoice of the subscription for the cycle
+// starting at billingDate, or nil when it has not been issued.
+func (b *Billing) cycleInvoice(subscriptionId interface{}, billingDate time.Time) *datatype.DataMap {
+	return b.query("Invoice").FindOne(datatype.DataMap{
+		"subscriptionId": subscriptionId,
+		"periodStart":    billingDate,
+	})
+}
+
+// ApplyPayment allocates a succeeded payment to the unpaid invoices of its
+// tenant, oldest first. What is left over becomes account credit.
+func (b *Billing) ApplyPayment(paymentId interface{}) error {
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	payment := b.query("Payment").FindOne(datatype.DataMap{"id": paymentId})
+	if helper.IsEmpty(payment) {
+		return ErrPaymentNotFound
+	}
+
+	if helper.GetValueOfString(payment, "status") != "succeeded" || helper.IsNotEmpty((*payment)["allocatedAt"]) {
+		return nil
+	}
+
+	now := helper.GetTimestamp(nil)
+	b.allocatePayment(*payment, now)
+	b.refresh(helper.GetValueOf(payment, "tenantId"), now)
+
+	return nil
+}
+
+func (b *Billing) allocatePayment(payment datatype.DataMap, now time.Time) {
+	paymentId := helper.GetValueOf(payment, "_id")
+	tenantId := helper.GetValueOf(payment, "tenantId")
+	remaining := helper.GetValueOfFloat(payment, "amount")
+
+	allocated := map[string]bool{}
+	allocations := b.query("PaymentAllocation").Find(datatype.DataMap{"paymentId": helper.ToString(paymentId)})
+	for _, allocation := range *allocations {
+		remaining -= helper.GetValueOfFloat(allocation, "amountAllocated")
+		allocated[helper.GetValueOfString(allocation, "invoiceId")] = true
+	}
+
+	for _, invoice := range b.tenantUnpaidInvoices(tenantId) {
+		if remaining <= 0 {
+			break
+		}
+
+		invoiceId := helper.GetValueOfString(invoice, "_id")
+		if allocated[invoiceId] {
+			continue
+		}
+
+		// The allocation and the invoice are stored together, so a rerun
+		// never finds an allocation the invoice didn't get. The unique index
+		// of the allocations turns away a second allocation of the payment
+		// to the invoice.
+		amount := math.Min(remaining, helper.GetValueOfFloat(invoice, "amountRemaining"))
+		err := b.transaction(func(tx *Tx) error {
+			_, err := billingCreated(b.txQuery(tx, "PaymentAllocation").Create(datatype.DataMap{
+				"tenantId":        tenantId,
+				"paymentId":       helper.ToString(paymentId),
+				"invoiceId":       invoiceId,
+				"amountAllocated": amount,
+				"createdAt":       now,
+			}), "Payment allocation rejected")
+			if err != nil {
+				return err
+			}
+
+			_, err = b.payInvoice(tx, invoice, amount, now)
+
+			return err
+		})
+
+		if err != nil {
+			console.Error("billing", err.Error())
+			continue
+		}
+
+		remaining = roundAmount(remaining - amount)
+	}
+
+	// The credit of what is left is stored with the payment marked as
+	// allocated, a rerun can't add it twice.
+	err := b.transaction(func(tx *Tx) error {
+		if remaining > 0 {
+			_, err := billingCreated(b.txQuery(tx, "AccountCredit").Create(datatype.DataMap{
+				"tenantId":    tenantId,
+				"paymentId":   helper.ToString(paymentId),
+				"amount":      remaining,
+				"description": "Unallocated payment",
+				"createdAt":   now,
+			}), "Account credit rejected")
+			if err != nil {
+				return err
+			}
+		}
+
+		return b.updateIn(tx, "Payment", &payment, datatype.DataMap{
+			"allocatedAt": now,
+			"updatedAt":   now,
+		})
+	})
+
+	if err != nil {
+		console.Error("billing", err.Error())
+	}
+}
+
+// CreditBalance returns the unused account credit of the tenant.
+func (b *Billing) CreditBalance(tenantId interface{}) float64 {
+	balance := 0.0
+
+	credits := b.query("AccountCredit").Find(datatype.DataMap{"tenantId": tenantId})
+	for _, credit := range *credits {
+		balance += helper.GetValueOfFloat(credit, "amount")
+	}
+
+	return roundAmount(balance)
+}
+
+// AddCredit gives the tenant account credit, used for unpaid and future
+// invoices.
+func (b *Billing) AddCredit(tenantId interface{}, amount float64, description string) {
+	now := helper.GetTimestamp(nil)
+
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	b.query("AccountCredit").Create(datatype.DataMap{
+		"tenantId":    tenantId,
+		"amount":      amount,
+		"description": description,
+		"createdAt":   now,
+	})
+
+	b.refresh(tenantId, now)
+}
+
+// ApplyCredits uses the account credit of the tenant to pay its unpaid
+// invoices. Every use is stored as a negative credit against the invoice.
+func (b *Billing) ApplyCredits(tenantId interface{}, now time.Time) {
+	b.run.Lock()
+	defer b.run.Unlock()
+
+	b.applyCredits(tenantId, now)
+}
+
+func (b *Billing) applyCredits(tenantId interface{}, now time.Time) {
+	balance := b.CreditBalance(tenantId)
+
+	for _, invoice := range b.tenantUnpaidInvoices(tenantId) {
+		if balance <= 0 {
+			break
+		}
+
+		var amount float64
+
+		// The invoice is paid together with the use of the credit
+		err := b.transaction(func(tx *Tx) error {
+			var err error
+			if amount, err = b.payInvoice(tx, invoice, balance, now); err != nil {
+				return err
+			}
+
+			_, err = billingCreated(b.txQuery(tx, "AccountCredit").Create(datatype.DataMap{
+				"tenantId":    tenantId,
+				"invoiceId":   helper.GetValueOfString(invoice, "_id"),
+				"amount":      -amount,
+				"description": "Applied to invoice",
+				"createdAt":   now,
+			}), "Account credit rejected")
+
+			return err
+		})
+
+		if err != nil {
+			console.Error("billing", err.Error())
+			break
+		}
+
+		balance = roundAmount(balance - amount)
+	}
+}
+
+// payInvoice pays up to amount of the invoice in the transaction and returns
+// the amount used.
+func (b *Billing) payInvoice(tx *Tx, invoice datatype.DataMap, amount float64, now time.Time) (float64, error) {
+	remaining := helper.GetValueOfFloat(invoice, "amountRemaining")
+	paid := math.Min(amount, remaining)
+	remaining = roundAmount(remaining - paid)
+
+	changes := datatype.DataMap{
+		"amountPaid":      roundAmount(helper.GetValueOfFloat(invoice, "amountPaid") + paid),
+		"amountRemaining": remaining,
+		"status":          "partially_paid",
+		"updatedAt":       now,
+	}
+
+	if remaining <= 0 {
+		changes["status"] = "paid"
+		changes["paidDate"] = now
+	}
+
+	return paid, b.updateIn(tx, "Invoice", &invoice, changes)
+}
+
+// refresh processes the subscriptions of the tenant right away, after a
+// payment or credit changed what is owed.
+func (b *Billing) refresh(tenantId interface{}, now time.Time) {
+	b.applyCredits(tenantId, now)
+
+	subscriptions := b.query("Subscription").Find(datatype.DataMap{"tenantId": tenantId})
+	for _, subscription := range *subscriptions {
+		b.process(&subscription, now)
+	}
+}
+
+func (b *Billing) unpaidInvoices(subscription *datatype.DataMap) []datatype.DataMap {
+	invoices := b.query("Invoice").Find(datatype.DataMap{
+		"subscriptionId": helper.GetValueOf(subscription, "_id"),
+		"status":         datatype.DataMap{"in": []interface{}{"pending", "partially_paid"}},
+	})
+
+	return sortInvoices(*invoices)
+}
+
+func (b *Billing) tenantUnpaidInvoices(tenantId interface{}) []datatype.DataMap {
+	invoices := b.query("Invoice").Find(datatype.DataMap{
+		"tenantId": tenantId,
+		"status":   datatype.DataMap{"in": []interface{}{"pending", "partially_paid"}},
+	})
+
+	return sortInvoices(*invoices)
+}
+
+// graceDays returns the longest grace period of the plans on the
+// subscription.
+func (b *Billing) graceDays(subscription *datatype.DataMap) int {
+	days := 0
+
+	items := b.query("SubscriptionItem").Find(datatype.DataMap{"subscriptionId": helper.GetValueOf(subscription, "_id")})
+	for _, item := range *items {
+		plan := b.query("PricingPlan").FindOne(datatype.DataMap{"id": helper.GetValueOf(item, "pricingPlanId")})
+		if helper.IsNotEmpty(plan) {
+			days = max(days, helper.GetValueOfInt(plan, "graceDays"))
+		}
+	}
+
+	return days
+}
+
+// usage sums the quantity of the usage records of the module whose period
+// starts within [start, end).
+func (b *Billing) usage(tenantId interface{}, moduleId interface{}, start time.Time, end time.Time) float64 {
+	return b.query("UsageRecord").Sum("quantity", datatype.DataMap{
+		"tenantId": tenantId,
+		"moduleId": moduleId,
+		"periodStart": datatype.DataMap{
+			"greaterThanOrEqualTo": start,
+			"lessThan":             end,
+		},
+	})
+}
+
+func (b *Billing) update(name string, data *datatype.DataMap, changes datatype.DataMap) {
+	b.updateIn(nil, name, data, changes)
+}
+
+// updateIn writes the changes of the record in the transaction and applies
+// them to data.
+func (b *Billing) updateIn(tx *Tx, name string, data *datatype.DataMap, changes datatype.DataMap) error {
+	result := b.txQuery(tx, name).Where("id", helper.GetValueOf(data, "_id")).Update(changes, nil)
+	if err, ok := result.(error); ok {
+		return err
+	}
+
+	for k, v := range changes {
+		(*data)[k] = v
+	}
+
+	return nil
+}
+
+// ModuleStatus returns the subscription status of the tenant for a module,
+// the best one when several subscriptions include it. It is empty when the
+// module is not billed for the tenant.
+func (b *Billing) ModuleStatus(tenantId interface{}, moduleName string) string {
+	key := helper.ToString(tenantId) + "|" + moduleName
+
+	b.mut.Lock()
+	cached, ok := b.status[key]
+	b.mut.Unlock()
+
+	if ok && time.Since(cached.checkedAt) < billingStatusCacheTime {
+		return cached.status
+	}
+
+	status := b.moduleStatus(tenantId, moduleName)
+
+	b.mut.Lock()
+	b.status[key] = billingStatus{status: status, checkedAt: time.Now()}
+	b.mut.Unlock()
+
+	return status
+}
+
+func (b *Billing) moduleStatus(tenantId interface{}, moduleName string) string {
+	where := datatype.DataMap{"tenantId": tenantId}
+	isBilled := false
+
+	if helper.IsNotEmpty(moduleName) {
+		module := b.query("Module").FindOne(datatype.DataMap{"moduleName": moduleName})
+		if helper.IsEmpty(module) {
+			return ""
+		}
+
+		moduleId := helper.GetValueOf(module, "_id")
+		where["moduleId"] = moduleId
+		isBilled = b.query("PricingPlan").Exist(datatype.DataMap{"moduleId": moduleId})
+	}
+
+	items := b.query("SubscriptionItem").Find(where)
+	if len(*items) == 0 {
+		if isBilled {
+			return SubscriptionExpired
+		}
+
+		return ""
+	}
+
+	best := ""
+	rank := map[string]int{
+		SubscriptionActive:   5,
+		SubscriptionTrial:    4,
+		SubscriptionGrace:    3,
+		SubscriptionPastDue:  2,
+		SubscriptionExpired:  1,
+		SubscriptionCanceled: 0,
+	}
+
+	for _, item := range *items {
+		subscription := b.query("Subscription").FindOne(datatype.DataMap{"id": helper.GetValueOf(item, "subscriptionId")})
+		status := helper.GetValueOfString(subscription, "status")
+
+		if _, ok := rank[status]; ok && (best == "" || rank[status] > rank[best]) {
+			best = status
+		}
+	}
+
+	if best == "" {
+		return SubscriptionExpired
+	}
+
+	return best
+}
+
+func (b *Billing) forget(tenantId interface{}) {
+	prefix := helper.ToString(tenantId) + "|"
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for k := range b.status {
+		if strings.HasPrefix(k, prefix) {
+			delete(b.status, k)
+		}
+	}
+}
+
+func billingPeriodEnd(start time.Time, billingCycle string) time.Time {
+	if billingCycle == "yearly" {
+		return start.AddDate(1, 0, 0)
+	}
+
+	return start.AddDate(0, 1, 0)
+}
+
+func billingItemDescription(pricingType string) string {
+	switch pricingType {
+	case PricingPerUser:
+		return "Active users"
+	case PricingPerUnit:
+		return "Units"
+	case PricingMetered:
+		return "Metered usage"
+	}
+
+	return "Subscription"
+}
+
+func sortInvoices(invoices []datatype.DataMap) []datatype.DataMap {
+	sort.SliceStable(invoices, func(i, j int) bool {
+		return helper.GetValueOfDate(invoices[i], "dueDate").Before(helper.GetValueOfDate(invoices[j], "dueDate"))
+	})
+
+	return invoices
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,219 @@
+package yekonga
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// newBillingTestApp starts a server with tenant billing on a local database
+// in a temporary home directory.
+func newBillingTestApp(t *testing.T) *YekongaData {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+
+	configFile := filepath.Join(dir, "config.json")
+	configJson := `{"appName": "billing-test", "database": {"kind": "local"}, "hasTenant": true, "hasTenantBilling": true}`
+	if err := os.WriteFile(configFile, []byte(configJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return ServerConfig(configFile, filepath.Join(dir, "database.json"))
+}
+
+func billingTestPlan(t *testing.T, b *Billing, price float64, currency string) string {
+	t.Helper()
+
+	plan, err := billingCreated(b.query("PricingPlan").Create(datatype.DataMap{
+		"pricingType":  PricingFlat,
+		"billingCycle": "monthly",
+		"price":        price,
+		"currency":     currency,
+		"trialDays":    0,
+		"graceDays":    7,
+	}), "Pricing plan rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return helper.GetValueOfString(plan, "_id")
+}
+
+func billingTestPayment(t *testing.T, b *Billing, tenantId interface{}, amount float64) interface{} {
+	t.Helper()
+
+	payment, err := billingCreated(b.query("Payment").Create(datatype.DataMap{
+		"tenantId": tenantId,
+		"amount":   amount,
+		"currency": "USD",
+		"status":   "succeeded",
+	}), "Payment rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return helper.GetValueOf(payment, "_id")
+}
+
+func billingTestAllocated(b *Billing, paymentId interface{}) float64 {
+	total := 0.0
+
+	allocations := b.query("PaymentAllocation").Find(datatype.DataMap{"paymentId": helper.ToString(paymentId)})
+	for _, allocation := range *allocations {
+		total += helper.GetValueOfFloat(allocation, "amountAllocated")
+	}
+
+	return total
+}
+
+func TestBilling(t *testing.T) {
+	app := newBillingTestApp(t)
+	b := app.Billing()
+
+	t.Run("partial allocation", func(t *testing.T) {
+		tenantId := bson.NewObjectID()
+
+		if _, err := b.Subscribe(tenantId, []SubscriptionItemInput{{PricingPlanId: billingTestPlan(t, b, 100, "USD")}}); err != nil {
+			t.Fatal(err)
+		}
+
+		paymentId := billingTestPayment(t, b, tenantId, 40)
+		if err := b.ApplyPayment(paymentId); err != nil {
+			t.Fatal(err)
+		}
+
+		invoices := b.tenantUnpaidInvoices(tenantId)
+		if len(invoices) != 1 {
+			t.Fatalf("unpaid invoices = %d, want 1", len(invoices))
+		}
+
+		invoice := invoices[0]
+		if paid, remaining := helper.GetValueOfFloat(invoice, "amountPaid"), helper.GetValueOfFloat(invoice, "amountRemaining"); paid != 40 || remaining != 60 {
+			t.Fatalf("invoice paid %v with %v remaining, want 40 and 60", paid, remaining)
+		}
+		if status := helper.GetValueOfString(invoice, "status"); status != "partially_paid" {
+			t.Fatalf("invoice status = %s, want partially_paid", status)
+		}
+		if allocated := billingTestAllocated(b, paymentId); allocated != 40 {
+			t.Fatalf("allocated = %v, want 40", allocated)
+		}
+		if credit := b.CreditBalance(tenantId); credit != 0 {
+			t.Fatalf("credit = %v, want 0", credit)
+		}
+	})
+
+	t.Run("rerun after a partial allocation", func(t *testing.T) {
+		tenantId := bson.NewObjectID()
+
+		for _, price := range []float64{100, 100} {
+			if _, err := b.Subscribe(tenantId, []SubscriptionItemInput{{PricingPlanId: billingTestPlan(t, b, price, "USD")}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A run that stopped after allocating the payment to the first
+		// invoice, before the payment was marked as allocated
+		paymentId := billingTestPayment(t, b, tenantId, 150)
+		first := b.tenantUnpaidInvoices(tenantId)[0]
+		now := helper.GetTimestamp(nil)
+
+		err := b.transaction(func(tx *Tx) error {
+			_, err := billingCreated(b.txQuery(tx, "PaymentAllocation").Create(datatype.DataMap{
+				"tenantId":        tenantId,
+				"paymentId":       helper.ToString(paymentId),
+				"invoiceId":       helper.GetValueOfString(first, "_id"),
+				"amountAllocated": 100,
+			}), "Payment allocation rejected")
+			if err != nil {
+				return err
+			}
+
+			_, err = b.payInvoice(tx, first, 100, now)
+
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b.Run(now)
+		b.Run(now)
+
+		if allocated := billingTestAllocated(b, paymentId); allocated != 150 {
+			t.Fatalf("allocated = %v, want 150", allocated)
+		}
+
+		firstNow := b.query("Invoice").FindOne(datatype.DataMap{"id": helper.GetValueOf(first, "_id")})
+		if paid := helper.GetValueOfFloat(firstNow, "amountPaid"); paid != 100 {
+			t.Fatalf("first invoice paid %v, want 100", paid)
+		}
+
+		invoices := b.tenantUnpaidInvoices(tenantId)
+		if len(invoices) != 1 || helper.GetValueOfFloat(invoices[0], "amountPaid") != 50 {
+			t.Fatalf("unpaid invoices = %v, want the second one with 50 paid", invoices)
+		}
+		if credit := b.CreditBalance(tenantId); credit != 0 {
+			t.Fatalf("credit = %v, want 0", credit)
+		}
+	})
+
+	t.Run("currency of the plans", func(t *testing.T) {
+		tenantId := bson.NewObjectID()
+
+		_, err := b.Subscribe(tenantId, []SubscriptionItemInput{
+			{PricingPlanId: billingTestPlan(t, b, 10, "USD")},
+			{PricingPlanId: billingTestPlan(t, b, 20, "EUR")},
+		})
+		if err == nil {
+			t.Fatal("subscribed to plans in USD and EUR")
+		}
+		if count := b.query("Subscription").Count(datatype.DataMap{"tenantId": tenantId}); count != 0 {
+			t.Fatalf("subscriptions = %d, want none", count)
+		}
+
+		if _, err := b.Subscribe(tenantId, []SubscriptionItemInput{
+			{PricingPlanId: billingTestPlan(t, b, 10, "EUR")},
+			{PricingPlanId: billingTestPlan(t, b, 20, "EUR")},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		invoices := b.tenantUnpaidInvoices(tenantId)
+		if len(invoices) != 1 {
+			t.Fatalf("unpaid invoices = %d, want 1", len(invoices))
+		}
+		if currency, due := helper.GetValueOfString(invoices[0], "currency"), helper.GetValueOfFloat(invoices[0], "amountDue"); currency != "EUR" || due != 30 {
+			t.Fatalf("invoice of %v %s, want 30 EUR", due, currency)
+		}
+
+		// A subscription stored with plans in several currencies is not
+		// invoiced in the currency of one of them
+		subscription, err := billingCreated(b.query("Subscription").Create(datatype.DataMap{
+			"tenantId":     tenantId,
+			"status":       SubscriptionActive,
+			"billingCycle": "monthly",
+		}), "Subscription rejected")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, plan := range []string{billingTestPlan(t, b, 10, "USD"), billingTestPlan(t, b, 20, "EUR")} {
+			b.query("SubscriptionItem").Create(datatype.DataMap{
+				"tenantId":       tenantId,
+				"subscriptionId": helper.GetValueOf(subscription, "_id"),
+				"pricingPlanId":  plan,
+			})
+		}
+
+		now := time.Now()
+		if _, err := b.issueInvoice(subscription, now, now, now.AddDate(0, 1, 0), now); err == nil {
+			t.Fatal("invoiced plans in USD and EUR")
+		}
+	})
+}
@@ -70,6 +70,9 @@ const (
 	YekongaKey          ContextKey = "yekongaObject"
 	RequestContextKey   ContextKey = "requestContext"
 	ResponseContextKey  ContextKey = "responseContext"
+	OTPVerifiedKey      ContextKey = "otpVerified"
+	BillingStatusKey    ContextKey = "billingStatus"
+	SocketClientKey     ContextKey = "socketClient"
 )
 
 type PrimaryCloudKey string
@@ -0,0 +1,271 @@
+package yekonga
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// ErrInvalidCursor is returned by Connection for a cursor that was not
+// issued for the same ordering.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type queryOrder struct {
+	Field      string
+	Descending bool
+}
+
+// ConnectionArgs selects a page of a cursor connection. First and After page
+// forward, Last and Before page backward. TotalCount adds the number of
+// records the filter matches, which costs an extra count query.
+type ConnectionArgs struct {
+	First      int
+	After      string
+	Last       int
+	Before     string
+	TotalCount bool
+}
+
+// ordering returns the order by fields in the order they were added.
+func (m *DataModelQuery) ordering() []queryOrder {
+	order := make([]queryOrder, 0, len(m.orderKeys))
+
+	for _, k := range m.orderKeys {
+		if v, ok := m.orderBy[k]; ok {
+			order = append(order, queryOrder{Field: k, Descending: strings.ToLower(v) == "desc"})
+		}
+	}
+
+	return order
+}
+
+// cursorOrdering is the ordering with _id as the last field, so that every
+// record has a distinct position.
+func (m *DataModelQuery) cursorOrdering() []queryOrder {
+	order := make([]queryOrder, 0, len(m.orderKeys)+1)
+
+	for _, o := range m.ordering() {
+		if o.Field == "id" {
+			o.Field = "_id"
+		}
+
+		order = append(order, o)
+
+		if o.Field == "_id" {
+			return order
+		}
+	}
+
+	return append(order, queryOrder{Field: "_id"})
+}
+
+// Connection returns a page of records after or before a cursor, built on
+// the order by fields plus _id. Unlike Paginate the page stays stable while
+// records are added or removed. It returns the edges with their cursor and
+// node, the pageInfo and, when asked for, the totalCount.
+func (m *DataModelQuery) Connection(where interface{}, args ConnectionArgs) interface{} {
+	m.WhereAll(where)
+	m.addTenantId()
+	m.addTrashedScope()
+
+	if !m.skipBeforeCommit {
+		triggerBefore := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
+		if v, ok := triggerBefore.(bool); ok && !v {
+			return nil
+		} else if helper.IsMap(triggerBefore) {
+			m.WhereAll(helper.ToDataMap(triggerBefore))
+		}
+
+		triggerBefore = m.runTriggerAction(BeforeFindTriggerAction, m.where)
+		if v, ok := triggerBefore.(bool); ok && !v {
+			return nil
+		} else if helper.IsMap(triggerBefore) {
+			m.WhereAll(helper.ToDataMap(triggerBefore))
+		}
+	}
+
+	order := m.cursorOrdering()
+	backward := args.Last > 0 || (args.First <= 0 && helper.IsNotEmpty(args.Before))
+
+	limit := args.First
+	cursor := args.After
+	if backward {
+		limit = args.Last
+		cursor = args.Before
+	}
+	if limit <= 0 {
+		limit = m.limit
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+
+	var values []interface{}
+	if helper.IsNotEmpty(cursor) {
+		var err error
+		if values, err = m.Model.decodeCursor(cursor, order); err != nil {
+			return err
+		}
+	}
+
+	seekOrder := order
+	if backward {
+		seekOrder = make([]queryOrder, len(order))
+		for i, o := range order {
+			seekOrder[i] = queryOrder{Field: o.Field, Descending: !o.Descending}
+		}
+	}
+
+	rows := *m.collection().seek(seekOrder, values, limit+1)
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	var result *[]datatype.DataMap = &rows
+
+	triggerAfter := m.runTriggerAction(AfterFindTriggerAllAction, result)
+	if helper.IsMapList(triggerAfter) {
+		v := helper.ToDataMapList(triggerAfter)
+		result = &v
+	}
+
+	triggerAfter = m.runTriggerAction(AfterFindTriggerAction, result)
+	if helper.IsMapList(triggerAfter) {
+		v := helper.ToDataMapList(triggerAfter)
+		result = &v
+	}
+
+	edges := make([]datatype.DataMap, 0, len(*result))
+	for _, row := range *result {
+		edges = append(edges, datatype.DataMap{
+			"cursor": m.Model.encodeCursor(row, order),
+			"node":   row,
+		})
+	}
+
+	pageInfo := datatype.DataMap{
+		"hasNextPage":     hasMore && !backward || backward && helper.IsNotEmpty(args.Before),
+		"hasPreviousPage": hasMore && backward || !backward && helper.IsNotEmpty(args.After),
+		"startCursor":     nil,
+		"endCursor":       nil,
+	}
+	if len(edges) > 0 {
+		pageInfo["startCursor"] = edges[0]["cursor"]
+		pageInfo["endCursor"] = edges[len(edges)-1]["cursor"]
+	}
+
+	connection := datatype.DataMap{
+		"edges":    edges,
+		"pageInfo": pageInfo,
+	}
+
+	if args.TotalCount {
+		connection["totalCount"] = m.collection().count()
+	}
+
+	return &connection
+}
+
+// encodeCursor builds the opaque cursor of a record from its order by values.
+func (m *DataModel) encodeCursor(row datatype.DataMap, order []queryOrder) string {
+	values := make([]interface{}, 0, len(order))
+	for _, o := range order {
+		values = append(values, m.cursorValue(o.Field, row[o.Field]))
+	}
+
+	b, _ := json.Marshal(values)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the order by values of a cursor, typed by field.
+func (m *DataModel) decodeCursor(cursor string, order []queryOrder) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var values []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil || len(values) != len(order) {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, v := range values {
+		if n, ok := v.(json.Number); ok {
+			if vi, err := n.Int64(); err == nil {
+				v = vi
+			} else {
+				v, _ = n.Float64()
+			}
+		}
+
+		values[i] = m.cursorValue(order[i].Field, v)
+	}
+
+	return values, nil
+}
+
+// cursorValue brings a value of an order by field to the form it is
+// compared in: ids as hex strings and dates as time.
+func (m *DataModel) cursorValue(field string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.ObjectID:
+		return v.Hex()
+	case bson.DateTime:
+		return v.Time().UTC()
+	case string:
+		if f, ok := m.Fields[field]; ok && f.Kind == DataModelDate {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t.UTC()
+			}
+		}
+	}
+
+	return value
+}
+
+// cursorCompare orders two records by the cursor ordering, used by the
+// backends that sort in memory.
+func (m *DataModel) cursorCompare(a datatype.DataMap, b []interface{}, order []queryOrder) int {
+	for i, o := range order {
+		va := m.cursorValue(o.Field, a[o.Field])
+		vb := b[i]
+
+		var c int
+		switch {
+		case va == nil && vb == nil:
+			c = 0
+		case va == nil:
+			c = -1
+		case vb == nil:
+			c = 1
+		default:
+			c, _ = validationCompare(va, vb)
+		}
+
+		if o.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
@@ -67,37 +67,39 @@ var DefaultAuthDatabaseStructure DatabaseStructureType = DatabaseStructureType{
 	"Users": {
 		"id": {"type": "ID", "default": nil, "required": false},
 		// "tenantId":           {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
-		"firstName":          {"type": "String", "default": nil, "required": false},
-		"secondName":         {"type": "String", "default": nil, "required": false},
-		"lastName":           {"type": "String", "default": nil, "required": false},
-		"username":           {"type": "String", "default": nil, "required": false, "protected": false},
-		"usernameType":       {"type": "String", "default": nil, "required": false, "options": []string{"name", "email", "phone", "whatsapp"}, "protected": false},
-		"email":              {"type": "String", "default": nil, "required": false, "protected": false},
-		"phone":              {"type": "String", "default": nil, "required": false, "protected": false},
-		"whatsapp":           {"type": "String", "default": nil, "required": false, "protected": false},
-		"profileUrl":         {"type": "URL", "default": nil, "required": false},
-		"gender":             {"type": "String", "default": nil, "required": false, "options": []string{"male", "female"}},
-		"dateOfBirth":        {"type": "Date", "default": nil, "required": false},
-		"password":           {"type": "String", "default": nil, "required": false, "protected": true},
-		"role":               {"type": "String", "default": nil, "required": false, "options": []string{"admin", "user"}},
-		"token":              {"type": "String", "default": nil, "required": false, "protected": true},
-		"googleToken":        {"type": "String", "default": nil, "required": false, "protected": true},
-		"rememberToken":      {"type": "String", "default": nil, "required": false, "protected": true},
-		"deviceToken":        {"type": "String", "default": nil, "required": false, "protected": true},
-		"status":             {"type": "String", "default": "active", "required": false, "options": []string{"active", "inactive"}},
-		"isActive":           {"type": "Boolean", "default": false, "required": false},
-		"isBanned":           {"type": "Boolean", "default": false, "required": false},
-		"isPhoneVerified":    {"type": "Boolean", "default": false, "required": false},
-		"phoneVerifyCode":    {"type": "String", "default": false, "required": false, "protected": true},
-		"phoneVerifiedAt":    {"type": "Date", "default": nil, "required": false},
-		"isEmailVerified":    {"type": "Boolean", "default": false, "required": false},
-		"emailVerifyCode":    {"type": "String", "default": false, "required": false, "protected": true},
-		"emailVerifiedAt":    {"type": "Date", "default": nil, "required": false},
-		"isWhatsappVerified": {"type": "Boolean", "default": false, "required": false},
-		"whatsappVerifyCode": {"type": "String", "default": false, "required": false, "protected": true},
-		"whatsappVerifiedAt": {"type": "Date", "default": nil, "required": false},
-		"otpCode":            {"type": "String", "default": nil, "required": false, "protected": true},
-		"otpCreatedAt":       {"type": "Date", "default": nil, "required": false},
+		"firstName":           {"type": "String", "default": nil, "required": false},
+		"secondName":          {"type": "String", "default": nil, "required": false},
+		"lastName":            {"type": "String", "default": nil, "required": false},
+		"username":            {"type": "String", "default": nil, "required": false, "protected": false},
+		"usernameType":        {"type": "String", "default": nil, "required": false, "options": []string{"name", "email", "phone", "whatsapp"}, "protected": false},
+		"email":               {"type": "String", "default": nil, "required": false, "protected": false},
+		"phone":               {"type": "String", "default": nil, "required": false, "protected": false},
+		"whatsapp":            {"type": "String", "default": nil, "required": false, "protected": false},
+		"profileUrl":          {"type": "URL", "default": nil, "required": false},
+		"gender":              {"type": "String", "default": nil, "required": false, "options": []string{"male", "female"}},
+		"dateOfBirth":         {"type": "Date", "default": nil, "required": false},
+		"password":            {"type": "String", "default": nil, "required": false, "protected": true},
+		"role":                {"type": "String", "default": nil, "required": false, "options": []string{"admin", "user"}},
+		"token":               {"type": "String", "default": nil, "required": false, "protected": true},
+		"googleToken":         {"type": "String", "default": nil, "required": false, "protected": true},
+		"rememberToken":       {"type": "String", "default": nil, "required": false, "protected": true},
+		"deviceToken":         {"type": "String", "default": nil, "required": false, "protected": true},
+		"status":              {"type": "String", "default": "active", "required": false, "options": []string{"active", "inactive"}},
+		"isActive":            {"type": "Boolean", "default": false, "required": false},
+		"isBanned":            {"type": "Boolean", "default": false, "required": false},
+		"isPhoneVerified":     {"type": "Boolean", "default": false, "required": false},
+		"phoneVerifyCode":     {"type": "String", "default": false, "required": false, "protected": true},
+		"phoneVerifiedAt":     {"type": "Date", "default": nil, "required": false},
+		"isEmailVerified":     {"type": "Boolean", "default": false, "required": false},
+		"emailVerifyCode":     {"type": "String", "default": false, "required": false, "protected": true},
+		"emailVerifiedAt":     {"type": "Date", "default": nil, "required": false},
+		"isWhatsappVerified":  {"type": "Boolean", "default": false, "required": false},
+		"whatsappVerifyCode":  {"type": "String", "default": false, "required": false, "protected": true},
+		"whatsappVerifiedAt":  {"type": "Date", "default": nil, "required": false},
+		"otpCode":             {"type": "String", "default": nil, "required": false, "protected": true},
+		"otpCreatedAt":        {"type": "Date", "default": nil, "required": false},
+		"resetTokenHash":      {"type": "String", "default": nil, "required": false, "protected": true},
+		"resetTokenExpiresAt": {"type": "Date", "default": nil, "required": false, "protected": true},
 		"userType": {
 			"type":     "String",
 			"default":  "individual",
@@ -120,8 +122,11 @@ var DefaultAuthDatabaseStructure DatabaseStructureType = DatabaseStructureType{
 		"otpCreatedAt":  {"type": "Date", "default": nil, "required": false, "protected": true},
 		"otpVerifiedAt": {"type": "Date", "default": nil, "required": false},
 		"count":         {"type": "Number", "default": 0, "required": false, "protected": true},
+		"expiresAt":     {"type": "Date", "default": nil, "required": false},
 		"createdAt":     {"type": "Date", "default": "now", "required": false},
 		"updatedAt":     {"type": "Date", "default": "now", "required": false},
+
+		ModelOptionsKey: {"ttl": map[string]interface{}{"field": "expiresAt"}},
 	},
 	"Profiles": {
 		"id":          {"type": "ID", "default": nil, "required": false},
@@ -166,6 +171,8 @@ var DefaultAuthDatabaseStructure DatabaseStructureType = DatabaseStructureType{
 		"expiresAt": {"type": "Date", "default": "now", "required": false},
 		// 	"roles":       make([]string, 0), // ["admin", "finance"],
 		// 	"permissions": make([]string, 0), // ["payroll.read", "asset.write"],
+
+		ModelOptionsKey: {"ttl": map[string]interface{}{"field": "expiresAt"}},
 	},
 	"LoginAttempts": {
 		"id":        {"type": "ID", "default": nil, "required": false},
@@ -174,8 +181,11 @@ var DefaultAuthDatabaseStructure DatabaseStructureType = DatabaseStructureType{
 		"profileId": {"type": "ID", "default": nil, "required": false, "foreignKey": "Profile.id"},
 		"userId":    {"type": "ID", "default": nil, "required": false, "foreignKey": "User.id"},
 		"username":  {"type": "String", "default": "active", "required": false, "options": []string{"success", "fail"}},
-		"status":    {"type": "String", "default": "active", "required": false, "options": []string{"success", "fail"}},
+		"ipAddress": {"type": "String", "default": nil, "required": false},
+		"status":    {"type": "String", "default": "active", "required": false, "options": []string{"success", "fail", "otp", "otpFail", "resetFail"}},
 		"timestamp": {"type": "Date", "default": "now", "required": false},
+
+		ModelOptionsKey: {"ttl": map[string]interface{}{"field": "timestamp", "after": "30d"}},
 	},
 	"UserDevices": {
 		"id":         {"type": "ID", "default": nil, "required": false},
@@ -285,15 +295,17 @@ var DefaultBillingDatabaseStructure DatabaseStructureType = DatabaseStructureTyp
 		"id":           {"type": "ID", "default": nil, "required": false},
 		"moduleId":     {"type": "ID", "default": nil, "required": false, "foreignKey": "Module.id"},
 		"pricingType":  {"type": "String", "default": "flat", "required": false, "options": []string{"flat", "per_user", "per_unit", "metered"}},
-		"billingCycle": {"type": "String", "default": "flat", "required": false, "options": []string{"monthly", "yearly"}},
+		"billingCycle": {"type": "String", "default": "monthly", "required": false, "options": []string{"monthly", "yearly"}},
 		"price":        {"type": "Float", "default": 0, "required": false},
+		"currency":     {"type": "String", "default": nil, "required": false},
 		"trialDays":    {"type": "Number", "default": 30, "required": false},
 		"graceDays":    {"type": "Number", "default": 7, "required": false},
 	},
 	"Subscriptions": {
 		"id":                 {"type": "ID", "default": nil, "required": false},
 		"tenantId":           {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
-		"status":             {"type": "String", "default": "flat", "required": false, "options": []string{"active", "grace", "trailing", "past_due", "canceled", "unpaid", "expired"}},
+		"status":             {"type": "String", "default": "trial", "required": false, "options": []string{"trial", "active", "grace", "trailing", "past_due", "canceled", "unpaid", "expired"}},
+		"billingCycle":       {"type": "String", "default": "monthly", "required": false, "options": []string{"monthly", "yearly"}},
 		"trialStart":         {"type": "Date", "default": nil, "required": false},
 		"trialEnd":           {"type": "Date", "default": nil, "required": false},
 		"currentPeriodStart": {"type": "Date", "default": nil, "required": false},
@@ -301,6 +313,8 @@ var DefaultBillingDatabaseStructure DatabaseStructureType = DatabaseStructureTyp
 		"gracePeriodEnd":     {"type": "Date", "default": nil, "required": false},
 		"nextBillingDate":    {"type": "Date", "default": nil, "required": false},
 		"canceledAt":         {"type": "Date", "default": nil, "required": false},
+		"createdAt":          {"type": "Date", "default": "now", "required": false},
+		"updatedAt":          {"type": "Date", "default": "now", "required": false},
 	},
 	"SubscriptionItems": {
 		"id":             {"type": "ID", "default": nil, "required": false},
@@ -311,23 +325,39 @@ var DefaultBillingDatabaseStructure DatabaseStructureType = DatabaseStructureTyp
 		"quantity":       {"type": "Float", "default": 0, "required": false},
 	},
 	"UsageRecords": {
+		ModelOptionsKey: {
+			"indexes": []interface{}{
+				map[string]interface{}{"fields": []interface{}{"tenantId", "moduleId", "periodStart"}},
+			},
+		},
 		"id":          {"type": "ID", "default": nil, "required": false},
 		"tenantId":    {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
 		"moduleId":    {"type": "ID", "default": nil, "required": false, "foreignKey": "Module.id"},
 		"quantity":    {"type": "Float", "default": 0, "required": false},
 		"periodStart": {"type": "Date", "default": nil, "required": false},
 		"periodEnd":   {"type": "Date", "default": nil, "required": false},
+		"createdAt":   {"type": "Date", "default": "now", "required": false},
 	},
 	"Invoices": {
+		ModelOptionsKey: {
+			"indexes": []interface{}{
+				map[string]interface{}{"fields": []interface{}{"subscriptionId", "periodStart"}, "unique": true},
+			},
+		},
 		"id":              {"type": "ID", "default": nil, "required": false},
 		"tenantId":        {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
 		"subscriptionId":  {"type": "ID", "default": nil, "required": false, "foreignKey": "Subscription.id"},
 		"amountDue":       {"type": "Float", "default": 0, "required": false},
 		"amountPaid":      {"type": "Float", "default": 0, "required": false},
 		"amountRemaining": {"type": "Float", "default": 0, "required": false},
-		"status":          {"type": "String", "default": "flat", "required": false, "options": []string{"paid", "pending", "partially_paid", "failed", "canceled"}},
+		"currency":        {"type": "String", "default": nil, "required": false},
+		"status":          {"type": "String", "default": "pending", "required": false, "options": []string{"paid", "pending", "partially_paid", "failed", "canceled"}},
+		"periodStart":     {"type": "Date", "default": nil, "required": false},
+		"periodEnd":       {"type": "Date", "default": nil, "required": false},
 		"dueDate":         {"type": "Date", "default": nil, "required": false},
 		"paidDate":        {"type": "Date", "default": nil, "required": false},
+		"createdAt":       {"type": "Date", "default": "now", "required": false},
+		"updatedAt":       {"type": "Date", "default": "now", "required": false},
 	},
 	"InvoiceItems": {
 		"id":                 {"type": "ID", "default": nil, "required": false},
@@ -353,16 +383,22 @@ var DefaultBillingDatabaseStructure DatabaseStructureType = DatabaseStructureTyp
 		"providerPaymentId": {"type": "String", "default": nil, "required": false},
 		"amount":            {"type": "Float", "default": 0, "required": false},
 		"currency":          {"type": "String", "default": nil, "required": false},
-		"status":            {"type": "String", "default": "flat", "required": false, "options": []string{"pending", "succeeded", "failed", "canceled", "refunded"}},
-		"paymentMethod":     {"type": "String", "default": "flat", "required": false, "options": []string{"card", "bank_transfer", "paypal", "crypto"}},
+		"status":            {"type": "String", "default": "pending", "required": false, "options": []string{"pending", "succeeded", "failed", "canceled", "refunded"}},
+		"paymentMethod":     {"type": "String", "default": nil, "required": false, "options": []string{"card", "bank_transfer", "paypal", "crypto"}},
 		"failureReason":     {"type": "String", "default": nil, "required": false},
 		"paidAt":            {"type": "Date", "default": "now", "required": false},
 		"refundedAt":        {"type": "Date", "default": nil, "required": false},
+		"allocatedAt":       {"type": "Date", "default": nil, "required": false},
 		"metadata":          {"type": "Any", "default": nil, "required": false},
 		"updatedAt":         {"type": "Date", "default": "now", "required": false},
 		"createdAt":         {"type": "Date", "default": "now", "required": false},
 	},
 	"PaymentAllocations": {
+		ModelOptionsKey: {
+			"indexes": []interface{}{
+				map[string]interface{}{"fields": []interface{}{"paymentId", "invoiceId"}, "unique": true},
+			},
+		},
 		"id":              {"type": "ID", "default": nil, "required": false},
 		"tenantId":        {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
 		"paymentId":       {"type": "String", "default": nil, "required": false, "foreignKey": "Payments.id"},
@@ -371,11 +407,13 @@ var DefaultBillingDatabaseStructure DatabaseStructureType = DatabaseStructureTyp
 		"createdAt":       {"type": "Date", "default": "now", "required": false},
 	},
 	"AccountCredits": {
-		"id":        {"type": "ID", "default": nil, "required": false},
-		"tenantId":  {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
-		"paymentId": {"type": "String", "default": nil, "required": false, "foreignKey": "Payments.id"},
-		"amount":    {"type": "Float", "default": 0, "required": false},
-		"createdAt": {"type": "Date", "default": "now", "required": false},
+		"id":          {"type": "ID", "default": nil, "required": false},
+		"tenantId":    {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
+		"paymentId":   {"type": "String", "default": nil, "required": false, "foreignKey": "Payments.id"},
+		"invoiceId":   {"type": "String", "default": nil, "required": false, "foreignKey": "Invoice.id"},
+		"amount":      {"type": "Float", "default": 0, "required": false},
+		"description": {"type": "String", "default": nil, "required": false},
+		"createdAt":   {"type": "Date", "default": "now", "required": false},
 	},
 }
 
@@ -433,23 +471,51 @@ var DefaultExtraDatabaseStructure DatabaseStructureType = DatabaseStructureType{
 		"status":    {"type": "String", "default": nil, "required": false, "options": []string{"waiting", "sent", "delivered"}},
 		"createdAt": {"type": "Date", "default": "now", "required": false},
 		"updatedAt": {"type": "Date", "default": "now", "required": false},
+
+		ModelOptionsKey: {"ttl": map[string]interface{}{"field": "createdAt", "after": "7d"}},
 	},
 	"AuditTrails": {
+		ModelOptionsKey: {
+			"indexes": []interface{}{
+				map[string]interface{}{"fields": []interface{}{"model", "documentId", "-createdAt"}},
+			},
+		},
 		"id":         {"type": "ID", "default": nil, "required": false},
 		"tenantId":   {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
 		"profileId":  {"type": "ID", "default": nil, "required": false, "foreignKey": "Profile.id"},
 		"userId":     {"type": "ID", "default": nil, "required": false, "foreignKey": "User.id"},
+		"adminId":    {"type": "ID", "default": nil, "required": false},
+		"username":   {"type": "String", "default": nil, "required": false},
+		"requestId":  {"type": "String", "default": nil, "required": false},
 		"action":     {"type": "String", "default": nil, "required": false},
 		"documentId": {"type": "ID", "default": nil, "required": false},
 		"collection": {"type": "String", "default": nil, "required": false},
 		"model":      {"type": "String", "default": nil, "required": false},
 		"newValues":  {"type": "Any", "default": nil, "required": false},
 		"oldValues":  {"type": "Any", "default": nil, "required": false},
+		"changes":    {"type": "Any", "default": nil, "required": false},
 		"ipAddress":  {"type": "String", "default": nil, "required": false},
 		"browser":    {"type": "Any", "default": nil, "required": false},
 		"userAgent":  {"type": "String", "default": nil, "required": false},
 		"createdAt":  {"type": "Date", "default": "now", "required": false},
 	},
+	"Revisions": {
+		ModelOptionsKey: {
+			"indexes": []interface{}{
+				map[string]interface{}{"fields": []interface{}{"model", "documentId", "-createdAt"}},
+			},
+		},
+		"id":         {"type": "ID", "default": nil, "required": false},
+		"tenantId":   {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
+		"userId":     {"type": "ID", "default": nil, "required": false, "foreignKey": "User.id"},
+		"model":      {"type": "String", "default": nil, "required": false},
+		"collection": {"type": "String", "default": nil, "required": false},
+		"documentId": {"type": "ID", "default": nil, "required": false},
+		"version":    {"type": "Integer", "default": nil, "required": false},
+		"action":     {"type": "String", "default": nil, "required": false},
+		"data":       {"type": "Any", "default": nil, "required": false},
+		"createdAt":  {"type": "Date", "default": "now", "required": false},
+	},
 	"Reports": {
 		"id":          {"type": "ID", "default": nil, "required": false},
 		"tenantId":    {"type": "ID", "default": nil, "required": false, "foreignKey": "Tenant.id"},
@@ -0,0 +1,211 @@
+package yekonga
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// dataLoaderBatchKey marks a row with the batch it was loaded in so the
+// relation resolvers of that row can find its siblings.
+const dataLoaderBatchKey = "_loader"
+
+// DataLoader batches the relation lookups of a GraphQL request. Rows of a
+// list are registered as one batch and the first relation resolved for any
+// of them loads the related records of the whole batch with a single `in`
+// query. The result is kept for the remaining rows and registered as the
+// batch of the next level.
+type DataLoader struct {
+	batches [][]datatype.DataMap
+	results map[string]map[string][]datatype.DataMap
+	mut     sync.Mutex
+}
+
+func NewDataLoader() *DataLoader {
+	return &DataLoader{
+		batches: make([][]datatype.DataMap, 0),
+		results: make(map[string]map[string][]datatype.DataMap),
+	}
+}
+
+// DataLoader returns the loader of the request, creating it on first use.
+func (c *RequestContext) DataLoader() *DataLoader {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.Loader == nil {
+		c.Loader = NewDataLoader()
+	}
+
+	return c.Loader
+}
+
+// Register adds the rows as a batch and tags every row with its batch id.
+func (l *DataLoader) Register(rows []datatype.DataMap) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.register(rows)
+}
+
+func (l *DataLoader) register(rows []datatype.DataMap) {
+	id := len(l.batches)
+	l.batches = append(l.batches, rows)
+
+	for _, row := range rows {
+		if row != nil {
+			row[dataLoaderBatchKey] = id
+		}
+	}
+}
+
+func (l *DataLoader) batch(row datatype.DataMap) ([]datatype.DataMap, int, bool) {
+	id, ok := row[dataLoaderBatchKey].(int)
+	if !ok || id < 0 || id >= len(l.batches) {
+		return nil, 0, false
+	}
+
+	return l.batches[id], id, true
+}
+
+// loadRelated resolves a relation of the source row through the loader. It
+// returns false when the relation can not be batched and the caller should
+// query it on its own.
+func (g *GraphqlAutoBuild) loadRelated(p *graphql.ResolveParams, name string, foreignKey string, targetKey string, isParent bool) ([]datatype.DataMap, bool) {
+	ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
+	source, ok := p.Source.(datatype.DataMap)
+	if ctx == nil || !ok || helper.IsEmpty(foreignKey) {
+		return nil, false
+	}
+
+	// Paging and grouping apply to the related list of each row and can not
+	// be answered from one shared query.
+	for _, k := range []string{"page", "skip", "groupBy", "distinct"} {
+		if _, exists := p.Args[k]; exists {
+			return nil, false
+		}
+	}
+
+	model := g.yekonga.Model(name)
+	if model == nil {
+		return nil, false
+	}
+
+	matchKey, sourceKey := dataLoaderKeys(model, foreignKey, targetKey, isParent)
+	loader := ctx.DataLoader()
+
+	loader.mut.Lock()
+	defer loader.mut.Unlock()
+
+	rows, batchId, ok := loader.batch(source)
+	if !ok {
+		return nil, false
+	}
+
+	cacheKey := fmt.Sprintf("%d|%s|%s|%s", batchId, p.Info.FieldName, name, helper.ToJson(p.Args))
+	grouped, loaded := loader.results[cacheKey]
+
+	if !loaded {
+		ids := make([]interface{}, 0, len(rows))
+		seen := make(map[string]bool, len(rows))
+
+		for _, row := range rows {
+			value := dataLoaderValue(row, sourceKey)
+			if helper.IsArray(value) {
+				return nil, false
+			}
+
+			key := dataLoaderKey(value)
+			if helper.IsEmpty(key) || seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			ids = append(ids, value)
+		}
+
+		grouped = make(map[string][]datatype.DataMap)
+
+		if len(ids) > 0 {
+			query := g.yekonga.ModelQuery(name)
+			params := *p
+			params.Source = nil
+			g.setModelParams(query, &params, "", "", isParent)
+			query.Take(0).Where(matchKey, datatype.DataMap{"in": ids})
+
+			list := query.Find(nil)
+			related := make([]datatype.DataMap, 0, len(*list))
+
+			for _, d := range *list {
+				dataMap := datatype.DataMap(g.formateOutputData(query, d, foreignKey, targetKey))
+				dataMap["_params"] = p.Args
+
+				key := dataLoaderKey(dataLoaderValue(dataMap, matchKey))
+				grouped[key] = append(grouped[key], dataMap)
+				related = append(related, dataMap)
+			}
+
+			loader.register(related)
+		}
+
+		loader.results[cacheKey] = grouped
+	}
+
+	result := grouped[dataLoaderKey(dataLoaderValue(source, sourceKey))]
+
+	if limit, ok := p.Args["limit"].(int); ok && limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, true
+}
+
+// dataLoaderKeys returns the field of the related model to match on and the
+// field of the source row holding the value, following setModelParams.
+func dataLoaderKeys(model *DataModel, foreignKey string, targetKey string, isParent bool) (string, string) {
+	if helper.Contains(model.ParentKeys, foreignKey) && !isParent {
+		return foreignKey, targetKey
+	}
+
+	return targetKey, foreignKey
+}
+
+func dataLoaderValue(row datatype.DataMap, key string) interface{} {
+	if key == "id" || key == "_id" {
+		if v, ok := row["_id"]; ok && v != nil {
+			return v
+		}
+
+		return row["id"]
+	}
+
+	return row[key]
+}
+
+// dataLoaderKey gives ids and plain values a comparable string form.
+func dataLoaderKey(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bson.ObjectID:
+		return v.Hex()
+	case *bson.ObjectID:
+		if v == nil {
+			return ""
+		}
+		return v.Hex()
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+
+	return fmt.Sprint(value)
+}
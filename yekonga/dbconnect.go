@@ -2,9 +2,14 @@ package yekonga
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/robertkonga/yekonga-server-go/config"
 	"github.com/robertkonga/yekonga-server-go/datatype"
@@ -13,6 +18,8 @@ import (
 	localDB "github.com/robertkonga/yekonga-server-go/plugins/database/db"
 	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/mongo"
 	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/mongo/options"
+	"github.com/robertkonga/yekonga-server-go/plugins/mysql"
+	pq "github.com/robertkonga/yekonga-server-go/plugins/postgres"
 )
 
 var graphqlOperations = []string{
@@ -40,6 +47,12 @@ var graphqlBooleanOperations = []string{
 	"exists",
 }
 
+var graphqlGeoOperations = []string{
+	"near",
+	"withinBox",
+	"withinPolygon",
+}
+
 var mongodbSpecialOperations = []string{
 	"$type",
 }
@@ -49,6 +62,7 @@ type dataModelQueryStructure interface {
 	findAll() *[]datatype.DataMap
 	find() *[]datatype.DataMap
 	pagination() *datatype.DataMap
+	seek(order []queryOrder, values []interface{}, limit int) *[]datatype.DataMap
 	summary() *datatype.DataMap
 	count() int64
 	max(string) interface{}
@@ -65,12 +79,13 @@ type dataModelQueryStructure interface {
 }
 
 type DatabaseConnections struct {
-	config        *config.YekongaConfig
-	appPath       string
-	mongodbClient *mongo.Client
-	localClient   *localDB.DB
-	mysqlClient   *sql.DB
-	sqlClient     *sql.DB
+	config         *config.YekongaConfig
+	appPath        string
+	mongodbClient  *mongo.Client
+	localClient    *localDB.DB
+	mysqlClient    *sql.DB
+	postgresClient *sql.DB
+	sqlClient      *sql.DB
 }
 
 func NewDatabaseConnections(config *config.YekongaConfig) *DatabaseConnections {
@@ -86,6 +101,8 @@ func (dc *DatabaseConnections) connect() {
 		dc.mongodbConnect()
 	} else if dc.config.Database.Kind == config.DBTypeMysql {
 		dc.mysqlConnect()
+	} else if dc.config.Database.Kind == config.DBTypePostgres {
+		dc.postgresConnect()
 	} else if dc.config.Database.Kind == config.DBTypeSql {
 		dc.sqlConnect()
 	} else {
@@ -94,11 +111,46 @@ func (dc *DatabaseConnections) connect() {
 
 }
 
+// migrate brings the database schema in line with the models.
+func (dc *DatabaseConnections) migrate(models map[string]*DataModel) {
+	if dc.config.Database.AutoMigrate != nil && !*dc.config.Database.AutoMigrate {
+		return
+	}
+
+	if dc.config.Database.Kind == config.DBTypeMongodb {
+		dc.mongodbMigrate(models)
+	} else if dc.config.Database.Kind == config.DBTypeMysql {
+		dc.mysqlMigrate(models)
+	} else if dc.config.Database.Kind == config.DBTypePostgres {
+		dc.postgresMigrate(models)
+	} else if dc.config.Database.Kind != config.DBTypeSql {
+		dc.localMigrate(models)
+	}
+}
+
+// migrateModel brings the schema of one model in line, whether or not the
+// database migrates on startup. It is used by the migrations.
+func (dc *DatabaseConnections) migrateModel(model *DataModel) error {
+	if dc.config.Database.Kind == config.DBTypeMongodb {
+		return dc.mongodbMigrateIndexes(model)
+	} else if dc.config.Database.Kind == config.DBTypeMysql {
+		return dc.mysqlMigrateModel(model)
+	} else if dc.config.Database.Kind == config.DBTypePostgres {
+		return dc.postgresMigrateModel(model)
+	} else if dc.config.Database.Kind != config.DBTypeSql {
+		return dc.localMigrateIndexes(model)
+	}
+
+	return nil
+}
+
 func (dc *DatabaseConnections) close() {
 	if dc.config.Database.Kind == config.DBTypeMongodb {
 		dc.mongodbClose()
 	} else if dc.config.Database.Kind == config.DBTypeMysql {
 		dc.mysqlClose()
+	} else if dc.config.Database.Kind == config.DBTypePostgres {
+		dc.postgresClose()
 	} else if dc.config.Database.Kind == config.DBTypeSql {
 		dc.sqlClose()
 	} else {
@@ -167,7 +219,19 @@ func (dc *DatabaseConnections) mongodbConnect() {
 
 func (dc *DatabaseConnections) localConnect() {
 	dbPath := dc.appPath + string(os.PathSeparator) + "database"
-	client, err := localDB.OpenDB(dbPath)
+	options := localDB.DefaultOptions()
+	switch policy := localDB.SyncPolicy(dc.config.Database.LocalSync); policy {
+	case localDB.SyncAlways, localDB.SyncInterval, localDB.SyncNever:
+		options.Sync = policy
+	case "":
+	default:
+		logger.Warn("Unknown localSync "+string(policy)+", the local database flushes every write", "always, interval or never")
+	}
+	if dc.config.Database.LocalCheckpoint > 0 {
+		options.CheckpointInterval = time.Duration(dc.config.Database.LocalCheckpoint) * time.Minute
+	}
+
+	client, err := localDB.OpenDBWithOptions(dbPath, options)
 
 	if err != nil {
 		logger.Error("Could not connect to LocalDatabase", err)
@@ -179,6 +243,103 @@ func (dc *DatabaseConnections) localConnect() {
 }
 
 func (dc *DatabaseConnections) mysqlConnect() {
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = dc.address("3306")
+	cfg.DBName = dc.config.Database.DatabaseName
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	cfg.Collation = "utf8mb4_unicode_ci"
+
+	if v, ok := dc.config.Database.Username.(string); ok {
+		cfg.User = v
+	}
+
+	if v, ok := dc.config.Database.Password.(string); ok {
+		cfg.Passwd = v
+	}
+
+	if dc.config.Database.SSL {
+		tlsConfig, err := dc.tlsConfig()
+		if err != nil {
+			logger.Error("Could not load MySQL TLS configuration", err)
+			return
+		}
+
+		cfg.TLS = tlsConfig
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		logger.Error("Could not connect to MySQL", err)
+		return
+	}
+
+	client := sql.OpenDB(connector)
+	dc.setPool(client)
+
+	if err := client.Ping(); err != nil {
+		logger.Error("Could not connect to MySQL", err)
+	} else {
+		logger.Success("Connected to MySQL!")
+	}
+
+	dc.mysqlClient = client
+}
+
+func (dc *DatabaseConnections) postgresConnect() {
+	host, port, _ := net.SplitHostPort(dc.address("5432"))
+	sslMode := "disable"
+
+	if dc.config.Database.SSL {
+		sslMode = "verify-full"
+		if dc.config.Database.SSLSkipVerify {
+			sslMode = "require"
+		}
+	}
+
+	params := [][2]string{
+		{"host", host},
+		{"port", port},
+		{"dbname", dc.config.Database.DatabaseName},
+		{"sslmode", sslMode},
+		{"sslrootcert", dc.config.Database.SSLCA},
+		{"sslcert", dc.config.Database.SSLCert},
+		{"sslkey", dc.config.Database.SSLKey},
+	}
+
+	if v, ok := dc.config.Database.Username.(string); ok {
+		params = append(params, [2]string{"user", v})
+	}
+
+	if v, ok := dc.config.Database.Password.(string); ok {
+		params = append(params, [2]string{"password", v})
+	}
+
+	dsn := make([]string, 0, len(params))
+	for _, p := range params {
+		if helper.IsNotEmpty(p[1]) {
+			value := strings.ReplaceAll(strings.ReplaceAll(p[1], `\`, `\\`), `'`, `\'`)
+			dsn = append(dsn, fmt.Sprintf("%s='%s'", p[0], value))
+		}
+	}
+
+	connector, err := pq.NewConnector(strings.Join(dsn, " "))
+	if err != nil {
+		logger.Error("Could not connect to PostgreSQL", err)
+		return
+	}
+
+	client := sql.OpenDB(connector)
+	dc.setPool(client)
+
+	if err := client.Ping(); err != nil {
+		logger.Error("Could not connect to PostgreSQL", err)
+	} else {
+		logger.Success("Connected to PostgreSQL!")
+	}
+
+	dc.postgresClient = client
 }
 
 func (dc *DatabaseConnections) sqlConnect() {
@@ -194,6 +355,93 @@ func (dc *DatabaseConnections) localClose() {
 }
 
 func (dc *DatabaseConnections) mysqlClose() {
+	if dc.mysqlClient == nil {
+		return
+	}
+
+	if err := dc.mysqlClient.Close(); err != nil {
+		logger.Warn("MySQL disconnected", err)
+	}
+}
+
+// address joins the configured host and port, falling back to defaultPort.
+func (dc *DatabaseConnections) address(defaultPort string) string {
+	host := dc.config.Database.Host
+	port := dc.config.Database.Port
+
+	if helper.IsEmpty(host) {
+		host = "127.0.0.1"
+	}
+
+	if helper.IsEmpty(port) {
+		port = defaultPort
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+func (dc *DatabaseConnections) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         dc.config.Database.Host,
+		InsecureSkipVerify: dc.config.Database.SSLSkipVerify,
+	}
+
+	if helper.IsNotEmpty(dc.config.Database.SSLCA) {
+		pem, err := os.ReadFile(dc.config.Database.SSLCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", dc.config.Database.SSLCA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if helper.IsNotEmpty(dc.config.Database.SSLCert) && helper.IsNotEmpty(dc.config.Database.SSLKey) {
+		cert, err := tls.LoadX509KeyPair(dc.config.Database.SSLCert, dc.config.Database.SSLKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (dc *DatabaseConnections) setPool(client *sql.DB) {
+	maxOpen := dc.config.Database.MaxOpenConns
+	maxIdle := dc.config.Database.MaxIdleConns
+	lifetime := dc.config.Database.ConnMaxLifetime
+
+	if maxOpen <= 0 {
+		maxOpen = 25
+	}
+
+	if maxIdle <= 0 {
+		maxIdle = 5
+	}
+
+	if lifetime <= 0 {
+		lifetime = 30
+	}
+
+	client.SetMaxOpenConns(maxOpen)
+	client.SetMaxIdleConns(maxIdle)
+	client.SetConnMaxLifetime(time.Duration(lifetime) * time.Minute)
+}
+
+func (dc *DatabaseConnections) postgresClose() {
+	if dc.postgresClient == nil {
+		return
+	}
+
+	if err := dc.postgresClient.Close(); err != nil {
+		logger.Warn("PostgreSQL disconnected", err)
+	}
 }
 
 func (dc *DatabaseConnections) sqlClose() {
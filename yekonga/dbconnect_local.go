@@ -1,15 +1,22 @@
 package yekonga
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/helper"
 	"github.com/robertkonga/yekonga-server-go/helper/logger"
 	localDB "github.com/robertkonga/yekonga-server-go/plugins/database/db"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
 )
 
 type localDbConnection struct {
@@ -27,6 +34,7 @@ func newLocalDBInstance(con *localDbConnection) localDbConnection {
 			Model:          con.query.Model,
 			RequestContext: con.query.RequestContext,
 			QueryContext:   con.query.QueryContext,
+			tx:             con.query.tx,
 		},
 	}
 }
@@ -57,11 +65,11 @@ func (con *localDbConnection) findAll() *[]datatype.DataMap {
 }
 
 func (con *localDbConnection) find() *[]datatype.DataMap {
-	var ids map[int]struct{} = make(map[int]struct{})
 	var result []datatype.DataMap = make([]datatype.DataMap, 0, 10)
-	var where = con.conditionParams()
+	var distances map[int]float64
 
-	localDB.EvalQuery(where, con.collection(), &ids)
+	ids, scores := con.matchIDs(true)
+	near, isNear := con.query.nearest()
 
 	// Convert ids to slice for ordering
 	idSlice := make([]int, 0, len(ids))
@@ -69,9 +77,34 @@ func (con *localDbConnection) find() *[]datatype.DataMap {
 		idSlice = append(idSlice, id)
 	}
 
+	if isNear && scores == nil {
+		distances = make(map[int]float64, len(idSlice))
+		for _, id := range idSlice {
+			if data, err := con.collection().Read(id); err == nil {
+				if point, ok := toGeoPoint(data[near.Field]); ok {
+					distances[id] = geoDistance(near.Point, point)
+				}
+			}
+		}
+	}
+
 	// Sort if needed
-	if con.hasOrderBy() {
-		con.sortIDs(&idSlice, &result)
+	if scores != nil {
+		sort.SliceStable(idSlice, func(a, b int) bool {
+			if scores[idSlice[a]] != scores[idSlice[b]] {
+				return scores[idSlice[a]] > scores[idSlice[b]]
+			}
+			return idSlice[a] < idSlice[b]
+		})
+	} else if distances != nil {
+		sort.SliceStable(idSlice, func(a, b int) bool {
+			if distances[idSlice[a]] != distances[idSlice[b]] {
+				return distances[idSlice[a]] < distances[idSlice[b]]
+			}
+			return idSlice[a] < idSlice[b]
+		})
+	} else if con.hasOrderBy() {
+		idSlice = con.sortIDs(ids)
 	}
 
 	// Apply limit and skip
@@ -97,13 +130,133 @@ func (con *localDbConnection) find() *[]datatype.DataMap {
 			data["id"] = idSlice[i]
 			data["_collection"] = con.query.Model.Collection
 			data["_model"] = con.query.Model.Name
+			if scores != nil {
+				data[SearchScoreKey] = scores[idSlice[i]]
+			}
 			result = append(result, data)
 		}
 	}
 
+	if isNear {
+		near.addDistance(result)
+	}
+
 	return &result
 }
 
+// matchIDs evaluates the where, the text search and the geo filters, which
+// the query engine of the store does not know about. The limit is left to
+// the caller when the records are filtered, ranked or sorted after the query.
+func (con *localDbConnection) matchIDs(keepLimit bool) (map[int]struct{}, map[int]float64) {
+	var ids map[int]struct{} = make(map[int]struct{})
+	var scores map[int]float64
+	var where = con.conditionParams()
+
+	if !keepLimit || con.searching() || con.hasGeoFilter() || con.hasOrderBy() {
+		if w, ok := where.(map[string]interface{}); ok {
+			delete(w, "limit")
+		}
+	}
+
+	localDB.EvalQuery(where, con.collection(), &ids)
+
+	if con.searching() {
+		scores = con.search(ids)
+	}
+
+	con.geoFilter(ids)
+
+	return ids, scores
+}
+
+func (con *localDbConnection) searching() bool {
+	return helper.IsNotEmpty(con.query.search) && len(con.query.Model.SearchFields) > 0
+}
+
+// search keeps the ids that contain words of the search text and returns
+// their relevance. The text index is created on first use when the store was
+// not migrated.
+func (con *localDbConnection) search(ids map[int]struct{}) map[int]float64 {
+	fields := con.query.Model.SearchFields
+	collection := con.collection()
+
+	scores, err := collection.TextSearch(fields, con.query.search)
+	if err != nil {
+		if err = collection.TextIndex(fields); err == nil {
+			scores, err = collection.TextSearch(fields, con.query.search)
+		}
+	}
+
+	if err != nil {
+		logger.Error("localDbConnection.search", err.Error())
+		scores = map[int]float64{}
+	}
+
+	for id := range ids {
+		if _, ok := scores[id]; !ok {
+			delete(ids, id)
+		}
+	}
+	for id := range scores {
+		if _, ok := ids[id]; !ok {
+			delete(scores, id)
+		}
+	}
+
+	return scores
+}
+
+func (con *localDbConnection) hasGeoFilter() bool {
+	for _, k := range con.query.Model.GeoFields {
+		if condition, ok := con.query.where[k]; ok && helper.IsMap(condition) {
+			for op := range helper.ToDataMap(condition) {
+				if helper.Contains(graphqlGeoOperations, op) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// geoFilter keeps the ids whose geo fields match the geo operators of the
+// where. A geo index narrows down the records that are read.
+func (con *localDbConnection) geoFilter(ids map[int]struct{}) {
+	collection := con.collection()
+
+	for _, k := range con.query.Model.GeoFields {
+		condition, ok := con.query.where[k]
+		if !ok || !helper.IsMap(condition) {
+			continue
+		}
+
+		for op, value := range helper.ToDataMap(condition) {
+			if !helper.Contains(graphqlGeoOperations, op) {
+				continue
+			}
+
+			if sw, ne, ok := geoBounds(op, value); ok && collection.HasGeoIndex(k) {
+				candidates, err := collection.GeoScan(k, sw.Latitude, sw.Longitude, ne.Latitude, ne.Longitude)
+				if err == nil {
+					for id := range ids {
+						if _, ok := candidates[id]; !ok {
+							delete(ids, id)
+						}
+					}
+				}
+			}
+
+			for id := range ids {
+				data, err := collection.Read(id)
+				if err != nil || !geoMatches(op, value, data[k]) {
+					delete(ids, id)
+				}
+			}
+		}
+	}
+}
+
 func (con *localDbConnection) pagination() *datatype.DataMap {
 	var lastPage int64 = 1
 	total := con.count()
@@ -137,21 +290,145 @@ func (con *localDbConnection) pagination() *datatype.DataMap {
 	return &result
 }
 
+// seek sorts the matching records in memory and returns the ones after the
+// cursor values.
+func (con *localDbConnection) seek(order []queryOrder, values []interface{}, limit int) *[]datatype.DataMap {
+	ids, _ := con.matchIDs(false)
+
+	model := con.query.Model
+	rows := make([]datatype.DataMap, 0, len(ids))
+	positions := make([][]interface{}, 0, len(ids))
+
+	for id := range ids {
+		data, err := con.collection().Read(id)
+		if err != nil {
+			continue
+		}
+
+		position := make([]interface{}, len(order))
+		for i, o := range order {
+			position[i] = model.cursorValue(o.Field, data[o.Field])
+		}
+
+		if len(values) > 0 && model.cursorCompare(data, values, order) <= 0 {
+			continue
+		}
+
+		data["id"] = id
+		data["_collection"] = model.Collection
+		data["_model"] = model.Name
+		rows = append(rows, data)
+		positions = append(positions, position)
+	}
+
+	index := make([]int, len(rows))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		return model.cursorCompare(rows[index[a]], positions[index[b]], order) < 0
+	})
+
+	result := make([]datatype.DataMap, 0, limit)
+	for _, i := range index {
+		if len(result) == limit {
+			break
+		}
+		result = append(result, rows[i])
+	}
+
+	return &result
+}
+
+// summary counts the filtered records and sums the number fields, with their
+// largest, smallest and average values. The sums and bounds are keyed by
+// field.
 func (con *localDbConnection) summary() *datatype.DataMap {
+	fields := con.query.Model.NumberFields
+	sums := datatype.DataMap{}
+	maxes := datatype.DataMap{}
+	mins := datatype.DataMap{}
+	averages := datatype.DataMap{}
+	counts := make(map[string]int, len(fields))
+	var count int64
+
+	for _, k := range fields {
+		sums[k] = 0.0
+		maxes[k] = nil
+		mins[k] = nil
+		averages[k] = 0.0
+	}
+
+	con.eachMatch(func(doc datatype.DataMap) {
+		count++
+
+		for _, k := range fields {
+			v, ok := doc[k]
+			if !ok || v == nil {
+				continue
+			}
+
+			value := helper.ToFloat64(v)
+			if counts[k] == 0 || value > helper.ToFloat64(maxes[k]) {
+				maxes[k] = v
+			}
+			if counts[k] == 0 || value < helper.ToFloat64(mins[k]) {
+				mins[k] = v
+			}
+			sums[k] = helper.ToFloat64(sums[k]) + value
+			counts[k]++
+		}
+	})
+
+	for _, k := range fields {
+		if counts[k] > 0 {
+			averages[k] = helper.ToFloat64(sums[k]) / float64(counts[k])
+		}
+	}
+
 	result := datatype.DataMap{
-		"count": con.count(),
-		"sum":   con.sum(""),
-		"max":   con.max(""),
-		"min":   con.min(""),
-		"graph": datatype.DataMap{},
+		"count":   count,
+		"sum":     sums,
+		"max":     maxes,
+		"min":     mins,
+		"average": averages,
+		"graph":   *con.graph(),
 	}
 
 	return &result
 }
 
+// eachMatch passes every record matching the filter to fn.
+func (con *localDbConnection) eachMatch(fn func(doc datatype.DataMap)) {
+	if con.searching() || con.hasGeoFilter() || len(con.query.where) > 0 {
+		ids, _ := con.matchIDs(false)
+		for id := range ids {
+			if doc, err := con.collection().Read(id); err == nil {
+				fn(doc)
+			}
+		}
+
+		return
+	}
+
+	con.collection().ForEachDoc(func(id int, data []byte) bool {
+		var doc datatype.DataMap
+		if err := json.Unmarshal(data, &doc); err == nil {
+			fn(doc)
+		}
+		return true
+	})
+}
+
 func (con *localDbConnection) count() int64 {
 	var count int64 = 0
 
+	if con.searching() || con.hasGeoFilter() || len(con.query.where) > 0 {
+		ids, _ := con.matchIDs(false)
+
+		return int64(len(ids))
+	}
+
 	con.collection().ForEachDoc(func(id int, data []byte) bool {
 		count++
 		return true
@@ -255,8 +532,26 @@ func (con *localDbConnection) average(key string) float64 {
 	return 0
 }
 
+// graph counts the filtered records per value of the group by fields, keyed
+// by the values joined with a comma. Without group by fields it is empty.
 func (con *localDbConnection) graph() *datatype.DataMap {
-	return &datatype.DataMap{}
+	result := datatype.DataMap{}
+	if len(con.query.groupBy) == 0 {
+		return &result
+	}
+
+	con.eachMatch(func(doc datatype.DataMap) {
+		keys := make([]string, 0, len(con.query.groupBy))
+		for _, k := range con.query.groupBy {
+			keys = append(keys, helper.ToString(doc[k]))
+		}
+
+		key := strings.Join(keys, ",")
+		count, _ := result[key].(int64)
+		result[key] = count + 1
+	})
+
+	return &result
 }
 
 func (con *localDbConnection) create(data datatype.DataMap) (*datatype.DataMap, error) {
@@ -264,6 +559,7 @@ func (con *localDbConnection) create(data datatype.DataMap) (*datatype.DataMap,
 	if err != nil {
 		return nil, err
 	}
+	con.undoInsert(id)
 
 	createdRecord := newLocalDBInstance(con).query.Where("id", id).FindOne(nil)
 	return createdRecord, nil
@@ -275,6 +571,7 @@ func (con *localDbConnection) createMany(data []datatype.DataMap) (*[]datatype.D
 	for _, d := range data {
 		id, err := con.collection().Insert(d)
 		if err == nil {
+			con.undoInsert(id)
 			record := newLocalDBInstance(con).query.Where("id", id).FindOne(nil)
 			if record != nil {
 				result = append(result, *record)
@@ -298,20 +595,32 @@ func (con *localDbConnection) update(data datatype.DataMap) (*datatype.DataMap,
 		return nil, errors.New("invalid id type")
 	}
 
-	// Merge existing data with new data
+	// Merge existing data with new data, under the lock so the version
+	// check and the write are atomic
+	localWriteLock.Lock()
 	doc, err := con.collection().Read(idInt)
 	if err != nil {
+		localWriteLock.Unlock()
 		return nil, err
 	}
+	previous := copyLocalDoc(doc)
+
+	if version, checked := con.query.versionCheck(); checked && helper.ToInt(doc[VersionKey]) != version {
+		localWriteLock.Unlock()
+		return nil, errVersionConflict
+	}
 
 	for k, v := range data {
 		doc[k] = v
 	}
+	con.incrementVersion(doc)
 
 	err = con.collection().Update(idInt, doc)
+	localWriteLock.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	con.undoUpdate(idInt, previous)
 
 	updatedRecord := newLocalDBInstance(con).query.Where("id", idInt).FindOne(nil)
 	return updatedRecord, nil
@@ -331,22 +640,36 @@ func (con *localDbConnection) updateMany(data datatype.DataMap) (*[]datatype.Dat
 			continue
 		}
 
+		localWriteLock.Lock()
 		doc, err := con.collection().Read(idInt)
 		if err != nil {
+			localWriteLock.Unlock()
 			continue
 		}
+		previous := copyLocalDoc(doc)
 
 		for k, v := range data {
 			doc[k] = v
 		}
+		con.incrementVersion(doc)
 
-		con.collection().Update(idInt, doc)
+		if con.collection().Update(idInt, doc) == nil {
+			con.undoUpdate(idInt, previous)
+		}
+		localWriteLock.Unlock()
 	}
 
 	updatedRecords := newLocalDBInstance(con).query.collection().find()
 	return updatedRecords, nil
 }
 
+// incrementVersion moves a record of a versioned model to its next version.
+func (con *localDbConnection) incrementVersion(doc map[string]interface{}) {
+	if con.query.Model.Versioned {
+		doc[VersionKey] = helper.ToInt(doc[VersionKey]) + 1
+	}
+}
+
 func (con *localDbConnection) delete() (interface{}, error) {
 	results := con.find()
 	if results == nil || len(*results) == 0 {
@@ -361,15 +684,62 @@ func (con *localDbConnection) delete() (interface{}, error) {
 			continue
 		}
 
+		var previous map[string]interface{}
+		if con.query.tx != nil {
+			previous, _ = con.collection().Read(idInt)
+		}
+
 		err := con.collection().Delete(idInt)
 		if err == nil {
 			deletedCount++
+			if previous != nil {
+				con.undoDelete(idInt, previous)
+			}
 		}
 	}
 
 	return deletedCount, nil
 }
 
+// undoInsert, undoUpdate and undoDelete record the inverse of a write in
+// the undo log of the transaction, the local database has no rollback of
+// its own. Writes outside a transaction are not recorded.
+func (con *localDbConnection) undoInsert(id int) {
+	if tx := con.query.tx; tx != nil {
+		col := con.collection()
+		tx.addUndo(func() error {
+			return col.Delete(id)
+		})
+	}
+}
+
+func (con *localDbConnection) undoUpdate(id int, previous map[string]interface{}) {
+	if tx := con.query.tx; tx != nil {
+		col := con.collection()
+		tx.addUndo(func() error {
+			return col.Update(id, previous)
+		})
+	}
+}
+
+func (con *localDbConnection) undoDelete(id int, previous map[string]interface{}) {
+	if tx := con.query.tx; tx != nil {
+		col := con.collection()
+		tx.addUndo(func() error {
+			return col.Restore(id, previous)
+		})
+	}
+}
+
+func copyLocalDoc(doc map[string]interface{}) map[string]interface{} {
+	previous := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		previous[k] = v
+	}
+
+	return previous
+}
+
 func (con *localDbConnection) selection() *[]string {
 	return &[]string{}
 }
@@ -378,50 +748,152 @@ func (con *localDbConnection) hasOrderBy() bool {
 	return len(con.query.orderBy) > 0
 }
 
-func (con *localDbConnection) sortIDs(ids *[]int, result *[]datatype.DataMap) {
-	if !con.hasOrderBy() {
-		return
+// sortIDs puts the ids in the order of the order by fields. With a sorted
+// index on the first field the index is walked in order, and the walk stops
+// once the records of the page are found; the records that tie on the first
+// field are sorted on the other fields in memory. Without the index all
+// records are sorted in memory.
+func (con *localDbConnection) sortIDs(ids map[int]struct{}) []int {
+	order := con.query.ordering()
+	collection := con.collection()
+
+	need := -1
+	if con.limit() > 0 {
+		need = con.limit()
+		if con.skip() > 0 {
+			need += con.skip()
+		}
 	}
 
-	// Load data for all IDs first
-	dataMap := make(map[int]datatype.DataMap)
-	for _, id := range *ids {
-		data, err := con.collection().Read(id)
-		if err == nil {
-			dataMap[id] = data
+	var groups [][]int
+	rest := order
+	if collection.HasSortedIndex(order[0].Field) {
+		rest = order[1:]
+		seen := make(map[int]bool, len(ids))
+		found := 0
+		var groupKey []byte
+
+		collection.SortedScan(order[0].Field, order[0].Descending, func(key []byte, id int) bool {
+			if _, ok := ids[id]; !ok || seen[id] {
+				return true
+			}
+			if len(groups) == 0 || !bytes.Equal(key, groupKey) {
+				if need >= 0 && found >= need {
+					return false
+				}
+				groups = append(groups, []int{})
+				groupKey = key
+			}
+
+			seen[id] = true
+			groups[len(groups)-1] = append(groups[len(groups)-1], id)
+			found++
+			return true
+		})
+	} else {
+		all := make([]int, 0, len(ids))
+		for id := range ids {
+			all = append(all, id)
+		}
+		sort.Ints(all)
+		groups = append(groups, all)
+	}
+
+	result := make([]int, 0, len(ids))
+	for _, group := range groups {
+		if len(group) > 1 && len(rest) > 0 {
+			con.sortGroup(group, rest)
 		}
+		result = append(result, group...)
 	}
 
-	// Sort by order by fields
-	// Note: This is a simplified sort, may need enhancement for complex sorting
-	for k, v := range con.query.orderBy {
-		isDesc := v == "desc"
-		_ = k
-		_ = isDesc
-		// Sort logic would go here based on field k and direction
+	return result
+}
+
+// sortGroup sorts the ids on the order by fields in memory.
+func (con *localDbConnection) sortGroup(ids []int, order []queryOrder) {
+	model := con.query.Model
+	rows := make(map[int]datatype.DataMap, len(ids))
+	positions := make(map[int][]interface{}, len(ids))
+
+	for _, id := range ids {
+		data, err := con.collection().Read(id)
+		if err != nil {
+			data = map[string]interface{}{}
+		}
+		data["id"] = id
+
+		position := make([]interface{}, len(order))
+		for i, o := range order {
+			position[i] = model.cursorValue(o.Field, data[o.Field])
+		}
+		rows[id] = data
+		positions[id] = position
 	}
+
+	sort.SliceStable(ids, func(a, b int) bool {
+		return model.cursorCompare(rows[ids[a]], positions[ids[b]], order) < 0
+	})
 }
 
 func (con *localDbConnection) conditionParams() interface{} {
-	var params = datatype.DataMap{}
+	var params = map[string]interface{}{}
 	var where = con.where()
 
-	if w, ok := where.(map[string][]datatype.DataMap); ok {
+	if w, ok := where.(map[string][]interface{}); ok {
 		for k, v := range w {
-			params[k] = v
+			params[k] = localQueryValue(v)
 		}
 	}
 
-	if con.limit() > 0 {
+	// The query engine has no skip, it takes the first records it finds
+	if con.limit() > 0 && con.skip() <= 0 {
 		params["limit"] = con.limit()
 	}
 
 	return params
 }
 
+// localQueryValue converts a condition into the plain maps and lists the
+// query engine of the store reads.
+func localQueryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.ObjectID:
+		return v.Hex()
+	case datatype.DataMap:
+		return localQueryValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, vi := range v {
+			result[k] = localQueryValue(vi)
+		}
+		return result
+	}
+
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			result[i] = localQueryValue(rv.Index(i).Interface())
+		}
+		return result
+	}
+
+	return value
+}
+
+// localRangeValue converts the value of a comparison: numbers and dates are
+// compared as such, other strings as text.
+func localRangeValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok && !helper.IsNumeric(s) && helper.StringToDatetime(s) == nil {
+		return s
+	}
+
+	return helper.ConvertCalculatedValue(value)
+}
+
 func (con *localDbConnection) where() interface{} {
-	var filters = map[string][]datatype.DataMap{
-		"and": []datatype.DataMap{},
+	var filters = map[string][]interface{}{
+		"and": []interface{}{},
 	}
 	operations := [...]string{
 		"equalTo",
@@ -453,12 +925,20 @@ func (con *localDbConnection) where() interface{} {
 		// logger.Info("where", 2)
 		for k, v := range con.query.where {
 			// logger.Info("where", 3)
+			// An int id is the document ID of the store, not the _id field
+			if id, ok := v.(int); ok && k == "_id" {
+				filters["and"] = append(filters["and"], strconv.Itoa(id))
+				continue
+			}
 
 			if vi, ok := v.(datatype.DataMap); ok {
 				// logger.Info("where", 4)
 				for ki, vii := range vi {
 					// logger.Info("where", 5)
-					if helper.Contains(operations[:], ki) ||
+					if helper.Contains(graphqlGeoOperations, ki) {
+						// Applied by geoFilter.
+						continue
+					} else if helper.Contains(operations[:], ki) ||
 						helper.Contains(arrayOperations[:], ki) ||
 						helper.Contains(booleanOperations[:], ki) {
 						// logger.Info("where", 6)
@@ -476,14 +956,14 @@ func (con *localDbConnection) where() interface{} {
 								},
 							})
 						case "lessThan":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"lt": viii,
 								"in": []interface{}{k},
 							})
 						case "notLessThan":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"not": datatype.DataMap{
@@ -492,13 +972,13 @@ func (con *localDbConnection) where() interface{} {
 								},
 							})
 						case "lessThanOrEqualTo":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"lte": viii,
 								"in":  []interface{}{k},
 							})
 						case "notLessThanOrEqualTo":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"not": datatype.DataMap{
 									"lte": viii,
@@ -506,13 +986,13 @@ func (con *localDbConnection) where() interface{} {
 								},
 							})
 						case "greaterThan":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"gt": viii,
 								"in": []interface{}{k},
 							})
 						case "notGreaterThan":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"not": datatype.DataMap{
 									"gt": viii,
@@ -520,13 +1000,13 @@ func (con *localDbConnection) where() interface{} {
 								},
 							})
 						case "greaterThanOrEqualTo":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"gte": viii,
 								"in":  []interface{}{k},
 							})
 						case "notGreaterThanOrEqualTo":
-							viii := helper.ConvertCalculatedValue(vii)
+							viii := localRangeValue(vii)
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"not": datatype.DataMap{
 									"gte": viii,
@@ -548,10 +1028,8 @@ func (con *localDbConnection) where() interface{} {
 						case "notIn":
 							// filters[k]["$nin"] = vii
 							filters["and"] = append(filters["and"], datatype.DataMap{
-								"not": datatype.DataMap{
-									"$nin": vii,
-									"in":   []interface{}{k},
-								},
+								"$nin": vii,
+								"in":   []interface{}{k},
 							})
 						case "exists":
 							if exists, ok := vii.(bool); ok {
@@ -576,14 +1054,11 @@ func (con *localDbConnection) where() interface{} {
 							}
 						case "matchesRegex":
 							filters["and"] = append(filters["and"], datatype.DataMap{
-								"has": vii,
-								"in":  []interface{}{k},
+								"regex": localRegex(vii, vi["options"]),
+								"in":    []interface{}{k},
 							})
 						case "options":
-							filters["and"] = append(filters["and"], datatype.DataMap{
-								"eq": vii,
-								"in": []interface{}{k},
-							})
+							// Applied with matchesRegex.
 						case "text":
 							filters["and"] = append(filters["and"], datatype.DataMap{
 								"eq": vii,
@@ -617,6 +1092,25 @@ func (con *localDbConnection) where() interface{} {
 	return filters
 }
 
+// localRegex adds the options of a matchesRegex the regexp package knows
+// as flags to the pattern.
+func localRegex(pattern interface{}, options interface{}) string {
+	flags := ""
+	if options != nil {
+		for _, o := range fmt.Sprint(options) {
+			if strings.ContainsRune("ims", o) && !strings.ContainsRune(flags, o) {
+				flags += string(o)
+			}
+		}
+	}
+
+	if flags == "" {
+		return fmt.Sprint(pattern)
+	}
+
+	return "(?" + flags + ")" + fmt.Sprint(pattern)
+}
+
 func (con *localDbConnection) groupBy() *[]interface{} {
 	return &[]interface{}{}
 }
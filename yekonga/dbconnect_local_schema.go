@@ -0,0 +1,236 @@
+package yekonga
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+	localDB "github.com/robertkonga/yekonga-server-go/plugins/database/db"
+)
+
+// localMigrate indexes the fields of the model indexes on the local store.
+// The store only has single path indexes, so a compound index becomes one
+// hash index and one sorted index per field; the sorted index serves range
+// conditions and the order by. The text index is an inverted index of the
+// words of the search fields and a geo index a grid of geohash cells.
+func (dc *DatabaseConnections) localMigrate(models map[string]*DataModel) {
+	if dc.localClient == nil {
+		return
+	}
+
+	names := make([]string, 0, len(models))
+	for k := range models {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := dc.localMigrateIndexes(models[name]); err != nil {
+			logger.Error("LocalDatabase migration failed for "+models[name].Collection, err)
+		}
+	}
+}
+
+func (dc *DatabaseConnections) localMigrateIndexes(model *DataModel) error {
+	if !dc.localClient.ColExists(model.Collection) {
+		if err := dc.localClient.Create(model.Collection); err != nil {
+			return err
+		}
+	}
+
+	collection := dc.localClient.Use(model.Collection)
+
+	existing := make(map[string]existingIndex)
+	for _, path := range collection.AllIndexes() {
+		name := strings.Join(path, ".")
+		existing[name] = existingIndex{Fields: []string{name}}
+	}
+
+	declared := make([]DataModelIndex, 0, len(model.Indexes))
+	seen := map[string]bool{}
+	for _, index := range model.Indexes {
+		if index.Text {
+			declared = append(declared, DataModelIndex{
+				Name:   localDB.TEXT_INDEX_PREFIX + "." + strings.Join(index.fieldNames(), "."),
+				Fields: index.Fields,
+				Text:   true,
+			})
+			continue
+		}
+
+		if index.Geo {
+			declared = append(declared, DataModelIndex{
+				Name:   localDB.GEO_INDEX_PREFIX + "." + index.Fields[0].Name,
+				Fields: index.Fields,
+				Geo:    true,
+			})
+			continue
+		}
+
+		for _, f := range index.Fields {
+			if seen[f.Name] {
+				continue
+			}
+			seen[f.Name] = true
+
+			declared = append(declared, DataModelIndex{
+				Name:   f.Name,
+				Fields: []DataModelIndexField{{Name: f.Name}},
+			}, DataModelIndex{
+				Name:   localDB.SORTED_INDEX_PREFIX + "." + f.Name,
+				Fields: []DataModelIndexField{{Name: f.Name}},
+			})
+		}
+	}
+
+	return reconcileIndexes("LocalDatabase", model.Collection, declared, existing, false, func(index DataModelIndex) error {
+		return collection.Index(strings.Split(index.Name, "."))
+	})
+}
+
+// localMigrationStore runs the migration steps on the local store. Every step
+// rewrites the documents it changes, under the local write lock.
+type localMigrationStore struct {
+	dc *DatabaseConnections
+}
+
+// rewrite passes every document of the collection to the function and saves
+// the ones it changed.
+func (s *localMigrationStore) rewrite(model *DataModel, change func(doc map[string]interface{}) bool) error {
+	if !s.dc.localClient.ColExists(model.Collection) {
+		return nil
+	}
+
+	collection := s.dc.localClient.Use(model.Collection)
+
+	localWriteLock.Lock()
+	defer localWriteLock.Unlock()
+
+	docs := make(map[int]map[string]interface{})
+	collection.ForEachDoc(func(id int, doc []byte) bool {
+		var data map[string]interface{}
+		if err := json.Unmarshal(doc, &data); err == nil && data != nil {
+			docs[id] = data
+		}
+		return true
+	})
+
+	for id, doc := range docs {
+		if !change(doc) {
+			continue
+		}
+
+		if err := collection.Update(id, doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *localMigrationStore) addField(model *DataModel, field DataModelField) error {
+	return nil
+}
+
+func (s *localMigrationStore) renameField(model *DataModel, field string, to string) error {
+	return s.rewrite(model, func(doc map[string]interface{}) bool {
+		value, ok := doc[field]
+		if !ok {
+			return false
+		}
+
+		doc[to] = value
+		delete(doc, field)
+		return true
+	})
+}
+
+func (s *localMigrationStore) dropField(model *DataModel, field string) error {
+	return s.rewrite(model, func(doc map[string]interface{}) bool {
+		if _, ok := doc[field]; !ok {
+			return false
+		}
+
+		delete(doc, field)
+		return true
+	})
+}
+
+func (s *localMigrationStore) changeType(model *DataModel, field DataModelField, convert func(value interface{}) interface{}) error {
+	return s.rewrite(model, func(doc map[string]interface{}) bool {
+		value, ok := doc[field.Name]
+		if !ok || value == nil {
+			return false
+		}
+
+		doc[field.Name] = convert(value)
+		return true
+	})
+}
+
+func (s *localMigrationStore) updateField(model *DataModel, field string, update func(record datatype.DataMap) (interface{}, bool)) error {
+	return s.rewrite(model, func(doc map[string]interface{}) bool {
+		value, ok := update(datatype.DataMap(doc))
+		if !ok {
+			return false
+		}
+
+		doc[field] = value
+		return true
+	})
+}
+
+// dropIndex removes the indexes localMigrateIndexes made for the index. A
+// field index stays while another index of the model starts with the field.
+func (s *localMigrationStore) dropIndex(model *DataModel, index DataModelIndex) error {
+	if !s.dc.localClient.ColExists(model.Collection) {
+		return nil
+	}
+
+	collection := s.dc.localClient.Use(model.Collection)
+
+	paths := make([]string, 0, len(index.Fields))
+	if index.Text {
+		paths = append(paths, localDB.TEXT_INDEX_PREFIX+"."+strings.Join(index.fieldNames(), "."))
+	} else if index.Geo {
+		paths = append(paths, localDB.GEO_INDEX_PREFIX+"."+index.Fields[0].Name)
+	} else {
+		used := map[string]bool{}
+		for _, v := range model.Indexes {
+			if v.Name == index.Name || v.Text || v.Geo {
+				continue
+			}
+
+			for _, f := range v.Fields {
+				used[f.Name] = true
+			}
+		}
+
+		for _, f := range index.Fields {
+			if !used[f.Name] {
+				paths = append(paths, f.Name, localDB.SORTED_INDEX_PREFIX+"."+f.Name)
+			}
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, path := range collection.AllIndexes() {
+		existing[strings.Join(path, ".")] = true
+	}
+
+	for _, path := range paths {
+		if !existing[path] {
+			continue
+		}
+
+		if err := collection.Unindex(strings.Split(path, ".")); err != nil {
+			return err
+		}
+
+		logger.Info("LocalDatabase index dropped", model.Collection+"."+path)
+	}
+
+	return nil
+}
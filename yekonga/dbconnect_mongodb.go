@@ -34,6 +34,7 @@ func newMongodbInstance(con *mongodbConnection) mongodbConnection {
 			Model:            con.query.Model,
 			RequestContext:   con.query.RequestContext,
 			QueryContext:     con.query.QueryContext,
+			tx:               con.query.tx,
 			isAdmin:          con.query.isAdmin,
 			skipBeforeCommit: con.query.skipBeforeCommit,
 		},
@@ -54,16 +55,18 @@ func (con *mongodbConnection) findOne() *datatype.DataMap {
 	var result datatype.DataMap
 
 	opts := options.FindOne()
-	if con.hasOrderBy() {
+	localWhere := con.where()
+	near, isNear := con.nearFilter(localWhere)
+
+	if con.hasOrderBy() && !isNear {
 		opts = opts.SetSort(con.orderBy())
 	}
-	localWhere := con.where()
 
 	// if con.query.Model.Name == "Certificate" {
 	// 	console.Log("mongodbConnection.findOne", "Cursor: %v", localWhere)
 	// }
 
-	res := con.collection().FindOne(context.TODO(), localWhere, opts)
+	res := con.collection().FindOne(*con.ctx, localWhere, opts)
 	err := res.Decode(&result)
 	if err != nil {
 		// logger.Error("mongodbConnection.findOne", err.Error())
@@ -71,6 +74,10 @@ func (con *mongodbConnection) findOne() *datatype.DataMap {
 		result["id"] = result["_id"]
 		result["_collection"] = con.query.Model.Collection
 		result["_model"] = con.query.Model.Name
+
+		if isNear {
+			near.addDistance([]datatype.DataMap{result})
+		}
 	}
 
 	return &result
@@ -113,7 +120,7 @@ func (con *mongodbConnection) find() *[]datatype.DataMap {
 
 		// console.Log("mongodbConnection.find", "Pipeline: %v", pipeline)
 
-		cursor, err = con.collection().Aggregate(context.TODO(), pipeline, opts)
+		cursor, err = con.collection().Aggregate(*con.ctx, pipeline, opts)
 		if err != nil {
 			logger.Error("mongodbConnection.find 0", err.Error())
 		}
@@ -130,18 +137,25 @@ func (con *mongodbConnection) find() *[]datatype.DataMap {
 			opts = opts.SetSkip(int64(con.skip()))
 		}
 
-		if con.hasOrderBy() {
+		filter := con.where()
+		_, isNear := con.nearFilter(filter)
+
+		if con.hasOrderBy() && !isNear {
 			opts = opts.SetSort(con.orderBy())
 		}
 
-		cursor, err = con.collection().Find(context.TODO(), con.where(), opts)
+		if helper.IsNotEmpty(con.query.search) {
+			opts = opts.SetProjection(bson.M{SearchScoreKey: bson.M{"$meta": "textScore"}})
+		}
+
+		cursor, err = con.collection().Find(*con.ctx, filter, opts)
 	}
 
 	if err != nil {
 		logger.Error("mongodbConnection.find", err.Error())
 	}
 
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(*con.ctx)
 
 	// if con.query.Model.Collection == "user_verifications" {
 	// 	console.Log("mongodbConnection.find", "Cursor: %v", con.where())
@@ -152,8 +166,8 @@ func (con *mongodbConnection) find() *[]datatype.DataMap {
 	} else {
 		result := make([]datatype.DataMap, 0, cursor.RemainingBatchLength())
 
-		// cursor.All(context.TODO(), &result)
-		for cursor.Next(context.TODO()) {
+		// cursor.All(*con.ctx, &result)
+		for cursor.Next(*con.ctx) {
 			// To decode into a struct, use cursor.Decode()
 			var data datatype.DataMap
 			err := cursor.Decode(&data)
@@ -178,6 +192,10 @@ func (con *mongodbConnection) find() *[]datatype.DataMap {
 		// 	console.Log("mongodbConnection.find", "Found %d documents", result)
 		// }
 
+		if near, ok := con.query.nearest(); ok {
+			near.addDistance(result)
+		}
+
 		return &result
 	}
 
@@ -214,14 +232,143 @@ func (con *mongodbConnection) pagination() *datatype.DataMap {
 	return &result
 }
 
+// seek finds the records of the filter after the cursor values, sorted by
+// the order.
+func (con *mongodbConnection) seek(order []queryOrder, values []interface{}, limit int) *[]datatype.DataMap {
+	var filter interface{} = con.where()
+
+	if len(values) > 0 {
+		alternatives := make([]interface{}, 0, len(order))
+
+		for i, o := range order {
+			// A null value matches null and missing fields.
+			item := bson.M{}
+			for j := 0; j < i; j++ {
+				item[order[j].Field] = con.seekValue(order[j].Field, values[j])
+			}
+
+			// MongoDB sorts null before every value, comparisons never
+			// match it.
+			switch {
+			case values[i] == nil && o.Descending:
+				// Nothing sorts after a null that comes last.
+				continue
+			case values[i] == nil:
+				item[o.Field] = bson.M{"$ne": nil}
+			case o.Descending:
+				item["$or"] = []interface{}{
+					bson.M{o.Field: bson.M{"$lt": con.seekValue(o.Field, values[i])}},
+					bson.M{o.Field: nil},
+				}
+			default:
+				item[o.Field] = bson.M{"$gt": con.seekValue(o.Field, values[i])}
+			}
+
+			alternatives = append(alternatives, item)
+		}
+
+		if len(alternatives) == 0 {
+			return &[]datatype.DataMap{}
+		}
+
+		filter = bson.M{"$and": []interface{}{filter, bson.M{"$or": alternatives}}}
+	}
+
+	sort := bson.D{}
+	for _, o := range order {
+		value := 1
+		if o.Descending {
+			value = -1
+		}
+		sort = append(sort, bson.E{Key: o.Field, Value: value})
+	}
+
+	opts := options.Find().SetSort(sort).SetLimit(int64(limit))
+
+	cursor, err := con.collection().Find(*con.ctx, filter, opts)
+	if err != nil {
+		logger.Error("mongodbConnection.seek", err.Error())
+		return &[]datatype.DataMap{}
+	}
+	defer cursor.Close(*con.ctx)
+
+	result := make([]datatype.DataMap, 0, limit)
+	for cursor.Next(*con.ctx) {
+		var data datatype.DataMap
+		if err := cursor.Decode(&data); err != nil {
+			logger.Error("mongodbConnection.seek", err.Error())
+		} else if data != nil {
+			data["id"] = data["_id"]
+			data["_collection"] = con.query.Model.Collection
+			data["_model"] = con.query.Model.Name
+			result = append(result, data)
+		}
+	}
+
+	return &result
+}
+
+// seekValue converts a cursor value back to the stored type.
+func (con *mongodbConnection) seekValue(field string, value interface{}) interface{} {
+	if v, ok := value.(string); ok && (field == "_id" || helper.Contains(con.query.Model.IDKeys, field)) {
+		return helper.ObjectID(v)
+	}
+
+	return value
+}
+
+// summary counts the filtered records and sums the number fields, with their
+// largest, smallest and average values, in one aggregation. The sums and
+// bounds are keyed by field.
 func (con *mongodbConnection) summary() *datatype.DataMap {
+	fields := con.query.Model.NumberFields
+	group := bson.M{"_id": nil, "count": bson.M{"$sum": 1}}
+
+	for i, k := range fields {
+		group[fmt.Sprintf("sum%d", i)] = bson.M{"$sum": "$" + k}
+		group[fmt.Sprintf("max%d", i)] = bson.M{"$max": "$" + k}
+		group[fmt.Sprintf("min%d", i)] = bson.M{"$min": "$" + k}
+		group[fmt.Sprintf("average%d", i)] = bson.M{"$avg": "$" + k}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: con.where()}},
+		{{Key: "$group", Value: group}},
+	}
+
+	var row bson.M
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, options.Aggregate())
+	if err != nil {
+		logger.Error("mongodbConnection.summary", err.Error())
+	} else {
+		defer cursor.Close(*con.ctx)
+
+		if cursor.Next(*con.ctx) {
+			if err := cursor.Decode(&row); err != nil {
+				logger.Error("mongodbConnection.summary", err.Error())
+			}
+		}
+	}
+
+	sums := datatype.DataMap{}
+	maxes := datatype.DataMap{}
+	mins := datatype.DataMap{}
+	averages := datatype.DataMap{}
+
+	for i, k := range fields {
+		sums[k] = helper.ToFloat64(row[fmt.Sprintf("sum%d", i)])
+		maxes[k] = row[fmt.Sprintf("max%d", i)]
+		mins[k] = row[fmt.Sprintf("min%d", i)]
+		averages[k] = helper.ToFloat64(row[fmt.Sprintf("average%d", i)])
+	}
 
 	result := datatype.DataMap{
-		"count": 0,
-		"sum":   0,
-		"max":   0,
-		"min":   0,
-		"graph": datatype.DataMap{},
+		"count":   int64(helper.ToFloat64(row["count"])),
+		"sum":     sums,
+		"max":     maxes,
+		"min":     mins,
+		"average": averages,
+		"graph":   *con.graph(),
 	}
 
 	return &result
@@ -244,15 +391,15 @@ func (con *mongodbConnection) count() int64 {
 			{{Key: "$group", Value: bson.M{"_id": groupId, "aggregateValue": bson.M{"$sum": 1}}}},
 		}
 
-		cursorResult, err := con.collection().Aggregate(context.TODO(), pipeline, opts)
+		cursorResult, err := con.collection().Aggregate(*con.ctx, pipeline, opts)
 		if err != nil {
 			logger.Error("mongodbConnection.count 1", err.Error())
 		}
-		defer cursorResult.Close(context.TODO())
+		defer cursorResult.Close(*con.ctx)
 
 		cursor = int64(cursorResult.RemainingBatchLength())
 	} else {
-		cursor, err = con.collection().CountDocuments(context.TODO(), con.where())
+		cursor, err = con.collection().CountDocuments(*con.ctx, con.where())
 		if err != nil {
 			logger.Error("mongodbConnection.count", err.Error())
 		}
@@ -271,17 +418,17 @@ func (con *mongodbConnection) sum(key string) float64 {
 		{{Key: "$group", Value: bson.M{"_id": nil, "aggregateValue": bson.M{"$sum": "$" + key}}}},
 	}
 
-	cursor, err := con.collection().Aggregate(context.TODO(), pipeline, opts)
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, opts)
 	if err != nil {
 		logger.Error("mongodbConnection.sum 1", err.Error())
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(*con.ctx)
 
 	// Retrieve the result
 	var result struct {
 		AggregateValue float64 `bson:"aggregateValue"`
 	}
-	if cursor.Next(context.TODO()) {
+	if cursor.Next(*con.ctx) {
 		if err := cursor.Decode(&result); err != nil {
 			logger.Error("mongodbConnection.sum 2", err.Error())
 		}
@@ -301,17 +448,17 @@ func (con *mongodbConnection) max(key string) interface{} {
 		{{Key: "$group", Value: bson.M{"_id": nil, "aggregateValue": bson.M{"$max": "$" + key}}}},
 	}
 
-	cursor, err := con.collection().Aggregate(context.TODO(), pipeline, opts)
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, opts)
 	if err != nil {
 		logger.Error("mongodbConnection.max 1", err.Error())
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(*con.ctx)
 
 	// Retrieve the result
 	var result struct {
 		AggregateValue interface{} `bson:"aggregateValue"`
 	}
-	if cursor.Next(context.TODO()) {
+	if cursor.Next(*con.ctx) {
 		if err := cursor.Decode(&result); err != nil {
 			logger.Error("mongodbConnection.max 2", err.Error())
 		}
@@ -348,17 +495,17 @@ func (con *mongodbConnection) min(key string) interface{} {
 		{{Key: "$group", Value: bson.M{"_id": nil, "aggregateValue": bson.M{"$min": "$" + key}}}},
 	}
 
-	cursor, err := con.collection().Aggregate(context.TODO(), pipeline, opts)
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, opts)
 	if err != nil {
 		logger.Error("mongodbConnection.min 1", err.Error())
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(*con.ctx)
 
 	// Retrieve the result
 	var result struct {
 		AggregateValue interface{} `bson:"aggregateValue"`
 	}
-	if cursor.Next(context.TODO()) {
+	if cursor.Next(*con.ctx) {
 
 		if err := cursor.Decode(&result); err != nil {
 			logger.Error("mongodbConnection.min 2", err.Error())
@@ -396,17 +543,17 @@ func (con *mongodbConnection) average(key string) float64 {
 		{{Key: "$group", Value: bson.M{"_id": nil, "aggregateValue": bson.M{"$avg": "$" + key}}}},
 	}
 
-	cursor, err := con.collection().Aggregate(context.TODO(), pipeline, opts)
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, opts)
 	if err != nil {
 		logger.Error("mongodbConnection.average 1", err.Error())
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(*con.ctx)
 
 	// Retrieve the result
 	var result struct {
 		AggregateValue float64 `bson:"aggregateValue"`
 	}
-	if cursor.Next(context.TODO()) {
+	if cursor.Next(*con.ctx) {
 		if err := cursor.Decode(&result); err != nil {
 			logger.Error("mongodbConnection.average 2", err.Error())
 		}
@@ -417,8 +564,50 @@ func (con *mongodbConnection) average(key string) float64 {
 	return result.AggregateValue
 }
 
+// graph counts the filtered records per value of the group by fields, keyed
+// by the values joined with a comma. Without group by fields it is empty.
 func (con *mongodbConnection) graph() *datatype.DataMap {
-	return &datatype.DataMap{}
+	result := datatype.DataMap{}
+	if len(con.query.groupBy) == 0 {
+		return &result
+	}
+
+	groupId := bson.M{}
+	for i, k := range con.query.groupBy {
+		groupId[fmt.Sprintf("k%d", i)] = "$" + k
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: con.where()}},
+		{{Key: "$group", Value: bson.M{"_id": groupId, "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := con.collection().Aggregate(*con.ctx, pipeline, options.Aggregate())
+	if err != nil {
+		logger.Error("mongodbConnection.graph", err.Error())
+		return &result
+	}
+	defer cursor.Close(*con.ctx)
+
+	for cursor.Next(*con.ctx) {
+		var row struct {
+			ID    bson.M `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			logger.Error("mongodbConnection.graph", err.Error())
+			return &result
+		}
+
+		keys := make([]string, 0, len(con.query.groupBy))
+		for i := range con.query.groupBy {
+			keys = append(keys, helper.ToString(row.ID[fmt.Sprintf("k%d", i)]))
+		}
+
+		result[strings.Join(keys, ",")] = row.Count
+	}
+
+	return &result
 }
 
 func (con *mongodbConnection) create(data datatype.DataMap) (*datatype.DataMap, error) {
@@ -456,15 +645,26 @@ func (con *mongodbConnection) createMany(data []datatype.DataMap) (*[]datatype.D
 
 func (con *mongodbConnection) update(data datatype.DataMap) (*datatype.DataMap, error) {
 	var result *datatype.DataMap
-	res, err := con.collection().UpdateOne(*con.ctx, con.where(), datatype.DataMap{
-		"$set": data,
-	})
+
+	// The version is checked in the filter, so the check and the write are
+	// one atomic operation
+	filter := con.where()
+	version, checked := con.query.versionCheck()
+	if checked {
+		(*filter)[VersionKey] = version
+	}
+
+	res, err := con.collection().UpdateOne(*con.ctx, filter, con.updateDocument(data))
 
 	if err != nil {
 		console.Log("mongodbConnection.update", err.Error())
 		return nil, err
 	}
 
+	if checked && res.MatchedCount == 0 {
+		return nil, errVersionConflict
+	}
+
 	if res.Acknowledged && res.MatchedCount > 0 {
 		result = con.findOne()
 	}
@@ -474,9 +674,7 @@ func (con *mongodbConnection) update(data datatype.DataMap) (*datatype.DataMap,
 
 func (con *mongodbConnection) updateMany(data datatype.DataMap) (*[]datatype.DataMap, error) {
 	var result *[]datatype.DataMap
-	res, err := con.collection().UpdateMany(*con.ctx, con.where(), datatype.DataMap{
-		"$set": data,
-	})
+	res, err := con.collection().UpdateMany(*con.ctx, con.where(), con.updateDocument(data))
 
 	if err != nil {
 		console.Log("mongodbConnection.updateMany", err.Error())
@@ -490,6 +688,20 @@ func (con *mongodbConnection) updateMany(data datatype.DataMap) (*[]datatype.Dat
 	return result, nil
 }
 
+// updateDocument sets the values and increments the version of a versioned
+// model.
+func (con *mongodbConnection) updateDocument(data datatype.DataMap) datatype.DataMap {
+	update := datatype.DataMap{
+		"$set": data,
+	}
+
+	if con.query.Model.Versioned {
+		update["$inc"] = datatype.DataMap{VersionKey: 1}
+	}
+
+	return update
+}
+
 func (con *mongodbConnection) delete() (interface{}, error) {
 	where := con.where()
 
@@ -528,6 +740,10 @@ func (con *mongodbConnection) where() *datatype.DataMap {
 		}
 	}
 
+	if helper.IsNotEmpty(con.query.search) {
+		filters["$text"] = bson.M{"$search": con.query.search}
+	}
+
 	return &filters
 }
 
@@ -647,6 +863,7 @@ func (con *mongodbConnection) extractWhereItem(where interface{}) datatype.DataM
 					if helper.Contains(graphqlOperations[:], ki) ||
 						helper.Contains(graphqlArrayOperations[:], ki) ||
 						helper.Contains(graphqlBooleanOperations[:], ki) ||
+						helper.Contains(graphqlGeoOperations[:], ki) ||
 						helper.Contains(mongodbSpecialOperations[:], ki) {
 
 						switch vii {
@@ -742,6 +959,27 @@ func (con *mongodbConnection) extractWhereItem(where interface{}) datatype.DataM
 								innerFilter["$regex"] = regexp.MustCompile(helper.CreateFuzzyRegex(_v)).String()
 								innerFilter["$options"] = "i"
 							}
+						case "near":
+							if near, ok := toGeoNear(vii); ok && near.MaxDistance > 0 {
+								innerFilter["$geoWithin"] = bson.M{
+									"$centerSphere": bson.A{bson.A{near.Point.Longitude, near.Point.Latitude}, near.MaxDistance / earthRadius},
+								}
+							} else {
+								innerFilter["$exists"] = true
+							}
+						case "withinBox":
+							if sw, ne, ok := toGeoBox(vii); ok {
+								innerFilter["$geoWithin"] = bson.M{"$geometry": mongodbPolygon([]GeoPoint{
+									sw,
+									{Latitude: sw.Latitude, Longitude: ne.Longitude},
+									ne,
+									{Latitude: ne.Latitude, Longitude: sw.Longitude},
+								})}
+							}
+						case "withinPolygon":
+							if polygon, ok := toGeoPolygon(vii); ok {
+								innerFilter["$geoWithin"] = bson.M{"$geometry": mongodbPolygon(polygon)}
+							}
 						case "options":
 							innerFilter["$eq"] = vii
 						case "text":
@@ -817,6 +1055,42 @@ func (con *mongodbConnection) extractWhereItem(where interface{}) datatype.DataM
 	return filters
 }
 
+// mongodbPolygon is the GeoJSON polygon of the corners.
+func mongodbPolygon(polygon []GeoPoint) bson.M {
+	ring := bson.A{}
+	for _, p := range closedPolygon(polygon) {
+		ring = append(ring, bson.A{p.Longitude, p.Latitude})
+	}
+
+	return bson.M{"type": "Polygon", "coordinates": bson.A{ring}}
+}
+
+// nearFilter replaces the near filter with $nearSphere, which returns the
+// records nearest first. It can't be counted or combined with a text search,
+// so count keeps the $geoWithin filter.
+func (con *mongodbConnection) nearFilter(filter *datatype.DataMap) (geoNear, bool) {
+	near, ok := con.query.nearest()
+	if !ok || helper.IsNotEmpty(con.query.search) {
+		return near, false
+	}
+
+	inner, ok := (*filter)[near.Field].(datatype.DataMap)
+	if !ok {
+		return near, false
+	}
+
+	delete(inner, "$geoWithin")
+	delete(inner, "$exists")
+
+	nearSphere := bson.M{"$geometry": bson.M{"type": "Point", "coordinates": bson.A{near.Point.Longitude, near.Point.Latitude}}}
+	if near.MaxDistance > 0 {
+		nearSphere["$maxDistance"] = near.MaxDistance
+	}
+	inner["$nearSphere"] = nearSphere
+
+	return near, true
+}
+
 func (con *mongodbConnection) hasGroup() bool {
 	if len(con.query.groupBy) > 0 {
 		return true
@@ -854,7 +1128,7 @@ func (con *mongodbConnection) groupBy() bson.M {
 }
 
 func (con *mongodbConnection) hasOrderBy() bool {
-	if len(con.query.orderBy) > 0 {
+	if len(con.query.orderBy) > 0 || (helper.IsNotEmpty(con.query.search) && !con.hasGroup()) {
 		return true
 	}
 
@@ -862,15 +1136,28 @@ func (con *mongodbConnection) hasOrderBy() bool {
 }
 
 func (con *mongodbConnection) orderBy() interface{} {
-	order := make(datatype.DataMap)
+	order := bson.D{}
 
-	if con.query.orderBy != nil {
-		for k, v := range con.query.orderBy {
-			order[k] = 1
-			if strings.ToLower(v) == "desc" {
-				order[k] = -1
+	for _, o := range con.query.rankedOrdering() {
+		if o.Field == DistanceKey {
+			// $nearSphere sorts by distance itself.
+			continue
+		}
+
+		if o.Field == SearchScoreKey {
+			// The relevance is gone once the records are grouped.
+			if con.hasGroup() {
+				continue
 			}
+			order = append(order, bson.E{Key: SearchScoreKey, Value: bson.M{"$meta": "textScore"}})
+			continue
+		}
+
+		value := 1
+		if o.Descending {
+			value = -1
 		}
+		order = append(order, bson.E{Key: o.Field, Value: value})
 	}
 
 	return order
@@ -0,0 +1,201 @@
+package yekonga
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/mongo"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/mongo/options"
+)
+
+// mongodbMigrate creates the model indexes on every collection and reports
+// the indexes that differ from the declarations. Indexes are never dropped.
+func (dc *DatabaseConnections) mongodbMigrate(models map[string]*DataModel) {
+	if dc.mongodbClient == nil {
+		return
+	}
+
+	names := make([]string, 0, len(models))
+	for k := range models {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := dc.mongodbMigrateIndexes(models[name]); err != nil {
+			logger.Error("MongoDB migration failed for "+models[name].Collection, err)
+		}
+	}
+}
+
+func (dc *DatabaseConnections) mongodbMigrateIndexes(model *DataModel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	view := dc.mongodbClient.
+		Database(dc.config.Database.DatabaseName).
+		Collection(model.Collection).
+		Indexes()
+
+	existing, err := mongodbCollectionIndexes(ctx, view)
+	if err != nil {
+		return err
+	}
+
+	return reconcileIndexes("MongoDB", model.Collection, model.Indexes, existing, true, func(index DataModelIndex) error {
+		keys := bson.D{}
+		for _, f := range index.Fields {
+			var value interface{} = 1
+			if index.Text {
+				value = "text"
+			} else if index.Geo {
+				value = "2dsphere"
+			} else if f.Descending {
+				value = -1
+			}
+
+			keys = append(keys, bson.E{Key: f.Name, Value: value})
+		}
+
+		opts := options.Index().SetName(index.Name)
+		if index.Unique {
+			opts.SetUnique(true)
+		}
+		if index.Expires {
+			opts.SetExpireAfterSeconds(int32(index.TTL))
+		}
+
+		_, err := view.CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+		return err
+	})
+}
+
+// mongodbCollectionIndexes returns the indexes of a collection keyed by name,
+// without the _id index. A missing collection has no indexes.
+func mongodbCollectionIndexes(ctx context.Context, view mongo.IndexView) (map[string]existingIndex, error) {
+	specs, err := view.ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]existingIndex)
+	for _, spec := range specs {
+		if spec.Name == "_id_" {
+			continue
+		}
+
+		var index existingIndex
+		if elements, err := spec.KeysDocument.Elements(); err == nil {
+			for _, e := range elements {
+				index.Fields = append(index.Fields, e.Key())
+			}
+		}
+		if spec.Unique != nil {
+			index.Unique = *spec.Unique
+		}
+		if spec.ExpireAfterSeconds != nil {
+			index.Expires = true
+			index.TTL = int(*spec.ExpireAfterSeconds)
+		}
+
+		indexes[spec.Name] = index
+	}
+
+	return indexes, nil
+}
+
+// mongodbMigrationStore runs the migration steps on MongoDB. Fields need no
+// schema, so adding one only sets its value and a type change converts the
+// values in place.
+type mongodbMigrationStore struct {
+	dc *DatabaseConnections
+}
+
+func (s *mongodbMigrationStore) collection(model *DataModel) *mongo.Collection {
+	return s.dc.mongodbClient.Database(s.dc.config.Database.DatabaseName).Collection(model.Collection)
+}
+
+func (s *mongodbMigrationStore) addField(model *DataModel, field DataModelField) error {
+	return nil
+}
+
+func (s *mongodbMigrationStore) renameField(model *DataModel, field string, to string) error {
+	_, err := s.collection(model).UpdateMany(context.Background(),
+		bson.M{field: bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{field: to}},
+	)
+
+	return err
+}
+
+func (s *mongodbMigrationStore) dropField(model *DataModel, field string) error {
+	_, err := s.collection(model).UpdateMany(context.Background(),
+		bson.M{field: bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{field: ""}},
+	)
+
+	return err
+}
+
+func (s *mongodbMigrationStore) changeType(model *DataModel, field DataModelField, convert func(value interface{}) interface{}) error {
+	return s.updateField(model, field.Name, func(record datatype.DataMap) (interface{}, bool) {
+		value, ok := record[field.Name]
+		if !ok || value == nil {
+			return nil, false
+		}
+
+		return convert(value), true
+	})
+}
+
+func (s *mongodbMigrationStore) updateField(model *DataModel, field string, update func(record datatype.DataMap) (interface{}, bool)) error {
+	ctx := context.Background()
+	collection := s.collection(model)
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var data bson.M
+		if err := cursor.Decode(&data); err != nil {
+			return err
+		}
+
+		record, _ := revisionValue(data).(datatype.DataMap)
+		value, ok := update(record)
+		if !ok {
+			continue
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": data["_id"]}, bson.M{"$set": bson.M{field: value}}); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (s *mongodbMigrationStore) dropIndex(model *DataModel, index DataModelIndex) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	view := s.collection(model).Indexes()
+
+	existing, err := mongodbCollectionIndexes(ctx, view)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := existing[index.Name]; !ok {
+		return nil
+	}
+
+	return view.DropOne(ctx, index.Name)
+}
@@ -0,0 +1,24 @@
+package yekonga
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+)
+
+func TestWhereComparesNumbersAsGiven(t *testing.T) {
+	con := &mongodbConnection{query: &DataModelQuery{Model: testSQLModel()}}
+	where := datatype.DataMap{
+		"price": datatype.DataMap{"lessThan": 5, "greaterThanOrEqualTo": "1.5"},
+		"stock": datatype.DataMap{"notGreaterThan": int64(20)},
+	}
+
+	want := datatype.DataMap{
+		"price": datatype.DataMap{"$lt": 5, "$gte": 1.5},
+		"stock": datatype.DataMap{"$not": datatype.DataMap{"$gt": int64(20)}},
+	}
+	if got := con.extractWhereItem(where); !reflect.DeepEqual(got, want) {
+		t.Fatalf("filter = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package yekonga
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// mysqlMigrate creates a table for every model and brings existing tables in
+// line with the database structure by adding missing columns, updating
+// column types and creating the model indexes. Columns are never dropped.
+func (dc *DatabaseConnections) mysqlMigrate(models map[string]*DataModel) {
+	if dc.mysqlClient == nil {
+		return
+	}
+
+	names := make([]string, 0, len(models))
+	for k := range models {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := dc.mysqlMigrateModel(models[name]); err != nil {
+			logger.Error("MySQL migration failed for "+models[name].Collection, err)
+		}
+	}
+}
+
+func (dc *DatabaseConnections) mysqlMigrateModel(model *DataModel) error {
+	table := mysqlDialect.quote(model.Collection)
+	fields := sqlModelColumns(model)
+
+	columns, err := dc.mysqlTableColumns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		definitions := []string{"`_id` VARCHAR(24) NOT NULL"}
+		for _, k := range fields {
+			definitions = append(definitions, fmt.Sprintf("%s %s NULL", mysqlDialect.quote(k), mysqlColumnType(model.Fields[k])))
+		}
+		definitions = append(definitions, "PRIMARY KEY (`_id`)")
+
+		query := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (\n\t%s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci",
+			table,
+			strings.Join(definitions, ",\n\t"),
+		)
+
+		if _, err := dc.mysqlClient.Exec(query); err != nil {
+			return err
+		}
+
+		logger.Info("MySQL table created", model.Collection)
+	} else {
+		for _, k := range fields {
+			columnType := mysqlColumnType(model.Fields[k])
+			current, exists := columns[k]
+
+			if !exists {
+				query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NULL", table, mysqlDialect.quote(k), columnType)
+				if _, err := dc.mysqlClient.Exec(query); err != nil {
+					return err
+				}
+
+				logger.Info("MySQL column added", model.Collection+"."+k)
+			} else if !mysqlSameColumnType(current, columnType) {
+				query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s NULL", table, mysqlDialect.quote(k), columnType)
+				if _, err := dc.mysqlClient.Exec(query); err != nil {
+					logger.Warn("MySQL could not change column type", model.Collection+"."+k, current, "->", columnType, err)
+				} else {
+					logger.Info("MySQL column type changed", model.Collection+"."+k, current, "->", columnType)
+				}
+			}
+		}
+	}
+
+	return dc.mysqlMigrateIndexes(model)
+}
+
+func (dc *DatabaseConnections) mysqlMigrateIndexes(model *DataModel) error {
+	existing, err := dc.mysqlTableIndexes(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	declared := make([]DataModelIndex, 0, len(model.Indexes))
+	for _, index := range model.Indexes {
+		// Geo fields are JSON columns, which a SPATIAL index can't cover.
+		if index.Geo {
+			continue
+		}
+
+		index.Name = limitIndexName(index.Name, 64)
+		declared = append(declared, index)
+	}
+
+	return reconcileIndexes("MySQL", model.Collection, declared, existing, false, func(index DataModelIndex) error {
+		columns := make([]string, 0, len(index.Fields))
+		for _, f := range index.Fields {
+			column := mysqlDialect.quote(f.Name)
+
+			// TEXT columns can only be indexed on a prefix, except by a
+			// FULLTEXT index.
+			if !index.Text && mysqlColumnType(model.Fields[f.Name]) == "TEXT" {
+				column += "(191)"
+			}
+			if f.Descending && !index.Text {
+				column += " DESC"
+			}
+
+			columns = append(columns, column)
+		}
+
+		kind := "INDEX"
+		if index.Text {
+			kind = "FULLTEXT INDEX"
+		} else if index.Unique {
+			kind = "UNIQUE INDEX"
+		}
+
+		query := fmt.Sprintf("CREATE %s %s ON %s (%s)",
+			kind,
+			mysqlDialect.quote(index.Name),
+			mysqlDialect.quote(model.Collection),
+			strings.Join(columns, ", "),
+		)
+
+		_, err := dc.mysqlClient.Exec(query)
+		return err
+	})
+}
+
+// mysqlTableColumns returns the existing columns of a table with their
+// column type, or an empty map when the table does not exist yet.
+func (dc *DatabaseConnections) mysqlTableColumns(table string) (map[string]string, error) {
+	rows, err := dc.mysqlClient.Query(
+		"SELECT COLUMN_NAME, COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, columnType string
+		if err := rows.Scan(&name, &columnType); err != nil {
+			return nil, err
+		}
+		columns[name] = columnType
+	}
+
+	return columns, rows.Err()
+}
+
+// mysqlTableIndexes returns the indexes of a table keyed by name, without
+// the primary key.
+func (dc *DatabaseConnections) mysqlTableIndexes(table string) (map[string]existingIndex, error) {
+	rows, err := dc.mysqlClient.Query(
+		"SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME <> 'PRIMARY' ORDER BY INDEX_NAME, SEQ_IN_INDEX",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]existingIndex)
+	for rows.Next() {
+		var name, column sql.NullString
+		var nonUnique int
+		if err := rows.Scan(&name, &column, &nonUnique); err != nil {
+			return nil, err
+		}
+
+		index := indexes[name.String]
+		index.Fields = append(index.Fields, column.String)
+		index.Unique = nonUnique == 0
+		indexes[name.String] = index
+	}
+
+	return indexes, rows.Err()
+}
+
+func mysqlColumnType(field DataModelField) string {
+	if sqlIsJSONField(field) {
+		return "JSON"
+	}
+
+	switch field.Kind {
+	case DataModelID:
+		return "VARCHAR(24)"
+	case DataModelNumber:
+		return "BIGINT"
+	case DataModelFloat:
+		return "DOUBLE"
+	case DataModelDate:
+		return "DATETIME(3)"
+	case DataModelBool:
+		return "TINYINT(1)"
+	case DataModelFile:
+		return "TEXT"
+	}
+
+	// Option and relation fields are indexed or compared often, which
+	// TEXT columns do not allow without a prefix length.
+	if len(field.Options) > 0 || helper.IsNotEmpty(field.ForeignKey.ModelName) {
+		return "VARCHAR(191)"
+	}
+
+	return "TEXT"
+}
+
+func mysqlSameColumnType(current string, expected string) bool {
+	current = strings.ToLower(current)
+	expected = strings.ToLower(expected)
+
+	// MySQL 8 reports integer types without a display width.
+	switch expected {
+	case "bigint":
+		return strings.HasPrefix(current, "bigint")
+	case "tinyint(1)":
+		return strings.HasPrefix(current, "tinyint")
+	case "json":
+		// MariaDB stores JSON as LONGTEXT.
+		return current == "json" || current == "longtext"
+	}
+
+	return current == expected
+}
+
+func newMysqlMigrationStore(dc *DatabaseConnections) *sqlMigrationStore {
+	return &sqlMigrationStore{
+		backend:    "MySQL",
+		client:     dc.mysqlClient,
+		dialect:    mysqlDialect,
+		nameLimit:  64,
+		columnType: mysqlColumnType,
+		columns:    dc.mysqlTableColumns,
+		indexes:    dc.mysqlTableIndexes,
+		dropQuery: func(table string, name string) string {
+			return fmt.Sprintf("DROP INDEX %s ON %s", mysqlDialect.quote(name), mysqlDialect.quote(table))
+		},
+	}
+}
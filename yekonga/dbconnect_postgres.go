@@ -0,0 +1,65 @@
+package yekonga
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var postgresDialect = &sqlDialect{
+	quote: func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	},
+	placeholder: func(index int) string {
+		return "$" + strconv.Itoa(index)
+	},
+	regex: func(column string, value string) string {
+		return fmt.Sprintf("%s::text ~* %s", column, value)
+	},
+	containsAll: func(column string, value string) string {
+		return fmt.Sprintf("%s @> %s::jsonb", column, value)
+	},
+	match: func(columns []string, value string) string {
+		return fmt.Sprintf("%s @@ plainto_tsquery('simple', %s)", postgresSearchVector(columns), value)
+	},
+	rank: func(columns []string, value string) string {
+		return fmt.Sprintf("ts_rank(%s, plainto_tsquery('simple', %s))", postgresSearchVector(columns), value)
+	},
+	geoPoint: postgresGeoPoint,
+	distance: func(point string, lng string, lat string) string {
+		// Haversine, PostgreSQL has no spherical distance without PostGIS.
+		return fmt.Sprintf("(2 * %v * asin(sqrt(power(sin(radians((%s)[1] - %s) / 2), 2) + cos(radians(%s)) * cos(radians((%s)[1])) * power(sin(radians((%s)[0] - %s) / 2), 2))))",
+			earthRadius, point, lat, lat, point, point, lng)
+	},
+	withinBox: func(point string, swLng string, swLat string, neLng string, neLat string) string {
+		return fmt.Sprintf("%s <@ box(point(%s, %s), point(%s, %s))", point, swLng, swLat, neLng, neLat)
+	},
+	polygon: func(polygon []GeoPoint) string {
+		corners := make([]string, 0, len(polygon))
+		for _, p := range polygon {
+			corners = append(corners, fmt.Sprintf("(%v,%v)", p.Longitude, p.Latitude))
+		}
+
+		return "(" + strings.Join(corners, ",") + ")"
+	},
+	withinShape: func(point string, polygon string) string {
+		return fmt.Sprintf("%s <@ %s::polygon", point, polygon)
+	},
+}
+
+// postgresGeoPoint is the point of a GeoJSON column, x being the longitude.
+// The geo index is built on the same expression.
+func postgresGeoPoint(column string) string {
+	return fmt.Sprintf("point((%s->'coordinates'->>0)::float8, (%s->'coordinates'->>1)::float8)", column, column)
+}
+
+// postgresSearchVector is the text search vector of the quoted columns. The
+// text index is built on the same expression, so that the planner uses it.
+func postgresSearchVector(columns []string) string {
+	values := make([]string, 0, len(columns))
+	for _, c := range columns {
+		values = append(values, fmt.Sprintf("coalesce(%s::text, '')", c))
+	}
+
+	return fmt.Sprintf("to_tsvector('simple', %s)", strings.Join(values, " || ' ' || "))
+}
@@ -0,0 +1,257 @@
+package yekonga
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// postgresMigrate syncs the tables of the current schema with the models:
+// missing tables and columns are created, changed types are converted and
+// the model indexes are created. Columns are never dropped.
+func (dc *DatabaseConnections) postgresMigrate(models map[string]*DataModel) {
+	if dc.postgresClient == nil {
+		return
+	}
+
+	names := make([]string, 0, len(models))
+	for k := range models {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := dc.postgresMigrateModel(models[name]); err != nil {
+			logger.Error("PostgreSQL migration failed for "+models[name].Collection, err)
+		}
+	}
+}
+
+func (dc *DatabaseConnections) postgresMigrateModel(model *DataModel) error {
+	table := postgresDialect.quote(model.Collection)
+	fields := sqlModelColumns(model)
+
+	columns, err := dc.postgresTableColumns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		definitions := []string{`"_id" VARCHAR(24) NOT NULL PRIMARY KEY`}
+		for _, k := range fields {
+			definitions = append(definitions, fmt.Sprintf("%s %s NULL", postgresDialect.quote(k), postgresColumnType(model.Fields[k])))
+		}
+
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table, strings.Join(definitions, ",\n\t"))
+		if _, err := dc.postgresClient.Exec(query); err != nil {
+			return err
+		}
+
+		logger.Info("PostgreSQL table created", model.Collection)
+	} else {
+		for _, k := range fields {
+			columnType := postgresColumnType(model.Fields[k])
+			current, exists := columns[k]
+			column := postgresDialect.quote(k)
+
+			if !exists {
+				query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NULL", table, column, columnType)
+				if _, err := dc.postgresClient.Exec(query); err != nil {
+					return err
+				}
+
+				logger.Info("PostgreSQL column added", model.Collection+"."+k)
+			} else if current != postgresInformationType(columnType) {
+				using := fmt.Sprintf("%s::%s", column, columnType)
+				if columnType == "JSONB" {
+					using = fmt.Sprintf("to_jsonb(%s)", column)
+				}
+
+				query := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s", table, column, columnType, using)
+				if _, err := dc.postgresClient.Exec(query); err != nil {
+					logger.Warn("PostgreSQL could not change column type", model.Collection+"."+k, current, "->", columnType, err)
+				} else {
+					logger.Info("PostgreSQL column type changed", model.Collection+"."+k, current, "->", columnType)
+				}
+			}
+		}
+	}
+
+	return dc.postgresMigrateIndexes(model)
+}
+
+func (dc *DatabaseConnections) postgresMigrateIndexes(model *DataModel) error {
+	existing, err := dc.postgresTableIndexes(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	declared := make([]DataModelIndex, 0, len(model.Indexes))
+	for _, index := range model.Indexes {
+		index.Name = limitIndexName(index.Name, 63)
+		declared = append(declared, index)
+	}
+
+	return reconcileIndexes("PostgreSQL", model.Collection, declared, existing, false, func(index DataModelIndex) error {
+		var definition string
+		if index.Text {
+			columns := make([]string, 0, len(index.Fields))
+			for _, f := range index.Fields {
+				columns = append(columns, postgresDialect.quote(f.Name))
+			}
+
+			definition = fmt.Sprintf("USING GIN (%s)", postgresSearchVector(columns))
+		} else if index.Geo {
+			definition = fmt.Sprintf("USING GIST ((%s))", postgresGeoPoint(postgresDialect.quote(index.Fields[0].Name)))
+		} else {
+			columns := make([]string, 0, len(index.Fields))
+			for _, f := range index.Fields {
+				column := postgresDialect.quote(f.Name)
+				if f.Descending {
+					column += " DESC"
+				}
+
+				columns = append(columns, column)
+			}
+
+			definition = "(" + strings.Join(columns, ", ") + ")"
+		}
+
+		kind := "INDEX"
+		if index.Unique {
+			kind = "UNIQUE INDEX"
+		}
+
+		query := fmt.Sprintf("CREATE %s IF NOT EXISTS %s ON %s %s",
+			kind,
+			postgresDialect.quote(index.Name),
+			postgresDialect.quote(model.Collection),
+			definition,
+		)
+
+		_, err := dc.postgresClient.Exec(query)
+		return err
+	})
+}
+
+// postgresTableIndexes returns the indexes of a table in the current schema
+// keyed by name, without the primary key. Expression indexes have no fields.
+func (dc *DatabaseConnections) postgresTableIndexes(table string) (map[string]existingIndex, error) {
+	rows, err := dc.postgresClient.Query(
+		`SELECT i.relname, ix.indisunique, COALESCE(a.attname, '')
+		FROM pg_index ix
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, position)
+		LEFT JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum AND k.attnum > 0
+		WHERE n.nspname = current_schema() AND t.relname = $1 AND NOT ix.indisprimary
+		ORDER BY i.relname, k.position`,
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]existingIndex)
+	for rows.Next() {
+		var name, column string
+		var unique bool
+		if err := rows.Scan(&name, &unique, &column); err != nil {
+			return nil, err
+		}
+
+		index := indexes[name]
+		if column != "" {
+			index.Fields = append(index.Fields, column)
+		}
+		index.Unique = unique
+		indexes[name] = index
+	}
+
+	return indexes, rows.Err()
+}
+
+// postgresTableColumns returns the columns of a table in the current schema
+// keyed by name with their information_schema data type.
+func (dc *DatabaseConnections) postgresTableColumns(table string) (map[string]string, error) {
+	rows, err := dc.postgresClient.Query(
+		"SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, err
+		}
+		columns[name] = dataType
+	}
+
+	return columns, rows.Err()
+}
+
+func postgresColumnType(field DataModelField) string {
+	if sqlIsJSONField(field) {
+		return "JSONB"
+	}
+
+	switch field.Kind {
+	case DataModelID:
+		return "VARCHAR(24)"
+	case DataModelNumber:
+		return "BIGINT"
+	case DataModelFloat:
+		return "DOUBLE PRECISION"
+	case DataModelDate:
+		return "TIMESTAMPTZ"
+	case DataModelBool:
+		return "BOOLEAN"
+	}
+
+	return "TEXT"
+}
+
+// postgresInformationType maps a column type to the name information_schema
+// reports for it.
+func postgresInformationType(columnType string) string {
+	switch columnType {
+	case "JSONB":
+		return "jsonb"
+	case "VARCHAR(24)":
+		return "character varying"
+	case "BIGINT":
+		return "bigint"
+	case "DOUBLE PRECISION":
+		return "double precision"
+	case "TIMESTAMPTZ":
+		return "timestamp with time zone"
+	case "BOOLEAN":
+		return "boolean"
+	}
+
+	return strings.ToLower(columnType)
+}
+
+func newPostgresMigrationStore(dc *DatabaseConnections) *sqlMigrationStore {
+	return &sqlMigrationStore{
+		backend:    "PostgreSQL",
+		client:     dc.postgresClient,
+		dialect:    postgresDialect,
+		nameLimit:  63,
+		columnType: postgresColumnType,
+		columns:    dc.postgresTableColumns,
+		indexes:    dc.postgresTableIndexes,
+		dropQuery: func(table string, name string) string {
+			return fmt.Sprintf("DROP INDEX IF EXISTS %s", postgresDialect.quote(name))
+		},
+	}
+}
@@ -17,7 +17,7 @@ import (
 type sqlConnection struct {
 	query  *DataModelQuery
 	ctx    *context.Context
-	client *sql.DB
+	client sqlExecutor
 	mut    sync.RWMutex
 }
 
@@ -29,6 +29,7 @@ func newSQLInstance(con *sqlConnection) sqlConnection {
 			Model:          con.query.Model,
 			RequestContext: con.query.RequestContext,
 			QueryContext:   con.query.QueryContext,
+			tx:             con.query.tx,
 		},
 	}
 }
@@ -133,6 +134,26 @@ func (con *sqlConnection) find() *[]datatype.DataMap {
 	return &result
 }
 
+func (con *sqlConnection) seek(order []queryOrder, values []interface{}, limit int) *[]datatype.DataMap {
+	stmt := newSQLStatement(mysqlDialect, con.query.Model)
+	query := stmt.seekQuery(con.query.where, con.query.search, order, values, limit)
+
+	rows, err := con.client.Query(query, stmt.args...)
+	if err != nil {
+		logger.Error("sqlConnection.seek", err.Error())
+		return &[]datatype.DataMap{}
+	}
+	defer rows.Close()
+
+	result, err := sqlScanRows(con.query.Model, rows)
+	if err != nil {
+		logger.Error("sqlConnection.seek", err.Error())
+		return &[]datatype.DataMap{}
+	}
+
+	return &result
+}
+
 func (con *sqlConnection) pagination() *datatype.DataMap {
 	var lastPage int64
 	total := con.count()
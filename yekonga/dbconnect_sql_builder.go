@@ -0,0 +1,784 @@
+package yekonga
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// sqlDialect holds the syntax that differs between the SQL backends.
+type sqlDialect struct {
+	quote       func(name string) string
+	placeholder func(index int) string
+	regex       func(column string, value string) string
+	containsAll func(column string, value string) string
+	match       func(columns []string, value string) string
+	rank        func(columns []string, value string) string
+	geoPoint    func(column string) string
+	distance    func(point string, lng string, lat string) string
+	withinBox   func(point string, swLng string, swLat string, neLng string, neLat string) string
+	polygon     func(polygon []GeoPoint) string
+	withinShape func(point string, polygon string) string
+	nullsFirst  bool // NULL sorts before every value in ascending order
+}
+
+var mysqlDialect = &sqlDialect{
+	nullsFirst: true,
+	quote: func(name string) string {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	},
+	placeholder: func(index int) string {
+		return "?"
+	},
+	regex: func(column string, value string) string {
+		return fmt.Sprintf("%s REGEXP %s", column, value)
+	},
+	containsAll: func(column string, value string) string {
+		return fmt.Sprintf("JSON_CONTAINS(%s, %s)", column, value)
+	},
+	match: func(columns []string, value string) string {
+		return fmt.Sprintf("MATCH(%s) AGAINST (%s IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", "), value)
+	},
+	rank: func(columns []string, value string) string {
+		return fmt.Sprintf("MATCH(%s) AGAINST (%s IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", "), value)
+	},
+	geoPoint: func(column string) string {
+		return fmt.Sprintf("POINT(CAST(JSON_EXTRACT(%s, '$.coordinates[0]') AS DOUBLE), CAST(JSON_EXTRACT(%s, '$.coordinates[1]') AS DOUBLE))", column, column)
+	},
+	distance: func(point string, lng string, lat string) string {
+		return fmt.Sprintf("ST_Distance_Sphere(%s, POINT(%s, %s))", point, lng, lat)
+	},
+	withinBox: func(point string, swLng string, swLat string, neLng string, neLat string) string {
+		return fmt.Sprintf("MBRContains(ST_MakeEnvelope(POINT(%s, %s), POINT(%s, %s)), %s)", swLng, swLat, neLng, neLat, point)
+	},
+	polygon: func(polygon []GeoPoint) string {
+		corners := make([]string, 0, len(polygon)+1)
+		for _, p := range closedPolygon(polygon) {
+			corners = append(corners, fmt.Sprintf("%v %v", p.Longitude, p.Latitude))
+		}
+
+		return "POLYGON((" + strings.Join(corners, ", ") + "))"
+	},
+	withinShape: func(point string, polygon string) string {
+		return fmt.Sprintf("ST_Contains(ST_GeomFromText(%s), %s)", polygon, point)
+	},
+}
+
+// sqlStatement collects the SQL fragments and bound arguments of one query,
+// so that the placeholders and the argument list always stay in step.
+type sqlStatement struct {
+	dialect *sqlDialect
+	model   *DataModel
+	args    []interface{}
+}
+
+func newSQLStatement(dialect *sqlDialect, model *DataModel) *sqlStatement {
+	return &sqlStatement{
+		dialect: dialect,
+		model:   model,
+		args:    make([]interface{}, 0),
+	}
+}
+
+func (s *sqlStatement) quote(name string) string {
+	if name == "id" {
+		name = "_id"
+	}
+
+	return s.dialect.quote(name)
+}
+
+func (s *sqlStatement) table() string {
+	return s.dialect.quote(s.model.Collection)
+}
+
+func (s *sqlStatement) bind(value interface{}) string {
+	s.args = append(s.args, sqlValue(value))
+
+	return s.dialect.placeholder(len(s.args))
+}
+
+// bindField binds a value that is written to the column of the given
+// field, encoding it as JSON when the column holds JSON.
+func (s *sqlStatement) bindField(key string, value interface{}) string {
+	if field, ok := s.model.Fields[key]; ok && value != nil && sqlIsJSONField(field) {
+		if b, err := json.Marshal(value); err == nil {
+			s.args = append(s.args, string(b))
+
+			return s.dialect.placeholder(len(s.args))
+		}
+	}
+
+	return s.bind(value)
+}
+
+func (s *sqlStatement) bindList(values []interface{}) string {
+	placeholders := make([]string, 0, len(values))
+
+	for _, v := range values {
+		placeholders = append(placeholders, s.bind(v))
+	}
+
+	return strings.Join(placeholders, ", ")
+}
+
+func (s *sqlStatement) where(where datatype.DataMap) string {
+	conditions := make([]string, 0, len(where))
+
+	for _, k := range sortedKeys(where) {
+		v := where[k]
+		var condition string
+
+		switch k {
+		case "AND", "OR", "NOR":
+			condition = s.whereGroup(k, v)
+		default:
+			condition = s.whereItem(k, v)
+		}
+
+		if helper.IsNotEmpty(condition) {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+func (s *sqlStatement) whereGroup(kind string, value interface{}) string {
+	conditions := make([]string, 0)
+
+	for _, item := range helper.ToDataMapList(value) {
+		if condition := s.where(item); helper.IsNotEmpty(condition) {
+			conditions = append(conditions, "("+condition+")")
+		}
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	switch kind {
+	case "OR":
+		return "(" + strings.Join(conditions, " OR ") + ")"
+	case "NOR":
+		return "NOT (" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
+func (s *sqlStatement) whereItem(key string, value interface{}) string {
+	column := s.quote(key)
+
+	if value == nil {
+		return column + " IS NULL"
+	}
+
+	if helper.IsMap(value) {
+		vm := helper.ToDataMap(value)
+		conditions := make([]string, 0, len(vm))
+		relation := datatype.DataMap{}
+
+		for _, op := range sortedKeys(vm) {
+			if isSQLOperator(op) {
+				conditions = append(conditions, s.whereOperator(key, op, vm[op]))
+			} else {
+				relation[op] = vm[op]
+			}
+		}
+
+		if len(relation) > 0 {
+			if condition := s.whereRelation(key, relation); helper.IsNotEmpty(condition) {
+				conditions = append(conditions, condition)
+			}
+		}
+
+		return strings.Join(conditions, " AND ")
+	}
+
+	if values, ok := sqlList(value); ok {
+		if len(values) == 0 {
+			return "1 = 0"
+		}
+
+		return fmt.Sprintf("%s IN (%s)", column, s.bindList(values))
+	}
+
+	return fmt.Sprintf("%s = %s", column, s.bind(value))
+}
+
+func (s *sqlStatement) whereOperator(key string, op string, value interface{}) string {
+	column := s.quote(key)
+
+	switch value {
+	case string(NULLValue), string(NullValue), string(nullValue):
+		value = nil
+	}
+
+	if helper.Contains(s.model.IDKeys, key) || key == "id" || key == "_id" {
+		if list, ok := sqlList(value); ok {
+			for i := range list {
+				list[i] = helper.ObjectID(list[i])
+			}
+			value = list
+		} else if helper.IsNotEmpty(value) {
+			value = helper.ObjectID(value)
+		}
+	}
+
+	compare := func(operator string, negate bool) string {
+		condition := fmt.Sprintf("%s %s %s", column, operator, s.bind(s.comparable(key, value)))
+		if negate {
+			return "NOT (" + condition + ")"
+		}
+
+		return condition
+	}
+
+	switch op {
+	case "equalTo", "$eq", "options", "text", "inQueryKey", "notInQueryKey":
+		if value == nil {
+			return column + " IS NULL"
+		}
+		return fmt.Sprintf("%s = %s", column, s.bind(value))
+	case "notEqualTo", "$ne":
+		if value == nil {
+			return column + " IS NOT NULL"
+		}
+		return fmt.Sprintf("(%s <> %s OR %s IS NULL)", column, s.bind(value), column)
+	case "lessThan", "$lt":
+		return compare("<", false)
+	case "notLessThan", "$not_lt":
+		return compare("<", true)
+	case "lessThanOrEqualTo", "$lte":
+		return compare("<=", false)
+	case "notLessThanOrEqualTo", "$not_lte":
+		return compare("<=", true)
+	case "greaterThan", "$gt":
+		return compare(">", false)
+	case "notGreaterThan", "$not_gt":
+		return compare(">", true)
+	case "greaterThanOrEqualTo", "$gte":
+		return compare(">=", false)
+	case "notGreaterThanOrEqualTo", "$not_gte":
+		return compare(">=", true)
+	case "in", "$in":
+		values, _ := sqlList(value)
+		if len(values) == 0 {
+			return "1 = 0"
+		}
+		return fmt.Sprintf("%s IN (%s)", column, s.bindList(values))
+	case "notIn", "$nin":
+		values, _ := sqlList(value)
+		if len(values) == 0 {
+			return "1 = 1"
+		}
+		return fmt.Sprintf("(%s NOT IN (%s) OR %s IS NULL)", column, s.bindList(values), column)
+	case "all", "$all":
+		values, _ := sqlList(value)
+		return s.dialect.containsAll(column, s.bind(values))
+	case "exists":
+		if exists, ok := value.(bool); ok && !exists {
+			return column + " IS NULL"
+		}
+		return column + " IS NOT NULL"
+	case "matchesRegex":
+		return s.dialect.regex(column, s.bind(helper.CreateFuzzyRegex(helper.ToString(value))))
+	case "near", "withinBox", "withinPolygon":
+		return s.whereGeo(column, op, value)
+	}
+
+	return fmt.Sprintf("%s = %s", column, s.bind(value))
+}
+
+// whereGeo builds the condition of a geo operator on the point of a geo
+// column. A near filter without maxDistance only needs a point.
+func (s *sqlStatement) whereGeo(column string, op string, value interface{}) string {
+	point := s.dialect.geoPoint(column)
+
+	switch op {
+	case "near":
+		if near, ok := toGeoNear(value); ok && near.MaxDistance > 0 {
+			return fmt.Sprintf("%s <= %s", s.distance(point, near.Point), s.bind(near.MaxDistance))
+		}
+	case "withinBox":
+		if sw, ne, ok := toGeoBox(value); ok {
+			return s.dialect.withinBox(point, s.bind(sw.Longitude), s.bind(sw.Latitude), s.bind(ne.Longitude), s.bind(ne.Latitude))
+		}
+	case "withinPolygon":
+		if polygon, ok := toGeoPolygon(value); ok {
+			return s.dialect.withinShape(point, s.bind(s.dialect.polygon(polygon)))
+		}
+	}
+
+	return column + " IS NOT NULL"
+}
+
+func (s *sqlStatement) distance(point string, to GeoPoint) string {
+	return s.dialect.distance(point, s.bind(to.Longitude), s.bind(to.Latitude))
+}
+
+// whereRelation resolves a filter on a related model to the matching keys,
+// the same way the MongoDB backend does.
+func (s *sqlStatement) whereRelation(key string, where datatype.DataMap) string {
+	if relation, ok := s.model.ParentFields[key]; ok {
+		list := s.model.App.ModelQuery(relation.ModelName).WhereAll(where).Find(nil)
+		ids := helper.GetList(list, relation.PrimaryKey)
+
+		return s.whereOperator(relation.ForeignKey, "in", ids)
+	} else if relation, ok := s.model.ChildrenFields[key]; ok {
+		list := s.model.App.ModelQuery(relation.ModelName).WhereAll(where).Find(nil)
+		ids := helper.GetList(list, relation.ForeignKey)
+
+		return s.whereOperator(relation.PrimaryKey, "in", ids)
+	}
+
+	return ""
+}
+
+func (s *sqlStatement) comparable(key string, value interface{}) interface{} {
+	if field, ok := s.model.Fields[key]; ok {
+		switch field.Kind {
+		case DataModelDate:
+			return helper.GetTimestamp(value)
+		case DataModelNumber:
+			if v := helper.ToFloat(value); v == float64(int64(v)) {
+				return int64(v)
+			}
+			return helper.ToFloat(value)
+		case DataModelFloat:
+			return helper.ToFloat(value)
+		}
+	}
+
+	return helper.ConvertCalculatedValue(value)
+}
+
+// filter builds the condition of the filter and the text search, or an
+// empty string when there is neither.
+func (s *sqlStatement) filter(where datatype.DataMap, search string) string {
+	conditions := make([]string, 0, 2)
+
+	if len(where) > 0 {
+		if condition := s.where(where); helper.IsNotEmpty(condition) {
+			conditions = append(conditions, "("+condition+")")
+		}
+	}
+
+	if helper.IsNotEmpty(search) && len(s.model.SearchFields) > 0 {
+		conditions = append(conditions, s.dialect.match(s.searchColumns(), s.bind(search)))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// searchColumns are the quoted columns of the text index, which the search
+// has to name exactly.
+func (s *sqlStatement) searchColumns() []string {
+	columns := make([]string, 0, len(s.model.SearchFields))
+	for _, f := range s.model.SearchFields {
+		columns = append(columns, s.dialect.quote(f))
+	}
+
+	return columns
+}
+
+// selectQuery selects the rows of the filter of the query, with the
+// relevance as _score while searching and the distance as _distance when
+// filtering near a point.
+func (s *sqlStatement) selectQuery(q *DataModelQuery) string {
+	columns := "*"
+	if helper.IsNotEmpty(q.search) && len(s.model.SearchFields) > 0 {
+		columns += fmt.Sprintf(", %s AS %s", s.dialect.rank(s.searchColumns(), s.bind(q.search)), s.dialect.quote(SearchScoreKey))
+	}
+
+	if near, ok := q.nearest(); ok {
+		columns += fmt.Sprintf(", %s AS %s", s.distance(s.dialect.geoPoint(s.quote(near.Field)), near.Point), s.dialect.quote(DistanceKey))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, s.table())
+
+	if condition := s.filter(q.where, q.search); helper.IsNotEmpty(condition) {
+		query += " WHERE " + condition
+	}
+
+	return query
+}
+
+func (s *sqlStatement) orderBy(order []queryOrder) string {
+	parts := make([]string, 0, len(order))
+
+	for _, o := range order {
+		direction := "ASC"
+		if o.Descending {
+			direction = "DESC"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", s.quote(o.Field), direction))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return "ORDER BY " + strings.Join(parts, ", ")
+}
+
+// seek builds the keyset condition that matches the rows after the given
+// values in the order, e.g. (a > ? OR (a = ? AND _id > ?)). A NULL value is
+// compared with IS NULL, and the rows after it depend on where the backend
+// sorts NULLs.
+func (s *sqlStatement) seek(order []queryOrder, values []interface{}) string {
+	alternatives := make([]string, 0, len(order))
+
+	for i, o := range order {
+		conditions := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				conditions = append(conditions, fmt.Sprintf("%s IS NULL", s.quote(order[j].Field)))
+			} else {
+				conditions = append(conditions, fmt.Sprintf("%s = %s", s.quote(order[j].Field), s.bind(values[j])))
+			}
+		}
+
+		// NULLs come before the values when they sort first ascending or
+		// last descending.
+		nullsBefore := s.dialect.nullsFirst != o.Descending
+		column := s.quote(o.Field)
+
+		if values[i] == nil {
+			if !nullsBefore {
+				// Nothing sorts after a NULL that comes last.
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("%s IS NOT NULL", column))
+		} else {
+			operator := ">"
+			if o.Descending {
+				operator = "<"
+			}
+
+			condition := fmt.Sprintf("%s %s %s", column, operator, s.bind(values[i]))
+			if !nullsBefore {
+				condition = fmt.Sprintf("(%s OR %s IS NULL)", condition, column)
+			}
+			conditions = append(conditions, condition)
+		}
+
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	if len(alternatives) == 0 {
+		return "(1 = 0)"
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// seekQuery selects the rows of the filter and the text search after the
+// cursor values, sorted by the order and limited to limit rows.
+func (s *sqlStatement) seekQuery(where datatype.DataMap, search string, order []queryOrder, values []interface{}, limit int) string {
+	query := fmt.Sprintf("SELECT * FROM %s", s.table())
+	conditions := make([]string, 0, 2)
+
+	if condition := s.filter(where, search); helper.IsNotEmpty(condition) {
+		conditions = append(conditions, condition)
+	}
+
+	if len(values) > 0 {
+		conditions = append(conditions, s.seek(order, values))
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return query + " " + s.orderBy(order) + fmt.Sprintf(" LIMIT %d", limit)
+}
+
+// columns returns the sorted table columns of the given record,
+// skipping keys that are not part of the model.
+func (s *sqlStatement) columns(data datatype.DataMap) []string {
+	keys := make([]string, 0, len(data))
+
+	for k := range data {
+		if k == "id" {
+			continue
+		}
+
+		if _, ok := s.model.Fields[k]; ok || k == "_id" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// insert is the multi-row INSERT of the records. The columns are the union
+// of the columns of every record, a record without one of them writes NULL
+// there, so records of different shapes keep all of their fields.
+func (s *sqlStatement) insert(data []datatype.DataMap) string {
+	seen := map[string]bool{}
+	keys := make([]string, 0)
+	for _, record := range data {
+		for _, k := range s.columns(record) {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	columns := make([]string, 0, len(keys))
+	for _, k := range keys {
+		columns = append(columns, s.quote(k))
+	}
+
+	rows := make([]string, 0, len(data))
+	for _, record := range data {
+		placeholders := make([]string, 0, len(keys))
+		for _, k := range keys {
+			placeholders = append(placeholders, s.bindField(k, record[k]))
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", s.table(), strings.Join(columns, ", "), strings.Join(rows, ", "))
+}
+
+// versionIncrement is the assignment that moves a versioned record to its
+// next version.
+func (s *sqlStatement) versionIncrement() string {
+	column := s.quote(VersionKey)
+
+	return fmt.Sprintf("%s = COALESCE(%s, 0) + 1", column, column)
+}
+
+// sqlModelColumns lists the model fields that map to table columns.
+func sqlModelColumns(model *DataModel) []string {
+	fields := make([]string, 0, len(model.Fields))
+
+	for k := range model.Fields {
+		if k == "id" || k == "_id" {
+			continue
+		}
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+func sqlIsJSONField(field DataModelField) bool {
+	return field.IsArray || field.Kind == DataModelArray || field.Kind == DataModelObject || field.Kind == DataModelAny || field.Kind == DataModelGeo
+}
+
+func isSQLOperator(op string) bool {
+	return helper.Contains(graphqlOperations[:], op) ||
+		helper.Contains(graphqlGeoOperations[:], op) ||
+		helper.Contains(graphqlArrayOperations[:], op) ||
+		helper.Contains(graphqlBooleanOperations[:], op) ||
+		strings.HasPrefix(op, "$") ||
+		op == "text" || op == "inQueryKey" || op == "notInQueryKey"
+}
+
+func sortedKeys(data datatype.DataMap) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sqlList returns the elements of a slice value, keeping their original types.
+func sqlList(value interface{}) ([]interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+
+	if _, ok := value.([]byte); ok {
+		return nil, false
+	}
+
+	if _, ok := value.(bson.ObjectID); ok {
+		return nil, false
+	}
+
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	list := make([]interface{}, 0, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		list = append(list, val.Index(i).Interface())
+	}
+
+	return list, true
+}
+
+// sqlValue converts a query value into something database/sql can bind.
+func sqlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bson.ObjectID:
+		return v.Hex()
+	case *bson.ObjectID:
+		if v == nil {
+			return nil
+		}
+		return v.Hex()
+	case time.Time:
+		return v.UTC()
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC()
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, []byte:
+		return v
+	}
+
+	if list, ok := sqlList(value); ok {
+		for i := range list {
+			if id, ok := list[i].(bson.ObjectID); ok {
+				list[i] = id.Hex()
+			}
+		}
+
+		b, _ := json.Marshal(list)
+		return string(b)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return helper.ToString(value)
+	}
+
+	return string(b)
+}
+
+// sqlScanRows reads every row into a DataMap, decoding each column
+// according to the model field it belongs to.
+func sqlScanRows(model *DataModel, rows *sql.Rows) ([]datatype.DataMap, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]datatype.DataMap, 0)
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range columns {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return result, err
+		}
+
+		data := make(datatype.DataMap, len(columns)+3)
+		for i, col := range columns {
+			data[col] = sqlDecodeValue(model, col, values[i])
+		}
+
+		if _, ok := data["_id"]; ok {
+			data["id"] = data["_id"]
+		}
+		data["_collection"] = model.Collection
+		data["_model"] = model.Name
+
+		result = append(result, data)
+	}
+
+	return result, rows.Err()
+}
+
+func sqlDecodeValue(model *DataModel, column string, value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	if column == SearchScoreKey || column == DistanceKey {
+		return helper.ToFloat(value)
+	}
+
+	field, ok := model.Fields[column]
+	if column == "_id" {
+		field, ok = DataModelField{Name: column, Kind: DataModelID, ID: true}, true
+	}
+
+	if !ok {
+		return value
+	}
+
+	if sqlIsJSONField(field) {
+		if v, ok := value.(string); ok {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+				return decoded
+			}
+		}
+
+		return value
+	}
+
+	switch field.Kind {
+	case DataModelID:
+		if v, ok := value.(string); ok {
+			if id, err := bson.ObjectIDFromHex(v); err == nil {
+				return id
+			}
+		}
+	case DataModelNumber:
+		switch v := value.(type) {
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		case float64:
+			return int64(v)
+		}
+	case DataModelFloat:
+		switch v := value.(type) {
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return n
+			}
+		case int64:
+			return float64(v)
+		}
+	case DataModelBool:
+		switch v := value.(type) {
+		case int64:
+			return v != 0
+		case string:
+			return v == "1" || strings.ToLower(v) == "true"
+		}
+	case DataModelDate:
+		if v, ok := value.(string); ok {
+			return helper.GetTimestamp(v)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,71 @@
+package yekonga
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+)
+
+func testSQLModel() *DataModel {
+	return &DataModel{
+		Collection: "products",
+		Fields: map[string]DataModelField{
+			"name":  {Name: "name", Kind: DataModelString},
+			"price": {Name: "price", Kind: DataModelNumber},
+			"tags":  {Name: "tags", Kind: DataModelArray},
+		},
+	}
+}
+
+func TestInsertWritesTheColumnsOfEveryRecord(t *testing.T) {
+	// The first record has no price and the second has no tags, as records
+	// read back from MongoDB often do
+	data := []datatype.DataMap{
+		{"_id": "a", "name": "Pen", "tags": []interface{}{"office"}},
+		{"_id": "b", "name": "Ink", "price": 4, "unknown": true},
+	}
+
+	for _, dialect := range []*sqlDialect{mysqlDialect, postgresDialect} {
+		stmt := newSQLStatement(dialect, testSQLModel())
+		query := stmt.insert(data)
+
+		want := "INSERT INTO `products` (`_id`, `name`, `price`, `tags`) VALUES (?, ?, ?, ?), (?, ?, ?, ?)"
+		if dialect == postgresDialect {
+			want = `INSERT INTO "products" ("_id", "name", "price", "tags") VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)`
+		}
+		if query != want {
+			t.Fatalf("query = %s, want %s", query, want)
+		}
+
+		args := []interface{}{"a", "Pen", nil, `["office"]`, "b", "Ink", 4, nil}
+		if !reflect.DeepEqual(stmt.args, args) {
+			t.Fatalf("args = %#v, want %#v", stmt.args, args)
+		}
+	}
+}
+
+func TestInsertOfOneRecord(t *testing.T) {
+	stmt := newSQLStatement(mysqlDialect, testSQLModel())
+	query := stmt.insert([]datatype.DataMap{{"_id": "a", "name": "Pen"}})
+
+	want := "INSERT INTO `products` (`_id`, `name`) VALUES (?, ?)"
+	if query != want {
+		t.Fatalf("query = %s, want %s", query, want)
+	}
+	if len(stmt.args) != 2 {
+		t.Fatalf("args = %#v, want 2 values", stmt.args)
+	}
+}
+
+func TestWhereComparesUnknownNumbersAsGiven(t *testing.T) {
+	stmt := newSQLStatement(mysqlDialect, testSQLModel())
+	query := stmt.where(datatype.DataMap{"stock": datatype.DataMap{"lessThan": 5}})
+
+	if want := "`stock` < ?"; query != want {
+		t.Fatalf("query = %s, want %s", query, want)
+	}
+	if args := []interface{}{5}; !reflect.DeepEqual(stmt.args, args) {
+		t.Fatalf("args = %#v, want %#v", stmt.args, args)
+	}
+}
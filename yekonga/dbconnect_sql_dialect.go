@@ -0,0 +1,526 @@
+package yekonga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// sqlDialectConnection runs the queries of a model on MySQL or PostgreSQL,
+// the dialect holds the syntax that differs between them.
+type sqlDialectConnection struct {
+	query   *DataModelQuery
+	ctx     *context.Context
+	client  sqlExecutor
+	dialect *sqlDialect
+	mut     sync.RWMutex
+}
+
+func newSQLDialectInstance(con *sqlDialectConnection) sqlDialectConnection {
+	return sqlDialectConnection{
+		ctx:     con.ctx,
+		client:  con.client,
+		dialect: con.dialect,
+		query: &DataModelQuery{
+			Model:            con.query.Model,
+			RequestContext:   con.query.RequestContext,
+			QueryContext:     con.query.QueryContext,
+			tx:               con.query.tx,
+			isAdmin:          con.query.isAdmin,
+			skipBeforeCommit: con.query.skipBeforeCommit,
+		},
+	}
+}
+
+func (con *sqlDialectConnection) connect() any {
+	return con.client
+}
+
+func (con *sqlDialectConnection) collection() any {
+	return con.client
+}
+
+func (con *sqlDialectConnection) statement() *sqlStatement {
+	return newSQLStatement(con.dialect, con.query.Model)
+}
+
+func (con *sqlDialectConnection) findOne() *datatype.DataMap {
+	stmt := con.statement()
+	query := con.buildSelectQuery(stmt)
+
+	if con.hasOrderBy() {
+		query += " " + stmt.orderBy(con.query.rankedOrdering())
+	}
+	query += " LIMIT 1"
+
+	result := con.queryRows("sqlDialectConnection.findOne", query, stmt.args)
+	if len(result) == 0 {
+		return nil
+	}
+
+	return &result[0]
+}
+
+func (con *sqlDialectConnection) findAll() *[]datatype.DataMap {
+	return con.find()
+}
+
+func (con *sqlDialectConnection) find() *[]datatype.DataMap {
+	stmt := con.statement()
+	query := con.buildSelectQuery(stmt)
+
+	if con.hasOrderBy() {
+		query += " " + stmt.orderBy(con.query.rankedOrdering())
+	}
+
+	if con.limit() > 0 {
+		query += fmt.Sprintf(" LIMIT %d", con.limit())
+
+		if con.skip() > 0 {
+			query += fmt.Sprintf(" OFFSET %d", con.skip())
+		}
+	}
+
+	result := con.queryRows("sqlDialectConnection.find", query, stmt.args)
+
+	return &result
+}
+
+func (con *sqlDialectConnection) queryRows(name string, query string, args []interface{}) []datatype.DataMap {
+	rows, err := con.client.QueryContext(*con.ctx, query, args...)
+	if err != nil {
+		logger.Error(name, err.Error())
+		return []datatype.DataMap{}
+	}
+	defer rows.Close()
+
+	result, err := sqlScanRows(con.query.Model, rows)
+	if err != nil {
+		logger.Error(name, err.Error())
+	}
+
+	return result
+}
+
+func (con *sqlDialectConnection) pagination() *datatype.DataMap {
+	var lastPage int64
+	total := con.count()
+	perPage := int64(con.limit())
+	if perPage <= 0 {
+		perPage = 10
+	}
+	currentPage := int64(con.page())
+	from := (perPage * (currentPage - 1)) + 1
+	to := perPage * (currentPage)
+	remainder := total % perPage
+
+	if remainder == 0 {
+		lastPage = (total) / perPage
+	} else {
+		lastPage = (total + (perPage - total%perPage)) / perPage
+	}
+
+	con.query.Take(int(perPage))
+
+	result := datatype.DataMap{
+		"total":       total,
+		"perPage":     perPage,
+		"currentPage": currentPage,
+		"lastPage":    lastPage,
+		"from":        from,
+		"to":          to,
+		"data":        con.find(),
+	}
+
+	return &result
+}
+
+func (con *sqlDialectConnection) seek(order []queryOrder, values []interface{}, limit int) *[]datatype.DataMap {
+	stmt := con.statement()
+	query := stmt.seekQuery(con.query.where, con.query.search, order, values, limit)
+
+	result := con.queryRows("sqlDialectConnection.seek", query, stmt.args)
+
+	return &result
+}
+
+// aggregate scans the aggregate expressions over the filtered records into
+// the targets, one per expression.
+func (con *sqlDialectConnection) aggregate(name string, expression string, targets ...interface{}) error {
+	stmt := con.statement()
+	query := fmt.Sprintf("SELECT %s FROM %s", expression, stmt.table())
+
+	if where := stmt.filter(con.query.where, con.query.search); helper.IsNotEmpty(where) {
+		query += " WHERE " + where
+	}
+
+	err := con.client.QueryRowContext(*con.ctx, query, stmt.args...).Scan(targets...)
+	if err != nil {
+		logger.Error(name, err.Error())
+	}
+
+	return err
+}
+
+func (con *sqlDialectConnection) count() int64 {
+	var count int64
+	if err := con.aggregate("sqlDialectConnection.count", "COUNT(*)", &count); err != nil {
+		return 0
+	}
+
+	return count
+}
+
+func (con *sqlDialectConnection) max(key string) interface{} {
+	var result interface{}
+	expression := fmt.Sprintf("MAX(%s)", con.dialect.quote(key))
+	if err := con.aggregate("sqlDialectConnection.max", expression, &result); err != nil {
+		return nil
+	}
+
+	return sqlDecodeValue(con.query.Model, key, result)
+}
+
+func (con *sqlDialectConnection) min(key string) interface{} {
+	var result interface{}
+	expression := fmt.Sprintf("MIN(%s)", con.dialect.quote(key))
+	if err := con.aggregate("sqlDialectConnection.min", expression, &result); err != nil {
+		return nil
+	}
+
+	return sqlDecodeValue(con.query.Model, key, result)
+}
+
+func (con *sqlDialectConnection) sum(key string) float64 {
+	var result float64
+	expression := fmt.Sprintf("COALESCE(SUM(%s), 0)", con.dialect.quote(key))
+	if err := con.aggregate("sqlDialectConnection.sum", expression, &result); err != nil {
+		return 0
+	}
+
+	return result
+}
+
+func (con *sqlDialectConnection) average(key string) float64 {
+	var result float64
+	expression := fmt.Sprintf("COALESCE(AVG(%s), 0)", con.dialect.quote(key))
+	if err := con.aggregate("sqlDialectConnection.average", expression, &result); err != nil {
+		return 0
+	}
+
+	return result
+}
+
+// summary counts the filtered records and sums the number fields, with their
+// largest, smallest and average values, in one query. The sums and bounds are
+// keyed by field.
+func (con *sqlDialectConnection) summary() *datatype.DataMap {
+	fields := con.query.Model.NumberFields
+	expressions := make([]string, 0, 1+4*len(fields))
+	expressions = append(expressions, "COUNT(*)")
+
+	for _, k := range fields {
+		column := con.dialect.quote(k)
+		expressions = append(expressions,
+			fmt.Sprintf("COALESCE(SUM(%s), 0)", column),
+			fmt.Sprintf("MAX(%s)", column),
+			fmt.Sprintf("MIN(%s)", column),
+			fmt.Sprintf("COALESCE(AVG(%s), 0)", column))
+	}
+
+	var count int64
+	values := make([]interface{}, 4*len(fields))
+	targets := make([]interface{}, 0, 1+len(values))
+	targets = append(targets, &count)
+	for i := range values {
+		targets = append(targets, &values[i])
+	}
+
+	sums := datatype.DataMap{}
+	maxes := datatype.DataMap{}
+	mins := datatype.DataMap{}
+	averages := datatype.DataMap{}
+
+	if err := con.aggregate("sqlDialectConnection.summary", strings.Join(expressions, ", "), targets...); err == nil {
+		for i, k := range fields {
+			sums[k] = helper.ToFloat64(sqlDecodeValue(con.query.Model, k, values[4*i]))
+			maxes[k] = sqlDecodeValue(con.query.Model, k, values[4*i+1])
+			mins[k] = sqlDecodeValue(con.query.Model, k, values[4*i+2])
+			averages[k] = helper.ToFloat64(sqlDecodeValue(con.query.Model, k, values[4*i+3]))
+		}
+	}
+
+	result := datatype.DataMap{
+		"count":   count,
+		"sum":     sums,
+		"max":     maxes,
+		"min":     mins,
+		"average": averages,
+		"graph":   *con.graph(),
+	}
+
+	return &result
+}
+
+// graph counts the filtered records per value of the group by fields, keyed
+// by the values joined with a comma. Without group by fields it is empty.
+func (con *sqlDialectConnection) graph() *datatype.DataMap {
+	result := datatype.DataMap{}
+	if len(con.query.groupBy) == 0 {
+		return &result
+	}
+
+	stmt := con.statement()
+	columns := make([]string, 0, len(con.query.groupBy))
+	for _, k := range con.query.groupBy {
+		columns = append(columns, stmt.quote(k))
+	}
+
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s", strings.Join(columns, ", "), stmt.table())
+	if where := stmt.filter(con.query.where, con.query.search); helper.IsNotEmpty(where) {
+		query += " WHERE " + where
+	}
+	query += " GROUP BY " + strings.Join(columns, ", ")
+
+	rows, err := con.client.QueryContext(*con.ctx, query, stmt.args...)
+	if err != nil {
+		logger.Error("sqlDialectConnection.graph", err.Error())
+		return &result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var count int64
+		values := make([]interface{}, len(columns))
+		targets := make([]interface{}, 0, len(columns)+1)
+		for i := range values {
+			targets = append(targets, &values[i])
+		}
+		targets = append(targets, &count)
+
+		if err := rows.Scan(targets...); err != nil {
+			logger.Error("sqlDialectConnection.graph", err.Error())
+			return &result
+		}
+
+		keys := make([]string, 0, len(values))
+		for i, k := range con.query.groupBy {
+			keys = append(keys, helper.ToString(sqlDecodeValue(con.query.Model, k, values[i])))
+		}
+
+		result[strings.Join(keys, ",")] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("sqlDialectConnection.graph", err.Error())
+	}
+
+	return &result
+}
+
+func (con *sqlDialectConnection) create(data datatype.DataMap) (*datatype.DataMap, error) {
+	result, err := con.createMany([]datatype.DataMap{data})
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil || len(*result) == 0 {
+		return nil, nil
+	}
+
+	return &(*result)[0], nil
+}
+
+func (con *sqlDialectConnection) createMany(data []datatype.DataMap) (*[]datatype.DataMap, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data provided")
+	}
+
+	stmt := con.statement()
+	ids := make([]interface{}, 0, len(data))
+
+	for _, record := range data {
+		if _, ok := record["_id"]; !ok {
+			record["_id"] = helper.ObjectID(record["id"])
+		}
+		ids = append(ids, record["_id"])
+	}
+
+	query := stmt.insert(data)
+
+	_, err := con.client.ExecContext(*con.ctx, query, stmt.args...)
+	if err != nil {
+		console.Log("sqlDialectConnection.createMany", err.Error())
+		return nil, err
+	}
+
+	where := datatype.DataMap{"_id": datatype.DataMap{"in": ids}}
+	instance := newSQLDialectInstance(con)
+	instance.query.WhereAll(where).Take(len(ids))
+
+	return instance.find(), nil
+}
+
+func (con *sqlDialectConnection) update(data datatype.DataMap) (*datatype.DataMap, error) {
+	current := con.findOne()
+	if current == nil {
+		return nil, nil
+	}
+
+	// The version is checked in the WHERE of the UPDATE, so the check and
+	// the write are one atomic statement
+	instance := newSQLDialectInstance(con)
+	instance.query.Where("_id", (*current)["_id"])
+	version, checked := con.query.versionCheck()
+	if checked {
+		instance.query.Where(VersionKey, version)
+	}
+
+	result, err := instance.exec("sqlDialectConnection.update", data)
+	if err != nil {
+		return nil, err
+	}
+
+	if checked {
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return nil, errVersionConflict
+		}
+		delete(instance.query.where, VersionKey)
+	}
+
+	return instance.findOne(), nil
+}
+
+func (con *sqlDialectConnection) updateMany(data datatype.DataMap) (*[]datatype.DataMap, error) {
+	ids := helper.GetList(con.find(), "_id")
+	if len(ids) == 0 {
+		return &[]datatype.DataMap{}, nil
+	}
+
+	instance := newSQLDialectInstance(con)
+	instance.query.Where("_id", datatype.DataMap{"in": ids}).Take(len(ids))
+
+	if _, err := instance.exec("sqlDialectConnection.updateMany", data); err != nil {
+		return nil, err
+	}
+
+	return instance.find(), nil
+}
+
+// exec runs an UPDATE of the given values against the current filter.
+func (con *sqlDialectConnection) exec(name string, data datatype.DataMap) (sql.Result, error) {
+	stmt := con.statement()
+	setParts := make([]string, 0, len(data))
+
+	for _, k := range stmt.columns(data) {
+		if k == "_id" {
+			continue
+		}
+		setParts = append(setParts, fmt.Sprintf("%s = %s", stmt.quote(k), stmt.bindField(k, data[k])))
+	}
+
+	if con.query.Model.Versioned {
+		setParts = append(setParts, stmt.versionIncrement())
+	}
+
+	if len(setParts) == 0 {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", stmt.table(), strings.Join(setParts, ", "), stmt.where(con.query.where))
+
+	result, err := con.client.ExecContext(*con.ctx, query, stmt.args...)
+	if err != nil {
+		console.Log(name, err.Error())
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (con *sqlDialectConnection) delete() (interface{}, error) {
+	stmt := con.statement()
+	where := stmt.where(con.query.where)
+
+	if !con.hasWhere() || helper.IsEmpty(where) {
+		return nil, errors.New("filter is empty, not allowed to delete all records at once")
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", stmt.table(), where)
+
+	result, err := con.client.ExecContext(*con.ctx, query, stmt.args...)
+	if err != nil {
+		console.Log("sqlDialectConnection.delete", err.Error())
+		return nil, err
+	}
+
+	deletedCount, _ := result.RowsAffected()
+
+	return datatype.DataMap{"DeletedCount": deletedCount}, nil
+}
+
+func (con *sqlDialectConnection) selection() *[]string {
+	return &[]string{}
+}
+
+func (con *sqlDialectConnection) where() *datatype.DataMap {
+	return &con.query.where
+}
+
+func (con *sqlDialectConnection) hasWhere() bool {
+	return len(con.query.where) > 0
+}
+
+func (con *sqlDialectConnection) hasOrderBy() bool {
+	_, near := con.query.nearest()
+
+	return len(con.query.orderBy) > 0 || helper.IsNotEmpty(con.query.search) || near
+}
+
+func (con *sqlDialectConnection) buildSelectQuery(stmt *sqlStatement) string {
+	return stmt.selectQuery(con.query)
+}
+
+func (con *sqlDialectConnection) groupBy() *[]interface{} {
+	return &[]interface{}{}
+}
+
+func (con *sqlDialectConnection) orderBy() *[]datatype.DataMap {
+	return &[]datatype.DataMap{}
+}
+
+func (con *sqlDialectConnection) limit() int {
+	if con.query.limit > 0 {
+		return con.query.limit
+	}
+
+	return -1
+}
+
+func (con *sqlDialectConnection) skip() int {
+	if con.query.skip > 0 {
+		return con.query.skip
+	}
+
+	if con.query.limit > 0 && con.query.page > 1 {
+		return con.query.limit * (con.query.page - 1)
+	}
+
+	return 0
+}
+
+func (con *sqlDialectConnection) page() int {
+	if con.query.page < 1 {
+		return 1
+	}
+
+	return con.query.page
+}
@@ -0,0 +1,270 @@
+package yekonga
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// sqlMigrationStore runs the migration steps on MySQL and PostgreSQL. The
+// backends differ in the column types, the schema lookups and the syntax to
+// drop an index.
+type sqlMigrationStore struct {
+	backend    string
+	client     *sql.DB
+	dialect    *sqlDialect
+	nameLimit  int
+	columnType func(field DataModelField) string
+	columns    func(table string) (map[string]string, error)
+	indexes    func(table string) (map[string]existingIndex, error)
+	dropQuery  func(table string, name string) string
+}
+
+func (s *sqlMigrationStore) exec(query string, args ...interface{}) error {
+	_, err := s.client.Exec(query, args...)
+	return err
+}
+
+func (s *sqlMigrationStore) addField(model *DataModel, field DataModelField) error {
+	columns, err := s.columns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := columns[field.Name]; exists {
+		return nil
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NULL",
+		s.dialect.quote(model.Collection), s.dialect.quote(field.Name), s.columnType(field))
+	if err := s.exec(query); err != nil {
+		return err
+	}
+
+	logger.Info(s.backend+" column added", model.Collection+"."+field.Name)
+
+	return nil
+}
+
+// renameField renames the column. When the new column was already added from
+// the database structure, the values are copied into it and the old column
+// is dropped.
+func (s *sqlMigrationStore) renameField(model *DataModel, field string, to string) error {
+	columns, err := s.columns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := columns[field]; !exists {
+		return nil
+	}
+
+	table := s.dialect.quote(model.Collection)
+
+	if _, exists := columns[to]; exists {
+		query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NULL",
+			table, s.dialect.quote(to), s.dialect.quote(field), s.dialect.quote(to))
+		if err := s.exec(query); err != nil {
+			return err
+		}
+
+		return s.dropField(model, field)
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, s.dialect.quote(field), s.dialect.quote(to))
+	if err := s.exec(query); err != nil {
+		return err
+	}
+
+	logger.Info(s.backend+" column renamed", model.Collection+"."+field, "->", to)
+
+	return nil
+}
+
+func (s *sqlMigrationStore) dropField(model *DataModel, field string) error {
+	columns, err := s.columns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := columns[field]; !exists {
+		return nil
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", s.dialect.quote(model.Collection), s.dialect.quote(field))
+	if err := s.exec(query); err != nil {
+		return err
+	}
+
+	logger.Info(s.backend+" column dropped", model.Collection+"."+field)
+
+	return nil
+}
+
+// changeType writes the converted values into a new column of the type and
+// swaps it in for the old one, so a failed conversion leaves the old column
+// as it was.
+func (s *sqlMigrationStore) changeType(model *DataModel, field DataModelField, convert func(value interface{}) interface{}) error {
+	columns, err := s.columns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	current, exists := columns[field.Name]
+	if !exists {
+		return s.addField(model, field)
+	}
+
+	table := s.dialect.quote(model.Collection)
+	column := s.dialect.quote(field.Name)
+	temporary := field.Name + "_migrating"
+
+	rows, err := s.client.Query(fmt.Sprintf("SELECT %s, %s FROM %s", s.dialect.quote("_id"), column, table))
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	for rows.Next() {
+		var id string
+		var value interface{}
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+
+		if v, ok := value.(string); ok && strings.Contains(strings.ToLower(current), "json") {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+				value = decoded
+			}
+		}
+
+		values[id] = value
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, exists := columns[temporary]; exists {
+		if err := s.dropField(model, temporary); err != nil {
+			return err
+		}
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NULL", table, s.dialect.quote(temporary), s.columnType(field))
+	if err := s.exec(query); err != nil {
+		return err
+	}
+
+	tx, err := s.client.Begin()
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s",
+		table, s.dialect.quote(temporary), s.dialect.placeholder(1), s.dialect.quote("_id"), s.dialect.placeholder(2))
+	for id, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if _, err := tx.Exec(query, sqlValue(convert(value)), id); err != nil {
+			tx.Rollback()
+			s.dropField(model, temporary)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.dropField(model, temporary)
+		return err
+	}
+
+	if err := s.exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, s.dialect.quote(temporary), column)
+	if err := s.exec(query); err != nil {
+		return err
+	}
+
+	logger.Info(s.backend+" column type changed", model.Collection+"."+field.Name, current, "->", s.columnType(field))
+
+	return nil
+}
+
+func (s *sqlMigrationStore) updateField(model *DataModel, field string, update func(record datatype.DataMap) (interface{}, bool)) error {
+	columns, err := s.columns(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := columns[field]; !exists {
+		return errors.New("column " + model.Collection + "." + field + " does not exist")
+	}
+
+	rows, err := s.client.Query(fmt.Sprintf("SELECT * FROM %s", s.dialect.quote(model.Collection)))
+	if err != nil {
+		return err
+	}
+
+	records, err := sqlScanRows(model, rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.client.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s",
+		s.dialect.quote(model.Collection), s.dialect.quote(field), s.dialect.placeholder(1), s.dialect.quote("_id"), s.dialect.placeholder(2))
+	for _, record := range records {
+		value, ok := update(record)
+		if !ok {
+			continue
+		}
+
+		if _, err := tx.Exec(query, sqlValue(value), sqlValue(record["_id"])); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlMigrationStore) dropIndex(model *DataModel, index DataModelIndex) error {
+	existing, err := s.indexes(model.Collection)
+	if err != nil {
+		return err
+	}
+
+	name := limitIndexName(index.Name, s.nameLimit)
+	if _, ok := existing[name]; !ok {
+		return nil
+	}
+
+	if err := s.exec(s.dropQuery(model.Collection, name)); err != nil {
+		return err
+	}
+
+	logger.Info(s.backend+" index dropped", model.Collection+"."+name)
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package yekonga
+
+import (
+	"math"
+	"sort"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// DistanceKey holds the distance in meters of a record found by a near
+// filter.
+const DistanceKey = "_distance"
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+// GeoPoint is the value of a geo field. It is stored as a GeoJSON point.
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type geoNear struct {
+	Field       string
+	Point       GeoPoint
+	MaxDistance float64
+}
+
+// toGeoPoint reads a point given as latitude and longitude, as a GeoJSON
+// point or as a [longitude, latitude] pair.
+func toGeoPoint(value interface{}) (GeoPoint, bool) {
+	switch v := value.(type) {
+	case GeoPoint:
+		return v, true
+	case *GeoPoint:
+		if v != nil {
+			return *v, true
+		}
+	case bson.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = e.Value
+		}
+		return toGeoPoint(m)
+	case datatype.DataMap:
+		return toGeoPoint(map[string]interface{}(v))
+	case map[string]interface{}:
+		if coordinates, ok := v["coordinates"]; ok {
+			return toGeoPoint(coordinates)
+		}
+
+		lat, okLat := v["latitude"]
+		lng, okLng := v["longitude"]
+		if !okLat || !okLng {
+			lat, okLat = v["lat"]
+			lng, okLng = v["lng"]
+		}
+		if okLat && okLng {
+			return GeoPoint{Latitude: helper.ToFloat(lat), Longitude: helper.ToFloat(lng)}.valid()
+		}
+	case bson.A:
+		return toGeoPoint([]interface{}(v))
+	case []interface{}:
+		if len(v) == 2 {
+			return GeoPoint{Latitude: helper.ToFloat(v[1]), Longitude: helper.ToFloat(v[0])}.valid()
+		}
+	case []float64:
+		if len(v) == 2 {
+			return GeoPoint{Latitude: v[1], Longitude: v[0]}.valid()
+		}
+	}
+
+	return GeoPoint{}, false
+}
+
+func (p GeoPoint) valid() (GeoPoint, bool) {
+	return p, p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+func (p GeoPoint) geoJSON() datatype.DataMap {
+	return datatype.DataMap{
+		"type":        "Point",
+		"coordinates": []interface{}{p.Longitude, p.Latitude},
+	}
+}
+
+// geoDistance is the great circle distance between two points in meters.
+func geoDistance(a GeoPoint, b GeoPoint) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLng/2), 2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// toGeoNear reads the near operator, a point and an optional maxDistance in
+// meters.
+func toGeoNear(value interface{}) (geoNear, bool) {
+	v := helper.ToMap[interface{}](value)
+	if v == nil {
+		return geoNear{}, false
+	}
+
+	point, ok := toGeoPoint(v["point"])
+	if !ok {
+		return geoNear{}, false
+	}
+
+	return geoNear{Point: point, MaxDistance: helper.ToFloat(v["maxDistance"])}, true
+}
+
+// toGeoBox reads the withinBox operator, the south west and north east
+// corners of the box.
+func toGeoBox(value interface{}) (GeoPoint, GeoPoint, bool) {
+	var sw, ne GeoPoint
+	var okSW, okNE bool
+
+	if v := helper.ToMap[interface{}](value); v != nil {
+		sw, okSW = toGeoPoint(v["southWest"])
+		ne, okNE = toGeoPoint(v["northEast"])
+	} else if v, ok := value.([]interface{}); ok && len(v) == 2 {
+		sw, okSW = toGeoPoint(v[0])
+		ne, okNE = toGeoPoint(v[1])
+	}
+
+	return sw, ne, okSW && okNE && sw.Latitude <= ne.Latitude && sw.Longitude <= ne.Longitude
+}
+
+// toGeoPolygon reads the withinPolygon operator, the corners of the polygon
+// in order. The polygon is closed by the backends.
+func toGeoPolygon(value interface{}) ([]GeoPoint, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	polygon := make([]GeoPoint, 0, len(list)+1)
+	for _, v := range list {
+		point, ok := toGeoPoint(v)
+		if !ok {
+			return nil, false
+		}
+		polygon = append(polygon, point)
+	}
+
+	if len(polygon) > 1 && polygon[0] == polygon[len(polygon)-1] {
+		polygon = polygon[:len(polygon)-1]
+	}
+
+	return polygon, len(polygon) >= 3
+}
+
+// closedPolygon returns the polygon with the first corner repeated at the
+// end, as GeoJSON and WKT expect.
+func closedPolygon(polygon []GeoPoint) []GeoPoint {
+	return append(append([]GeoPoint{}, polygon...), polygon[0])
+}
+
+// geoInPolygon reports whether the point lies inside the polygon, by
+// counting the edges a ray from the point crosses.
+func geoInPolygon(p GeoPoint, polygon []GeoPoint) bool {
+	inside := false
+
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) &&
+			p.Longitude < (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// geoMatches applies a geo operator to a stored value, used by the backends
+// that filter in memory.
+func geoMatches(op string, condition interface{}, value interface{}) bool {
+	point, ok := toGeoPoint(value)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case "near":
+		near, ok := toGeoNear(condition)
+		return ok && (near.MaxDistance <= 0 || geoDistance(near.Point, point) <= near.MaxDistance)
+	case "withinBox":
+		sw, ne, ok := toGeoBox(condition)
+		return ok && point.Latitude >= sw.Latitude && point.Latitude <= ne.Latitude &&
+			point.Longitude >= sw.Longitude && point.Longitude <= ne.Longitude
+	case "withinPolygon":
+		polygon, ok := toGeoPolygon(condition)
+		return ok && geoInPolygon(point, polygon)
+	}
+
+	return false
+}
+
+// geoBounds is the box around a geo operator, used to look up the
+// candidates in a grid index. ok is false when the operator has no bounds.
+func geoBounds(op string, condition interface{}) (sw GeoPoint, ne GeoPoint, ok bool) {
+	switch op {
+	case "near":
+		near, ok := toGeoNear(condition)
+		if !ok || near.MaxDistance <= 0 {
+			return sw, ne, false
+		}
+
+		dLat := near.MaxDistance / earthRadius * 180 / math.Pi
+		dLng := 180.0
+		if c := math.Cos(near.Point.Latitude * math.Pi / 180); c > 0.01 {
+			dLng = math.Min(180, dLat/c)
+		}
+
+		sw = GeoPoint{Latitude: math.Max(-90, near.Point.Latitude-dLat), Longitude: math.Max(-180, near.Point.Longitude-dLng)}
+		ne = GeoPoint{Latitude: math.Min(90, near.Point.Latitude+dLat), Longitude: math.Min(180, near.Point.Longitude+dLng)}
+
+		return sw, ne, true
+	case "withinBox":
+		return toGeoBox(condition)
+	case "withinPolygon":
+		polygon, ok := toGeoPolygon(condition)
+		if !ok {
+			return sw, ne, false
+		}
+
+		sw, ne = polygon[0], polygon[0]
+		for _, p := range polygon[1:] {
+			sw.Latitude = math.Min(sw.Latitude, p.Latitude)
+			sw.Longitude = math.Min(sw.Longitude, p.Longitude)
+			ne.Latitude = math.Max(ne.Latitude, p.Latitude)
+			ne.Longitude = math.Max(ne.Longitude, p.Longitude)
+		}
+
+		return sw, ne, true
+	}
+
+	return sw, ne, false
+}
+
+// Near limits the query to the records whose geo field lies within
+// maxDistance meters of the point, nearest first. A maxDistance of zero
+// only sorts by distance. The distance is returned in the _distance field.
+func (m *DataModelQuery) Near(field string, point GeoPoint, maxDistance float64) *DataModelQuery {
+	return m.Where(field, datatype.DataMap{
+		"near": datatype.DataMap{
+			"point":       datatype.DataMap{"latitude": point.Latitude, "longitude": point.Longitude},
+			"maxDistance": maxDistance,
+		},
+	})
+}
+
+// nearest returns the near filter of the query, which orders the records by
+// distance. Only a near filter on the top level of the where orders them.
+func (m *DataModelQuery) nearest() (geoNear, bool) {
+	keys := make([]string, 0, len(m.Model.GeoFields))
+	for _, k := range m.Model.GeoFields {
+		if _, ok := m.where[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		condition := helper.ToMap[interface{}](m.where[k])
+		if condition == nil {
+			continue
+		}
+
+		if near, ok := toGeoNear(condition["near"]); ok {
+			near.Field = k
+
+			return near, true
+		}
+	}
+
+	return geoNear{}, false
+}
+
+// addDistance sets the distance to the near point on the records, for the
+// backends that do not return it.
+func (near geoNear) addDistance(rows []datatype.DataMap) {
+	for _, row := range rows {
+		if point, ok := toGeoPoint(row[near.Field]); ok {
+			row[DistanceKey] = geoDistance(near.Point, point)
+		}
+	}
+}
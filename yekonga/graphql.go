@@ -1,16 +1,20 @@
 package yekonga
 
 import (
+	"errors"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/helper"
 	"github.com/robertkonga/yekonga-server-go/helper/console"
 	"github.com/robertkonga/yekonga-server-go/helper/logger"
 	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/language/ast"
 )
 
 type EmptyKey string
@@ -48,12 +52,16 @@ type FilterValue struct {
 type GraphqlSubscription struct {
 	Client *Client
 	Model  string
+	Event  string
 	Body   struct {
 		Query         string
 		OperationName string
 		Variables     map[string]interface{}
 	}
 	Headers map[string]interface{}
+
+	params graphql.ResolveParams
+	events chan graphqlSubscriptionEvent
 }
 
 type GraphqlActionResult struct {
@@ -65,7 +73,7 @@ type GraphqlActionResult struct {
 
 type GraphqlAutoBuild struct {
 	yekonga             *YekongaData
-	GraphqlSubscription map[string]map[string]GraphqlSubscription
+	GraphqlSubscription map[string]map[string]*GraphqlSubscription
 	Database            map[string]*DataModel
 	EnumTypes           map[string]*graphql.Enum
 	QueryTypes          map[string]*graphql.Object
@@ -90,7 +98,7 @@ func NewGraphqlAutoBuild(yekonga *YekongaData, database map[string]*DataModel) *
 	autoBuild := GraphqlAutoBuild{
 		yekonga:             yekonga,
 		Database:            database,
-		GraphqlSubscription: make(map[string]map[string]GraphqlSubscription),
+		GraphqlSubscription: make(map[string]map[string]*GraphqlSubscription),
 		EnumTypes:           make(map[string]*graphql.Enum),
 		QueryTypes:          make(map[string]*graphql.Object),
 		MutationTypes:       make(map[string]*graphql.InputObject),
@@ -149,6 +157,7 @@ func (g *GraphqlAutoBuild) initialize() {
 			if helper.IsNotEmpty(vi.Model) {
 				g.QueryTypes[k].AddFieldConfig(ki, g.getRelativeQueryField(ki, vi.Model.Name, false, foreignKey, targetKey))
 				g.QueryTypes[k].AddFieldConfig(helper.ToVariable(helper.Singularize(ki)+"_paginate"), g.getQueryPaginationField(vi.Model.Name, foreignKey, targetKey))
+				g.QueryTypes[k].AddFieldConfig(helper.ToVariable(helper.Singularize(ki)+"_connection"), g.getQueryConnectionField(vi.Model.Name, foreignKey, targetKey))
 				g.QueryTypes[k].AddFieldConfig(helper.ToVariable(helper.Singularize(ki)+"_summary"), g.getQuerySummaryField(vi.Model.Name, foreignKey, targetKey))
 
 				g.MutationTypes[helper.ToCamelCase(k+"_input")].AddFieldConfig(helper.ToVariable(helper.Pluralize(ki)), &graphql.InputObjectFieldConfig{
@@ -169,8 +178,9 @@ func (g *GraphqlAutoBuild) initialize() {
 	}
 
 	s, err := graphql.NewSchema(graphql.SchemaConfig{
-		Query:    g.GetQuery(),
-		Mutation: g.GetMutation(),
+		Query:        g.GetQuery(),
+		Mutation:     g.GetMutation(),
+		Subscription: g.GetSubscription(),
 	})
 
 	if err != nil {
@@ -193,7 +203,7 @@ func (g *GraphqlAutoBuild) initialize() {
 func (g *GraphqlAutoBuild) GetQuery() *graphql.Object {
 	var fields = make(graphql.Fields)
 
-	for k := range g.Database {
+	for k, model := range g.Database {
 		var foreignKey string
 		var targetKey string
 		k = helper.ToVariable(helper.Singularize(k))
@@ -201,8 +211,26 @@ func (g *GraphqlAutoBuild) GetQuery() *graphql.Object {
 		fields[k] = g.getQuerySingleField(k, foreignKey, targetKey)
 		fields[helper.Pluralize(k)] = g.getQueryMultipleField(k, foreignKey, targetKey)
 		fields[helper.ToVariable(k+"_paginate")] = g.getQueryPaginationField(k, foreignKey, targetKey)
+		fields[helper.ToVariable(k+"_connection")] = g.getQueryConnectionField(k, foreignKey, targetKey)
 		fields[helper.ToVariable(k+"_summary")] = g.getQuerySummaryField(k, foreignKey, targetKey)
 		fields[helper.ToVariable("download_"+helper.Pluralize(k))] = g.getQueryDownloadField(k, foreignKey, targetKey)
+
+		if model.Audit.Enabled {
+			if field := g.getQueryHistoryField(k); field != nil {
+				fields[helper.ToVariable(k+"_history")] = field
+			}
+		}
+
+		if model.Revisions {
+			if field := g.getQueryRevisionsField(k); field != nil {
+				fields[helper.ToVariable(k+"_revisions")] = field
+			}
+			fields[helper.ToVariable(k+"_as_of")] = g.getQueryAsOfField(k)
+		}
+	}
+
+	if field := g.getQuerySearchField(); field != nil {
+		fields["search"] = field
 	}
 
 	g.setCustomQuery(&fields, QueryType)
@@ -219,7 +247,7 @@ func (g *GraphqlAutoBuild) GetQuery() *graphql.Object {
 func (g *GraphqlAutoBuild) GetMutation() *graphql.Object {
 	var fields = make(graphql.Fields)
 
-	for k := range g.Database {
+	for k, model := range g.Database {
 		var foreignKey string
 		var targetKey string
 		k = helper.ToVariable(helper.Singularize(k))
@@ -229,6 +257,15 @@ func (g *GraphqlAutoBuild) GetMutation() *graphql.Object {
 		fields[helper.ToVariable("update_"+k)] = g.getMutationUpdateField(k, foreignKey, targetKey)
 		fields[helper.ToVariable("delete_"+k)] = g.getMutationDeleteField(k, foreignKey, targetKey)
 		fields[helper.ToVariable(k+"_action")] = g.getMutationActionField(k, foreignKey, targetKey)
+
+		if model.Revisions {
+			fields[helper.ToVariable("restore_"+k+"_revision")] = g.getMutationRestoreRevisionField(k)
+		}
+
+		if model.SoftDelete {
+			fields[helper.ToVariable("restore_"+k)] = g.getMutationRestoreField(k, foreignKey, targetKey)
+			fields[helper.ToVariable("force_delete_"+k)] = g.getMutationForceDeleteField(k, foreignKey, targetKey)
+		}
 	}
 
 	g.setCustomQuery(&fields, MutationType)
@@ -320,6 +357,14 @@ func (g *GraphqlAutoBuild) getQuerySingleField(collection string, foreignKey str
 				return nil, nil
 			}
 
+			if related, ok := g.loadRelated(&p, name, foreignKey, targetKey, true); ok {
+				if len(related) > 0 {
+					return related[0], nil
+				}
+
+				return nil, nil
+			}
+
 			data := model.FindOne(nil)
 
 			if helper.IsNotEmpty(data) {
@@ -353,6 +398,9 @@ func (g *GraphqlAutoBuild) getQueryMultipleField(collection string, foreignKey s
 			"orderBy": &graphql.ArgumentConfig{
 				Type: orderByKind,
 			},
+			"search": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
 			"groupBy": &graphql.ArgumentConfig{
 				Type: groupByKind,
 			},
@@ -376,6 +424,14 @@ func (g *GraphqlAutoBuild) getQueryMultipleField(collection string, foreignKey s
 			var model = g.yekonga.ModelQuery(name)
 			g.setModelParams(model, &p, foreignKey, targetKey, false)
 
+			if related, ok := g.loadRelated(&p, name, foreignKey, targetKey, false); ok {
+				if related == nil {
+					related = []datatype.DataMap{}
+				}
+
+				return related, nil
+			}
+
 			data := model.Find(nil)
 
 			g.loadRelatedData(data, model, &p, foreignKey, targetKey)
@@ -404,6 +460,9 @@ func (g *GraphqlAutoBuild) getQueryPaginationField(collection string, foreignKey
 			"orderBy": &graphql.ArgumentConfig{
 				Type: orderByKind,
 			},
+			"search": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
 			"groupBy": &graphql.ArgumentConfig{
 				Type: groupByKind,
 			},
@@ -440,6 +499,284 @@ func (g *GraphqlAutoBuild) getQueryPaginationField(collection string, foreignKey
 	}
 }
 
+func (g *GraphqlAutoBuild) getQueryConnectionField(collection string, foreignKey string, targetKey string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	queryKind := g.QueryTypes[helper.ToCamelCase(name+"_connection")]
+	whereKind := g.MutationTypes[helper.ToCamelCase("where_"+name+"_input")]
+	orderByKind := g.MutationTypes[helper.ToCamelCase("order_by_"+name+"_input")]
+
+	return &graphql.Field{
+		Type:        queryKind,
+		Description: fmt.Sprintf("Cursor connection of %v", strings.ToTitle(helper.Pluralize(name))),
+		Args: graphql.FieldConfigArgument{
+			"where": &graphql.ArgumentConfig{
+				Type: whereKind,
+			},
+			"orderBy": &graphql.ArgumentConfig{
+				Type: orderByKind,
+			},
+			"search": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"first": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"after": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"last": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"before": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessRole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"route": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var model = g.yekonga.ModelQuery(name)
+			if !g.setModelParams(model, &p, foreignKey, targetKey, false) {
+				return nil, nil
+			}
+
+			args := ConnectionArgs{
+				After:      helper.GetValueOfString(p.Args, "after"),
+				Before:     helper.GetValueOfString(p.Args, "before"),
+				TotalCount: graphqlSelects(p, "totalCount"),
+			}
+			if v, ok := p.Args["first"].(int); ok {
+				args.First = v
+			}
+			if v, ok := p.Args["last"].(int); ok {
+				args.Last = v
+			}
+
+			result := model.Connection(nil, args)
+			if err, ok := result.(error); ok {
+				return nil, err
+			}
+
+			data, ok := result.(*datatype.DataMap)
+			if !ok || data == nil {
+				return nil, nil
+			}
+
+			if edges, ok := (*data)["edges"].([]datatype.DataMap); ok {
+				nodes := make([]datatype.DataMap, len(edges))
+				for i, edge := range edges {
+					nodes[i], _ = edge["node"].(datatype.DataMap)
+				}
+
+				g.loadRelatedData(&nodes, model, &p, foreignKey, targetKey)
+
+				for i := range edges {
+					edges[i]["node"] = nodes[i]
+				}
+			}
+
+			return *data, nil
+		},
+	}
+}
+
+// getQueryHistoryField lists the audit entries of a record, newest first.
+func (g *GraphqlAutoBuild) getQueryHistoryField(collection string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	queryKind, ok := g.QueryTypes["AuditTrail"]
+	if !ok {
+		return nil
+	}
+
+	return &graphql.Field{
+		Type:        graphql.NewList(queryKind),
+		Description: fmt.Sprintf("Revisions of a %v", name),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(ScalarIDType),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
+			limit, _ := p.Args["limit"].(int)
+
+			return g.yekonga.History(name, p.Args["id"], limit, ctx), nil
+		},
+	}
+}
+
+// getQueryRevisionsField lists the revisions of a record, newest first.
+func (g *GraphqlAutoBuild) getQueryRevisionsField(collection string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	queryKind, ok := g.QueryTypes[RevisionModel]
+	if !ok {
+		return nil
+	}
+
+	return &graphql.Field{
+		Type:        graphql.NewList(queryKind),
+		Description: fmt.Sprintf("Revisions of a %v", name),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(ScalarIDType),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var model = g.yekonga.ModelQuery(name)
+			g.setModelParams(model, &p, "", "", false)
+			limit, _ := p.Args["limit"].(int)
+
+			return model.Revisions(p.Args["id"], limit), nil
+		},
+	}
+}
+
+// getQueryAsOfField returns a record as it was at a time.
+func (g *GraphqlAutoBuild) getQueryAsOfField(collection string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	return &graphql.Field{
+		Type:        g.QueryTypes[name],
+		Description: fmt.Sprintf("%v as it was at a time", name),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(ScalarIDType),
+			},
+			"timestamp": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(ScalarDateType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var model = g.yekonga.ModelQuery(name)
+			g.setModelParams(model, &p, "", "", false)
+
+			at, ok := p.Args["timestamp"].(time.Time)
+			if !ok {
+				return nil, errors.New("timestamp must be a date")
+			}
+
+			if data := model.AsOf(p.Args["id"], at); data != nil {
+				return *data, nil
+			}
+
+			return nil, nil
+		},
+	}
+}
+
+// getQuerySearchField is the search across the models with search fields.
+// Each hit holds the model name, the relevance and the record, resolved to
+// the type of its model.
+func (g *GraphqlAutoBuild) getQuerySearchField() *graphql.Field {
+	names := make([]string, 0)
+	for k, model := range g.Database {
+		if len(model.SearchFields) > 0 {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	types := make([]*graphql.Object, 0, len(names))
+	for _, k := range names {
+		types = append(types, g.QueryTypes[helper.ToCamelCase(helper.Singularize(g.Database[k].Name))])
+	}
+
+	nodeKind := graphql.NewUnion(graphql.UnionConfig{
+		Name:  "SearchNode",
+		Types: types,
+		ResolveType: func(p graphql.ResolveTypeParams) *graphql.Object {
+			data := helper.ToMap[interface{}](p.Value)
+
+			return g.QueryTypes[helper.ToCamelCase(helper.Singularize(helper.ToString(data["_model"])))]
+		},
+	})
+
+	hitKind := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchHit",
+		Fields: graphql.Fields{
+			"model": &graphql.Field{
+				Type: graphql.String,
+			},
+			"score": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"node": &graphql.Field{
+				Type: nodeKind,
+			},
+		},
+	})
+
+	return &graphql.Field{
+		Type:        graphql.NewList(hitKind),
+		Description: "Search across the searchable models, by relevance",
+		Args: graphql.FieldConfigArgument{
+			"text": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"models": &graphql.ArgumentConfig{
+				Type: graphql.NewList(graphql.String),
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
+
+			var models []string
+			if v, ok := p.Args["models"].([]interface{}); ok {
+				for _, m := range v {
+					models = append(models, helper.ToString(m))
+				}
+			}
+
+			limit, _ := p.Args["limit"].(int)
+			text, _ := p.Args["text"].(string)
+
+			hits := g.yekonga.Search(text, models, limit, ctx)
+			result := make([]datatype.DataMap, 0, len(hits))
+
+			for _, hit := range hits {
+				modelName := helper.ToString(hit["_model"])
+				model := g.yekonga.ModelQuery(modelName)
+				if model == nil {
+					continue
+				}
+				model.RequestContext = ctx
+
+				node := datatype.DataMap(g.formateOutputData(model, hit, "", ""))
+				if ctx != nil {
+					ctx.DataLoader().Register([]datatype.DataMap{node})
+				}
+
+				result = append(result, datatype.DataMap{
+					"model": modelName,
+					"score": hit[SearchScoreKey],
+					"node":  node,
+				})
+			}
+
+			return result, nil
+		},
+	}
+}
+
 func (g *GraphqlAutoBuild) getQueryDownloadField(collection string, foreignKey string, targetKey string) *graphql.Field {
 	name := helper.ToCamelCase(helper.Singularize(collection))
 
@@ -1024,6 +1361,10 @@ func (g *GraphqlAutoBuild) getMutationCreateField(collection string, foreignKey
 			g.setModelParams(model, &p, foreignKey, targetKey, false)
 			created := model.Create(data)
 
+			if err, ok := created.(*ValidationError); ok {
+				return nil, err
+			}
+
 			for ki, vi := range model.Model.ChildrenFields {
 				var foreignKey string = vi.ForeignKey
 				var targetKey string = vi.PrimaryKey
@@ -1100,6 +1441,11 @@ func (g *GraphqlAutoBuild) getMutationImportField(collection string, foreignKey
 
 			g.setModelParams(model, &p, foreignKey, targetKey, false)
 			imported := model.Import(data, uniqueKeys)
+
+			if err, ok := imported.(*ValidationError); ok {
+				return nil, err
+			}
+
 			savedData := helper.GetValueOf(imported, "data")
 
 			if helper.IsArray(savedData) {
@@ -1175,22 +1521,30 @@ func (g *GraphqlAutoBuild) getMutationUpdateField(collection string, foreignKey
 	resultKind := g.QueryTypes[helper.ToCamelCase("update_"+name+"_input_result_output")]
 	whereKind := g.MutationTypes[helper.ToCamelCase("where_"+name+"_input")]
 
+	args := graphql.FieldConfigArgument{
+		"input": &graphql.ArgumentConfig{
+			Type: inputKind,
+		},
+		"where": &graphql.ArgumentConfig{
+			Type: whereKind,
+		},
+		"accessRole": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"route": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	}
+
+	if model := g.yekonga.Model(name); model != nil && model.Versioned {
+		args["expectedVersion"] = &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		}
+	}
+
 	return &graphql.Field{
 		Type: resultKind,
-		Args: graphql.FieldConfigArgument{
-			"input": &graphql.ArgumentConfig{
-				Type: inputKind,
-			},
-			"where": &graphql.ArgumentConfig{
-				Type: whereKind,
-			},
-			"accessRole": &graphql.ArgumentConfig{
-				Type: graphql.String,
-			},
-			"route": &graphql.ArgumentConfig{
-				Type: graphql.String,
-			},
-		},
+		Args: args,
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
 			var data = helper.ToDataMap(g.getInputData(p.Args))
@@ -1198,12 +1552,26 @@ func (g *GraphqlAutoBuild) getMutationUpdateField(collection string, foreignKey
 			var result datatype.DataMap = make(datatype.DataMap)
 			g.setModelParams(model, &p, foreignKey, targetKey, false)
 
+			if v, ok := p.Args["expectedVersion"]; ok && v != nil {
+				model.ExpectVersion(helper.ToInt(v))
+			}
+
 			result["success"] = false
 			result["status"] = false
 			result["message"] = "Fail"
 			result["data"] = nil
 
 			updated := model.Update(data, nil)
+
+			if err, ok := updated.(*ValidationError); ok {
+				return nil, err
+			}
+
+			if err, ok := updated.(*ConflictError); ok {
+				err.Current = g.formateOutputData(model, err.Current, "", "")
+				return nil, err
+			}
+
 			// console.Log("updated", model.where)
 			// console.Log("updated", updated)
 			id := helper.GetValueOf(updated, "_id")
@@ -1293,6 +1661,145 @@ func (g *GraphqlAutoBuild) getMutationDeleteField(collection string, foreignKey
 	}
 }
 
+func (g *GraphqlAutoBuild) getMutationRestoreField(collection string, foreignKey string, targetKey string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	whereKind := g.MutationTypes[helper.ToCamelCase("where_"+name+"_input")]
+	resultKind := g.QueryTypes[helper.ToCamelCase("delete_"+name+"_input_result_output")]
+
+	return &graphql.Field{
+		Type: resultKind,
+		Args: graphql.FieldConfigArgument{
+			"where": &graphql.ArgumentConfig{
+				Type: whereKind,
+			},
+			"accessRole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"route": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var result = make(datatype.DataMap)
+			var model = g.yekonga.ModelQuery(name)
+			g.setModelParams(model, &p, foreignKey, targetKey, false)
+
+			result["success"] = false
+			result["status"] = false
+			result["message"] = "Fail"
+			result["data"] = nil
+			restored := model.Restore(nil)
+
+			if err, ok := restored.(error); ok {
+				return nil, err
+			}
+
+			if list, ok := restored.(*[]datatype.DataMap); ok && list != nil && len(*list) > 0 {
+				result["success"] = true
+				result["status"] = true
+				result["message"] = "Success"
+				result["data"] = *list
+			}
+
+			return result, nil
+		},
+	}
+}
+
+func (g *GraphqlAutoBuild) getMutationRestoreRevisionField(collection string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	resultKind := g.QueryTypes[helper.ToCamelCase("update_"+name+"_input_result_output")]
+
+	return &graphql.Field{
+		Type: resultKind,
+		Args: graphql.FieldConfigArgument{
+			"revisionId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(ScalarIDType),
+			},
+			"accessRole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"route": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var result = make(datatype.DataMap)
+			var model = g.yekonga.ModelQuery(name)
+			g.setModelParams(model, &p, "", "", false)
+
+			result["success"] = false
+			result["status"] = false
+			result["message"] = "Fail"
+			result["data"] = nil
+
+			restored := model.RestoreRevision(p.Args["revisionId"])
+
+			if err, ok := restored.(*ValidationError); ok {
+				return nil, err
+			}
+
+			if err, ok := restored.(error); ok {
+				return nil, err
+			}
+
+			if helper.IsNotEmpty(helper.GetValueOf(restored, "_id")) {
+				result["success"] = true
+				result["status"] = true
+				result["message"] = "Success"
+				result["data"] = helper.ToMap[interface{}](restored)
+			}
+
+			return result, nil
+		},
+	}
+}
+
+func (g *GraphqlAutoBuild) getMutationForceDeleteField(collection string, foreignKey string, targetKey string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	whereKind := g.MutationTypes[helper.ToCamelCase("where_"+name+"_input")]
+	resultKind := g.QueryTypes[helper.ToCamelCase("delete_"+name+"_input_result_output")]
+
+	return &graphql.Field{
+		Type: resultKind,
+		Args: graphql.FieldConfigArgument{
+			"where": &graphql.ArgumentConfig{
+				Type: whereKind,
+			},
+			"accessRole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"route": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var result = make(datatype.DataMap)
+			var model = g.yekonga.ModelQuery(name)
+			g.setModelParams(model, &p, foreignKey, targetKey, false)
+
+			result["success"] = false
+			result["status"] = false
+			result["message"] = "Fail"
+			result["data"] = nil
+			deleted := helper.ToMap[interface{}](model.ForceDelete(nil))
+			deletedCount := helper.ToFloat(helper.GetValueOf(deleted, "DeletedCount"))
+
+			if deletedCount > 0 {
+				result["success"] = true
+				result["status"] = true
+				result["message"] = "Success"
+				result["data"] = nil
+			}
+
+			return result, nil
+		},
+	}
+}
+
 func (g *GraphqlAutoBuild) getMutationActionField(collection string, foreignKey string, targetKey string) *graphql.Field {
 	name := helper.ToCamelCase(helper.Singularize(collection))
 
@@ -1387,6 +1894,18 @@ func (g *GraphqlAutoBuild) addQueryType(collection string, model *DataModel) {
 		fields[k] = g.getQueryField(k, &v)
 	}
 
+	if len(model.SearchFields) > 0 {
+		fields[SearchScoreKey] = &graphql.Field{
+			Type: graphql.Float,
+		}
+	}
+
+	if len(model.GeoFields) > 0 {
+		fields[DistanceKey] = &graphql.Field{
+			Type: graphql.Float,
+		}
+	}
+
 	modelFields := graphql.NewObject(graphql.ObjectConfig{
 		Name:   name,
 		Fields: fields,
@@ -1438,6 +1957,37 @@ func (g *GraphqlAutoBuild) addQueryType(collection string, model *DataModel) {
 	})
 	g.QueryTypes[paginateName] = modelPaginate
 
+	var edgeName = helper.ToCamelCase(name + "_edge")
+	modelEdge := graphql.NewObject(graphql.ObjectConfig{
+		Name: edgeName,
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: modelFields,
+			},
+		},
+	})
+	g.QueryTypes[edgeName] = modelEdge
+
+	var connectionName = helper.ToCamelCase(name + "_connection")
+	modelConnection := graphql.NewObject(graphql.ObjectConfig{
+		Name: connectionName,
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(modelEdge),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(PageInfoType),
+			},
+			"totalCount": &graphql.Field{
+				Type: graphql.Int,
+			},
+		},
+	})
+	g.QueryTypes[connectionName] = modelConnection
+
 	var downloadName = helper.ToCamelCase("download_" + helper.Pluralize(name))
 	var downloadFields = graphql.Fields{
 		"filename": &graphql.Field{
@@ -1732,6 +2282,12 @@ func (g *GraphqlAutoBuild) addOrderByInputType(collection string, model *DataMod
 }
 
 func (g *GraphqlAutoBuild) getQueryField(name string, field *DataModelField) *graphql.Field {
+	if field.Kind == DataModelGeo {
+		return &graphql.Field{
+			Name: name,
+			Type: GeoPointType,
+		}
+	}
 
 	scalar := graphql.String
 
@@ -1779,6 +2335,17 @@ func (g *GraphqlAutoBuild) getRelativeQueryField(fieldName string, modelName str
 }
 
 func (g *GraphqlAutoBuild) getInputField(name string, field *DataModelField) *graphql.InputObjectFieldConfig {
+	if field.Kind == DataModelGeo {
+		if field.Required {
+			return &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(GeoPointInputType),
+			}
+		}
+
+		return &graphql.InputObjectFieldConfig{
+			Type: GeoPointInputType,
+		}
+	}
 
 	scalar := graphql.String
 
@@ -1915,6 +2482,47 @@ func (g *GraphqlAutoBuild) getParamValue(params map[string]interface{}, key stri
 	return value
 }
 
+// graphqlSelects reports whether the resolved field selects the named sub
+// field, looking into fragments.
+func graphqlSelects(p graphql.ResolveParams, name string) bool {
+	var selects func(set *ast.SelectionSet) bool
+	selects = func(set *ast.SelectionSet) bool {
+		if set == nil {
+			return false
+		}
+
+		for _, selection := range set.Selections {
+			switch s := selection.(type) {
+			case *ast.Field:
+				if s.Name != nil && s.Name.Value == name {
+					return true
+				}
+			case *ast.InlineFragment:
+				if selects(s.SelectionSet) {
+					return true
+				}
+			case *ast.FragmentSpread:
+				if s.Name == nil {
+					continue
+				}
+				if fragment, ok := p.Info.Fragments[s.Name.Value].(*ast.FragmentDefinition); ok && selects(fragment.SelectionSet) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	for _, field := range p.Info.FieldASTs {
+		if selects(field.SelectionSet) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (g *GraphqlAutoBuild) getInputData(params map[string]interface{}) interface{} {
 	var input interface{}
 
@@ -1934,6 +2542,28 @@ func (g *GraphqlAutoBuild) getWhereInputField(collection string, name string, fi
 	scalar := graphql.String
 	fields := make(graphql.InputObjectConfigFieldMap)
 
+	if field.Kind == DataModelGeo {
+		fields["near"] = &graphql.InputObjectFieldConfig{
+			Type: GeoNearInputType,
+		}
+		fields["withinBox"] = &graphql.InputObjectFieldConfig{
+			Type: GeoBoxInputType,
+		}
+		fields["withinPolygon"] = &graphql.InputObjectFieldConfig{
+			Type: graphql.NewList(GeoPointInputType),
+		}
+		fields["exists"] = &graphql.InputObjectFieldConfig{
+			Type: graphql.Boolean,
+		}
+
+		return &graphql.InputObjectFieldConfig{
+			Type: graphql.NewInputObject(graphql.InputObjectConfig{
+				Name:   helper.ToCamelCase("where_" + collection + "_input_" + name + "_field"),
+				Fields: fields,
+			}),
+		}
+	}
+
 	_v := field.Kind
 
 	switch _v {
@@ -2118,8 +2748,12 @@ func (g *GraphqlAutoBuild) setModelParams(model *DataModelQuery, p *graphql.Reso
 	}
 
 	localOrderBy := helper.ToMap[string](p.Args["orderBy"])
-	for k, v := range localOrderBy {
-		model.OrderBy(k, v)
+	if len(localOrderBy) > 0 {
+		model.OrderByAll([]map[string]string{localOrderBy})
+	}
+
+	if v, ok := p.Args["search"].(string); ok && helper.IsNotEmpty(v) {
+		model.Search(v)
 	}
 
 	if v, ok := p.Args["groupBy"].([]interface{}); ok {
@@ -2163,6 +2797,8 @@ func (g *GraphqlAutoBuild) setModelParams(model *DataModelQuery, p *graphql.Reso
 	return true
 }
 
+// loadRelatedData formats the rows of a list and registers them with the
+// request DataLoader so their relations are fetched in one query per level.
 func (g *GraphqlAutoBuild) loadRelatedData(data *[]datatype.DataMap, model *DataModelQuery, p *graphql.ResolveParams, foreignKey string, targetKey string) {
 	ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
 
@@ -2172,50 +2808,9 @@ func (g *GraphqlAutoBuild) loadRelatedData(data *[]datatype.DataMap, model *Data
 		(*data)[i] = dataMap
 	}
 
-	for level, keys := range ctx.QuerySelectors {
-		fmt.Sprintf("level: %v, len(keys): %v, == %v", level, len(keys), keys)
-		// console.Log("level: %v, len(keys): %v, == %v", level, len(keys), keys)
-
-		// 	if len(keys) > 0 {
-		// 		selects := []string{}
-		// 		related := []string{}
-		// 		for _, key := range keys {
-		// 			if strings.HasPrefix(key, "_c_") {
-		// 				related = append(related, key[3:])
-		// 			} else if strings.HasPrefix(key, "_p_") {
-
-		// 			} else {
-		// 				selects = append(selects, key)
-		// 			}
-		// 		}
-
-		// 		if len(related) > 0 {
-		// 			relatedData := datatype.DataMap{}
-		// 			for _, k := range related {
-		// 				relatedModel := helper.ToCamelCase(helper.Singularize(k))
-		// 				// console.Log("ChildrenFields", relatedModel)
-
-		// 				if f, exists := model.Model.ChildrenFields[k]; exists {
-		// 					ids := helper.GetList(*data, f.ForeignKey)
-		// 					console.Log("ChildrenFields", f.ForeignKey, ids)
-		// 					children := g.yekonga.ModelQuery(relatedModel).WhereAll(datatype.DataMap{f.ForeignKey: map[string]interface{}{"in": ids}}).Find(nil)
-		// 					console.Log("relatedModel=========", len(*children))
-		// 					relatedData[k] = children
-		// 				} else if f, exists := model.Model.ParentFields[k]; exists {
-		// 					ids := helper.GetList(*data, f.ForeignKey)
-		// 					console.Log("ParentFields", f.ForeignKey, ids)
-		// 					// var model = g.yekonga.ModelQuery(relatedModel).Where(f.ForeignKey, ids).FindOne(nil)
-		// 				}
-
-		// 			}
-		// 		}
-
-		// 		fmt.Sprintf("level: %v, selected: %v, related: %v \n\n", level, selects, related)
-		// 	}
+	if ctx != nil {
+		ctx.DataLoader().Register(*data)
 	}
-
-	// console.Success("Reverse", ctx.QuerySelectors)
-
 }
 
 func (g *GraphqlAutoBuild) formateOutputData(model *DataModelQuery, data interface{}, foreignKey string, targetKey string) map[string]interface{} {
@@ -3,10 +3,12 @@ package yekonga
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/api/idtoken"
 
 	"github.com/robertkonga/yekonga-server-go/datatype"
@@ -105,6 +107,10 @@ func _otp(g *GraphqlAutoBuild) *graphql.Field {
 				username = helper.PhoneFormat(username)
 			}
 
+			if wait := g.yekonga.OTPResendWait(username, req.Request); wait > 0 {
+				return nil, fmt.Errorf("Please wait %d seconds before requesting a new code", int(wait.Seconds())+1)
+			}
+
 			if helper.IsNotEmpty(tenantId) {
 				if helper.IsNotEmpty(username) {
 					u := g.yekonga.SetOTPVerification(username, usernameType, tenantConfig.PublicCanRegister, "login", req.Request)
@@ -215,8 +221,12 @@ func _login(g *GraphqlAutoBuild) *graphql.Field {
 			}
 			if helper.IsNotEmpty(username) {
 				// user = g.yekonga.GetUser(username, false)
-				u := g.yekonga.OTPVerification(username, password, usernameType, true, req.Request)
-				if helper.IsNotEmpty(u) {
+				if loginType == "otp" {
+					u := g.yekonga.OTPVerification(username, password, usernameType, true, req.Request)
+					if helper.IsNotEmpty(u) {
+						user = *u
+					}
+				} else if u := g.yekonga.UserVerification(username, req.Request); helper.IsNotEmpty(u) {
 					user = *u
 				}
 			}
@@ -232,7 +242,8 @@ func _login(g *GraphqlAutoBuild) *graphql.Field {
 				user = v
 			}
 
-			if helper.IsNotEmpty(user) {
+			// A password is checked by AttemptLogin, an OTP was checked above.
+			if helper.IsNotEmpty(user) || (loginType != "otp" && helper.IsNotEmpty(username)) {
 				attemptData := AttemptData{
 					Username:     username,
 					UsernameType: usernameType,
@@ -370,7 +381,10 @@ func _verifyOtp(g *GraphqlAutoBuild) *graphql.Field {
 			}
 			if helper.IsNotEmpty(username) {
 				// user = g.yekonga.GetUser(username, false)
-				user = *g.yekonga.OTPVerification(username, password, usernameType, false, req.Request)
+				u := g.yekonga.OTPVerification(username, password, usernameType, false, req.Request)
+				if helper.IsNotEmpty(u) {
+					user = *u
+				}
 			}
 
 			triggerResult, _ := g.yekonga.authTriggerCallback(BeforeLoginTriggerAction, req, &QueryContext{
@@ -384,7 +398,8 @@ func _verifyOtp(g *GraphqlAutoBuild) *graphql.Field {
 				user = v
 			}
 
-			if helper.IsNotEmpty(user) {
+			// A password is checked by AttemptLogin, an OTP was checked above.
+			if helper.IsNotEmpty(user) || (loginType != "otp" && helper.IsNotEmpty(username)) {
 				attemptData := AttemptData{
 					Username:     username,
 					UsernameType: usernameType,
@@ -809,10 +824,6 @@ func _tenantAvailability(g *GraphqlAutoBuild) *graphql.Field {
 
 // resetPassword ( input: ResetPasswordInput! ): ActionResponse,
 func _resetPassword(g *GraphqlAutoBuild) *graphql.Field {
-	var foreignKey string
-	var targetKey string
-	var name string = "User"
-
 	return &graphql.Field{
 		Type: ActionResponseType,
 		Args: graphql.FieldConfigArgument{
@@ -821,23 +832,29 @@ func _resetPassword(g *GraphqlAutoBuild) *graphql.Field {
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			var data = helper.ToDataMap(g.getInputData(p.Args))
-			var model = g.yekonga.ModelQuery(name)
+			var ctx, _ = p.Context.Value(RequestContextKey).(*RequestContext)
+			var input = helper.ToDataMap(g.getInputData(p.Args))
+			var username = helper.GetValueOfString(input, "username")
+			var usernameType = helper.GetValueOfString(input, "usernameType")
 
-			g.setModelParams(model, &p, foreignKey, targetKey, false)
-			user := model.FindOne(data)
+			if helper.IsEmpty(username) {
+				return nil, errors.New("Username is required")
+			}
 
-			return user, nil
+			g.yekonga.SetPasswordResetToken(username, usernameType, ctx.Request)
+
+			// The response is the same whether the user exists or not so the
+			// endpoint can not be used to find registered usernames.
+			return datatype.DataMap{
+				"status":  true,
+				"message": "If the account exists a reset code has been sent",
+			}, nil
 		},
 	}
 }
 
 // confirmToken ( input: ConfirmTokenInput! ): ConfirmTokenResponse,
 func _confirmToken(g *GraphqlAutoBuild) *graphql.Field {
-	var foreignKey string
-	var targetKey string
-	var name string = "User"
-
 	return &graphql.Field{
 		Type: ConfirmTokenResponseType,
 		Args: graphql.FieldConfigArgument{
@@ -846,22 +863,29 @@ func _confirmToken(g *GraphqlAutoBuild) *graphql.Field {
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var ctx, _ = p.Context.Value(RequestContextKey).(*RequestContext)
 			var input = helper.ToDataMap(g.getInputData(p.Args))
-			var model = g.yekonga.ModelQuery(name)
+			var token = helper.GetValueOfString(input, "token")
 
-			g.setModelParams(model, &p, foreignKey, targetKey, false)
-			user := model.FindOne(input)
+			user := g.yekonga.PasswordResetUser(token, ctx.Request)
+			if helper.IsEmpty(user) {
+				return datatype.DataMap{
+					"status": false,
+				}, nil
+			}
 
-			return user, nil
+			return datatype.DataMap{
+				"status": true,
+				"email":  helper.GetValueOfString(user, "email"),
+				"token":  token,
+			}, nil
 		},
 	}
 }
 
 // changePassword ( input: ChangePasswordInput! ): ActionResponse,
 func _changePassword(g *GraphqlAutoBuild) *graphql.Field {
-	var foreignKey string
-	var targetKey string
-	var name string = "User"
+	const userModelName = "User"
 
 	return &graphql.Field{
 		Type: ActionResponseType,
@@ -871,13 +895,60 @@ func _changePassword(g *GraphqlAutoBuild) *graphql.Field {
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var ctx, _ = p.Context.Value(RequestContextKey).(*RequestContext)
 			var input = helper.ToDataMap(g.getInputData(p.Args))
-			var model = g.yekonga.ModelQuery(name)
+			var token = helper.GetValueOfString(input, "token")
+			var password = helper.GetValueOfString(input, "password")
+			var passwordConfirmation = helper.GetValueOfString(input, "passwordConfirmation")
+			var user *datatype.DataMap
 
-			g.setModelParams(model, &p, foreignKey, targetKey, false)
-			user := model.FindOne(input)
+			if helper.IsEmpty(password) {
+				return nil, errors.New("Password is required")
+			}
 
-			return user, nil
+			if password != passwordConfirmation {
+				return nil, errors.New("Password confirmation does not match")
+			}
+
+			if helper.IsNotEmpty(token) {
+				user = g.yekonga.PasswordResetUser(token, ctx.Request)
+
+				if helper.IsEmpty(user) {
+					return nil, errors.New("Token is invalid or has expired")
+				}
+			} else if helper.IsNotEmpty(ctx.Auth) && helper.IsNotEmpty(ctx.Auth.ID) {
+				user = g.yekonga.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().FindOne(datatype.DataMap{
+					"id": ctx.Auth.ID,
+				})
+
+				if helper.IsEmpty(user) {
+					return nil, errors.New("User does not exists")
+				}
+
+				currentPassword := helper.GetValueOfString(input, "currentPassword")
+				hash := helper.GetValueOfString(user, "password")
+
+				if helper.IsNotEmpty(hash) {
+					if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(currentPassword)); err != nil {
+						return nil, errors.New("Current password is incorrect")
+					}
+				}
+			} else {
+				return nil, errors.New("Not authorized")
+			}
+
+			userId := helper.GetValueOfString(user, "id")
+
+			if err := g.yekonga.SetPassword(userId, password); err != nil {
+				return nil, err
+			}
+
+			g.yekonga.RevokeRefreshTokens(userId)
+
+			return datatype.DataMap{
+				"status":  true,
+				"message": "SUCCESS",
+			}, nil
 		},
 	}
 }
@@ -0,0 +1,302 @@
+package yekonga
+
+import (
+	"errors"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/language/ast"
+)
+
+// Model events a subscription can listen to.
+const (
+	SubscriptionCreated = "created"
+	SubscriptionUpdated = "updated"
+	SubscriptionDeleted = "deleted"
+)
+
+// subscriptionBufferSize is the number of events a subscription can fall
+// behind before new ones are dropped.
+const subscriptionBufferSize = 64
+
+// graphqlSubscriptionEvent carries either the id of a created or updated
+// record, which the subscription reads back with its own scope, or a
+// deleted record that was already matched before it was removed.
+type graphqlSubscriptionEvent struct {
+	id     interface{}
+	record map[string]interface{}
+}
+
+func (g *GraphqlAutoBuild) GetSubscription() *graphql.Object {
+	var fields = make(graphql.Fields)
+
+	for k := range g.Database {
+		k = helper.ToVariable(helper.Singularize(k))
+
+		for _, event := range []string{SubscriptionCreated, SubscriptionUpdated, SubscriptionDeleted} {
+			fields[helper.ToVariable("on_"+k+"_"+event)] = g.getSubscriptionField(k, event)
+		}
+	}
+
+	var subscriptionType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:   "Subscription",
+			Fields: fields,
+		})
+
+	return subscriptionType
+}
+
+func (g *GraphqlAutoBuild) getSubscriptionField(collection string, event string) *graphql.Field {
+	name := helper.ToCamelCase(helper.Singularize(collection))
+
+	queryKind := g.QueryTypes[name]
+	whereKind := g.MutationTypes[helper.ToCamelCase("where_"+name+"_input")]
+
+	return &graphql.Field{
+		Type:        queryKind,
+		Description: "Listen to " + event + " " + name + " records",
+		Args: graphql.FieldConfigArgument{
+			"where": &graphql.ArgumentConfig{
+				Type: whereKind,
+			},
+			"accessRole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"route": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+			return g.subscribe(name, event, p)
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			// Every event is executed as a request of its own, relations
+			// must not be served from the loader of the previous event.
+			if ctx, ok := p.Context.Value(RequestContextKey).(*RequestContext); ok {
+				ctx.mut.Lock()
+				ctx.Loader = nil
+				ctx.mut.Unlock()
+			}
+
+			return p.Source, nil
+		},
+	}
+}
+
+// subscribe registers the subscription and returns the channel the
+// subscription executor reads its events from. The subscription ends when
+// the operation context is canceled.
+func (g *GraphqlAutoBuild) subscribe(name string, event string, p graphql.ResolveParams) (interface{}, error) {
+	ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
+	client, _ := p.Context.Value(SocketClientKey).(*Client)
+
+	if ctx == nil || client == nil {
+		return nil, errors.New("subscriptions are only served over the websocket endpoint")
+	}
+
+	if g.yekonga.Config.AuthorizedOnly && ctx.Auth == nil {
+		return nil, errors.New("Must be authorized/login")
+	}
+
+	id := helper.UUID()
+	subscription := &GraphqlSubscription{
+		Client:  client,
+		Model:   g.yekonga.ModelQuery(name).Model.Name,
+		Event:   event,
+		Headers: make(map[string]interface{}),
+		params:  p,
+		events:  make(chan graphqlSubscriptionEvent, subscriptionBufferSize),
+	}
+	subscription.Body.Variables = p.Info.VariableValues
+	if op, ok := p.Info.Operation.(*ast.OperationDefinition); ok {
+		if op.Name != nil {
+			subscription.Body.OperationName = op.Name.Value
+		}
+		if op.Loc != nil && op.Loc.Source != nil {
+			subscription.Body.Query = string(op.Loc.Source.Body)
+		}
+	}
+	for k, v := range ctx.Request.HttpRequest.Header {
+		subscription.Headers[k] = v
+	}
+
+	g.addSubscription(id, subscription)
+
+	output := make(chan interface{})
+
+	go func() {
+		defer close(output)
+		defer g.removeSubscription(subscription.Model, id)
+
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case e := <-subscription.events:
+				record := e.record
+				if record == nil {
+					record = g.findSubscribed(subscription, e.id)
+				}
+				if record == nil {
+					continue
+				}
+
+				select {
+				case output <- record:
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return output, nil
+}
+
+func (g *GraphqlAutoBuild) addSubscription(id string, subscription *GraphqlSubscription) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if _, ok := g.GraphqlSubscription[subscription.Model]; !ok {
+		g.GraphqlSubscription[subscription.Model] = make(map[string]*GraphqlSubscription)
+	}
+
+	g.GraphqlSubscription[subscription.Model][id] = subscription
+}
+
+func (g *GraphqlAutoBuild) removeSubscription(model string, id string) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	delete(g.GraphqlSubscription[model], id)
+	if len(g.GraphqlSubscription[model]) == 0 {
+		delete(g.GraphqlSubscription, model)
+	}
+}
+
+// subscriptions returns the subscriptions listening to the event of the model.
+func (g *GraphqlAutoBuild) subscriptions(model string, event string) []*GraphqlSubscription {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+
+	list := make([]*GraphqlSubscription, 0)
+	for _, v := range g.GraphqlSubscription[model] {
+		if v.Event == event {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// publish notifies the subscriptions of a created or updated record. The
+// records are read back by every subscription so its filter, tenant and
+// access rules apply, which happens off the write path.
+func (g *GraphqlAutoBuild) publish(model string, event string, records ...datatype.DataMap) {
+	subscriptions := g.subscriptions(model, event)
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	for _, record := range records {
+		id := helper.GetValueOf(record, "_id")
+		if helper.IsEmpty(id) {
+			id = helper.GetValueOf(record, "id")
+		}
+		if helper.IsEmpty(id) {
+			continue
+		}
+
+		for _, s := range subscriptions {
+			s.push(graphqlSubscriptionEvent{id: id})
+		}
+	}
+}
+
+// prepareDeleted matches the records the query is about to delete against
+// the delete subscriptions of the model. It must run before the records are
+// removed, the returned function publishes the matches once the delete has
+// succeeded. It returns nil when nobody listens.
+func (g *GraphqlAutoBuild) prepareDeleted(m *DataModelQuery, records []datatype.DataMap) func() {
+	subscriptions := g.subscriptions(m.Model.Name, SubscriptionDeleted)
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, 0, len(records))
+	for _, v := range records {
+		ids = append(ids, helper.GetValueOf(v, "_id"))
+	}
+
+	matches := make(map[*GraphqlSubscription][]map[string]interface{})
+	for _, s := range subscriptions {
+		model, ok := g.subscribedQuery(s)
+		if !ok {
+			continue
+		}
+
+		data := model.Where("id", map[string]interface{}{"in": ids}).Find(nil)
+		if data == nil {
+			continue
+		}
+
+		for _, d := range *data {
+			matches[s] = append(matches[s], g.subscribedOutput(s, model, d))
+		}
+	}
+
+	return func() {
+		for s, records := range matches {
+			for _, record := range records {
+				s.push(graphqlSubscriptionEvent{record: record})
+			}
+		}
+	}
+}
+
+// findSubscribed reads the record back with the scope and filter of the
+// subscription, nil when the subscription can not see it.
+func (g *GraphqlAutoBuild) findSubscribed(s *GraphqlSubscription, id interface{}) map[string]interface{} {
+	model, ok := g.subscribedQuery(s)
+	if !ok {
+		return nil
+	}
+
+	data := model.Where("id", id).FindOne(nil)
+	if helper.IsEmpty(data) {
+		return nil
+	}
+
+	return g.subscribedOutput(s, model, *data)
+}
+
+func (g *GraphqlAutoBuild) subscribedQuery(s *GraphqlSubscription) (*DataModelQuery, bool) {
+	p := s.params
+	p.Args = make(map[string]interface{}, len(s.params.Args))
+	for k, v := range s.params.Args {
+		p.Args[k] = v
+	}
+
+	model := g.yekonga.ModelQuery(s.Model)
+	status := g.setModelParams(model, &p, "", "", true)
+
+	return model, status
+}
+
+func (g *GraphqlAutoBuild) subscribedOutput(s *GraphqlSubscription, model *DataModelQuery, data datatype.DataMap) map[string]interface{} {
+	dataMap := g.formateOutputData(model, data, "", "")
+	dataMap["_params"] = s.params.Args
+
+	return dataMap
+}
+
+func (s *GraphqlSubscription) push(e graphqlSubscriptionEvent) {
+	select {
+	case s.events <- e:
+	default:
+		console.Error("GraphqlSubscription", "event dropped, subscription is behind on "+s.Model+" "+s.Event)
+	}
+}
@@ -415,6 +415,90 @@ var GraphDataset = graphql.NewObject(graphql.ObjectConfig{
 	},
 })
 
+// PageInfoType is the Relay page info shared by the model connections.
+var PageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"hasNextPage": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Boolean),
+		},
+		"hasPreviousPage": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Boolean),
+		},
+		"startCursor": &graphql.Field{
+			Type: graphql.String,
+		},
+		"endCursor": &graphql.Field{
+			Type: graphql.String,
+		},
+	},
+})
+
+// GeoPointType is the output of a geo field, read from its GeoJSON point.
+var GeoPointType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "GeoPoint",
+	Fields: graphql.Fields{
+		"latitude": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if point, ok := toGeoPoint(p.Source); ok {
+					return point.Latitude, nil
+				}
+
+				return nil, nil
+			},
+		},
+		"longitude": &graphql.Field{
+			Type: graphql.Float,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if point, ok := toGeoPoint(p.Source); ok {
+					return point.Longitude, nil
+				}
+
+				return nil, nil
+			},
+		},
+	},
+})
+
+var GeoPointInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "GeoPointInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"latitude": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(graphql.Float),
+		},
+		"longitude": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(graphql.Float),
+		},
+	},
+})
+
+// GeoNearInputType filters the records within maxDistance meters of the
+// point, nearest first.
+var GeoNearInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "GeoNearInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"point": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(GeoPointInputType),
+		},
+		"maxDistance": &graphql.InputObjectFieldConfig{
+			Type: graphql.Float,
+		},
+	},
+})
+
+var GeoBoxInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "GeoBoxInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"southWest": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(GeoPointInputType),
+		},
+		"northEast": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(GeoPointInputType),
+		},
+	},
+})
+
 var ActionResponseType = graphql.NewObject(graphql.ObjectConfig{
 	Name: "ActionResponse",
 	Fields: graphql.Fields{
@@ -761,6 +845,9 @@ var ResetPasswordInput = graphql.NewInputObject(graphql.InputObjectConfig{
 		"username": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
+		"usernameType": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
 	},
 })
 
@@ -779,6 +866,9 @@ var ChangePasswordInput = graphql.NewInputObject(graphql.InputObjectConfig{
 		"token": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
+		"currentPassword": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
 		"password": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
@@ -0,0 +1,403 @@
+package yekonga
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/config"
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+type DataModelIndexField struct {
+	Name       string
+	Descending bool
+}
+
+// DataModelIndex is an index declared in the model options or implied by a
+// foreign key or the tenant id.
+type DataModelIndex struct {
+	Name    string
+	Fields  []DataModelIndexField
+	Unique  bool
+	Expires bool
+	TTL     int
+	Text    bool
+	Geo     bool
+}
+
+// existingIndex is an index as reported by the database.
+type existingIndex struct {
+	Fields  []string
+	Unique  bool
+	Expires bool
+	TTL     int
+}
+
+// getDataModelIndexes parses the indexes option. An entry is either a field
+// name or an object with fields, unique, name, expireAfterSeconds and type.
+// A field prefixed with "-" is indexed in descending order.
+func getDataModelIndexes(value interface{}) []DataModelIndex {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	indexes := make([]DataModelIndex, 0, len(list))
+
+	for _, v := range list {
+		var index DataModelIndex
+
+		switch item := v.(type) {
+		case string:
+			index.Fields = getDataModelIndexFields([]interface{}{item})
+		case map[string]interface{}:
+			if fields, ok := item["fields"].([]interface{}); ok {
+				index.Fields = getDataModelIndexFields(fields)
+			} else if field, ok := item["fields"].(string); ok {
+				index.Fields = getDataModelIndexFields([]interface{}{field})
+			}
+
+			if name, ok := item["name"].(string); ok {
+				index.Name = strings.TrimSpace(name)
+			}
+
+			if unique, ok := item["unique"].(bool); ok {
+				index.Unique = unique
+			}
+
+			if ttl, ok := item["expireAfterSeconds"]; ok {
+				index.Expires = true
+				index.TTL = helper.ToInt(ttl)
+			}
+
+			if kind, ok := item["type"].(string); ok {
+				switch strings.ToLower(strings.TrimSpace(kind)) {
+				case "text":
+					index.Text = true
+				case "geo", "2dsphere":
+					index.Geo = true
+				}
+			}
+		}
+
+		if len(index.Fields) > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+func getDataModelIndexFields(list []interface{}) []DataModelIndexField {
+	fields := make([]DataModelIndexField, 0, len(list))
+
+	for _, v := range list {
+		name, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		field := DataModelIndexField{Name: strings.TrimPrefix(name, "-")}
+		field.Descending = field.Name != name
+
+		if helper.IsNotEmpty(field.Name) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// getIndexes resolves the declared indexes once the fields are known and adds
+// a text index on the searchable fields, a geo index on every geo field and
+// an implicit index on every foreign key and on the tenant id that no
+// declared index already starts with. The ttl option expires the records
+// through an index on its field. Declarations on unknown fields are skipped.
+// The fields of the text index are the search fields of the model.
+func (m *DataModel) getIndexes() []DataModelIndex {
+	indexes := make([]DataModelIndex, 0, len(m.Indexes)+len(m.ParentKeys)+1)
+	leading := map[string]bool{}
+	geo := map[string]bool{}
+
+	for _, index := range m.Indexes {
+		valid := true
+		for _, f := range index.Fields {
+			if _, ok := m.Fields[f.Name]; !ok && f.Name != "_id" {
+				logger.Warn("Index on "+m.Name+" skipped, unknown field", f.Name)
+				valid = false
+			}
+		}
+
+		if !valid {
+			continue
+		}
+
+		if index.Expires && (len(index.Fields) != 1 || m.Fields[index.Fields[0].Name].Kind != DataModelDate) {
+			logger.Warn("Index on "+m.Name+" skipped, expireAfterSeconds needs a single date field", index.fieldNames())
+			continue
+		}
+
+		if index.Geo && (len(index.Fields) != 1 || m.Fields[index.Fields[0].Name].Kind != DataModelGeo) {
+			logger.Warn("Index on "+m.Name+" skipped, a geo index needs a single geo field", index.fieldNames())
+			continue
+		}
+
+		if helper.IsEmpty(index.Name) {
+			index.Name = "idx_" + m.Collection + "_" + strings.Join(index.fieldNames(), "_")
+		}
+
+		if index.Text {
+			if len(m.SearchFields) > 0 {
+				logger.Warn("Index on "+m.Name+" skipped, a model has one text index", index.fieldNames())
+				continue
+			}
+			m.SearchFields = index.fieldNames()
+		} else if index.Geo {
+			geo[index.Fields[0].Name] = true
+		} else {
+			leading[index.Fields[0].Name] = true
+		}
+		indexes = append(indexes, index)
+	}
+
+	if helper.IsNotEmpty(m.TTL.Field) {
+		var ok bool
+		if indexes, ok = m.ttlIndex(indexes); ok {
+			leading[m.TTL.Field] = true
+		}
+	}
+
+	// The searchable fields share one text index, unless one is declared.
+	if len(m.SearchFields) == 0 {
+		searchable := make([]string, 0)
+		for k, f := range m.Fields {
+			if f.Searchable {
+				searchable = append(searchable, k)
+			}
+		}
+		sort.Strings(searchable)
+
+		if len(searchable) > 0 {
+			index := DataModelIndex{Name: "idx_" + m.Collection + "_search", Text: true}
+			for _, k := range searchable {
+				index.Fields = append(index.Fields, DataModelIndexField{Name: k})
+			}
+
+			m.SearchFields = searchable
+			indexes = append(indexes, index)
+		}
+	}
+
+	// Every geo field gets a geo index, unless one is declared.
+	geoFields := append([]string{}, m.GeoFields...)
+	sort.Strings(geoFields)
+
+	for _, k := range geoFields {
+		if geo[k] {
+			continue
+		}
+
+		indexes = append(indexes, DataModelIndex{
+			Name:   "idx_" + m.Collection + "_" + k,
+			Fields: []DataModelIndexField{{Name: k}},
+			Geo:    true,
+		})
+	}
+
+	keys := make([]string, 0, len(m.ParentKeys)+1)
+	keys = append(keys, m.ParentKeys...)
+	if m.HasTenant && !helper.Contains(keys, TenantIDKey) {
+		keys = append(keys, TenantIDKey)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if leading[k] {
+			continue
+		}
+
+		indexes = append(indexes, DataModelIndex{
+			Name:   "idx_" + m.Collection + "_" + k,
+			Fields: []DataModelIndexField{{Name: k}},
+		})
+	}
+
+	return indexes
+}
+
+// limitIndexName shortens an index name to the limit of the backend, keeping
+// it unique with a hash suffix.
+func limitIndexName(name string, limit int) string {
+	if len(name) > limit {
+		sum := sha1.Sum([]byte(name))
+		name = name[:limit-9] + "_" + hex.EncodeToString(sum[:])[:8]
+	}
+
+	return name
+}
+
+func (index DataModelIndex) fieldNames() []string {
+	names := make([]string, len(index.Fields))
+	for i, f := range index.Fields {
+		names[i] = f.Name
+	}
+
+	return names
+}
+
+// differs reports how an existing index with the same name differs from the
+// declaration, or an empty string when it matches. Text and geo index fields
+// are not compared since the backends report them in their own format.
+func (index DataModelIndex) differs(current existingIndex, nativeTTL bool) string {
+	if !index.Text && !index.Geo && strings.Join(current.Fields, ",") != strings.Join(index.fieldNames(), ",") {
+		return "fields " + strings.Join(current.Fields, ",") + " -> " + strings.Join(index.fieldNames(), ",")
+	}
+
+	if current.Unique != index.Unique {
+		return "unique " + helper.ToString(current.Unique) + " -> " + helper.ToString(index.Unique)
+	}
+
+	if nativeTTL && (current.Expires != index.Expires || current.TTL != index.TTL) {
+		return "expireAfterSeconds " + expireAfter(current.Expires, current.TTL) + " -> " + expireAfter(index.Expires, index.TTL)
+	}
+
+	return ""
+}
+
+func expireAfter(expires bool, ttl int) string {
+	if !expires {
+		return "none"
+	}
+
+	return helper.ToString(ttl)
+}
+
+// reconcileIndexes creates the declared indexes that are missing and reports
+// the drift between the declared and the existing ones. Indexes are never
+// dropped or rebuilt, that is left to the developer.
+func reconcileIndexes(backend string, collection string, declared []DataModelIndex, existing map[string]existingIndex, nativeTTL bool, create func(index DataModelIndex) error) error {
+	names := map[string]bool{}
+
+	for _, index := range declared {
+		names[index.Name] = true
+
+		if current, exists := existing[index.Name]; exists {
+			if diff := index.differs(current, nativeTTL); diff != "" {
+				logger.Warn(backend+" index differs from declaration", collection+"."+index.Name, diff)
+			}
+			continue
+		}
+
+		if err := create(index); err != nil {
+			return err
+		}
+
+		logger.Info(backend+" index created", collection+"."+index.Name)
+	}
+
+	extra := make([]string, 0)
+	for name := range existing {
+		if !names[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+
+	for _, name := range extra {
+		logger.Warn(backend+" index is not declared", collection+"."+name)
+	}
+
+	return nil
+}
+
+// setIndexExpiry registers the cronjob that removes expired records on the
+// backends that have no native TTL index. A negative expiryInterval leaves the
+// expiry to another instance.
+func (y *YekongaData) setIndexExpiry() {
+	if y.Config.Database.Kind == config.DBTypeMongodb || y.Config.Database.ExpiryInterval < 0 {
+		return
+	}
+
+	hasTTL := false
+
+	for _, model := range y.models {
+		for _, index := range model.Indexes {
+			if index.Expires {
+				hasTTL = true
+			}
+		}
+	}
+
+	if !hasTTL {
+		return
+	}
+
+	interval := y.Config.Database.ExpiryInterval
+	if interval == 0 {
+		interval = 1 // default every minute
+	}
+
+	y.RegisterCronjob("IndexExpiry", time.Duration(interval)*time.Minute, func(app *YekongaData, t time.Time) {
+		app.ExpireIndexedRecords(t)
+	})
+}
+
+// ExpireIndexedRecords removes the records whose expireAfterSeconds index
+// field is older than the expiry. It is called by the expiry cronjob. The
+// records are deleted in batches like any other delete, so the delete events
+// and the audit entries are written for them.
+func (y *YekongaData) ExpireIndexedRecords(t time.Time) {
+	for _, model := range y.models {
+		for _, index := range model.Indexes {
+			if !index.Expires {
+				continue
+			}
+
+			cutoff := t.Add(-time.Duration(index.TTL) * time.Second)
+			if err := model.expireRecords(index.Fields[0].Name, cutoff); err != nil {
+				console.Error("ExpireIndexedRecords", model.Name, err.Error())
+			}
+		}
+	}
+}
+
+// expireRecords deletes the records whose field is older than the cutoff, the
+// oldest first. A batch ends at the date of its last record, so a batch that
+// is found again was not deleted, which stops the expiry.
+func (m *DataModel) expireRecords(field string, cutoff time.Time) error {
+	last := ""
+
+	for {
+		query := m.Query().SkipTenant().SkipBeforeCommit().WithTrashed().OrderBy(field, "asc").Take(ttlReapBatch)
+		expired := query.Where(field, datatype.DataMap{"lessThan": cutoff}).collection().find()
+		if expired == nil || len(*expired) == 0 {
+			return nil
+		}
+
+		upTo := (*expired)[len(*expired)-1][field]
+		if helper.ToJson(upTo) == last {
+			return errors.New("expired records were not deleted")
+		}
+		last = helper.ToJson(upTo)
+
+		result := m.Query().SkipTenant().SkipBeforeCommit().ForceDelete(datatype.DataMap{
+			field: datatype.DataMap{"lessThan": cutoff, "lessThanOrEqualTo": upTo},
+		})
+
+		if err, ok := result.(error); ok {
+			return err
+		}
+
+		if len(*expired) < ttlReapBatch {
+			return nil
+		}
+	}
+}
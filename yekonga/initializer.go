@@ -12,7 +12,6 @@ import (
 	"github.com/robertkonga/yekonga-server-go/config"
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/helper"
-	"github.com/robertkonga/yekonga-server-go/helper/jwt"
 	"github.com/robertkonga/yekonga-server-go/helper/logger"
 	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
 	"github.com/robertkonga/yekonga-server-go/plugins/graphql/gqlerrors"
@@ -259,6 +258,7 @@ func (y *YekongaData) initialize() {
 	})
 
 	y.initializerOtherRoutes()
+	y.initializerRestRoutes()
 
 }
 
@@ -417,7 +417,7 @@ func (y *YekongaData) refreshTokenProcess(req *Request, res *Response, refreshTo
 							ExpiresAt:    today.Add(time.Minute * accessTokenExpireTime),
 						}
 
-						newAccessToken, _ := jwt.EncodeJWT(payload.ToMap(), y.Config.Authentication.SecretToken)
+						newAccessToken, _ := y.EncodeToken(payload)
 						newRefreshToken := y.getRefreshToken(*req.Client(), payload, false)
 
 						status = http.StatusOK
@@ -584,23 +584,27 @@ func parseRequest(r *http.Request, params interface{}) error {
 }
 
 func formatErrors(errs []gqlerrors.FormattedError) []gqlerrors.FormattedError {
-	formatted := make([]gqlerrors.FormattedError, len(errs))
+	formatted := make([]gqlerrors.FormattedError, 0, len(errs))
 
 	// console.Error("GraphQL Error", errs)
 
-	for i, err := range errs {
-		// Intercept Schema/Validation errors
-		if strings.Contains(err.Message, "Unknown field") || strings.Contains(err.Message, "got invalid value") {
-			formatted[i] = gqlerrors.FormattedError{
+	for _, err := range errs {
+		// Field errors of the model validation keep their path and code
+		if fieldErrors, ok := validationFormattedErrors(err); ok {
+			formatted = append(formatted, fieldErrors...)
+		} else if strings.Contains(err.Message, "Unknown field") || strings.Contains(err.Message, "got invalid value") {
+			// Intercept Schema/Validation errors
+			formatted = append(formatted, gqlerrors.FormattedError{
 				Message: "Invalid request format. Please check your input fields.",
 				// You can add custom extensions for the frontend to read
-			}
+			})
 		} else {
-			// Keep the original error or mask it for production
-			formatted[i] = gqlerrors.FormattedError{
-				Message: err.Message,
-				// You can add custom extensions for the frontend to read
-			}
+			// Keep the original error or mask it for production, the
+			// extensions carry codes such as VERSION_CONFLICT
+			formatted = append(formatted, gqlerrors.FormattedError{
+				Message:    err.Message,
+				Extensions: err.Extensions,
+			})
 		}
 	}
 	return formatted
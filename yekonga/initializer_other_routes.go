@@ -341,6 +341,11 @@ func (y *YekongaData) initializerOtherRoutes() {
 	y.Get("/theme.css", runCustomCSS(y))
 	y.Get("/custom-style.css", runCustomCSS(y))
 
+	y.Get("/.well-known/jwks.json", func(req *Request, res *Response) {
+		res.SetHeader("Cache-Control", "public, max-age=300")
+		res.Json(y.TokenKeys().JWKS())
+	})
+
 	if y.Config.ApiPlaygroundEnable || y.Config.AuthPlaygroundEnable {
 		y.Get("/playground", func(req *Request, res *Response) {
 			content, _ := StaticFS.ReadFile("static/playground/index.html")
@@ -510,7 +515,7 @@ func uploadExcelFileHandler(w http.ResponseWriter, r *http.Request) {
 		// If encoding fails, we can't change the header anymore,
 		// but we can log the error.
 		fmt.Println("Error encoding JSON:", err)
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err.Error())
 	}
 }
 
@@ -590,7 +595,7 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 		// If encoding fails, we can't change the header anymore,
 		// but we can log the error.
 		fmt.Println("Error encoding JSON:", err)
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err.Error())
 	}
 }
 
@@ -677,6 +682,6 @@ func uploadMultipleFileHandler(w http.ResponseWriter, r *http.Request) {
 		// If encoding fails, we can't change the header anymore,
 		// but we can log the error.
 		fmt.Println("Error encoding JSON:", err)
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err.Error())
 	}
 }
@@ -0,0 +1,554 @@
+package yekonga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+)
+
+// restReservedParams are query string keys which shape or authenticate a
+// REST request instead of filtering the records.
+var restReservedParams = []string{
+	"where",
+	"orderBy",
+	"limit",
+	"page",
+	"skip",
+	"field",
+	"accessRole",
+	"route",
+	"token",
+	"app-key",
+	"application-key",
+	"master-key",
+}
+
+// initializerRestRoutes registers CRUD endpoints for every model under the
+// configured restAPI prefix. The handlers go through DataModelQuery just like
+// the GraphQL resolvers so triggers, tenants and protected fields apply.
+func (y *YekongaData) initializerRestRoutes() {
+	if helper.IsEmpty(y.Config.RestApi) {
+		return
+	}
+
+	names := make([]string, 0, len(y.models))
+	for k := range y.models {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		y.addRestRoutes(y.models[name])
+	}
+}
+
+func (y *YekongaData) addRestRoutes(model *DataModel) {
+	name := model.Name
+	base := strings.TrimRight(y.Config.RestApi, "/") + "/" + model.Collection
+
+	// Fixed paths are registered before "/:id" since routes match in order.
+	y.Get(base+"/count", func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		res.Json(datatype.DataMap{
+			"count": query.Count(nil),
+		})
+	})
+
+	y.Get(base+"/summary", func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		field := req.Query("field")
+		if helper.IsNotEmpty(field) {
+			f, ok := model.Fields[field]
+			if !ok || (f.Kind != DataModelNumber && f.Kind != DataModelFloat) {
+				restError(res, http.StatusBadRequest, fmt.Sprintf("field %q is not a number field", field))
+				return
+			}
+		}
+
+		summary := query.Summary(nil)
+		if summary == nil {
+			res.Json(datatype.DataMap{"count": 0})
+			return
+		}
+
+		if helper.IsEmpty(field) {
+			res.Json(*summary)
+			return
+		}
+
+		// With a field only its values are kept from the per field maps
+		result := datatype.DataMap{
+			"count": (*summary)["count"],
+		}
+		for _, k := range []string{"sum", "max", "min", "average"} {
+			if values, ok := (*summary)[k].(datatype.DataMap); ok {
+				result[k] = values[field]
+			}
+		}
+
+		res.Json(result)
+	})
+
+	y.Get(base+"/paginate", func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result := query.Paginate(nil)
+		if result == nil {
+			res.Json(datatype.DataMap{"data": []map[string]interface{}{}})
+			return
+		}
+
+		output := datatype.DataMap{}
+		for k, v := range *result {
+			output[k] = v
+		}
+
+		if list, ok := (*result)["data"].(*[]datatype.DataMap); ok {
+			output["data"] = y.restOutputList(query, list)
+		}
+
+		res.Json(output)
+	})
+
+	y.Get(base, func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		res.Json(y.restOutputList(query, query.Find(nil)))
+	})
+
+	y.Post(base, func(req *Request, res *Response) {
+		data, ok := restBody(req)
+		if !ok {
+			restError(res, http.StatusBadRequest, "Request body must be a JSON object")
+			return
+		}
+
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		created, err := restResultData(query.Create(data))
+		if err != nil {
+			restWriteError(res, err)
+			return
+		}
+		if created == nil {
+			restError(res, http.StatusForbidden, "Request rejected")
+			return
+		}
+
+		res.Status(http.StatusCreated)
+		res.Json(restMutationResult(y.graphqlBuild.formateOutputData(query, created, "", "")))
+	})
+
+	y.Get(base+"/:id", func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		data := query.FindOne(datatype.DataMap{"_id": req.Param("id")})
+		if helper.IsEmpty(data) {
+			restError(res, http.StatusNotFound, "Not found")
+			return
+		}
+
+		restSetETag(res, query, *data)
+		res.Json(y.graphqlBuild.formateOutputData(query, *data, "", ""))
+	})
+
+	update := func(req *Request, res *Response) {
+		id := req.Param("id")
+		data, ok := restBody(req)
+		if !ok {
+			restError(res, http.StatusBadRequest, "Request body must be a JSON object")
+			return
+		}
+		delete(data, "id")
+		delete(data, "_id")
+
+		exists, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		if exists.Count(datatype.DataMap{"_id": id}) == 0 {
+			restError(res, http.StatusNotFound, "Not found")
+			return
+		}
+
+		query, _ := y.restModelQuery(name, req, res)
+		if version, ok, err := restIfMatch(req, query); err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		} else if ok {
+			query.ExpectVersion(version)
+		}
+
+		updated, err := restResultData(query.Update(data, datatype.DataMap{"_id": id}))
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			conflict.Current = y.graphqlBuild.formateOutputData(query, conflict.Current, "", "")
+		}
+		if err != nil {
+			restWriteError(res, err)
+			return
+		}
+		if updated == nil {
+			restError(res, http.StatusForbidden, "Request rejected")
+			return
+		}
+
+		restSetETag(res, query, updated)
+		res.Json(restMutationResult(y.graphqlBuild.formateOutputData(query, updated, "", "")))
+	}
+
+	y.Put(base+"/:id", update)
+	y.Patch(base+"/:id", update)
+
+	y.Delete(base+"/:id", func(req *Request, res *Response) {
+		query, err := y.restModelQuery(name, req, res)
+		if err != nil {
+			restError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result := query.Delete(datatype.DataMap{"_id": req.Param("id")})
+		if e, ok := result.(error); ok {
+			restError(res, http.StatusBadRequest, e.Error())
+			return
+		}
+		if result == nil {
+			restError(res, http.StatusForbidden, "Request rejected")
+			return
+		}
+
+		deletedCount := helper.ToInt(helper.GetValueOf(helper.ToMap[interface{}](result), "DeletedCount"))
+		if deletedCount == 0 {
+			restError(res, http.StatusNotFound, "Not found")
+			return
+		}
+
+		res.Json(datatype.DataMap{
+			"success":      true,
+			"status":       true,
+			"message":      "Success",
+			"deletedCount": deletedCount,
+		})
+	})
+}
+
+// restModelQuery prepares a query for the model with the request context,
+// the access role and route, the filters and the list options taken from the
+// query string.
+func (y *YekongaData) restModelQuery(name string, req *Request, res *Response) (*DataModelQuery, error) {
+	query := y.ModelQuery(name)
+	values := url.Values(req.QueryMap())
+
+	where, err := restWhere(query.Model, values)
+	if err != nil {
+		return nil, err
+	}
+
+	query.SetRequestContext(&RequestContext{
+		Auth:         req.Auth(),
+		App:          y,
+		Request:      req,
+		Response:     res,
+		TokenPayload: req.TokenPayload(),
+		Client:       req.Client(),
+	})
+
+	query.QueryContext.Params = make(map[string]interface{})
+	for k := range values {
+		query.QueryContext.Params[k] = values.Get(k)
+	}
+	query.QueryContext.Filters = &where
+	query.QueryContext.AccessRole = req.Query("accessRole")
+	query.QueryContext.Route = req.Query("route")
+
+	query.WhereAll(where)
+
+	for _, item := range strings.Split(req.Query("orderBy"), ",") {
+		item = strings.TrimSpace(item)
+		if helper.IsEmpty(item) {
+			continue
+		}
+
+		direction := "ASC"
+		if strings.HasPrefix(item, "-") {
+			item = item[1:]
+			direction = "DESC"
+		} else if k, v, ok := strings.Cut(item, ":"); ok {
+			item = k
+			direction = strings.ToUpper(v)
+		}
+
+		query.OrderBy(item, direction)
+	}
+
+	if v := req.QueryInt("limit", 0); v > 0 {
+		query.Take(v)
+	}
+	if v := req.QueryInt("page", 0); v > 0 {
+		query.Page(v)
+	}
+	if v := req.QueryInt("skip", 0); v > 0 {
+		query.Skip(v)
+	}
+
+	return query, nil
+}
+
+// restWhere turns query string parameters into a where filter. A plain
+// "field=value" matches the value, "field[operator]=value" applies any of the
+// GraphQL where operators and "where" accepts a raw JSON filter.
+func restWhere(model *DataModel, values url.Values) (datatype.DataMap, error) {
+	where := datatype.DataMap{}
+
+	if raw := values.Get("where"); helper.IsNotEmpty(raw) {
+		if err := json.Unmarshal([]byte(raw), &where); err != nil {
+			return nil, errors.New("where must be a JSON object")
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if helper.Contains(restReservedParams, key) {
+			continue
+		}
+
+		name, operator := key, "equalTo"
+		if k, op, ok := strings.Cut(key, "["); ok && strings.HasSuffix(op, "]") {
+			name, operator = k, strings.TrimSuffix(op, "]")
+		}
+
+		if name == "id" {
+			name = "_id"
+		}
+
+		field, ok := model.Fields[name]
+		if !ok && name != "_id" {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+
+		var value interface{}
+		items := values[key]
+
+		switch {
+		case helper.Contains(graphqlArrayOperations, operator):
+			list := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				for _, v := range strings.Split(item, ",") {
+					list = append(list, restValue(field, v))
+				}
+			}
+			value = list
+		case helper.Contains(graphqlBooleanOperations, operator):
+			b, err := strconv.ParseBool(items[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", key)
+			}
+			value = b
+		case operator == "matchesRegex" || operator == "options":
+			value = items[0]
+		case helper.Contains(graphqlOperations, operator):
+			if operator == "equalTo" && len(items) > 1 {
+				list := make([]interface{}, 0, len(items))
+				for _, v := range items {
+					list = append(list, restValue(field, v))
+				}
+				operator, value = "in", list
+			} else {
+				value = restValue(field, items[0])
+			}
+		default:
+			return nil, fmt.Errorf("unknown operator %q", operator)
+		}
+
+		filter, ok := where[name].(map[string]interface{})
+		if !ok {
+			filter = map[string]interface{}{}
+		}
+		filter[operator] = value
+		where[name] = filter
+	}
+
+	return where, nil
+}
+
+// restValue converts a query string value to the type of the field.
+func restValue(field DataModelField, value string) interface{} {
+	switch field.Kind {
+	case DataModelNumber:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case DataModelFloat:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case DataModelBool:
+		if v, err := strconv.ParseBool(value); err == nil {
+			return v
+		}
+	}
+
+	return value
+}
+
+func restBody(req *Request) (datatype.DataMap, bool) {
+	body := req.Body()
+	if !helper.IsMap(body) {
+		return nil, false
+	}
+
+	return helper.ToDataMap(body), true
+}
+
+// restResultData unwraps the result of a create or update which is either the
+// record, an error, or nil when a trigger rejected the request.
+func restResultData(result interface{}) (datatype.DataMap, error) {
+	switch v := result.(type) {
+	case error:
+		return nil, v
+	case *datatype.DataMap:
+		if v == nil || helper.IsEmpty(*v) {
+			return nil, nil
+		}
+		return *v, nil
+	}
+
+	if helper.IsMap(result) {
+		return helper.ToDataMap(result), nil
+	}
+
+	return nil, nil
+}
+
+func (y *YekongaData) restOutputList(query *DataModelQuery, list *[]datatype.DataMap) []map[string]interface{} {
+	output := []map[string]interface{}{}
+	if list == nil {
+		return output
+	}
+
+	for _, d := range *list {
+		output = append(output, y.graphqlBuild.formateOutputData(query, d, "", ""))
+	}
+
+	return output
+}
+
+func restMutationResult(data map[string]interface{}) datatype.DataMap {
+	return datatype.DataMap{
+		"success": true,
+		"status":  true,
+		"message": "Success",
+		"data":    data,
+	}
+}
+
+func restError(res *Response, status int, message string) {
+	res.Status(status)
+	res.Json(datatype.DataMap{
+		"status": status,
+		"error":  message,
+	})
+}
+
+// restIfMatch reads the version an update expects from the If-Match header,
+// ok is false when there is none or the model has no versions.
+func restIfMatch(req *Request, query *DataModelQuery) (version int, ok bool, err error) {
+	value := strings.TrimSpace(req.Header("If-Match"))
+	if !query.Model.Versioned || value == "" || value == "*" {
+		return 0, false, nil
+	}
+
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err = strconv.Atoi(value)
+	if err != nil {
+		return 0, false, errors.New("If-Match must be the version of the record")
+	}
+
+	return version, true, nil
+}
+
+// restSetETag sends the version of a record of a versioned model as its
+// ETag, the value to send back in If-Match.
+func restSetETag(res *Response, query *DataModelQuery, data datatype.DataMap) {
+	if query.Model.Versioned {
+		res.SetHeader("ETag", fmt.Sprintf(`"%d"`, helper.ToInt(data[VersionKey])))
+	}
+}
+
+// restWriteError answers a failed write, listing the field errors when the
+// input broke the validation rules of the model and the current record when
+// it changed since the expected version.
+func restWriteError(res *Response, err error) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		res.Status(http.StatusPreconditionFailed)
+		res.Json(datatype.DataMap{
+			"status":  http.StatusPreconditionFailed,
+			"error":   conflict.Error(),
+			"code":    "VERSION_CONFLICT",
+			"current": conflict.Current,
+		})
+		return
+	}
+
+	if errors.Is(err, ErrRecordNotFound) {
+		restError(res, http.StatusNotFound, "Not found")
+		return
+	}
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		restError(res, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res.Status(http.StatusUnprocessableEntity)
+	res.Json(datatype.DataMap{
+		"status": http.StatusUnprocessableEntity,
+		"error":  validation.Error(),
+		"errors": validation.Errors,
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/gateway/setting"
 	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/jwt"
 	"github.com/robertkonga/yekonga-server-go/helper/logger"
 )
 
@@ -78,6 +79,10 @@ type YekongaData struct {
 	staticConfig           []*StaticConfig
 	logger                 *log.Logger
 	cronjob                *Cronjob
+	tokenKeys              *jwt.KeySet
+	billing                *Billing
+	migrations             map[string]Migration
+	migrationModel         *DataModel
 	mut                    sync.RWMutex
 
 	Config   *config.YekongaConfig
@@ -128,15 +133,23 @@ func ServerConfig(configFile string, databaseFile string) *YekongaData {
 	}
 
 	dbConnect.appPath = Server.HomeDirectory()
+	if err := Server.setTokenKeys(); err != nil {
+		log.Fatalf("Fatal Error: Could not load the token keys: %v", err)
+	}
 	SetSystemModelDBconnection(Server, &systemModels)
 
 	graphqlBuild := NewGraphqlAutoBuild(Server, systemModels)
 	Server.graphqlBuild = graphqlBuild
 
 	dbConnect.connect()
+	dbConnect.migrate(systemModels)
 	Server.initialize()
 	Server.cronjob = NewCronjob(Server)
 	Server.setNotification()
+	Server.setBilling()
+	Server.setSoftDelete()
+	Server.setIndexExpiry()
+	Server.setBackup()
 
 	return Server
 }
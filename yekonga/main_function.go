@@ -11,12 +11,15 @@ import (
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/helper"
 	"github.com/robertkonga/yekonga-server-go/helper/console"
-	"github.com/robertkonga/yekonga-server-go/helper/jwt"
 	"github.com/robertkonga/yekonga-server-go/helper/logger"
 	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// userVerificationExpiry is how long a verification record that was never
+// verified is kept after its last code. Verified records don't expire.
+const userVerificationExpiry = 24 * time.Hour
+
 type AttemptData struct {
 	ProfileID    string
 	UserID       string
@@ -48,48 +51,177 @@ func (y *YekongaData) OTPVerification(username interface{}, password string, use
 	var user *datatype.DataMap
 	var userId interface{}
 	var tenant = req.Tenant()
-	tenantId := req.TenantId()
-	notTenant := helper.IsEmpty(tenantId)
-	var defaultUser = y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Where("username", username).FindOne(nil)
 	var isOwner = false
 
 	if v, ok := username.(string); ok {
 		username = v
 	}
 
+	// Login resolves the code once before AttemptLogin checks it again, the
+	// second check has to reuse the first result as the code may be reset.
+	verifiedKey := string(OTPVerifiedKey) + ":" + helper.ToString(username)
+	if v, ok := req.GetContext(verifiedKey).(*datatype.DataMap); ok {
+		return v
+	}
+
+	if helper.IsEmpty(password) || y.OTPLocked(helper.ToString(username), req) {
+		return nil
+	}
+
+	var defaultUser = y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Where("username", username).FindOne(nil)
+
 	if helper.IsNotEmpty(defaultUser) {
 		userId = helper.GetValueOfString(defaultUser, "_id")
 		isOwner = (userId == tenant.UserId)
 	}
 
-	if notTenant {
-		tenantId = helper.ObjectID("000000000000000000000000")
-	}
-
-	user = y.ModelQuery(userVerificationModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Where("tenantId", tenantId).Where("username", username).FindOne(nil)
+	user = y.UserVerification(helper.ToString(username), req)
 
 	if helper.IsNotEmpty(user) {
-		otpCode := helper.GetValueOf(user, "otpCode")
+		otpCode := helper.GetValueOfString(user, "otpCode")
+		otpCreatedAt := helper.GetValueOfDate(user, "otpCreatedAt")
 		timestamp := helper.GetTimestamp(nil)
 
-		if helper.IsNotEmpty(password) && otpCode == password {
-			if config.Config.ResetOTP {
-				otpCode = nil
-			}
+		expireTime := y.Config.OtpExpireTime
+		if expireTime <= 0 {
+			expireTime = 10 // default 10 minutes
+		}
+
+		valid := otpCreatedAt.Add(time.Minute*expireTime).After(timestamp) &&
+			helper.CompareOTP(otpCode, password, y.Config.Authentication.SecretToken)
 
-			y.ModelQuery(userVerificationModelName).SkipBeforeCommit().SetRequest(req, &Response{}).Where("username", username).Update(datatype.DataMap{
-				"otpCode":       otpCode,
+		if valid {
+			body := datatype.DataMap{
 				"otpVerifiedAt": timestamp,
+				"expiresAt":     nil,
 				"updatedAt":     timestamp,
-			}, nil)
+			}
+
+			if config.Config.ResetOTP {
+				body["otpCode"] = nil
+			}
+
+			y.ModelQuery(userVerificationModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Where("id", helper.GetValueOf(user, "_id")).Update(body, nil)
 
 			u := y.GetUser(*user, isOwner || canCreate)
+			req.SetContext(verifiedKey, &u)
 
-			user = &u
+			return &u
 		}
 	}
 
-	return user
+	y.recordOTPFailure(helper.ToString(username), req)
+
+	return nil
+}
+
+// OTPLocked reports whether OTP verification is locked for the username or
+// the client IP after too many failed attempts within the lockout window.
+func (y *YekongaData) OTPLocked(username string, req *Request) bool {
+	return y.attemptsLocked("otpFail", username, req)
+}
+
+// PasswordResetLocked reports whether password reset tokens are locked for
+// the client IP after too many unknown or expired tokens, with the limits of
+// the OTP lockout.
+func (y *YekongaData) PasswordResetLocked(req *Request) bool {
+	return y.attemptsLocked("resetFail", "", req)
+}
+
+// attemptsLocked counts the failed attempts of the status for the username
+// and for the client IP within the lockout window.
+func (y *YekongaData) attemptsLocked(status string, username string, req *Request) bool {
+	const loginAttemptModelName = "LoginAttempt"
+
+	maxAttempts := y.Config.OtpMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5 // default 5 attempts
+	}
+
+	lockoutTime := y.Config.OtpLockoutTime
+	if lockoutTime <= 0 {
+		lockoutTime = 15 // default 15 minutes
+	}
+
+	since := helper.GetTimestamp(nil).Add(-time.Minute * lockoutTime)
+	where := []datatype.DataMap{}
+
+	if helper.IsNotEmpty(username) {
+		where = append(where, datatype.DataMap{"username": username})
+	}
+
+	if client := req.Client(); client != nil && helper.IsNotEmpty(client.IpAddress) {
+		where = append(where, datatype.DataMap{"ipAddress": client.IpAddress})
+	}
+
+	for _, w := range where {
+		w["status"] = status
+		w["timestamp"] = datatype.DataMap{"greaterThan": since}
+
+		count := y.ModelQuery(loginAttemptModelName).SkipTenant().SkipBeforeCommit().Count(w)
+		if count >= int64(maxAttempts) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OTPResendWait returns how long the username has to wait before a new OTP
+// can be sent, zero when a code can be sent right away.
+func (y *YekongaData) OTPResendWait(username string, req *Request) time.Duration {
+	cooldown := y.Config.OtpResendCooldown
+	if cooldown <= 0 {
+		cooldown = 60 // default 60 seconds
+	}
+
+	user := y.UserVerification(username, req)
+	if helper.IsEmpty(user) || helper.IsEmpty(helper.GetValueOf(user, "otpCode")) {
+		return 0
+	}
+
+	wait := time.Until(helper.GetValueOfDate(user, "otpCreatedAt").Add(time.Second * cooldown))
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// UserVerification returns the verification record of the username in the
+// tenant of the request. It neither checks nor spends an OTP.
+func (y *YekongaData) UserVerification(username string, req *Request) *datatype.DataMap {
+	const userVerificationModelName = "UserVerification"
+
+	tenantId := req.TenantId()
+	if helper.IsEmpty(tenantId) {
+		tenantId = helper.ObjectID("000000000000000000000000")
+	}
+
+	return y.ModelQuery(userVerificationModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Where("tenantId", tenantId).Where("username", username).FindOne(nil)
+}
+
+func (y *YekongaData) recordOTPFailure(username string, req *Request) {
+	y.recordAttemptFailure("otpFail", username, req)
+}
+
+func (y *YekongaData) recordAttemptFailure(status string, username string, req *Request) {
+	const loginAttemptModelName = "LoginAttempt"
+	var ipAddress string
+	var domain string
+
+	if client := req.Client(); client != nil {
+		ipAddress = client.IpAddress
+		domain = client.OriginDomain()
+	}
+
+	y.ModelQuery(loginAttemptModelName).SkipTenant().SkipBeforeCommit().Create(datatype.DataMap{
+		"domain":    domain,
+		"username":  username,
+		"ipAddress": ipAddress,
+		"status":    status,
+		"timestamp": helper.GetTimestamp(nil),
+	})
 }
 
 func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string, canCreate bool, target string, req *Request) *datatype.DataMap {
@@ -126,6 +258,11 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 		}
 	}
 
+	otpLength := y.Config.OtpLength
+	if otpLength <= 0 {
+		otpLength = 6 // default 6 digits
+	}
+
 	if helper.IsNotEmpty(username) {
 		var userId interface{}
 		var otpCode string
@@ -157,24 +294,30 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 
 		if helper.IsNotEmpty(user) {
 			id := helper.GetValueOf(user, "_id")
-			otpCode = helper.GetValueOfString(user, "otpCode")
-			otpCreatedAt = helper.GetValueOfDate(user, "otpCreatedAt")
 			var u interface{}
 
-			if helper.IsEmpty(otpCode) {
-				otpCode = helper.GetRandomInt(4)
-				otpCreatedAt = helper.GetTimestamp(nil)
+			// A code sent within the resend cooldown stays valid and is not
+			// sent again.
+			if y.OTPResendWait(username, req) > 0 {
+				return user
 			}
 
+			otpCode = helper.GetSecureNumber(otpLength)
+			otpCreatedAt = helper.GetTimestamp(nil)
+
 			userBody := datatype.DataMap{
 				"userId":       userId,
 				"usernameType": usernameType,
 				"target":       target,
-				"otpCode":      otpCode,
+				"otpCode":      helper.HashOTP(otpCode, y.Config.Authentication.SecretToken),
 				"otpCreatedAt": otpCreatedAt,
 				"updatedAt":    helper.GetTimestamp(nil),
 			}
 
+			if helper.IsEmpty(helper.GetValueOf(user, "otpVerifiedAt")) {
+				userBody["expiresAt"] = otpCreatedAt.Add(userVerificationExpiry)
+			}
+
 			if notTenant {
 				u = y.ModelQuery(userVerificationModelName).Where("id", id).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).Update(userBody, nil)
 			} else {
@@ -186,7 +329,7 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 				user = v
 			}
 		} else if isOwner || isTenantUser || canCreate || notTenant {
-			otpCode = helper.GetRandomInt(4)
+			otpCode = helper.GetSecureNumber(otpLength)
 			otpCreatedAt = helper.GetTimestamp(nil)
 			var u interface{}
 
@@ -196,8 +339,9 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 				"username":     username,
 				"usernameType": usernameType,
 				"target":       target,
-				"otpCode":      otpCode,
+				"otpCode":      helper.HashOTP(otpCode, y.Config.Authentication.SecretToken),
 				"otpCreatedAt": otpCreatedAt,
+				"expiresAt":    otpCreatedAt.Add(userVerificationExpiry),
 				"updatedAt":    helper.GetTimestamp(nil),
 				"createdAt":    helper.GetTimestamp(nil),
 			}
@@ -222,17 +366,21 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 			var email string
 			userId := helper.GetValueOfString(user, "userId")
 
+			// Only the hash is stored, the templates get the plain code.
+			data := helper.ToDataMap(*user)
+			data["otpCode"] = otpCode
+
 			if helper.IsPhone(username) {
 				if usernameType == "whatsapp" {
 					whatsapp = username
-					message = helper.GetWhatsappContent("otp", *user)
+					message = helper.GetWhatsappContent("otp", data)
 				} else {
 					phone = username
-					message = helper.GetTextContent("otp", *user)
+					message = helper.GetTextContent("otp", data)
 				}
 			} else if helper.IsEmail(username) {
 				email = username
-				message = helper.GetEmailContent("", "otp", *user)
+				message = helper.GetEmailContent("", "otp", data)
 			}
 
 			y.Notify(&NotifiedUser{
@@ -252,6 +400,165 @@ func (y *YekongaData) SetOTPVerification(value interface{}, usernameType string,
 	return user
 }
 
+// SetPasswordResetToken issues a new password reset token for the user with
+// the given username and sends it over email, SMS or WhatsApp depending on
+// the username type. Only a hash of the token is stored against the user.
+func (y *YekongaData) SetPasswordResetToken(username string, usernameType string, req *Request) *datatype.DataMap {
+	const userModelName = "User"
+
+	if helper.IsEmpty(username) {
+		return nil
+	}
+
+	if helper.IsPhone(username) {
+		username = helper.FormatPhone(username)
+	}
+
+	where := datatype.DataMap{
+		"OR": []datatype.DataMap{
+			{"username": username},
+			{"email": username},
+			{"phone": username},
+			{"whatsapp": username},
+		},
+	}
+
+	user := y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).FindOne(where)
+	if helper.IsEmpty(user) || helper.GetValueOfBoolean(user, "isBanned") {
+		return nil
+	}
+
+	if helper.IsEmpty(usernameType) {
+		usernameType = helper.GetValueOfString(user, "usernameType")
+	}
+
+	expireTime := y.Config.PasswordResetExpireTime
+	if expireTime <= 0 {
+		expireTime = 30 // default 30 minutes
+	}
+
+	userId := helper.GetValueOfString(user, "id")
+	token := helper.GetSecureToken(8)
+
+	y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().Where("id", userId).Update(datatype.DataMap{
+		"resetTokenHash":      helper.HashToken(token),
+		"resetTokenExpiresAt": time.Now().Add(time.Minute * expireTime),
+		"updatedAt":           helper.GetTimestamp(nil),
+	}, nil)
+
+	data := helper.ToDataMap(*user)
+	data["otpCode"] = token
+
+	var message string
+	var whatsapp string
+	var phone string
+	var email string
+
+	if helper.IsPhone(username) {
+		if usernameType == "whatsapp" {
+			whatsapp = username
+			message = helper.GetWhatsappContent("reset_password", data)
+		} else {
+			phone = username
+			message = helper.GetTextContent("reset_password", data)
+		}
+	} else if helper.IsEmail(username) {
+		email = username
+		message = helper.GetEmailContent("", "reset_password", data)
+	} else if helper.IsEmail(helper.GetValueOfString(user, "email")) {
+		email = helper.GetValueOfString(user, "email")
+		message = helper.GetEmailContent("", "reset_password", data)
+	} else if helper.IsPhone(helper.GetValueOfString(user, "phone")) {
+		phone = helper.GetValueOfString(user, "phone")
+		message = helper.GetTextContent("reset_password", data)
+	}
+
+	y.Notify(&NotifiedUser{
+		UserID:   userId,
+		Email:    email,
+		Phone:    phone,
+		Whatsapp: whatsapp,
+	}, NotificationParams{
+		Title:    "Password Reset",
+		Text:     message,
+		HTML:     message,
+		Whatsapp: message,
+	})
+
+	return user
+}
+
+// PasswordResetUser returns the user owning a password reset token, or nil
+// when the token is unknown or has expired. Unknown and expired tokens count
+// as failed attempts of the client IP, which is locked out like an OTP.
+func (y *YekongaData) PasswordResetUser(token string, req *Request) *datatype.DataMap {
+	const userModelName = "User"
+
+	token = strings.ToUpper(strings.TrimSpace(token))
+	if helper.IsEmpty(token) || y.PasswordResetLocked(req) {
+		return nil
+	}
+
+	user := y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().SetRequest(req, &Response{}).FindOne(datatype.DataMap{
+		"resetTokenHash": helper.HashToken(token),
+	})
+
+	if helper.IsEmpty(user) || helper.GetValueOfDate(user, "resetTokenExpiresAt").Before(time.Now()) {
+		y.recordAttemptFailure("resetFail", "", req)
+
+		return nil
+	}
+
+	return user
+}
+
+// SetPassword stores a bcrypt hash of the password for the user and clears
+// any pending password reset token.
+func (y *YekongaData) SetPassword(userId string, password string) error {
+	const userModelName = "User"
+
+	cost := y.Config.Authentication.SaltRound
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return err
+	}
+
+	result := y.ModelQuery(userModelName).SkipTenant().SkipBeforeCommit().Where("id", userId).Update(datatype.DataMap{
+		"password":            string(hash),
+		"resetTokenHash":      nil,
+		"resetTokenExpiresAt": nil,
+		"updatedAt":           helper.GetTimestamp(nil),
+	}, nil)
+
+	if err, ok := result.(error); ok {
+		return err
+	}
+
+	if result == nil {
+		return errors.New("Password update rejected")
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokens revokes every active refresh token of the user so all
+// the existing sessions have to login again.
+func (y *YekongaData) RevokeRefreshTokens(userId string) {
+	const refreshTokenModelName = "RefreshToken"
+
+	tokens := y.ModelQuery(refreshTokenModelName).SkipTenant().SkipBeforeCommit().Take(0).Where("userId", userId).Where("revoked", false).Find(nil)
+
+	for _, token := range *tokens {
+		y.ModelQuery(refreshTokenModelName).SkipTenant().SkipBeforeCommit().Where("id", helper.GetValueOf(token, "_id")).Update(datatype.DataMap{
+			"revoked": true,
+		}, nil)
+	}
+}
+
 func (y *YekongaData) GetUser(value interface{}, canCreate bool) datatype.DataMap {
 	const userModelName = "User"
 	const profileModelName = "Profile"
@@ -393,6 +700,7 @@ func (y *YekongaData) RecordLoginAttempt(status string, ctx context.Context, inp
 
 	y.ModelQuery(loginAttemptModelName).SkipBeforeCommit().Create(datatype.DataMap{
 		"domain":    domain,
+		"ipAddress": req.Client.IpAddress,
 		"profileId": profileId,
 		"userId":    input.UserID,
 		"username":  input.Username,
@@ -648,7 +956,7 @@ func (y *YekongaData) GetLoginData(req *RequestContext, input *LoginData) *datat
 		}
 
 		var token interface{}
-		token, _ = jwt.EncodeJWT(payloadData.ToMap(), y.Config.Authentication.SecretToken)
+		token, _ = y.EncodeToken(payloadData)
 
 		user["token"] = token
 		user["uuid"] = userId
@@ -5,11 +5,11 @@ import (
 	"errors"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/robertkonga/yekonga-server-go/datatype"
 	"github.com/robertkonga/yekonga-server-go/helper"
-	"github.com/robertkonga/yekonga-server-go/helper/jwt"
 )
 
 // Define custom middleware keys
@@ -22,7 +22,63 @@ const (
 	CatchMiddleware   MiddlewareType = "catch"
 )
 
+// graphqlMutation matches a mutation operation in a GraphQL document.
+var graphqlMutation = regexp.MustCompile(`(?:^|\})\s*mutation\b`)
+
+// Middleware to check the subscription of the tenant for the requested module
 func BillingMiddleware(req *Request, res *Response) (int, error) {
+	app := req.App
+	config := app.Config
+	billing := app.Billing()
+	tenantId := req.TenantId()
+
+	if billing == nil || !billing.Enabled() || helper.IsEmpty(tenantId) {
+		return http.StatusOK, nil
+	}
+
+	masterKey := req.GetContext(string(MasterKey))
+	if helper.IsNotEmpty(masterKey) && masterKey == config.MasterKey {
+		return http.StatusOK, nil
+	}
+
+	// Only the data APIs are billed, authentication stays open so tenants
+	// can still login and pay.
+	currentPath := req.HttpRequest.URL.Path
+	isGraphql := currentPath == app.AppendBaseUrl(config.Graphql.ApiRoute)
+	isRest := helper.IsNotEmpty(config.RestApi) &&
+		strings.HasPrefix(currentPath, app.AppendBaseUrl(config.RestApi)+"/") &&
+		!(helper.IsNotEmpty(config.RestAuthApi) && strings.HasPrefix(currentPath, app.AppendBaseUrl(config.RestAuthApi)))
+
+	if !isGraphql && !isRest {
+		return http.StatusOK, nil
+	}
+
+	moduleName := req.Param("moduleName")
+	if helper.IsEmpty(moduleName) {
+		if payload, ok := req.GetContext(string(TokenPayloadKey)).(TokenPayload); ok {
+			moduleName = payload.ModuleName
+		}
+	}
+
+	status := billing.ModuleStatus(tenantId, moduleName)
+	req.SetContext(string(BillingStatusKey), status)
+
+	switch status {
+	case SubscriptionExpired, SubscriptionCanceled:
+		return http.StatusPaymentRequired, errors.New("Subscription is not active")
+	case SubscriptionPastDue:
+		method := req.HttpRequest.Method
+		readOnly := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+
+		if isGraphql && method == http.MethodPost {
+			readOnly = !graphqlMutation.MatchString(helper.GetValueOfString(req.RawBody, "query"))
+		}
+
+		if !readOnly {
+			return http.StatusPaymentRequired, errors.New("Subscription is past due, only read access is allowed")
+		}
+	}
+
 	return http.StatusOK, nil
 }
 
@@ -57,6 +113,13 @@ func ClientMiddleware(req *Request, res *Response) (int, error) {
 
 	origin = proto + "://" + helper.ExtractDomain(origin)
 
+	// Keep the id of a proxy so the audit trail matches its logs
+	requestId := r.Header.Get("X-Request-Id")
+	if helper.IsEmpty(requestId) {
+		requestId = helper.UUID()
+	}
+	res.SetHeader("X-Request-Id", requestId)
+
 	client := ClientPayload{
 		Host:      host,
 		Proto:     proto,
@@ -66,6 +129,7 @@ func ClientMiddleware(req *Request, res *Response) (int, error) {
 		Origin:    origin,
 		UserAgent: r.UserAgent(),
 		IpAddress: ipAddress,
+		RequestId: requestId,
 	}
 
 	req.SetContext(string(ClientPayloadKey), client)
@@ -174,6 +238,7 @@ func TokenMiddleware(req *Request, res *Response) (int, error) {
 		app.AppendBaseUrl("tenant-config"),
 		app.AppendBaseUrl("theme.css"),
 		app.AppendBaseUrl("custom-style.css"),
+		app.AppendBaseUrl(".well-known/jwks.json"),
 		app.AppendBaseUrl(config.RestApi),
 		app.AppendBaseUrl(config.RestAuthApi),
 		app.AppendBaseUrl(config.Graphql.ApiRoute),
@@ -183,7 +248,8 @@ func TokenMiddleware(req *Request, res *Response) (int, error) {
 		!strings.HasPrefix(currentPath, app.AppendBaseUrl("me/")) &&
 		!strings.HasPrefix(currentPath, app.AppendBaseUrl("refresh/")) &&
 		!strings.HasPrefix(currentPath, app.AppendBaseUrl("download/")) &&
-		!strings.HasPrefix(currentPath, app.AppendBaseUrl("translations/")))
+		!strings.HasPrefix(currentPath, app.AppendBaseUrl("translations/")) &&
+		!(helper.IsNotEmpty(config.RestApi) && strings.HasPrefix(currentPath, app.AppendBaseUrl(config.RestApi)+"/")))
 
 	var isValid bool
 	var accessToken string
@@ -215,7 +281,7 @@ func TokenMiddleware(req *Request, res *Response) (int, error) {
 	}
 
 	if helper.IsNotEmpty(accessToken) {
-		isValid, tokenPayloadMap = jwt.DecodeJWT(accessToken, config.Authentication.SecretToken)
+		isValid, tokenPayloadMap = app.DecodeToken(accessToken)
 
 		if !isValid || helper.IsEmpty(tokenPayloadMap) {
 			if mandatoryValidToken {
@@ -341,7 +407,10 @@ func ApplicationIDMiddleware(req *Request, res *Response) (int, error) {
 			appKey = req.GetHeader("X-Core-Application-Id")
 		}
 
-		if !helper.Contains(allowed, extension) {
+		// Other services fetch the public keys without an application key.
+		isPublicKeys := path == req.App.AppendBaseUrl(".well-known/jwks.json")
+
+		if !helper.Contains(allowed, extension) && !isPublicKeys {
 			if helper.IsEmpty(appKey) {
 				appKey = req.Query("application-key")
 
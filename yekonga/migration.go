@@ -0,0 +1,639 @@
+package yekonga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/config"
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// MigrationsCollection keeps the migrations that ran and the lock of the
+// instance that is migrating.
+const MigrationsCollection = "_migrations"
+
+// migrationLockName is the name of the lock record in the migrations
+// collection.
+const migrationLockName = "_lock"
+
+// migrationLockTimeout is how long a lock holds before another instance may
+// take it over, in case the instance that took it died.
+const migrationLockTimeout = 30 * time.Minute
+
+// migrationMutex keeps the migrations of one process in line, the lock record
+// keeps the other instances out.
+var migrationMutex sync.Mutex
+
+type MigrationAction string
+
+const (
+	MigrationAddField    MigrationAction = "addField"
+	MigrationRenameField MigrationAction = "renameField"
+	MigrationDropField   MigrationAction = "dropField"
+	MigrationChangeType  MigrationAction = "changeType"
+	MigrationBackfill    MigrationAction = "backfill"
+	MigrationAddIndex    MigrationAction = "addIndex"
+	MigrationDropIndex   MigrationAction = "dropIndex"
+)
+
+// MigrationStep is one change of a migration. Model is the name of the model
+// the change applies to.
+//
+//   - addField adds Field, of Type or the type of the model field, and sets
+//     it to Value on the records that don't have it.
+//   - renameField renames Field to To.
+//   - dropField removes Field from every record.
+//   - changeType converts Field to Type with Convert, or with the default
+//     conversion of the type.
+//   - backfill sets Field on the records that don't have it, to the result
+//     of Backfill or to Value.
+//   - addIndex and dropIndex take Index, declared as in the indexes option.
+type MigrationStep struct {
+	Action   MigrationAction                           `json:"action"`
+	Model    string                                    `json:"model"`
+	Field    string                                    `json:"field"`
+	To       string                                    `json:"to"`
+	Type     string                                    `json:"type"`
+	Value    interface{}                               `json:"value"`
+	Index    interface{}                               `json:"index"`
+	Convert  func(value interface{}) interface{}       `json:"-"`
+	Backfill func(record datatype.DataMap) interface{} `json:"-"`
+}
+
+// Migration is a versioned change of the database. Migrations run in the
+// order of their ids, Up when migrating up and Down when rolling back.
+type Migration struct {
+	Id          string          `json:"id"`
+	Description string          `json:"description"`
+	Up          []MigrationStep `json:"up"`
+	Down        []MigrationStep `json:"down"`
+}
+
+// MigrationState is a migration as reported by MigrationStatus. Missing is
+// set on a migration that ran but is no longer registered.
+type MigrationState struct {
+	Id          string
+	Description string
+	Applied     bool
+	AppliedAt   *time.Time
+	Missing     bool
+}
+
+// migrationStore runs the steps of the migrations on one backend.
+type migrationStore interface {
+	addField(model *DataModel, field DataModelField) error
+	renameField(model *DataModel, field string, to string) error
+	dropField(model *DataModel, field string) error
+	changeType(model *DataModel, field DataModelField, convert func(value interface{}) interface{}) error
+	updateField(model *DataModel, field string, update func(record datatype.DataMap) (interface{}, bool)) error
+	dropIndex(model *DataModel, index DataModelIndex) error
+}
+
+// RegisterMigration adds a migration written in Go. It replaces a JSON
+// migration file with the same id.
+func (y *YekongaData) RegisterMigration(migration Migration) {
+	y.mut.Lock()
+	defer y.mut.Unlock()
+
+	if y.migrations == nil {
+		y.migrations = make(map[string]Migration)
+	}
+
+	y.migrations[migration.Id] = migration
+}
+
+// Migrate runs the migrations that didn't run yet, in order. It stops at the
+// first one that fails, the migrations before it stay applied.
+func (y *YekongaData) Migrate() error {
+	return y.withMigrationLock(func(store migrationStore) error {
+		migrations, err := y.registeredMigrations()
+		if err != nil {
+			return err
+		}
+
+		applied := y.appliedMigrations()
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Id]; ok {
+				continue
+			}
+
+			if err := y.runMigrationSteps(store, migration.Up); err != nil {
+				return fmt.Errorf("migration %s failed: %w", migration.Id, err)
+			}
+
+			result := y.migrationQuery().Create(datatype.DataMap{
+				"name":        migration.Id,
+				"description": migration.Description,
+				"appliedAt":   time.Now(),
+			})
+			if err, ok := result.(error); ok {
+				return fmt.Errorf("migration %s ran but was not recorded: %w", migration.Id, err)
+			}
+
+			logger.Info("Migration applied", migration.Id)
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the last count migrations that ran, newest first.
+func (y *YekongaData) MigrateDown(count int) error {
+	if count <= 0 {
+		count = 1
+	}
+
+	return y.withMigrationLock(func(store migrationStore) error {
+		migrations, err := y.registeredMigrations()
+		if err != nil {
+			return err
+		}
+
+		applied := y.appliedMigrations()
+
+		for i := len(migrations) - 1; i >= 0 && count > 0; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Id]; !ok {
+				continue
+			}
+
+			if len(migration.Down) == 0 {
+				return fmt.Errorf("migration %s can't be rolled back, it has no down steps", migration.Id)
+			}
+
+			if err := y.runMigrationSteps(store, migration.Down); err != nil {
+				return fmt.Errorf("rollback of migration %s failed: %w", migration.Id, err)
+			}
+
+			result := y.migrationQuery().Delete(datatype.DataMap{"name": migration.Id})
+			if err, ok := result.(error); ok {
+				return fmt.Errorf("migration %s rolled back but is still recorded: %w", migration.Id, err)
+			}
+
+			logger.Info("Migration rolled back", migration.Id)
+			count--
+		}
+
+		return nil
+	})
+}
+
+// MigrationStatus lists the registered migrations in order, with the ones
+// that ran but are no longer registered at the end.
+func (y *YekongaData) MigrationStatus() ([]MigrationState, error) {
+	if _, err := y.migrationStore(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := y.registeredMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := y.appliedMigrations()
+	result := make([]MigrationState, 0, len(migrations))
+
+	for _, migration := range migrations {
+		state := MigrationState{Id: migration.Id, Description: migration.Description}
+		if record, ok := applied[migration.Id]; ok {
+			state.Applied = true
+			state.AppliedAt = migrationAppliedAt(record)
+			delete(applied, migration.Id)
+		}
+
+		result = append(result, state)
+	}
+
+	missing := make([]string, 0, len(applied))
+	for id := range applied {
+		missing = append(missing, id)
+	}
+	sort.Strings(missing)
+
+	for _, id := range missing {
+		result = append(result, MigrationState{
+			Id:          id,
+			Description: helper.ToString(applied[id]["description"]),
+			Applied:     true,
+			AppliedAt:   migrationAppliedAt(applied[id]),
+			Missing:     true,
+		})
+	}
+
+	return result, nil
+}
+
+// registeredMigrations returns the JSON migration files and the migrations
+// registered in Go, ordered by id.
+func (y *YekongaData) registeredMigrations() ([]Migration, error) {
+	migrations, err := loadMigrationFiles(y.migrationsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	y.mut.RLock()
+	for id, migration := range y.migrations {
+		migrations[id] = migration
+	}
+	y.mut.RUnlock()
+
+	result := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		result = append(result, migration)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (y *YekongaData) migrationsPath() string {
+	path := y.Config.Database.MigrationsPath
+	if helper.IsEmpty(path) {
+		path = "migrations"
+	}
+
+	if !helper.FileExists(path) {
+		path = helper.GetPath(path)
+	}
+
+	return path
+}
+
+// loadMigrationFiles reads the migrations in the JSON files of the directory.
+// The id of a migration defaults to the name of its file.
+func loadMigrationFiles(dir string) (map[string]Migration, error) {
+	migrations := make(map[string]Migration)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		return migrations, nil
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var migration Migration
+		if err := json.Unmarshal(data, &migration); err != nil {
+			return nil, fmt.Errorf("invalid migration file %s: %w", filepath.Base(file), err)
+		}
+
+		if helper.IsEmpty(migration.Id) {
+			migration.Id = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+
+		migrations[migration.Id] = migration
+	}
+
+	return migrations, nil
+}
+
+// appliedMigrations returns the records of the migrations that ran, keyed by
+// id.
+func (y *YekongaData) appliedMigrations() map[string]datatype.DataMap {
+	result := make(map[string]datatype.DataMap)
+
+	records := y.migrationQuery().Find(nil)
+	if records == nil {
+		return result
+	}
+
+	for _, record := range *records {
+		name := helper.ToString(record["name"])
+		if name != migrationLockName {
+			result[name] = record
+		}
+	}
+
+	return result
+}
+
+func migrationAppliedAt(record datatype.DataMap) *time.Time {
+	if t, ok := revisionTime(record["appliedAt"]); ok {
+		return &t
+	}
+
+	return nil
+}
+
+// migrationQuery is a query on the migrations collection. The collection has
+// a model of its own that is not part of the database structure, so it is
+// not exposed by GraphQL or REST.
+func (y *YekongaData) migrationQuery() *DataModelQuery {
+	y.mut.Lock()
+	if y.migrationModel == nil {
+		model := newDataModel(y.Config, "Migrations", map[string]map[string]interface{}{
+			"name":        {"type": "String", "required": true},
+			"description": {"type": "Text"},
+			"owner":       {"type": "String"},
+			"appliedAt":   {"type": "Date"},
+			"expiresAt":   {"type": "Date"},
+			ModelOptionsKey: {
+				"indexes": []interface{}{
+					map[string]interface{}{"fields": []interface{}{"name"}, "unique": true},
+				},
+			},
+		})
+		model.Collection = MigrationsCollection
+		model.App = y
+		model.DBConnect = y.dbConnect
+
+		if err := y.dbConnect.migrateModel(model); err != nil {
+			logger.Error("Migrations collection", err)
+		}
+
+		y.migrationModel = model
+	}
+	y.mut.Unlock()
+
+	return y.migrationModel.Query().SkipTenant().SkipBeforeCommit()
+}
+
+func (y *YekongaData) migrationStore() (migrationStore, error) {
+	store := y.dbConnect.migrationStore()
+	if store == nil {
+		return nil, errors.New("migrations can't run on the " + string(y.Config.Database.Kind) + " database, it is not connected or not supported")
+	}
+
+	return store, nil
+}
+
+// withMigrationLock runs the function while holding the migration lock. The
+// lock is a record in the migrations collection, its unique name keeps a
+// second instance from taking it at the same time.
+func (y *YekongaData) withMigrationLock(run func(store migrationStore) error) error {
+	store, err := y.migrationStore()
+	if err != nil {
+		return err
+	}
+
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	owner := helper.UUID()
+	if hostname, err := os.Hostname(); err == nil {
+		owner = hostname + ":" + helper.ToString(os.Getpid()) + ":" + owner
+	}
+
+	query := y.migrationQuery()
+	if current := query.FindOne(datatype.DataMap{"name": migrationLockName}); current != nil {
+		if expiresAt, ok := revisionTime((*current)["expiresAt"]); ok && expiresAt.After(time.Now()) {
+			return fmt.Errorf("migrations are locked by %v until %s", (*current)["owner"], expiresAt.Format(time.RFC3339))
+		}
+
+		y.migrationQuery().Delete(datatype.DataMap{"name": migrationLockName, "owner": (*current)["owner"]})
+	}
+
+	result := y.migrationQuery().Create(datatype.DataMap{
+		"name":      migrationLockName,
+		"owner":     owner,
+		"expiresAt": time.Now().Add(migrationLockTimeout),
+	})
+	if err, ok := result.(error); ok {
+		return fmt.Errorf("migrations are locked by another instance: %w", err)
+	}
+
+	defer y.migrationQuery().Delete(datatype.DataMap{"name": migrationLockName, "owner": owner})
+
+	return run(store)
+}
+
+func (y *YekongaData) runMigrationSteps(store migrationStore, steps []MigrationStep) error {
+	for i, step := range steps {
+		if err := y.runMigrationStep(store, step); err != nil {
+			return fmt.Errorf("step %d (%s %s.%s): %w", i+1, step.Action, step.Model, step.Field, err)
+		}
+	}
+
+	return nil
+}
+
+func (y *YekongaData) runMigrationStep(store migrationStore, step MigrationStep) error {
+	model := y.findDataModel(step.Model)
+	if model == nil {
+		return errors.New("unknown model " + step.Model)
+	}
+
+	if step.Action != MigrationAddIndex && step.Action != MigrationDropIndex && helper.IsEmpty(step.Field) {
+		return errors.New("field is required")
+	}
+
+	switch step.Action {
+	case MigrationAddField:
+		field := migrationField(model, step.Field, step.Type)
+		if err := store.addField(model, field); err != nil {
+			return err
+		}
+
+		if step.Value == nil {
+			return nil
+		}
+
+		return store.updateField(model, step.Field, func(record datatype.DataMap) (interface{}, bool) {
+			return step.Value, record[step.Field] == nil
+		})
+	case MigrationRenameField:
+		if helper.IsEmpty(step.To) {
+			return errors.New("to is required")
+		}
+
+		return store.renameField(model, step.Field, step.To)
+	case MigrationDropField:
+		return store.dropField(model, step.Field)
+	case MigrationChangeType:
+		if helper.IsEmpty(step.Type) {
+			return errors.New("type is required")
+		}
+
+		field := migrationField(model, step.Field, step.Type)
+		convert := step.Convert
+		if convert == nil {
+			convert = migrationConverter(field)
+		}
+
+		if err := store.changeType(model, field, convert); err != nil {
+			return err
+		}
+
+		return y.dbConnect.migrateModel(model)
+	case MigrationBackfill:
+		if step.Backfill == nil && step.Value == nil {
+			return errors.New("backfill or value is required")
+		}
+
+		return store.updateField(model, step.Field, func(record datatype.DataMap) (interface{}, bool) {
+			if record[step.Field] != nil {
+				return nil, false
+			}
+
+			if step.Backfill != nil {
+				return step.Backfill(record), true
+			}
+
+			return step.Value, true
+		})
+	case MigrationAddIndex, MigrationDropIndex:
+		index, err := migrationIndex(model, step.Index)
+		if err != nil {
+			return err
+		}
+
+		if step.Action == MigrationDropIndex {
+			return store.dropIndex(model, index)
+		}
+
+		indexed := *model
+		indexed.Indexes = []DataModelIndex{index}
+		for _, v := range model.Indexes {
+			if v.Name != index.Name {
+				indexed.Indexes = append(indexed.Indexes, v)
+			}
+		}
+
+		return y.dbConnect.migrateModel(&indexed)
+	}
+
+	return errors.New("unknown action " + string(step.Action))
+}
+
+// findDataModel finds a model by its name, class or collection.
+func (y *YekongaData) findDataModel(name string) *DataModel {
+	for _, model := range y.models {
+		if model.Name == name || model.Class == name || model.Collection == name {
+			return model
+		}
+	}
+
+	if model, ok := y.models[helper.ToCamelCase(helper.Singularize(name))]; ok {
+		return model
+	}
+
+	return nil
+}
+
+// migrationField is the field of a step, of the given type or else of the
+// type it has in the model.
+func migrationField(model *DataModel, name string, kind string) DataModelField {
+	if helper.IsEmpty(kind) {
+		if field, ok := model.Fields[name]; ok {
+			return field
+		}
+
+		kind = "String"
+	}
+
+	return *getDataModelField(name, map[string]interface{}{"type": kind})
+}
+
+// migrationIndex reads the index of an addIndex or dropIndex step and names
+// it like the indexes of the model options.
+func migrationIndex(model *DataModel, value interface{}) (DataModelIndex, error) {
+	indexes := getDataModelIndexes([]interface{}{value})
+	if len(indexes) == 0 {
+		return DataModelIndex{}, errors.New("index is required")
+	}
+
+	index := indexes[0]
+	if helper.IsEmpty(index.Name) {
+		index.Name = "idx_" + model.Collection + "_" + strings.Join(index.fieldNames(), "_")
+	}
+
+	return index, nil
+}
+
+// migrationConverter is the default conversion of changeType, to the type of
+// the field. A value that can't be converted is kept as it is.
+func migrationConverter(field DataModelField) func(value interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		if value == nil || field.IsArray {
+			return value
+		}
+
+		switch field.Kind {
+		case DataModelString:
+			if helper.IsMap(value) || helper.IsArray(value) {
+				return helper.ToJson(value)
+			}
+			return helper.ToString(value)
+		case DataModelNumber:
+			if v, ok := value.(bool); ok {
+				if v {
+					return int64(1)
+				}
+				return int64(0)
+			}
+			return int64(helper.ToFloat(value))
+		case DataModelFloat:
+			if v, ok := value.(bool); ok {
+				if v {
+					return 1.0
+				}
+				return 0.0
+			}
+			return helper.ToFloat(value)
+		case DataModelBool:
+			switch v := value.(type) {
+			case bool:
+				return v
+			case string:
+				v = strings.ToLower(strings.TrimSpace(v))
+				return v == "true" || v == "1" || v == "yes" || v == "on"
+			}
+			return helper.ToFloat(value) != 0
+		case DataModelDate:
+			if t, ok := revisionTime(value); ok {
+				return t
+			}
+		case DataModelID:
+			if v, ok := value.(string); ok {
+				if id, err := bson.ObjectIDFromHex(v); err == nil {
+					return id
+				}
+			}
+		}
+
+		return value
+	}
+}
+
+// migrationStore returns the store that runs the migration steps on the
+// database, nil when the database is not connected or has none.
+func (dc *DatabaseConnections) migrationStore() migrationStore {
+	switch dc.config.Database.Kind {
+	case config.DBTypeMongodb:
+		if dc.mongodbClient != nil {
+			return &mongodbMigrationStore{dc: dc}
+		}
+	case config.DBTypeMysql:
+		if dc.mysqlClient != nil {
+			return newMysqlMigrationStore(dc)
+		}
+	case config.DBTypePostgres:
+		if dc.postgresClient != nil {
+			return newPostgresMigrationStore(dc)
+		}
+	case config.DBTypeSql:
+	default:
+		if dc.localClient != nil {
+			return &localMigrationStore{dc: dc}
+		}
+	}
+
+	return nil
+}
@@ -10,6 +10,11 @@ import (
 
 const TenantIDKey = "tenantId"
 
+// ModelOptionsKey holds the options of a model in the database structure,
+// next to its fields, e.g. "_options": {"softDelete": true, "retentionDays": 30,
+// "versioned": true, "revisions": true, "audit": true}.
+const ModelOptionsKey = "_options"
+
 type DataModelFieldType string
 
 const (
@@ -23,6 +28,7 @@ const (
 	DataModelAny    DataModelFieldType = "any"
 	DataModelArray  DataModelFieldType = "array"
 	DataModelFile   DataModelFieldType = "file"
+	DataModelGeo    DataModelFieldType = "geo"
 )
 
 // Define custom middleware keys
@@ -50,6 +56,8 @@ type DataModelField struct {
 	DefaultValue interface{}
 	ForeignKey   DataModelFieldForeignKey
 	Options      []DataModelFieldOptions
+	Validation   DataModelFieldValidation
+	Searchable   bool
 	ID           bool
 }
 
@@ -74,11 +82,21 @@ type DataModel struct {
 	PrimaryKey     string
 	PrimaryName    string
 	HasTenant      bool
+	SoftDelete     bool
+	Versioned      bool
+	Revisions      bool
+	Audit          DataModelAudit
+	RetentionDays  int
+	TTL            DataModelTTL
+	Rules          []DataModelRule
+	Indexes        []DataModelIndex
+	SearchFields   []string
 	Required       []string
 	Protected      []string
 	DateFields     []string
 	OptionFields   []string
 	FileFields     []string
+	GeoFields      []string
 	ValidFields    []string
 	BooleanFields  []string
 	NumberFields   []string
@@ -206,6 +224,7 @@ func (m *DataModel) initialize(collection string, fields map[string]map[string]i
 	m.DateFields = make([]string, 0, count)
 	m.OptionFields = make([]string, 0, count)
 	m.FileFields = make([]string, 0, count)
+	m.GeoFields = make([]string, 0, count)
 	m.ValidFields = make([]string, 0, count)
 	m.ParentKeys = make([]string, 0, count)
 	m.RelativeKeys = make([]string, 0, count)
@@ -222,6 +241,11 @@ func (m *DataModel) initialize(collection string, fields map[string]map[string]i
 			continue
 		}
 
+		if k == ModelOptionsKey {
+			m.setOptions(v)
+			continue
+		}
+
 		if k == TenantIDKey {
 			m.HasTenant = true
 		}
@@ -256,6 +280,9 @@ func (m *DataModel) initialize(collection string, fields map[string]map[string]i
 		if field.Kind == DataModelFile {
 			m.FileFields = append(m.FileFields, k)
 		}
+		if field.Kind == DataModelGeo {
+			m.GeoFields = append(m.GeoFields, k)
+		}
 		if field.Kind == DataModelBool {
 			m.BooleanFields = append(m.BooleanFields, k)
 		}
@@ -287,7 +314,71 @@ func (m *DataModel) initialize(collection string, fields map[string]map[string]i
 		m.ValidFields = append(m.ValidFields, k)
 	}
 
+	if m.SoftDelete {
+		if !helper.Contains(m.ValidFields, DeletedAtKey) {
+			k := DeletedAtKey
+			field := *m.getDataModelField(k, map[string]interface{}{"type": "Date", "default": nil, "required": false})
+
+			m.Fields[k] = field
+			m.ValidFields = append(m.ValidFields, k)
+			m.DateFields = append(m.DateFields, k)
+		}
+
+		if !helper.Contains(m.ValidFields, DeletedByKey) {
+			k := DeletedByKey
+			field := *m.getDataModelField(k, map[string]interface{}{"type": "ID", "default": nil, "required": false})
+
+			m.Fields[k] = field
+			m.ValidFields = append(m.ValidFields, k)
+			m.IDKeys = append(m.IDKeys, k)
+		}
+	}
+
+	if m.Versioned && !helper.Contains(m.ValidFields, VersionKey) {
+		k := VersionKey
+		field := *m.getDataModelField(k, map[string]interface{}{"type": "Integer", "default": 1, "required": false})
+
+		m.Fields[k] = field
+		m.ValidFields = append(m.ValidFields, k)
+		m.NumberFields = append(m.NumberFields, k)
+	}
+
 	sort.Strings(m.ValidFields)
+	m.Indexes = m.getIndexes()
+}
+
+func (m *DataModel) setOptions(options map[string]interface{}) {
+	if v, ok := options["softDelete"].(bool); ok {
+		m.SoftDelete = v
+	}
+
+	if v, ok := options["versioned"].(bool); ok {
+		m.Versioned = v
+	}
+
+	if v, ok := options["revisions"].(bool); ok {
+		m.Revisions = v
+	}
+
+	if v, ok := options["audit"]; ok {
+		m.Audit = getDataModelAudit(v)
+	}
+
+	if v, ok := options["retentionDays"]; ok {
+		m.RetentionDays = helper.ToInt(v)
+	}
+
+	if v, ok := options["rules"]; ok {
+		m.Rules = getDataModelRules(v)
+	}
+
+	if v, ok := options["indexes"]; ok {
+		m.Indexes = getDataModelIndexes(v)
+	}
+
+	if v, ok := options["ttl"]; ok {
+		m.TTL = getDataModelTTL(v)
+	}
 }
 
 func (m *DataModel) getDataModelField(name string, field map[string]interface{}) *DataModelField {
@@ -308,6 +399,7 @@ func getDataModelField(name string, field map[string]interface{}) *DataModelFiel
 	var kind DataModelFieldType = DataModelString
 	var required bool
 	var protected bool
+	var searchable bool
 	var isArray bool = false
 	var defaultValue interface{}
 	var foreignKey DataModelFieldForeignKey
@@ -353,6 +445,8 @@ func getDataModelField(name string, field map[string]interface{}) *DataModelFiel
 				kind = DataModelFile
 			case "file":
 				kind = DataModelFile
+			case "geo", "point", "location":
+				kind = DataModelGeo
 			}
 		}
 	}
@@ -375,6 +469,12 @@ func getDataModelField(name string, field map[string]interface{}) *DataModelFiel
 		}
 	}
 
+	if v, ok := field["searchable"]; ok {
+		if vi, oki := v.(bool); oki {
+			searchable = vi
+		}
+	}
+
 	hasDefault := false
 	if v, ok := field["default"]; ok {
 		hasDefault = true
@@ -467,6 +567,8 @@ func getDataModelField(name string, field map[string]interface{}) *DataModelFiel
 		DefaultValue: defaultValue,
 		ForeignKey:   foreignKey,
 		Options:      options,
+		Validation:   getDataModelFieldValidation(name, field),
+		Searchable:   searchable,
 		IsArray:      isArray,
 		ID:           kind == DataModelID,
 	}
@@ -2,6 +2,8 @@ package yekonga
 
 import (
 	"context"
+	"errors"
+	"sort"
 
 	"github.com/robertkonga/yekonga-server-go/config"
 	"github.com/robertkonga/yekonga-server-go/datatype"
@@ -37,6 +39,9 @@ type QueryContext struct {
 	Params     map[string]interface{}
 	AccessRole string
 	Route      string
+	// Tx is the transaction the query runs in, nil outside app.Transaction.
+	// Triggers use Tx.ModelQuery so their writes commit or roll back with it.
+	Tx *Tx
 }
 
 type DataModelQuery struct {
@@ -50,12 +55,18 @@ type DataModelQuery struct {
 	skip             int
 	where            datatype.DataMap
 	orderBy          map[string]string
+	orderKeys        []string
 	selection        []string
 	distinct         []string
 	groupBy          []string
 	groupByRaw       map[string]interface{}
 	skipBeforeCommit bool
 	skipTenant       bool
+	search           string
+	trashed          trashedScope
+	forceDelete      bool
+	expectedVersion  *int
+	tx               *Tx
 }
 
 func NewDataModelQuery(model *DataModel) DataModelQuery {
@@ -73,6 +84,7 @@ func (m *DataModelQuery) NewInstance() *DataModelQuery {
 		Model:            m.Model,
 		isAdmin:          false,
 		skipBeforeCommit: m.skipBeforeCommit,
+		tx:               m.tx,
 		QueryContext: QueryContext{
 			Params: make(map[string]interface{}),
 		},
@@ -251,19 +263,26 @@ func (m *DataModelQuery) OrderBy(name string, value string) *DataModelQuery {
 		m.orderBy = make(map[string]string)
 	}
 
+	if _, ok := m.orderBy[name]; !ok {
+		m.orderKeys = append(m.orderKeys, name)
+	}
 	m.orderBy[name] = value
 
 	return m
 }
 
+// OrderByAll sorts by the fields in the order of the list. The fields of one
+// map have no order of their own and are taken alphabetically.
 func (m *DataModelQuery) OrderByAll(values []map[string]string) *DataModelQuery {
-	if m.orderBy == nil {
-		m.orderBy = make(map[string]string)
-	}
-
 	for _, o := range values {
-		for k, v := range o {
-			m.orderBy[k] = v
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			m.OrderBy(k, o[k])
 		}
 	}
 
@@ -336,7 +355,12 @@ func (m *DataModelQuery) Create(data datatype.DataMap) interface{} {
 		}
 	}
 
-	result, err := m.collection().create(*(m.formatInputData(data, CreateInputAction)))
+	input := *(m.formatInputData(data, CreateInputAction))
+	if errs := m.validate(input, nil); len(errs) > 0 {
+		return m.validationError(errs)
+	}
+
+	result, err := m.collection().create(input)
 
 	if err != nil {
 		return err
@@ -354,10 +378,17 @@ func (m *DataModelQuery) Create(data datatype.DataMap) interface{} {
 		result = &v
 	}
 
-	m.Model.App.socketServer.Of("/").Emit("database", datatype.DataMap{
-		"action": "create",
-		"model":  m.Model.Name,
-	}, nil)
+	if result != nil {
+		record := *result
+		m.audit(string(CreateInputAction), nil, []datatype.DataMap{record})
+		m.afterCommit(func() {
+			m.emitDatabaseEvent("create", []datatype.DataMap{record}, nil)
+
+			if g := m.Model.App.graphqlBuild; g != nil {
+				g.publish(m.Model.Name, SubscriptionCreated, record)
+			}
+		})
+	}
 
 	return result
 }
@@ -365,6 +396,7 @@ func (m *DataModelQuery) Create(data datatype.DataMap) interface{} {
 func (m *DataModelQuery) Update(data datatype.DataMap, where interface{}) interface{} {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		triggerBefore := m.runTriggerAction(BeforeUpdateTriggerAllAction, data)
@@ -382,7 +414,28 @@ func (m *DataModelQuery) Update(data datatype.DataMap, where interface{}) interf
 		}
 	}
 
-	result, err := m.collection().update(*(m.formatInputData(data, UpdateInputAction)))
+	// The record the update changes is loaded once, the validation checks
+	// against it and the audit trail and the revisions keep its values from
+	// before the update
+	target := datatype.DataMap{}
+	var before []datatype.DataMap
+	if m.Model.Audit.Enabled || m.Model.Revisions || m.needsValidationTarget() {
+		if current := m.snapshotOne(); current != nil {
+			target = *current
+			before = []datatype.DataMap{*current}
+		}
+	}
+
+	changes := *(m.formatInputData(data, UpdateInputAction))
+	if errs := m.validate(changes, target); len(errs) > 0 {
+		return m.validationError(errs)
+	}
+
+	result, err := m.collection().update(changes)
+
+	if errors.Is(err, errVersionConflict) {
+		return m.conflictError()
+	}
 
 	if err != nil {
 		console.Log(err.Error())
@@ -401,10 +454,18 @@ func (m *DataModelQuery) Update(data datatype.DataMap, where interface{}) interf
 		result = &v
 	}
 
-	m.Model.App.socketServer.Of("/").Emit("database", datatype.DataMap{
-		"action": "update",
-		"model":  m.Model.Name,
-	}, nil)
+	if result != nil {
+		record := *result
+		m.audit(string(UpdateInputAction), auditBefore(before, record), []datatype.DataMap{record})
+		m.keepRevisions(string(UpdateInputAction), auditBefore(before, record))
+		m.afterCommit(func() {
+			m.emitDatabaseEvent("update", []datatype.DataMap{record}, changes)
+
+			if g := m.Model.App.graphqlBuild; g != nil {
+				g.publish(m.Model.Name, SubscriptionUpdated, record)
+			}
+		})
+	}
 
 	return result
 }
@@ -454,10 +515,12 @@ func (m *DataModelQuery) Import(data []interface{}, uniqueKeys []string) interfa
 	result := map[string]interface{}{}
 	formattedCreateData := make([]datatype.DataMap, 0, len(data))
 	formattedUpdateData := make([]datatype.DataMap, 0, len(data))
+	validationErrors := make([]FieldError, 0)
+	uniqueRows := newImportUniqueness(m.Model)
 	// inputIds := []string{}
 
 ParentLoop:
-	for _, v := range data {
+	for i, v := range data {
 		vi := helper.ToDataMap(v)
 
 		if helper.IsNotEmpty(vi) {
@@ -496,18 +559,31 @@ ParentLoop:
 				if helper.IsNotEmpty(existsData) {
 					// Update existing data
 					vi["_id"] = helper.GetValueOf(existsData, "_id")
+					input := *m.formatInputData(vi, UpdateInputAction)
+					validationErrors = append(validationErrors, m.validate(input, *existsData, i)...)
+					validationErrors = append(validationErrors, uniqueRows.check(input, i)...)
 					formattedUpdateData = append(formattedUpdateData, vi)
 				} else {
 					// Create new data
-					formattedCreateData = append(formattedCreateData, *m.formatInputData(vi, ImportInputAction))
+					input := *m.formatInputData(vi, ImportInputAction)
+					validationErrors = append(validationErrors, m.validate(input, nil, i)...)
+					validationErrors = append(validationErrors, uniqueRows.check(input, i)...)
+					formattedCreateData = append(formattedCreateData, input)
 				}
 			} else {
 				// No unique keys found, treat as new data
-				formattedCreateData = append(formattedCreateData, *m.formatInputData(vi, ImportInputAction))
+				input := *m.formatInputData(vi, ImportInputAction)
+				validationErrors = append(validationErrors, m.validate(input, nil, i)...)
+				validationErrors = append(validationErrors, uniqueRows.check(input, i)...)
+				formattedCreateData = append(formattedCreateData, input)
 			}
 		}
 	}
 
+	if len(validationErrors) > 0 {
+		return m.validationError(validationErrors)
+	}
+
 	if len(formattedCreateData) > 0 {
 		createData, err := m.collection().createMany(formattedCreateData)
 
@@ -531,6 +607,18 @@ ParentLoop:
 		if result, ok := triggerAfter.([]datatype.DataMap); ok {
 			createData = &(result)
 		}
+
+		if createData != nil {
+			records := *createData
+			m.audit(string(ImportInputAction), nil, records)
+			m.afterCommit(func() {
+				m.emitDatabaseImport(records)
+
+				if g := m.Model.App.graphqlBuild; g != nil {
+					g.publish(m.Model.Name, SubscriptionCreated, records...)
+				}
+			})
+		}
 	}
 
 	// console.Log("formattedUpdateData", formattedUpdateData)
@@ -559,17 +647,13 @@ ParentLoop:
 	result["updated"] = updated
 	result["data"] = afterData
 
-	m.Model.App.socketServer.Of("/").Broadcast("database", datatype.DataMap{
-		"action": "import",
-		"model":  m.Model.Name,
-	}, nil)
-
 	return result
 }
 
 func (m *DataModelQuery) Delete(where interface{}) interface{} {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		triggerBefore := m.runTriggerAction(BeforeDeleteTriggerAllAction, m.where)
@@ -586,7 +670,23 @@ func (m *DataModelQuery) Delete(where interface{}) interface{} {
 			m.WhereAll(helper.ToDataMap(triggerBefore))
 		}
 	}
-	result, err := m.collection().delete()
+
+	// The records are gone after the delete, keep them for the events.
+	deleted := m.snapshot()
+
+	var publishDeleted func()
+	if g := m.Model.App.graphqlBuild; g != nil && len(deleted) > 0 {
+		publishDeleted = g.prepareDeleted(m, deleted)
+	}
+
+	var result interface{}
+	var err error
+
+	if m.Model.SoftDelete && !m.forceDelete {
+		result, err = m.softDelete(len(deleted))
+	} else {
+		result, err = m.collection().delete()
+	}
 
 	if err != nil {
 		return err
@@ -602,14 +702,45 @@ func (m *DataModelQuery) Delete(where interface{}) interface{} {
 		result = helper.ToDataMap(triggerAfter)
 	}
 
-	m.Model.App.socketServer.Of("/").Broadcast("database", datatype.DataMap{
-		"action": "delete",
-		"model":  m.Model.Name,
-	}, nil)
+	m.audit("delete", deleted, nil)
+	m.keepRevisions("delete", deleted)
+	m.afterCommit(func() {
+		m.emitDatabaseEvent("delete", deleted, nil)
+
+		if publishDeleted != nil {
+			publishDeleted()
+		}
+	})
 
 	return result
 }
 
+// snapshot returns the records the query filter matches as they are. The
+// filter already holds the tenant and the before triggers of the write.
+func (m *DataModelQuery) snapshot() []datatype.DataMap {
+	query := m.NewInstance().SkipTenant().SkipBeforeCommit()
+	query.where = m.where
+
+	records := query.collection().find()
+	if records == nil {
+		return nil
+	}
+
+	return *records
+}
+
+// snapshotOne returns the record an update of the query filter changes, the
+// first one it matches, as it is.
+func (m *DataModelQuery) snapshotOne() *datatype.DataMap {
+	query := m.NewInstance().SkipTenant().SkipBeforeCommit()
+	query.where = m.where
+	query.orderBy = m.orderBy
+	query.orderKeys = m.orderKeys
+	query.search = m.search
+
+	return query.collection().findOne()
+}
+
 func (m *DataModelQuery) Exist(where interface{}) bool {
 	result := m.FindOne(where)
 
@@ -651,6 +782,7 @@ func (m *DataModelQuery) First(where interface{}) *datatype.DataMap {
 func (m *DataModelQuery) FindOne(where interface{}) *datatype.DataMap {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		triggerBefore := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -688,6 +820,7 @@ func (m *DataModelQuery) FindOne(where interface{}) *datatype.DataMap {
 func (m *DataModelQuery) Find(where interface{}) *[]datatype.DataMap {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		triggerBefore := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -727,6 +860,7 @@ func (m *DataModelQuery) Find(where interface{}) *[]datatype.DataMap {
 func (m *DataModelQuery) Paginate(where interface{}) *datatype.DataMap {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		triggerBefore := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -764,6 +898,7 @@ func (m *DataModelQuery) Paginate(where interface{}) *datatype.DataMap {
 func (m *DataModelQuery) Summary(where interface{}) *datatype.DataMap {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -787,6 +922,7 @@ func (m *DataModelQuery) Summary(where interface{}) *datatype.DataMap {
 func (m *DataModelQuery) Count(where interface{}) int64 {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -810,6 +946,7 @@ func (m *DataModelQuery) Count(where interface{}) int64 {
 func (m *DataModelQuery) Sum(target string, where interface{}) float64 {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -833,6 +970,7 @@ func (m *DataModelQuery) Sum(target string, where interface{}) float64 {
 func (m *DataModelQuery) Max(target string, where interface{}) interface{} {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -856,6 +994,7 @@ func (m *DataModelQuery) Max(target string, where interface{}) interface{} {
 func (m *DataModelQuery) Min(target string, where interface{}) interface{} {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -879,6 +1018,7 @@ func (m *DataModelQuery) Min(target string, where interface{}) interface{} {
 func (m *DataModelQuery) Average(target string, where interface{}) float64 {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	if !m.skipBeforeCommit {
 		result := m.runTriggerAction(BeforeFindTriggerAllAction, m.where)
@@ -902,6 +1042,7 @@ func (m *DataModelQuery) Average(target string, where interface{}) float64 {
 func (m *DataModelQuery) Graph(where interface{}, p *graphql.ResolveParams) interface{} {
 	m.WhereAll(where)
 	m.addTenantId()
+	m.addTrashedScope()
 
 	ctx, _ := p.Context.Value(RequestContextKey).(*RequestContext)
 	parent := p.Source
@@ -1147,8 +1288,17 @@ func (m *DataModelQuery) formatInputData(input datatype.DataMap, action InputAct
 
 			formatInput[k] = m.formatInputDataField(k, v)
 		}
+
+		if m.Model.Versioned {
+			formatInput[VersionKey] = 1
+		}
 	case UpdateInputAction:
 		for _, k := range m.Model.ValidFields {
+			// The backends increment the version, the input can't set it
+			if m.Model.Versioned && k == VersionKey {
+				continue
+			}
+
 			if k != "_id" && k != "id" {
 				if v, ok := input[k]; ok {
 					formatInput[k] = m.formatInputDataField(k, v)
@@ -1177,6 +1327,12 @@ func (m *DataModelQuery) formatInputDataField(key string, value interface{}) int
 			v = helper.ToInt(value)
 		} else if helper.Contains(m.Model.FloatFields, key) {
 			v = helper.ToFloat(value)
+		} else if helper.Contains(m.Model.GeoFields, key) {
+			if point, ok := toGeoPoint(value); ok {
+				v = point.geoJSON()
+			} else {
+				v = nil
+			}
 		} else {
 			v = value
 		}
@@ -1224,6 +1380,7 @@ func (m *DataModelQuery) runTriggerAction(action TriggerAction, data interface{}
 	case AfterDeleteTriggerAction, AfterDeleteTriggerAllAction:
 		m.QueryContext.Data = data
 	}
+	m.QueryContext.Tx = m.tx
 
 	var result interface{}
 	var err error
@@ -1284,6 +1441,10 @@ func (m *DataModelQuery) getTenantId() interface{} {
 
 func (m *DataModelQuery) collection() dataModelQueryStructure {
 	ctx := context.TODO()
+	if m.tx != nil {
+		// The Mongo session travels in the context.
+		ctx = m.tx.ctx
+	}
 
 	switch m.Model.DatabaseType {
 	case config.DBTypeMongodb:
@@ -1296,13 +1457,21 @@ func (m *DataModelQuery) collection() dataModelQueryStructure {
 		return &sqlConnection{
 			query:  m,
 			ctx:    &ctx,
-			client: m.Model.DBConnect.sqlClient,
+			client: m.sqlClient(m.Model.DBConnect.sqlClient),
 		}
 	case config.DBTypeMysql:
-		return &mysqlConnection{
-			query:  m,
-			ctx:    &ctx,
-			client: m.Model.DBConnect.mysqlClient,
+		return &sqlDialectConnection{
+			query:   m,
+			ctx:     &ctx,
+			client:  m.sqlClient(m.Model.DBConnect.mysqlClient),
+			dialect: mysqlDialect,
+		}
+	case config.DBTypePostgres:
+		return &sqlDialectConnection{
+			query:   m,
+			ctx:     &ctx,
+			client:  m.sqlClient(m.Model.DBConnect.postgresClient),
+			dialect: postgresDialect,
 		}
 	}
 
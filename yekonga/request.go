@@ -23,6 +23,7 @@ type RequestContext struct {
 	QuerySelectors   map[uint][]string
 	QueryRelatedData datatype.JsonObject
 	QueryWhereData   datatype.JsonObject
+	Loader           *DataLoader
 	mut              sync.RWMutex
 }
 
@@ -93,22 +94,72 @@ func (a *AuthPayload) ToJson() string {
 
 type AuditTrailChanges struct {
 	Action     string
-	DocumentId string
+	DocumentId interface{}
 	Collection string
 	Model      string
 	NewValues  map[string]interface{}
 	OldValues  map[string]interface{}
+	Diff       map[string]AuditTrailDiff
 }
 
+// AuditTrailDiff is the value of a field before and after a write.
+type AuditTrailDiff struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// NewDataAuditTrail starts the audit trail of a request, taking the actor
+// from the token, the client details and the request id. The context may be
+// nil for writes that don't come from a request.
 func NewDataAuditTrail(request *RequestContext) *DataAuditTrails {
-	return &DataAuditTrails{
-		App:       request.App,
-		TenantId:  request.Request.TenantId(),
-		UserId:    request.TokenPayload.UserId,
-		ProfileId: request.TokenPayload.UserId,
-		AdminId:   request.TokenPayload.AdminId,
-		Changes:   make(map[string]AuditTrailChanges),
+	a := &DataAuditTrails{
+		Changes:   make([]AuditTrailChanges, 0, 1),
+		Timestamp: time.Now(),
+	}
+
+	if request == nil {
+		return a
+	}
+
+	a.App = request.App
+
+	if request.Request != nil {
+		a.TenantId = request.Request.TenantId()
+	}
+
+	if payload := request.TokenPayload; payload != nil {
+		a.UserId = payload.UserId
+		a.ProfileId = payload.ProfileId
+		a.UsernameType = payload.UsernameType
+		a.Username = payload.Username
+
+		if helper.IsNotEmpty(payload.TenantId) {
+			a.TenantId = payload.TenantId
+		}
+
+		// An admin acting on behalf of the user
+		if helper.IsNotEmpty(payload.AdminId) && payload.AdminId != payload.UserId {
+			a.AdminId = payload.AdminId
+		}
+	} else if auth := request.Auth; auth != nil {
+		a.UserId = auth.ID
+		a.ProfileId = auth.ProfileID
+		a.Username = auth.Username
+	}
+
+	if client := request.Client; client != nil {
+		a.ClientOrigin = client.Origin
+		a.ClientHost = client.Host
+		a.ClientPort = client.Port
+		a.ClientProto = client.Proto
+		a.ClientPath = client.Path
+		a.ClientMethod = client.Method
+		a.ClientUserAgent = client.UserAgent
+		a.ClientIpAddress = client.IpAddress
+		a.RequestId = client.RequestId
 	}
+
+	return a
 }
 
 type DataAuditTrails struct {
@@ -117,6 +168,7 @@ type DataAuditTrails struct {
 	UserId    string
 	ProfileId string
 	AdminId   string
+	RequestId string
 
 	UsernameType string
 	Username     string
@@ -131,8 +183,11 @@ type DataAuditTrails struct {
 	ClientUserAgent string
 	ClientIpAddress string
 
-	Changes   map[string]AuditTrailChanges
+	Changes   []AuditTrailChanges
 	Timestamp time.Time
+
+	// tx is the transaction of the audited write, the entries are saved in it
+	tx *Tx
 }
 
 func (a *DataAuditTrails) ToMap() map[string]interface{} {
@@ -141,6 +196,7 @@ func (a *DataAuditTrails) ToMap() map[string]interface{} {
 		"userId":          a.UserId,
 		"profileId":       a.ProfileId,
 		"adminId":         a.AdminId,
+		"requestId":       a.RequestId,
 		"usernameType":    a.UsernameType,
 		"username":        a.Username,
 		"clientOrigin":    a.ClientOrigin,
@@ -159,66 +215,70 @@ func (a *DataAuditTrails) ToMap() map[string]interface{} {
 	return result
 }
 
-func (a *DataAuditTrails) Append(model *DataModel, action string, documentId string, newValues map[string]interface{}) {
-	// Implement logging logic here, e.g., save to database or write to a file
-
-	for model, value := range a.Changes {
-		a.App.ModelQuery("AuditTrail").Create(datatype.DataMap{
-			"tenantId":        a.TenantId,
-			"userId":          a.UserId,
-			"profileId":       a.ProfileId,
-			"adminId":         a.AdminId,
-			"usernameType":    a.UsernameType,
-			"username":        a.Username,
-			"model":           model,
-			"action":          value.Action,
-			"documentId":      value.DocumentId,
-			"oldValues":       value.OldValues,
-			"newValues":       value.NewValues,
-			"clientOrigin":    a.ClientOrigin,
-			"clientHost":      a.ClientHost,
-			"clientPort":      a.ClientPort,
-			"clientProto":     a.ClientProto,
-			"clientPath":      a.ClientPath,
-			"clientMethod":    a.ClientMethod,
-			"clientBrowser":   a.ClientBrowser,
-			"clientUserAgent": a.ClientUserAgent,
-			"clientIpAddress": a.ClientIpAddress,
-		})
+// Append records one write of a record, its values before and after and the
+// fields that changed, and saves it as an AuditTrail entry. The protected
+// fields and the fields the model excludes from its audit are left out.
+func (a *DataAuditTrails) Append(model *DataModel, action string, documentId interface{}, oldValues map[string]interface{}, newValues map[string]interface{}) {
+	oldValues = auditValues(model, oldValues)
+	newValues = auditValues(model, newValues)
+
+	change := AuditTrailChanges{
+		Action:     action,
+		DocumentId: documentId,
+		Collection: model.Collection,
+		Model:      model.Name,
+		NewValues:  newValues,
+		OldValues:  oldValues,
+		Diff:       auditDiff(oldValues, newValues),
+	}
+
+	// An update that changed nothing isn't worth an entry
+	if action == string(UpdateInputAction) && len(change.Diff) == 0 {
+		return
 	}
 
+	a.Changes = append(a.Changes, change)
+
+	app := a.App
+	if app == nil {
+		app = model.App
+	}
+
+	query := app.ModelQuery("AuditTrail")
+	if query == nil {
+		return
+	}
+	query.tx = a.tx
+
+	tenantId := a.TenantId
+	if v, ok := newValues[TenantIDKey]; ok && helper.IsNotEmpty(v) {
+		tenantId = v
+	} else if v, ok := oldValues[TenantIDKey]; ok && helper.IsNotEmpty(v) {
+		tenantId = v
+	}
+
+	query.Create(datatype.DataMap{
+		"tenantId":   tenantId,
+		"userId":     a.UserId,
+		"profileId":  a.ProfileId,
+		"adminId":    a.AdminId,
+		"username":   a.Username,
+		"requestId":  a.RequestId,
+		"model":      change.Model,
+		"collection": change.Collection,
+		"action":     change.Action,
+		"documentId": change.DocumentId,
+		"oldValues":  change.OldValues,
+		"newValues":  change.NewValues,
+		"changes":    change.Diff,
+		"ipAddress":  a.ClientIpAddress,
+		"userAgent":  a.ClientUserAgent,
+		"createdAt":  a.Timestamp,
+	})
 }
 
 func (a *DataAuditTrails) Log() {
-	// Implement logging logic here, e.g., save to database or write to a file
 	console.Log("DataAuditTrails", "Audit Trail: %v", a.ToMap())
-
-	for model, value := range a.Changes {
-		a.App.ModelQuery("AuditTrail").Create(datatype.DataMap{
-			"tenantId":        a.TenantId,
-			"userId":          a.UserId,
-			"profileId":       a.ProfileId,
-			"adminId":         a.AdminId,
-			"usernameType":    a.UsernameType,
-			"username":        a.Username,
-			"model":           model,
-			"collection":      value.Collection,
-			"action":          value.Action,
-			"documentId":      value.DocumentId,
-			"oldValues":       value.OldValues,
-			"newValues":       value.NewValues,
-			"clientOrigin":    a.ClientOrigin,
-			"clientHost":      a.ClientHost,
-			"clientPort":      a.ClientPort,
-			"clientProto":     a.ClientProto,
-			"clientPath":      a.ClientPath,
-			"clientMethod":    a.ClientMethod,
-			"clientBrowser":   a.ClientBrowser,
-			"clientUserAgent": a.ClientUserAgent,
-			"clientIpAddress": a.ClientIpAddress,
-			"timestamp":       a.Timestamp,
-		})
-	}
 }
 
 type TokenPayload struct {
@@ -277,6 +337,7 @@ type ClientPayload struct {
 	Method    string      `json:"method"`
 	UserAgent string      `json:"userAgent"`
 	IpAddress string      `json:"ipAddress"`
+	RequestId string      `json:"requestId"`
 }
 
 func (a *ClientPayload) ToMap() map[string]interface{} {
@@ -290,6 +351,7 @@ func (a *ClientPayload) ToMap() map[string]interface{} {
 		"method":    a.Method,
 		"userAgent": a.UserAgent,
 		"ipAddress": a.IpAddress,
+		"requestId": a.RequestId,
 	}
 
 	return result
@@ -0,0 +1,274 @@
+package yekonga
+
+import (
+	"errors"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// RevisionModel keeps the revisions of the records of the models with
+// revisions.
+const RevisionModel = "Revision"
+
+// keepRevisions saves the records of a model with revisions as they were
+// before an update or delete, in the transaction of the write so they are
+// rolled back with it. A revision holds the record at its version, without
+// its protected fields, and the time it was replaced.
+func (m *DataModelQuery) keepRevisions(action string, records []datatype.DataMap) {
+	if !m.Model.Revisions || m.Model.Name == RevisionModel || len(records) == 0 {
+		return
+	}
+
+	actor := NewDataAuditTrail(m.RequestContext)
+
+	for _, record := range records {
+		query := m.Model.App.ModelQuery(RevisionModel)
+		if query == nil {
+			return
+		}
+		query.tx = m.tx
+
+		query.Create(datatype.DataMap{
+			TenantIDKey:  record[TenantIDKey],
+			"userId":     actor.UserId,
+			"model":      m.Model.Name,
+			"collection": m.Model.Collection,
+			"documentId": auditDocumentId(record),
+			"version":    helper.ToInt(record[VersionKey]),
+			"action":     action,
+			"data":       revisionData(m.Model, record),
+		})
+	}
+}
+
+// revisionQuery is a query on the revisions of the model with the request
+// context of the query, so the find triggers of Revision decide what the
+// caller can read.
+func (m *DataModelQuery) revisionQuery() *DataModelQuery {
+	query := m.Model.App.ModelQuery(RevisionModel)
+	if query == nil {
+		return nil
+	}
+
+	if m.RequestContext != nil {
+		query.SetRequestContext(m.RequestContext)
+	}
+
+	return query.Where("model", m.Model.Name)
+}
+
+// canReadRecord tells whether the caller can read the record through the find
+// triggers of its model. Records removed for good can't be read.
+func (m *DataModelQuery) canReadRecord(id interface{}) bool {
+	query := m.Model.Query()
+	if m.RequestContext != nil {
+		query.SetRequestContext(m.RequestContext)
+	}
+
+	return !helper.IsEmpty(query.WithTrashed().FindOne(datatype.DataMap{"id": id}))
+}
+
+// canReadRemovedRecord tells whether the caller could read a record removed
+// for good, from its data in a revision. The find triggers of the model can't
+// run on it, the caller must be in the tenant of the record.
+func (m *DataModelQuery) canReadRemovedRecord(data datatype.DataMap) bool {
+	query := m.Model.Query()
+	if m.RequestContext != nil {
+		query.SetRequestContext(m.RequestContext)
+	}
+	query.skipTenant = m.skipTenant
+	query.addTenantId()
+
+	tenantId, scoped := query.where[TenantIDKey]
+	if !scoped {
+		return true
+	}
+
+	return helper.ToString(tenantId) == helper.ToString(helper.ObjectID(data[TenantIDKey]))
+}
+
+// revisionData is the record of a revision without the protected fields of
+// the model.
+func revisionData(model *DataModel, record interface{}) datatype.DataMap {
+	data, ok := revisionValue(record).(datatype.DataMap)
+	if !ok {
+		return nil
+	}
+
+	for _, k := range model.Protected {
+		delete(data, k)
+	}
+
+	return data
+}
+
+// Revisions lists the revisions of a record, newest first. The caller gets
+// none unless they can read the record.
+func (m *DataModelQuery) Revisions(id interface{}, limit int) []datatype.DataMap {
+	query := m.revisionQuery()
+	if query == nil || helper.IsEmpty(id) || !m.canReadRecord(id) {
+		return []datatype.DataMap{}
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	result := query.
+		Where("documentId", id).
+		OrderBy("createdAt", "desc").
+		Take(limit).
+		Find(nil)
+
+	if result == nil {
+		return []datatype.DataMap{}
+	}
+
+	for i := range *result {
+		(*result)[i]["data"] = revisionData(m.Model, (*result)[i]["data"])
+	}
+
+	return *result
+}
+
+// AsOf returns a record as it was at the time, nil when it didn't exist yet
+// or was deleted by then. The revision replaced first after the time holds
+// the record as it was, without one the record is still as it is now. It is
+// nil as well when the caller can't read the record.
+func (m *DataModelQuery) AsOf(id interface{}, at time.Time) *datatype.DataMap {
+	query := m.revisionQuery()
+	if query == nil || helper.IsEmpty(id) || !m.canReadRecord(id) {
+		return nil
+	}
+
+	var record datatype.DataMap
+
+	revision := query.
+		Where("documentId", id).
+		Where("createdAt", datatype.DataMap{"greaterThan": at}).
+		OrderBy("createdAt", "asc").
+		FindOne(nil)
+
+	if revision != nil {
+		data := revisionData(m.Model, (*revision)["data"])
+		if data == nil {
+			return nil
+		}
+
+		record = data
+	} else {
+		current := m.WithTrashed().FindOne(datatype.DataMap{"_id": id})
+		if current == nil {
+			return nil
+		}
+
+		record = *current
+
+		if deletedAt, ok := revisionTime(record[DeletedAtKey]); ok && !deletedAt.After(at) {
+			return nil
+		}
+	}
+
+	if createdAt, ok := revisionTime(record["createdAt"]); ok && createdAt.After(at) {
+		return nil
+	}
+
+	return &record
+}
+
+// RestoreRevision writes a revision back to its record with Update, or with
+// Create when the record was removed for good. The record moves to a new
+// version and its state before the restore is kept as a revision too. The
+// caller must be able to read the record, or for a removed record be in its
+// tenant, otherwise the revision is not found.
+func (m *DataModelQuery) RestoreRevision(revisionId interface{}) interface{} {
+	query := m.revisionQuery()
+	if query == nil {
+		return errors.New(m.Model.Name + " keeps no revisions")
+	}
+
+	revision := query.FindOne(datatype.DataMap{"_id": revisionId})
+	if revision == nil {
+		return errors.New("revision not found")
+	}
+
+	id := (*revision)["documentId"]
+	data, ok := revisionValue((*revision)["data"]).(datatype.DataMap)
+	if !ok || helper.IsEmpty(data) {
+		return errors.New("revision has no data")
+	}
+
+	delete(data, VersionKey)
+	delete(data, "_id")
+	delete(data, "id")
+
+	exists := m.NewInstance().SkipBeforeCommit().WithTrashed().Count(datatype.DataMap{"_id": id}) > 0
+	if exists && !m.canReadRecord(id) || !exists && !m.canReadRemovedRecord(data) {
+		return errors.New("revision not found")
+	}
+
+	if !exists {
+		data["id"] = id
+
+		return m.Create(data)
+	}
+
+	return m.WithTrashed().Update(data, datatype.DataMap{"_id": id})
+}
+
+// revisionTime reads a date of a record, stored as time, a BSON date or a
+// string once it went through JSON.
+func revisionTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	case bson.DateTime:
+		return v.Time(), true
+	case string:
+		if t := helper.StringToDatetime(v); t != nil {
+			return *t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// revisionValue turns the documents MongoDB decodes into maps and lists, the
+// form the records have on the other databases.
+func revisionValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		result := make(datatype.DataMap, len(v))
+		for _, e := range v {
+			result[e.Key] = revisionValue(e.Value)
+		}
+		return result
+	case bson.M:
+		return revisionValue(map[string]interface{}(v))
+	case bson.A:
+		return revisionValue([]interface{}(v))
+	case datatype.DataMap:
+		return revisionValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		result := make(datatype.DataMap, len(v))
+		for k, vi := range v {
+			result[k] = revisionValue(vi)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, vi := range v {
+			result[i] = revisionValue(vi)
+		}
+		return result
+	}
+
+	return value
+}
@@ -0,0 +1,97 @@
+package yekonga
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+)
+
+// SearchScoreKey holds the relevance of a record found by Search.
+const SearchScoreKey = "_score"
+
+// Search limits the query to the records whose search fields contain words
+// of the text and sorts them by relevance, before any order by fields. The
+// relevance is returned in the _score field. A model without search fields
+// falls back to a fuzzy match on its primary name field, without relevance.
+func (m *DataModelQuery) Search(text string) *DataModelQuery {
+	text = strings.TrimSpace(text)
+	if helper.IsEmpty(text) {
+		return m
+	}
+
+	if len(m.Model.SearchFields) == 0 {
+		if helper.IsNotEmpty(m.Model.PrimaryName) {
+			m.Where(m.Model.PrimaryName, datatype.DataMap{"matchesRegex": text})
+		}
+
+		return m
+	}
+
+	m.search = text
+
+	return m
+}
+
+// rankedOrdering is the ordering with the relevance first while searching
+// and the distance first when filtering near a point.
+func (m *DataModelQuery) rankedOrdering() []queryOrder {
+	order := m.ordering()
+
+	if _, ok := m.nearest(); ok {
+		order = append([]queryOrder{{Field: DistanceKey}}, order...)
+	}
+
+	if helper.IsNotEmpty(m.search) {
+		order = append([]queryOrder{{Field: SearchScoreKey, Descending: true}}, order...)
+	}
+
+	return order
+}
+
+// Search runs the text search on every model with search fields, or on the
+// given models, and returns the best matches across them by relevance. The
+// queries run with the request context, so the find triggers decide what the
+// caller can read.
+func (y *YekongaData) Search(text string, models []string, limit int, context *RequestContext) []datatype.DataMap {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	names := make([]string, 0, len(y.models))
+	for name, model := range y.models {
+		if len(model.SearchFields) == 0 {
+			continue
+		}
+
+		if len(models) > 0 && !helper.Contains(models, name) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]datatype.DataMap, 0, limit)
+	for _, name := range names {
+		query := y.models[name].Query().Search(text).Take(limit)
+		if context != nil {
+			query.SetRequestContext(context)
+		}
+
+		if data := query.Find(nil); data != nil {
+			result = append(result, *data...)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return helper.ToFloat(result[i][SearchScoreKey]) > helper.ToFloat(result[j][SearchScoreKey])
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
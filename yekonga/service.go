@@ -6,9 +6,11 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"time"
 
 	"github.com/kardianos/service"
+	"github.com/robertkonga/yekonga-server-go/helper"
 )
 
 // Global loggers for clarity.
@@ -20,6 +22,9 @@ type ServiceConfig struct {
 	Name        string
 	DisplayName string
 	Description string
+	// App loads the app without serving it, with its migrations registered.
+	// The migrate, backup and restore commands need it.
+	App func() *YekongaData
 }
 type YekongaService struct {
 	runServiceCallback ServiceCallback
@@ -84,6 +89,20 @@ func ServiceSetup(config ServiceConfig, callback ServiceCallback) {
 			}
 			statusLog.Println("Service stopped successfully")
 			return
+		case "migrate":
+			if config.App == nil {
+				statusLog.Fatal("The migrate command needs ServiceConfig.App")
+			}
+
+			applicationMigrate(config.App(), os.Args[2:])
+			return
+		case "backup", "restore":
+			if config.App == nil {
+				statusLog.Fatal("The " + os.Args[1] + " command needs ServiceConfig.App")
+			}
+
+			applicationBackup(config.App(), os.Args[1], os.Args[2:])
+			return
 		}
 	}
 
@@ -166,7 +185,7 @@ func applicationLiveLogs(name string) {
 	}
 
 	fmt.Printf("--- Starting live log stream for %v --- \n", name)
-	fmt.Println("   (Press Ctrl+C to stop the Go program and the command)\n")
+	fmt.Print("   (Press Ctrl+C to stop the Go program and the command)\n\n")
 
 	// 3. Use a bufio.Scanner in a goroutine to read the output line by line
 	// This is crucial for handling the continuous stream from 'journalctl -f'
@@ -228,3 +247,107 @@ func applicationStatus(name string) {
 		log.Printf("Command finished with error: %v", err)
 	}
 }
+
+// applicationMigrate runs migrate up, migrate down [count] and migrate status.
+// Without a subcommand it migrates up.
+func applicationMigrate(app *YekongaData, args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	var err error
+
+	switch command {
+	case "up":
+		err = app.Migrate()
+	case "down":
+		count := 1
+		if len(args) > 1 {
+			if count, err = strconv.Atoi(args[1]); err != nil {
+				err = fmt.Errorf("invalid count %s", args[1])
+			}
+		}
+
+		if err == nil {
+			err = app.MigrateDown(count)
+		}
+	case "status":
+		var states []MigrationState
+		states, err = app.MigrationStatus()
+
+		for _, state := range states {
+			status := "pending"
+			if state.Missing {
+				status = "missing"
+			} else if state.Applied {
+				status = "applied"
+			}
+
+			appliedAt := ""
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%-8s %-19s %s %s\n", status, appliedAt, state.Id, state.Description)
+		}
+	default:
+		err = fmt.Errorf("unknown command migrate %s, use up, down or status", command)
+	}
+
+	if err != nil {
+		statusLog.Printf("Migration failed: %v", err)
+		fmt.Printf("Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if command != "status" {
+		statusLog.Println("Migration " + command + " finished successfully")
+		fmt.Println("Migration " + command + " finished successfully")
+	}
+}
+
+// applicationBackup runs backup [file] [tenantId] and restore <file>
+// [tenantId]. Without a file the backup goes into the backup directory.
+func applicationBackup(app *YekongaData, command string, args []string) {
+	file := ""
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	options := BackupOptions{}
+	if len(args) > 1 {
+		options.TenantId = args[1]
+	}
+
+	var manifest *BackupManifest
+	var err error
+
+	switch command {
+	case "backup":
+		if helper.IsEmpty(file) {
+			file = app.BackupFile(time.Now())
+		}
+
+		manifest, err = app.Backup(file, options)
+	case "restore":
+		if helper.IsEmpty(file) {
+			err = fmt.Errorf("restore needs the backup file")
+		} else {
+			manifest, err = app.Restore(file, options)
+		}
+	}
+
+	if err != nil {
+		statusLog.Printf("The %s failed: %v", command, err)
+		fmt.Printf("The %s failed: %v\n", command, err)
+		os.Exit(1)
+	}
+
+	for _, model := range manifest.Models {
+		fmt.Printf("%-24s %8d %s\n", model.Name, model.Count, model.File)
+	}
+
+	statusLog.Println("The " + command + " of " + file + " finished successfully")
+	fmt.Println("The " + command + " of " + file + " finished successfully")
+}
@@ -66,6 +66,36 @@ func NewSocketServer(y *YekongaData) *SocketServer {
 		// Yekonga.Cloud.runOnMessage(null, "unsubscribe", deviceId);
 	})
 
+	root.On("database-subscribe", func(c *Client, content interface{}) {
+		data := helper.ToMap[interface{}](content)
+		model := helper.GetMapString(data, "model")
+		id := helper.GetMapString(data, "id")
+
+		room, err := c.subscribeDatabase(model, id)
+		if err != nil {
+			root.EmitToClient(c, "database-subscription", datatype.DataMap{
+				"model":   model,
+				"id":      id,
+				"status":  false,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		root.EmitToClient(c, "database-subscription", datatype.DataMap{
+			"model":  model,
+			"id":     id,
+			"room":   room,
+			"status": true,
+		})
+	})
+
+	root.On("database-unsubscribe", func(c *Client, content interface{}) {
+		data := helper.ToMap[interface{}](content)
+
+		c.unsubscribeDatabase(helper.GetMapString(data, "model"), helper.GetMapString(data, "id"))
+	})
+
 	root.On("acknowledge", func(c *Client, id interface{}) {
 		console.Log("acknowledge clientID %s: %s", c.ID, id)
 
@@ -226,7 +256,8 @@ func (s *SocketServer) ServeWS(req *Request, res *Response) {
 	namespace := s.getOrCreateNamespace(nsPath)
 
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: []string{GraphqlTransportWS},
 	}
 	conn, err := upgrader.Upgrade(*w, r, nil)
 	if err != nil {
@@ -247,6 +278,13 @@ func (s *SocketServer) ServeWS(req *Request, res *Response) {
 		Response:  res,
 	}
 
+	// GraphQL clients speak graphql-transport-ws instead of the event
+	// protocol, they are not part of the namespace.
+	if conn.Subprotocol() == GraphqlTransportWS {
+		newGraphqlTransportConnection(s.App, client).serve()
+		return
+	}
+
 	namespace.addClient(client)
 
 	// Send client ID (compatible with previous client code)
@@ -485,7 +523,7 @@ func (c *Client) handleMessage(msg *Message) {
 		var d struct {
 			Room string `json:"room"`
 		}
-		if json.Unmarshal(msg.Data, &d) == nil {
+		if json.Unmarshal(msg.Data, &d) == nil && !isDatabaseRoom(d.Room) {
 			n.JoinRoom(d.Room, c)
 		}
 	case "leave":
@@ -501,7 +539,9 @@ func (c *Client) handleMessage(msg *Message) {
 		}
 		if json.Unmarshal(msg.Data, &d) == nil {
 			for _, room := range d.Rooms {
-				n.JoinRoom(room, c)
+				if !isDatabaseRoom(room) {
+					n.JoinRoom(room, c)
+				}
 			}
 		}
 	case "createChannel":
@@ -511,7 +551,7 @@ func (c *Client) handleMessage(msg *Message) {
 			Event   string          `json:"event"`
 			Payload json.RawMessage `json:"payload"`
 		}
-		if json.Unmarshal(msg.Data, &d) == nil {
+		if json.Unmarshal(msg.Data, &d) == nil && d.Event != DatabaseEvent {
 			n.Broadcast(d.Event, d.Payload, c)
 		}
 	case "toRoom":
@@ -520,7 +560,7 @@ func (c *Client) handleMessage(msg *Message) {
 			Event   string          `json:"event"`
 			Payload json.RawMessage `json:"payload"`
 		}
-		if json.Unmarshal(msg.Data, &d) == nil {
+		if json.Unmarshal(msg.Data, &d) == nil && !isDatabaseRoom(d.Room) && d.Event != DatabaseEvent {
 			n.SendToRoom(d.Room, d.Event, d.Payload, c)
 		}
 	case "toClient":
@@ -533,7 +573,7 @@ func (c *Client) handleMessage(msg *Message) {
 			n.mu.Lock()
 			target, ok := n.Clients[d.ClientID]
 			n.mu.Unlock()
-			if ok {
+			if ok && d.Event != DatabaseEvent {
 				n.EmitToClient(target, d.Event, d.Payload)
 			}
 		}
@@ -0,0 +1,312 @@
+package yekonga
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/bson"
+)
+
+// DatabaseEvent is the socket event sent for writes through DataModelQuery.
+const DatabaseEvent = "database"
+
+// databaseRoomPrefix marks the rooms of database events. Clients join them
+// through `database-subscribe` only, the generic join is refused so the
+// tenant and read checks can not be skipped.
+const databaseRoomPrefix = "database:"
+
+// DatabaseRoom returns the room database events are sent to. The tenant room
+// receives the events of every model the client may read, the model room
+// those of one model and the record room those of a single record.
+func DatabaseRoom(tenantId interface{}, model string, id interface{}) string {
+	room := databaseRoomPrefix + databaseRoomKey(tenantId)
+
+	if helper.IsNotEmpty(model) {
+		room += ":" + model
+
+		if helper.IsNotEmpty(id) {
+			room += ":" + databaseRoomKey(id)
+		}
+	}
+
+	return room
+}
+
+func isDatabaseRoom(room string) bool {
+	return strings.HasPrefix(room, databaseRoomPrefix)
+}
+
+func databaseRoomKey(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case bson.ObjectID:
+		return value.Hex()
+	case *bson.ObjectID:
+		return value.Hex()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// emitDatabaseEvent tells the clients in the rooms of the records about the
+// write. Each record is sent with its id and the changed fields, protected
+// fields are never sent, and only to the clients that can read the record.
+func (m *DataModelQuery) emitDatabaseEvent(action string, records []datatype.DataMap, changes datatype.DataMap) {
+	app := m.Model.App
+	if app.socketServer == nil {
+		return
+	}
+
+	namespace := app.socketServer.Of("/")
+	fields := m.publicFields(changes)
+
+	for _, record := range records {
+		id := helper.GetValueOf(record, "_id")
+		if helper.IsEmpty(id) {
+			id = helper.GetValueOf(record, "id")
+		}
+
+		data := datatype.DataMap{
+			"action": action,
+			"model":  m.Model.Name,
+			"id":     databaseRoomKey(id),
+		}
+		if changes != nil {
+			data["changes"] = fields
+		} else if action != "delete" {
+			data["changes"] = m.publicFields(record)
+		}
+
+		tenantId := m.recordTenantId(record)
+
+		namespace.sendDatabaseEvent(m.Model.Name, id, []string{
+			DatabaseRoom(tenantId, "", nil),
+			DatabaseRoom(tenantId, m.Model.Name, nil),
+			DatabaseRoom(tenantId, m.Model.Name, id),
+		}, data)
+	}
+}
+
+// emitDatabaseImport sends one event per tenant for the records created by
+// an import, the updated records already had their own events.
+func (m *DataModelQuery) emitDatabaseImport(records []datatype.DataMap) {
+	app := m.Model.App
+	if app.socketServer == nil || len(records) == 0 {
+		return
+	}
+
+	ids := make(map[string][]string)
+	for _, record := range records {
+		tenantId := databaseRoomKey(m.recordTenantId(record))
+		ids[tenantId] = append(ids[tenantId], databaseRoomKey(helper.GetValueOf(record, "_id")))
+	}
+
+	namespace := app.socketServer.Of("/")
+	for tenantId, list := range ids {
+		namespace.sendDatabaseEvent(m.Model.Name, nil, []string{
+			DatabaseRoom(tenantId, "", nil),
+			DatabaseRoom(tenantId, m.Model.Name, nil),
+		}, datatype.DataMap{
+			"action": "import",
+			"model":  m.Model.Name,
+			"ids":    list,
+		})
+	}
+}
+
+func (m *DataModelQuery) publicFields(data datatype.DataMap) datatype.DataMap {
+	if data == nil {
+		return nil
+	}
+
+	fields := make(datatype.DataMap, len(data))
+	for k, v := range data {
+		if helper.Contains(m.Model.Protected, k) || k == dataLoaderBatchKey {
+			continue
+		}
+		fields[k] = v
+	}
+
+	return fields
+}
+
+func (m *DataModelQuery) hasTenantRooms() bool {
+	return m.Model.HasTenant && (m.Model.App.Config.HasTenant || m.Model.App.Config.HasTenantCatch)
+}
+
+func (m *DataModelQuery) recordTenantId(record datatype.DataMap) interface{} {
+	if !m.hasTenantRooms() {
+		return nil
+	}
+
+	return helper.GetValueOf(record, TenantIDKey)
+}
+
+// canRead runs the before find triggers of the model for the request
+// without querying. It is false when a trigger rejects the read.
+func (m *DataModelQuery) canRead() bool {
+	m.addTenantId()
+
+	if m.skipBeforeCommit {
+		return true
+	}
+
+	for _, action := range []TriggerAction{BeforeFindTriggerAllAction, BeforeFindTriggerAction} {
+		if v, ok := m.runTriggerAction(action, m.where).(bool); ok && !v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sendDatabaseEvent sends the event once to every client in any of the
+// rooms. The tenant room spans all models, its clients only get the events
+// of models they may read. The event of a record only goes to the clients
+// whose find triggers let them read it, a record removed for good can't be
+// read any more and its event only goes to the room of the record.
+func (n *Namespace) sendDatabaseEvent(model string, id interface{}, rooms []string, data interface{}) {
+	recipients := make(map[string]*Client)
+	tenantClients := make(map[string]*Client)
+	recordClients := make(map[string]bool)
+
+	n.mu.Lock()
+	for i, room := range rooms {
+		for cid := range n.Rooms[room] {
+			if client, exists := n.Clients[cid]; exists {
+				if i == 0 {
+					tenantClients[cid] = client
+				} else {
+					recipients[cid] = client
+				}
+				if i == 2 {
+					recordClients[cid] = true
+				}
+			}
+		}
+	}
+	n.mu.Unlock()
+
+	for cid, client := range tenantClients {
+		if _, ok := recipients[cid]; !ok && client.canRead(model) {
+			recipients[cid] = client
+		}
+	}
+
+	removed := false
+	if helper.IsNotEmpty(id) && len(recipients) > 0 {
+		if query := n.App.ModelQuery(model); query != nil {
+			removed = query.SkipTenant().SkipBeforeCommit().WithTrashed().Count(datatype.DataMap{"_id": id}) == 0
+		}
+	}
+
+	for cid, client := range recipients {
+		if helper.IsNotEmpty(id) {
+			if removed && !recordClients[cid] || !removed && !client.canReadRecord(model, id) {
+				continue
+			}
+		}
+
+		n.EmitToClient(client, DatabaseEvent, data)
+	}
+}
+
+// canRead reports whether the client may read the model. It runs the
+// triggers each time, the token of the connection may change.
+func (c *Client) canRead(model string) bool {
+	if query := c.Namespace.App.ModelQuery(model); query != nil {
+		return query.SetRequest(c.Request, c.Response).canRead()
+	}
+
+	return false
+}
+
+// canReadRecord reports whether the client finds the record with the find
+// triggers of the model, which may scope the read to the owner or a role.
+func (c *Client) canReadRecord(model string, id interface{}) bool {
+	query := c.Namespace.App.ModelQuery(model)
+	if query == nil {
+		return false
+	}
+
+	return query.SetRequest(c.Request, c.Response).WithTrashed().Count(datatype.DataMap{"_id": id}) > 0
+}
+
+// tenantId returns the tenant of the connection, the same one queries of
+// the connection are scoped to.
+func (c *Client) tenantId() interface{} {
+	if payload := c.Request.TokenPayload(); payload != nil && helper.IsNotEmpty(payload.TenantId) {
+		return payload.TenantId
+	}
+
+	return c.Request.TenantId()
+}
+
+// databaseRoom resolves the room of a subscription request. The model may
+// be given in any case or number, "users" and "User" are the same model.
+func (c *Client) databaseRoom(model string, id string) (string, *DataModel, error) {
+	app := c.Namespace.App
+	tenantId := c.tenantId()
+
+	if helper.IsEmpty(model) {
+		if !(app.Config.HasTenant || app.Config.HasTenantCatch) {
+			tenantId = nil
+		}
+
+		return DatabaseRoom(tenantId, "", nil), nil, nil
+	}
+
+	dataModel, ok := app.models[helper.ToCamelCase(helper.Singularize(model))]
+	if !ok {
+		return "", nil, fmt.Errorf("model %q not found", model)
+	}
+
+	if !dataModel.Query().hasTenantRooms() {
+		tenantId = nil
+	}
+
+	return DatabaseRoom(tenantId, dataModel.Name, id), dataModel, nil
+}
+
+// subscribeDatabase joins the client to the database room of its tenant, a
+// model or a record after checking it may read them.
+func (c *Client) subscribeDatabase(model string, id string) (string, error) {
+	app := c.Namespace.App
+
+	if app.Config.AuthorizedOnly && c.Request.Auth() == nil {
+		return "", errors.New("Must be authorized/login")
+	}
+
+	room, dataModel, err := c.databaseRoom(model, id)
+	if err != nil {
+		return "", err
+	}
+
+	if dataModel == nil {
+		if (app.Config.HasTenant || app.Config.HasTenantCatch) && helper.IsEmpty(c.tenantId()) {
+			return "", errors.New("tenant not found for the connection")
+		}
+	} else {
+		if !c.canRead(dataModel.Name) {
+			return "", fmt.Errorf("not allowed to read %s", dataModel.Name)
+		}
+
+		if helper.IsNotEmpty(id) && !dataModel.Query().SetRequest(c.Request, c.Response).Exist(datatype.DataMap{"id": id}) {
+			return "", fmt.Errorf("%s %s not found", dataModel.Name, id)
+		}
+	}
+
+	c.Namespace.JoinRoom(room, c)
+
+	return room, nil
+}
+
+func (c *Client) unsubscribeDatabase(model string, id string) {
+	if room, _, err := c.databaseRoom(model, id); err == nil {
+		c.Namespace.LeaveRoom(room, c)
+	}
+}
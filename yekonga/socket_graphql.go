@@ -0,0 +1,434 @@
+package yekonga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/gqlerrors"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/language/ast"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/language/parser"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/language/source"
+	"github.com/robertkonga/yekonga-server-go/plugins/websocket"
+)
+
+// GraphqlTransportWS is the websocket subprotocol of the graphql-ws library,
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const GraphqlTransportWS = "graphql-transport-ws"
+
+// graphql-transport-ws message types.
+const (
+	gqlConnectionInit = "connection_init"
+	gqlConnectionAck  = "connection_ack"
+	gqlPing           = "ping"
+	gqlPong           = "pong"
+	gqlSubscribe      = "subscribe"
+	gqlNext           = "next"
+	gqlError          = "error"
+	gqlComplete       = "complete"
+)
+
+// graphql-transport-ws close codes.
+const (
+	gqlCloseBadRequest        = 4400
+	gqlCloseUnauthorized      = 4401
+	gqlCloseForbidden         = 4403
+	gqlCloseInitTimeout       = 4408
+	gqlCloseSubscriberExists  = 4409
+	gqlCloseTooManyInitialise = 4429
+)
+
+// gqlConnectionInitTimeout is how long a client has to send connection_init.
+const gqlConnectionInitTimeout = 10 * time.Second
+
+type graphqlTransportMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphqlTransportSubscribe struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlTransportConnection serves one graphql-transport-ws connection.
+// Every operation runs in its own goroutine and is canceled by a complete
+// message from the client or when the connection closes.
+type graphqlTransportConnection struct {
+	app          *YekongaData
+	client       *Client
+	done         chan struct{}
+	closeOnce    sync.Once
+	initialised  bool
+	acknowledged bool
+	operations   map[string]context.CancelFunc
+	mut          sync.Mutex
+}
+
+func newGraphqlTransportConnection(app *YekongaData, client *Client) *graphqlTransportConnection {
+	return &graphqlTransportConnection{
+		app:        app,
+		client:     client,
+		done:       make(chan struct{}),
+		operations: make(map[string]context.CancelFunc),
+	}
+}
+
+func (t *graphqlTransportConnection) serve() {
+	defer t.shutdown()
+
+	go t.writePump()
+
+	initTimer := time.AfterFunc(gqlConnectionInitTimeout, func() {
+		t.mut.Lock()
+		initialised := t.initialised
+		t.mut.Unlock()
+
+		if !initialised {
+			t.close(gqlCloseInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		_, message, err := t.client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg graphqlTransportMessage
+		if err := json.Unmarshal(message, &msg); err != nil || msg.Type == "" {
+			t.close(gqlCloseBadRequest, "Invalid message received")
+			return
+		}
+
+		if !t.handleMessage(&msg) {
+			return
+		}
+	}
+}
+
+// handleMessage returns false when the connection was closed.
+func (t *graphqlTransportConnection) handleMessage(msg *graphqlTransportMessage) bool {
+	switch msg.Type {
+	case gqlConnectionInit:
+		t.mut.Lock()
+		initialised := t.initialised
+		t.initialised = true
+		t.mut.Unlock()
+
+		if initialised {
+			t.close(gqlCloseTooManyInitialise, "Too many initialisation requests")
+			return false
+		}
+
+		if err := t.authenticate(msg.Payload); err != nil {
+			t.close(gqlCloseForbidden, err.Error())
+			return false
+		}
+
+		t.mut.Lock()
+		t.acknowledged = true
+		t.mut.Unlock()
+
+		t.send(graphqlTransportMessage{Type: gqlConnectionAck})
+	case gqlPing:
+		t.send(graphqlTransportMessage{Type: gqlPong, Payload: msg.Payload})
+	case gqlPong:
+	case gqlSubscribe:
+		t.mut.Lock()
+		acknowledged := t.acknowledged
+		_, exists := t.operations[msg.ID]
+		t.mut.Unlock()
+
+		if !acknowledged {
+			t.close(gqlCloseUnauthorized, "Unauthorized")
+			return false
+		}
+		if helper.IsEmpty(msg.ID) {
+			t.close(gqlCloseBadRequest, "Invalid message received")
+			return false
+		}
+		if exists {
+			t.close(gqlCloseSubscriberExists, "Subscriber for "+msg.ID+" already exists")
+			return false
+		}
+
+		var payload graphqlTransportSubscribe
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || helper.IsEmpty(payload.Query) {
+			t.close(gqlCloseBadRequest, "Invalid message received")
+			return false
+		}
+
+		t.subscribe(msg.ID, payload)
+	case gqlComplete:
+		t.mut.Lock()
+		cancel, ok := t.operations[msg.ID]
+		delete(t.operations, msg.ID)
+		t.mut.Unlock()
+
+		if ok {
+			cancel()
+		}
+	default:
+		t.close(gqlCloseBadRequest, "Invalid message received")
+		return false
+	}
+
+	return true
+}
+
+// authenticate applies the access token of the connection_init payload the
+// same way TokenMiddleware does for HTTP requests. Browsers can not set
+// headers on a websocket, the token cookie is used when the payload has none.
+func (t *graphqlTransportConnection) authenticate(raw json.RawMessage) error {
+	req := t.client.Request
+	res := t.client.Response
+
+	payload := map[string]interface{}{}
+	if len(raw) > 0 {
+		json.Unmarshal(raw, &payload)
+	}
+
+	var accessToken string
+	for _, key := range []string{"Authorization", "authorization", "accessToken", "access_token", "token"} {
+		if v, ok := payload[key].(string); ok && helper.IsNotEmpty(v) {
+			accessToken = strings.TrimSpace(strings.TrimPrefix(v, "Bearer"))
+			break
+		}
+	}
+
+	if helper.IsEmpty(accessToken) {
+		return nil
+	}
+
+	isValid, tokenPayloadMap := t.app.DecodeToken(accessToken)
+	if !isValid || helper.IsEmpty(tokenPayloadMap) {
+		return errors.New("Access token invalid")
+	}
+
+	var tokenPayload TokenPayload
+	json.Unmarshal([]byte(helper.ToJson(tokenPayloadMap)), &tokenPayload)
+
+	if tokenPayload.ExpiresAt.Before(helper.GetTimestamp(nil)) {
+		return errors.New("Token expired")
+	}
+
+	if client := req.Client(); client != nil && helper.IsNotEmpty(tokenPayload.Domain) && client.OriginDomain() != tokenPayload.Domain {
+		return errors.New("Domain mismatch")
+	}
+
+	if t.app.Config.TenantOnly && helper.IsEmpty(tokenPayload.TenantId) {
+		return errors.New("tenant not found for the request")
+	}
+
+	req.SetContext(string(AccessTokenKey), accessToken)
+	req.SetContext(string(TokenPayloadKey), tokenPayload)
+
+	if helper.IsNotEmpty(tokenPayload.TenantId) && helper.IsEmpty(req.TenantId()) {
+		req.SetTenantId(tokenPayload.TenantId)
+	}
+
+	UserInfoMiddleware(req, res)
+
+	return nil
+}
+
+func (t *graphqlTransportConnection) subscribe(id string, payload graphqlTransportSubscribe) {
+	req := t.client.Request
+	schema := t.app.graphqlBuild.Schema
+
+	if !t.app.Config.ApiPlaygroundEnable && isIntrospectionQuery(payload.Query) {
+		t.sendErrors(id, []gqlerrors.FormattedError{{Message: "Introspection is disabled"}})
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(payload.Query),
+			Name: "GraphQL request",
+		}),
+	})
+	if err != nil {
+		t.sendErrors(id, gqlerrors.FormatErrors(err))
+		return
+	}
+
+	validation := graphql.ValidateDocument(&schema, document, nil)
+	if !validation.IsValid {
+		t.sendErrors(id, formatErrors(validation.Errors))
+		return
+	}
+
+	operation := operationType(document, payload.OperationName)
+	if err := t.billingError(operation); err != nil {
+		t.sendErrors(id, []gqlerrors.FormattedError{{Message: err.Error()}})
+		return
+	}
+
+	requestContext := &RequestContext{
+		Auth:         req.Auth(),
+		App:          t.app,
+		Request:      req,
+		Response:     t.client.Response,
+		TokenPayload: req.TokenPayload(),
+		Client:       req.Client(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, RequestContextKey, requestContext)
+	ctx = context.WithValue(ctx, SocketClientKey, t.client)
+
+	t.mut.Lock()
+	t.operations[id] = cancel
+	t.mut.Unlock()
+
+	params := graphql.ExecuteParams{
+		Schema:        schema,
+		Root:          make(map[string]interface{}),
+		AST:           document,
+		OperationName: payload.OperationName,
+		Args:          payload.Variables,
+		Context:       ctx,
+	}
+
+	go func() {
+		defer func() {
+			t.mut.Lock()
+			_, active := t.operations[id]
+			delete(t.operations, id)
+			t.mut.Unlock()
+
+			cancel()
+
+			// The client does not expect complete for an operation it
+			// completed itself.
+			if active {
+				t.send(graphqlTransportMessage{ID: id, Type: gqlComplete})
+			}
+		}()
+
+		if operation != ast.OperationTypeSubscription {
+			t.next(id, graphql.Execute(&params))
+			return
+		}
+
+		for result := range graphql.ExecuteSubscription(params) {
+			if ctx.Err() != nil {
+				continue
+			}
+
+			t.next(id, result)
+		}
+	}()
+}
+
+// billingError applies the rules of BillingMiddleware to an operation, the
+// websocket upgrade itself is not billed.
+func (t *graphqlTransportConnection) billingError(operation string) error {
+	req := t.client.Request
+	billing := t.app.Billing()
+	tenantId := req.TenantId()
+
+	if billing == nil || !billing.Enabled() || helper.IsEmpty(tenantId) {
+		return nil
+	}
+
+	var moduleName string
+	if payload := req.TokenPayload(); payload != nil {
+		moduleName = payload.ModuleName
+	}
+
+	switch billing.ModuleStatus(tenantId, moduleName) {
+	case SubscriptionExpired, SubscriptionCanceled:
+		return errors.New("Subscription is not active")
+	case SubscriptionPastDue:
+		if operation == ast.OperationTypeMutation {
+			return errors.New("Subscription is past due, only read access is allowed")
+		}
+	}
+
+	return nil
+}
+
+func (t *graphqlTransportConnection) next(id string, result *graphql.Result) {
+	if len(result.Errors) > 0 {
+		result.Errors = formatErrors(result.Errors)
+	}
+
+	t.send(graphqlTransportMessage{ID: id, Type: gqlNext, Payload: helper.ToByte(result)})
+}
+
+func (t *graphqlTransportConnection) sendErrors(id string, errs []gqlerrors.FormattedError) {
+	t.send(graphqlTransportMessage{ID: id, Type: gqlError, Payload: helper.ToByte(errs)})
+}
+
+func (t *graphqlTransportConnection) send(msg graphqlTransportMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	select {
+	case t.client.send <- data:
+	case <-t.done:
+	}
+}
+
+func (t *graphqlTransportConnection) writePump() {
+	for {
+		select {
+		case msg := <-t.client.send:
+			if err := t.client.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				t.client.conn.Close()
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *graphqlTransportConnection) close(code int, reason string) {
+	t.client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	t.client.conn.Close()
+}
+
+// shutdown cancels the running operations once the connection is gone.
+func (t *graphqlTransportConnection) shutdown() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.client.conn.Close()
+
+		t.mut.Lock()
+		for id, cancel := range t.operations {
+			cancel()
+			delete(t.operations, id)
+		}
+		t.mut.Unlock()
+	})
+}
+
+// operationType returns the type of the operation that will be executed for
+// the document.
+func operationType(document *ast.Document, operationName string) string {
+	for _, definition := range document.Definitions {
+		op, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if helper.IsEmpty(operationName) || (op.Name != nil && op.Name.Value == operationName) {
+			return op.Operation
+		}
+	}
+
+	return ast.OperationTypeQuery
+}
@@ -0,0 +1,218 @@
+package yekonga
+
+import (
+	"errors"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+)
+
+const (
+	DeletedAtKey = "deletedAt"
+	DeletedByKey = "deletedBy"
+)
+
+type trashedScope int
+
+const (
+	withoutTrashed trashedScope = iota
+	withTrashed
+	onlyTrashed
+)
+
+// WithTrashed includes the soft deleted records in the query.
+func (m *DataModelQuery) WithTrashed() *DataModelQuery {
+	m.trashed = withTrashed
+
+	return m
+}
+
+// OnlyTrashed limits the query to the soft deleted records.
+func (m *DataModelQuery) OnlyTrashed() *DataModelQuery {
+	m.trashed = onlyTrashed
+
+	return m
+}
+
+// Restore clears deletedAt and deletedBy of the soft deleted records the
+// filter matches and returns them.
+func (m *DataModelQuery) Restore(where interface{}) interface{} {
+	if !m.Model.SoftDelete {
+		return errors.New(m.Model.Name + " does not use soft delete")
+	}
+
+	m.OnlyTrashed()
+	m.WhereAll(where)
+	m.addTenantId()
+	m.addTrashedScope()
+
+	if !m.hasFilter() {
+		return errors.New("filter is empty, not allowed to restore all records at once")
+	}
+
+	changes := datatype.DataMap{
+		DeletedAtKey: nil,
+		DeletedByKey: nil,
+	}
+
+	if !m.skipBeforeCommit {
+		triggerBefore := m.runTriggerAction(BeforeUpdateTriggerAllAction, changes)
+		if v, ok := triggerBefore.(bool); ok && !v {
+			return nil
+		}
+
+		triggerBefore = m.runTriggerAction(BeforeUpdateTriggerAction, changes)
+		if v, ok := triggerBefore.(bool); ok && !v {
+			return nil
+		}
+	}
+
+	trashed := m.snapshot()
+	if len(trashed) == 0 {
+		return &[]datatype.DataMap{}
+	}
+
+	if _, err := m.collection().updateMany(changes); err != nil {
+		return err
+	}
+
+	// The filter no longer matches once deletedAt is cleared.
+	ids := helper.GetList(&trashed, "_id")
+	result := m.NewInstance().SkipTenant().SkipBeforeCommit().WithTrashed().Find(datatype.DataMap{
+		"_id": datatype.DataMap{"in": ids},
+	})
+
+	if result != nil && len(*result) > 0 {
+		records := *result
+		m.audit("restore", trashed, records)
+		m.keepRevisions("restore", trashed)
+		m.afterCommit(func() {
+			m.emitDatabaseEvent("update", records, changes)
+
+			if g := m.Model.App.graphqlBuild; g != nil {
+				g.publish(m.Model.Name, SubscriptionUpdated, records...)
+			}
+		})
+	}
+
+	return result
+}
+
+// ForceDelete removes the records the filter matches from the database,
+// soft deleted or not.
+func (m *DataModelQuery) ForceDelete(where interface{}) interface{} {
+	m.forceDelete = true
+
+	if m.trashed == withoutTrashed {
+		m.WithTrashed()
+	}
+
+	return m.Delete(where)
+}
+
+// addTrashedScope hides the soft deleted records unless the query asks for
+// them or filters on deletedAt itself.
+func (m *DataModelQuery) addTrashedScope() {
+	if !m.Model.SoftDelete || m.trashed == withTrashed {
+		return
+	}
+
+	if _, ok := m.where[DeletedAtKey]; ok {
+		return
+	}
+
+	if m.trashed == onlyTrashed {
+		m.Where(DeletedAtKey, datatype.DataMap{"notEqualTo": nil})
+	} else {
+		m.Where(DeletedAtKey, nil)
+	}
+}
+
+// hasFilter reports whether the where holds a condition besides the tenant
+// and the trashed scope the query adds itself.
+func (m *DataModelQuery) hasFilter() bool {
+	for k := range m.where {
+		if k != DeletedAtKey && k != TenantIDKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// softDelete stamps the matched records instead of removing them. count is
+// the number of records the filter matched before the write.
+func (m *DataModelQuery) softDelete(count int) (interface{}, error) {
+	if !m.hasFilter() {
+		return nil, errors.New("filter is empty, not allowed to delete all records at once")
+	}
+
+	if count > 0 {
+		_, err := m.collection().updateMany(datatype.DataMap{
+			DeletedAtKey: helper.GetTimestamp(nil),
+			DeletedByKey: m.deletedBy(),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return datatype.DataMap{"DeletedCount": int64(count)}, nil
+}
+
+func (m *DataModelQuery) deletedBy() interface{} {
+	if m.RequestContext == nil {
+		return nil
+	}
+
+	if auth := m.RequestContext.Auth; auth != nil && helper.IsNotEmpty(auth.ID) {
+		return helper.ObjectID(auth.ID)
+	}
+
+	if payload := m.RequestContext.TokenPayload; payload != nil && helper.IsNotEmpty(payload.UserId) {
+		return helper.ObjectID(payload.UserId)
+	}
+
+	return nil
+}
+
+func (y *YekongaData) setSoftDelete() {
+	hasRetention := false
+
+	for _, model := range y.models {
+		if model.SoftDelete && model.RetentionDays > 0 {
+			hasRetention = true
+			break
+		}
+	}
+
+	if !hasRetention {
+		return
+	}
+
+	y.RegisterCronjob("SoftDeletePurge", time.Hour, func(app *YekongaData, t time.Time) {
+		app.PurgeTrashed(t)
+	})
+}
+
+// PurgeTrashed removes the records that were soft deleted longer ago than
+// the retention days of their model. It is called by the purge cronjob.
+func (y *YekongaData) PurgeTrashed(t time.Time) {
+	for _, model := range y.models {
+		if !model.SoftDelete || model.RetentionDays <= 0 {
+			continue
+		}
+
+		cutoff := t.AddDate(0, 0, -model.RetentionDays)
+		result := model.Query().SkipTenant().SkipBeforeCommit().OnlyTrashed().ForceDelete(datatype.DataMap{
+			DeletedAtKey: datatype.DataMap{"lessThan": cutoff},
+		})
+
+		if err, ok := result.(error); ok {
+			console.Error("PurgeTrashed", model.Name, err.Error())
+		}
+	}
+}
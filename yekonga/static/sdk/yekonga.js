@@ -177,6 +177,7 @@ class YekongaCloudFunction {
                 console.debug(`${$this._socketId} connect`);
 
                 $this.socket.emit('subscribe', $this._socketId);
+                $this.socket.emit('database-subscribe', {});
                 $this._refreshListeners($this);
             })
 
@@ -191,10 +192,10 @@ class YekongaCloudFunction {
                 }
             })
 
-            this.socket.on('database', ({ action, model, id }) => {
-                console.log(`Database ${action} on model ${model} with id ${id}`);
+            this.socket.on('database', ({ action, model, id, ids }) => {
+                console.log(`Database ${action} on model ${model} with id ${id || ids}`);
 
-                $this._refreshListeners($this, model, action, id);
+                $this._refreshListeners($this, model, action, id || ids);
             });
         }
 
@@ -0,0 +1,63 @@
+package yekonga
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/jwt"
+)
+
+// setTokenKeys loads the access token signing keys. HS256 with the secret
+// token stays the default, the asymmetric algorithms keep their keys in
+// JwtKeysPath so other services can verify tokens through the JWKS route.
+// Keys that fail to load are an error, the algorithm is never downgraded.
+func (y *YekongaData) setTokenKeys() error {
+	auth := y.Config.Authentication
+
+	keysPath := auth.JwtKeysPath
+	if helper.IsEmpty(keysPath) {
+		keysPath = filepath.Join(y.HomeDirectory(), "keys")
+	}
+
+	keyRotation := auth.JwtKeyRotation
+	if keyRotation <= 0 {
+		keyRotation = 30 // default 30 days
+	}
+
+	accessTokenExpireTime := y.Config.AccessTokenExpireTime
+	if accessTokenExpireTime <= 0 {
+		accessTokenExpireTime = 15 // default 15 minutes
+	}
+
+	keys, err := jwt.NewKeySet(auth.JwtAlgorithm, auth.SecretToken, keysPath, time.Hour*24*keyRotation, time.Minute*accessTokenExpireTime)
+	if err != nil {
+		return err
+	}
+
+	y.tokenKeys = keys
+
+	return nil
+}
+
+// TokenKeys returns the key set access tokens are signed and verified with.
+func (y *YekongaData) TokenKeys() *jwt.KeySet {
+	return y.tokenKeys
+}
+
+// EncodeToken signs the token payload. The `exp` claim follows the payload
+// expiry so it matches AccessTokenExpireTime.
+func (y *YekongaData) EncodeToken(payload TokenPayload) (string, error) {
+	claims := payload.ToMap()
+
+	if !payload.ExpiresAt.IsZero() {
+		claims["exp"] = payload.ExpiresAt.Unix()
+	}
+
+	return y.tokenKeys.Encode(claims)
+}
+
+// DecodeToken verifies an access token with the key named by its `kid`.
+func (y *YekongaData) DecodeToken(token string) (bool, map[string]interface{}) {
+	return y.tokenKeys.Decode(token)
+}
@@ -0,0 +1,215 @@
+package yekonga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/robertkonga/yekonga-server-go/config"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+	"github.com/robertkonga/yekonga-server-go/plugins/mongo-driver/mongo"
+)
+
+// sqlExecutor is implemented by *sql.DB and *sql.Tx, the SQL backends run
+// their statements on either.
+type sqlExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tx groups the writes of several models. Queries created with
+// Tx.ModelQuery run in a Mongo session transaction or an *sql.Tx depending
+// on the database. The local database has no transactions, its writes are
+// applied at once and undone in reverse order on rollback.
+//
+// A Tx must not be used from more than one goroutine.
+type Tx struct {
+	app    *YekongaData
+	ctx    context.Context
+	sqlTx  *sql.Tx
+	undo   []func() error
+	events []func()
+	mut    sync.Mutex
+}
+
+// Transaction runs fn in a transaction. It is committed when fn returns nil
+// and rolled back when it returns an error or panics. Socket events and
+// GraphQL subscriptions of the writes are only sent after the commit.
+//
+//	err := app.Transaction(ctx, func(tx *yekonga.Tx) error {
+//		invoice := tx.ModelQuery("Invoice").Create(data)
+//		if err, ok := invoice.(error); ok {
+//			return err
+//		}
+//		...
+//	})
+func (y *YekongaData) Transaction(ctx context.Context, fn func(tx *Tx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	tx := &Tx{app: y, ctx: ctx}
+	dc := y.dbConnect
+
+	var err error
+
+	switch dc.config.Database.Kind {
+	case config.DBTypeMongodb:
+		err = tx.runMongodb(dc.mongodbClient, fn)
+	case config.DBTypeMysql:
+		err = tx.runSql(dc.mysqlClient, fn)
+	case config.DBTypePostgres:
+		err = tx.runSql(dc.postgresClient, fn)
+	case config.DBTypeSql:
+		err = tx.runSql(dc.sqlClient, fn)
+	default:
+		err = tx.runLocal(fn)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, event := range tx.events {
+		event()
+	}
+
+	return nil
+}
+
+// ModelQuery returns a query of the model that runs inside the transaction.
+func (tx *Tx) ModelQuery(name string) *DataModelQuery {
+	query := tx.app.ModelQuery(name)
+	if query != nil {
+		query.tx = tx
+	}
+
+	return query
+}
+
+// Context returns the context the statements of the transaction run with.
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// afterCommit queues fn until the transaction is committed.
+func (tx *Tx) afterCommit(fn func()) {
+	tx.mut.Lock()
+	defer tx.mut.Unlock()
+
+	tx.events = append(tx.events, fn)
+}
+
+// addUndo records how to revert a write on the local database.
+func (tx *Tx) addUndo(fn func() error) {
+	tx.mut.Lock()
+	defer tx.mut.Unlock()
+
+	tx.undo = append(tx.undo, fn)
+}
+
+// reset clears what an attempt left behind before the callback is retried.
+func (tx *Tx) reset() {
+	tx.mut.Lock()
+	defer tx.mut.Unlock()
+
+	tx.undo = nil
+	tx.events = nil
+}
+
+func (tx *Tx) call(fn func(tx *Tx) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New("transaction panicked")
+			}
+			logger.Error("Transaction", r)
+		}
+	}()
+
+	return fn(tx)
+}
+
+// runMongodb uses WithTransaction so the callback is retried on transient
+// transaction errors. Mongo transactions need a replica set or mongos.
+func (tx *Tx) runMongodb(client *mongo.Client, fn func(tx *Tx) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	parent := tx.ctx
+
+	_, err = session.WithTransaction(parent, func(ctx context.Context) (interface{}, error) {
+		tx.reset()
+		tx.ctx = ctx
+
+		return nil, tx.call(fn)
+	})
+
+	tx.ctx = parent
+
+	return err
+}
+
+func (tx *Tx) runSql(client *sql.DB, fn func(tx *Tx) error) error {
+	sqlTx, err := client.BeginTx(tx.ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx.sqlTx = sqlTx
+
+	if err := tx.call(fn); err != nil {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			logger.Error("Transaction rollback", rollbackErr.Error())
+		}
+
+		return err
+	}
+
+	return sqlTx.Commit()
+}
+
+func (tx *Tx) runLocal(fn func(tx *Tx) error) error {
+	err := tx.call(fn)
+	if err == nil {
+		return nil
+	}
+
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if undoErr := tx.undo[i](); undoErr != nil {
+			logger.Error("Transaction undo", undoErr.Error())
+		}
+	}
+
+	return err
+}
+
+// afterCommit runs fn once the transaction of the query is committed, right
+// away for queries outside a transaction.
+func (m *DataModelQuery) afterCommit(fn func()) {
+	if m.tx != nil {
+		m.tx.afterCommit(fn)
+		return
+	}
+
+	fn()
+}
+
+// sqlClient returns the statement executor of the query, the *sql.Tx of its
+// transaction or the shared pool.
+func (m *DataModelQuery) sqlClient(client *sql.DB) sqlExecutor {
+	if m.tx != nil && m.tx.sqlTx != nil {
+		return m.tx.sqlTx
+	}
+
+	return client
+}
@@ -0,0 +1,105 @@
+package yekonga
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/logger"
+)
+
+// ttlReapBatch is the number of expired records the expiry cronjob deletes
+// at a time.
+const ttlReapBatch = 500
+
+// DataModelTTL is the ttl option of a model, the records expire the
+// duration after the date in the field. A duration alone expires the records
+// after their createdAt, "ttl": {"field": "expiresAt"} expires them at the
+// date in the field.
+type DataModelTTL struct {
+	Field string
+	After time.Duration
+}
+
+func getDataModelTTL(value interface{}) DataModelTTL {
+	ttl := DataModelTTL{Field: "createdAt"}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		ttl.Field = ""
+		if field, ok := v["field"].(string); ok {
+			ttl.Field = strings.TrimSpace(field)
+		}
+
+		if after, ok := v["after"]; ok {
+			ttl.After = getTTLDuration(after)
+		}
+	default:
+		ttl.After = getTTLDuration(v)
+	}
+
+	return ttl
+}
+
+// getTTLDuration reads a duration given in seconds or as a string like
+// "90s", "15m", "24h" or "30d".
+func getTTLDuration(value interface{}) time.Duration {
+	str, ok := value.(string)
+	if !ok {
+		return time.Duration(helper.ToInt(value)) * time.Second
+	}
+
+	str = strings.TrimSpace(str)
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil {
+			return time.Duration(n * float64(24*time.Hour))
+		}
+	}
+
+	if d, err := time.ParseDuration(str); err == nil {
+		return d
+	}
+
+	return time.Duration(helper.ToInt(str)) * time.Second
+}
+
+// ttlIndex returns the expiring index of the ttl option. The declared plain
+// index on the field expires the records when there is one, otherwise a new
+// index is added on the field.
+func (m *DataModel) ttlIndex(indexes []DataModelIndex) ([]DataModelIndex, bool) {
+	field, ok := m.Fields[m.TTL.Field]
+	if !ok || field.Kind != DataModelDate {
+		logger.Warn("TTL on "+m.Name+" skipped, it needs a date field", m.TTL.Field)
+		return indexes, false
+	}
+
+	if m.TTL.After < 0 {
+		logger.Warn("TTL on "+m.Name+" skipped, the duration is negative", m.TTL.After.String())
+		return indexes, false
+	}
+
+	seconds := int(m.TTL.After / time.Second)
+
+	for i, index := range indexes {
+		if len(index.Fields) != 1 || index.Fields[0].Name != m.TTL.Field || index.Text || index.Geo {
+			continue
+		}
+
+		if index.Expires && index.TTL != seconds {
+			logger.Warn("TTL on "+m.Name+" overrides expireAfterSeconds", index.Name)
+		}
+
+		indexes[i].Expires = true
+		indexes[i].TTL = seconds
+
+		return indexes, true
+	}
+
+	return append(indexes, DataModelIndex{
+		Name:    "idx_" + m.Collection + "_" + m.TTL.Field + "_ttl",
+		Fields:  []DataModelIndexField{{Name: m.TTL.Field}},
+		Expires: true,
+		TTL:     seconds,
+	}), true
+}
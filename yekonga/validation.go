@@ -0,0 +1,534 @@
+package yekonga
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+	"github.com/robertkonga/yekonga-server-go/helper/console"
+	"github.com/robertkonga/yekonga-server-go/plugins/graphql/gqlerrors"
+	"github.com/robertkonga/yekonga-server-go/plugins/uuid"
+)
+
+type ValidationCode string
+
+const (
+	ValidationRequired  ValidationCode = "REQUIRED"
+	ValidationMin       ValidationCode = "MIN"
+	ValidationMax       ValidationCode = "MAX"
+	ValidationMinLength ValidationCode = "MIN_LENGTH"
+	ValidationMaxLength ValidationCode = "MAX_LENGTH"
+	ValidationPattern   ValidationCode = "PATTERN"
+	ValidationFormat    ValidationCode = "FORMAT"
+	ValidationUnique    ValidationCode = "UNIQUE"
+	ValidationOptions   ValidationCode = "OPTIONS"
+	ValidationRule      ValidationCode = "RULE"
+)
+
+// validationFormats are the values of the "format" field attribute.
+var validationFormats = map[string]func(value string) bool{
+	"email": func(value string) bool {
+		return helper.IsEmail(value)
+	},
+	"phone": func(value string) bool {
+		return helper.IsPhone(value)
+	},
+	"url": func(value string) bool {
+		u, err := url.ParseRequestURI(value)
+		return err == nil && helper.IsNotEmpty(u.Scheme) && helper.IsNotEmpty(u.Host)
+	},
+	"uuid": func(value string) bool {
+		_, err := uuid.FromString(value)
+		return err == nil
+	},
+}
+
+// validationRuleOperators are the keys of a cross-field rule. The value of
+// the key is the name of the other field.
+var validationRuleOperators = []string{
+	"equalTo",
+	"notEqualTo",
+	"lessThan",
+	"lessThanOrEqualTo",
+	"greaterThan",
+	"greaterThanOrEqualTo",
+	"requiredWith",
+	"requiredWithout",
+}
+
+type DataModelFieldValidation struct {
+	Min       *float64
+	Max       *float64
+	MinLength *int
+	MaxLength *int
+	Pattern   *regexp.Regexp
+	Format    string
+	Unique    bool
+	Strict    bool
+}
+
+// DataModelRule compares Field to Other with Operator, e.g.
+// {"field": "endDate", "greaterThan": "startDate"} in the model options.
+type DataModelRule struct {
+	Field    string
+	Operator string
+	Other    string
+	Message  string
+}
+
+// FieldError is a failed validation of one input field. Path leads from the
+// mutation input to the field, with the row index first for imports.
+type FieldError struct {
+	Path    []interface{}  `json:"path"`
+	Field   string         `json:"field"`
+	Code    ValidationCode `json:"code"`
+	Message string         `json:"message"`
+}
+
+// ValidationError is returned by Create, Update and Import when the input
+// breaks the validation rules of the model.
+type ValidationError struct {
+	Model  string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		messages = append(messages, fe.Message)
+	}
+
+	return "Validation failed: " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":   "VALIDATION_FAILED",
+		"model":  e.Model,
+		"errors": e.Errors,
+	}
+}
+
+func getDataModelFieldValidation(name string, field map[string]interface{}) DataModelFieldValidation {
+	var validation DataModelFieldValidation
+
+	if v, ok := field["min"]; ok && helper.IsNumeric(v) {
+		min := helper.ToFloat(v)
+		validation.Min = &min
+	}
+
+	if v, ok := field["max"]; ok && helper.IsNumeric(v) {
+		max := helper.ToFloat(v)
+		validation.Max = &max
+	}
+
+	if v, ok := field["minLength"]; ok && helper.IsNumeric(v) {
+		minLength := helper.ToInt(v)
+		validation.MinLength = &minLength
+	}
+
+	if v, ok := field["maxLength"]; ok && helper.IsNumeric(v) {
+		maxLength := helper.ToInt(v)
+		validation.MaxLength = &maxLength
+	}
+
+	if v, ok := field["pattern"].(string); ok && helper.IsNotEmpty(v) {
+		pattern, err := regexp.Compile(v)
+		if err != nil {
+			console.Error("Invalid pattern of "+name, err.Error())
+		} else {
+			validation.Pattern = pattern
+		}
+	}
+
+	if v, ok := field["format"].(string); ok && helper.IsNotEmpty(v) {
+		format := strings.ToLower(strings.TrimSpace(v))
+		if _, ok := validationFormats[format]; ok {
+			validation.Format = format
+		} else {
+			console.Error("Unknown format of "+name, v)
+		}
+	}
+
+	if v, ok := field["unique"].(bool); ok {
+		validation.Unique = v
+	}
+
+	if v, ok := field["strict"].(bool); ok {
+		validation.Strict = v
+	}
+
+	return validation
+}
+
+func getDataModelRules(value interface{}) []DataModelRule {
+	rules := make([]DataModelRule, 0)
+
+	for _, item := range helper.ToDataMapList(value) {
+		field := helper.GetValueOfString(item, "field")
+		if helper.IsEmpty(field) {
+			continue
+		}
+
+		for _, op := range validationRuleOperators {
+			if other, ok := item[op].(string); ok && helper.IsNotEmpty(other) {
+				rules = append(rules, DataModelRule{
+					Field:    field,
+					Operator: op,
+					Other:    other,
+					Message:  helper.GetValueOfString(item, "message"),
+				})
+			}
+		}
+	}
+
+	return rules
+}
+
+// validate checks the formatted input of a write against the field rules and
+// the cross-field rules of the model. current is the stored record an update
+// applies to, nil for a new record. path is put in front of the field names.
+func (m *DataModelQuery) validate(input datatype.DataMap, current datatype.DataMap, path ...interface{}) []FieldError {
+	errs := make([]FieldError, 0)
+	fieldError := func(field string, code ValidationCode, message string) {
+		errs = append(errs, FieldError{
+			Path:    append(append([]interface{}{}, path...), field),
+			Field:   field,
+			Code:    code,
+			Message: message,
+		})
+	}
+
+	for _, k := range m.Model.ValidFields {
+		if k == "id" || k == "_id" {
+			continue
+		}
+
+		value, ok := input[k]
+		if !ok && current != nil {
+			continue
+		}
+
+		field := m.Model.Fields[k]
+
+		if validationIsBlank(value) {
+			if field.Required {
+				fieldError(k, ValidationRequired, k+" is required")
+			}
+			continue
+		}
+
+		if code, message := field.validateValue(value); helper.IsNotEmpty(code) {
+			fieldError(k, code, message)
+			continue
+		}
+
+		if field.Validation.Unique && !m.isUniqueValue(k, value, current) {
+			fieldError(k, ValidationUnique, k+" is already taken")
+		}
+	}
+
+	record := input
+	if current != nil {
+		record = datatype.DataMap{}
+		for k, v := range current {
+			record[k] = v
+		}
+		for k, v := range input {
+			record[k] = v
+		}
+	}
+
+	for _, rule := range m.Model.Rules {
+		if current != nil {
+			_, hasField := input[rule.Field]
+			_, hasOther := input[rule.Other]
+			if !hasField && !hasOther {
+				continue
+			}
+		}
+
+		if !rule.check(record) {
+			message := rule.Message
+			if helper.IsEmpty(message) {
+				operator := strings.ReplaceAll(helper.ToUnderscore(rule.Operator), "_", " ")
+				message = fmt.Sprintf("%s must be %s %s", rule.Field, operator, rule.Other)
+			}
+
+			fieldError(rule.Field, ValidationRule, message)
+		}
+	}
+
+	return errs
+}
+
+func (f DataModelField) validateValue(value interface{}) (ValidationCode, string) {
+	validation := f.Validation
+
+	if validation.Strict && len(f.Options) > 0 {
+		values := []interface{}{value}
+		if helper.IsArray(value) {
+			values = helper.ToList[interface{}](value)
+		}
+
+		for _, v := range values {
+			found := false
+			for _, option := range f.Options {
+				if option.Value == helper.ToString(v) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return ValidationOptions, fmt.Sprintf("%s must be one of the options, got %v", f.Name, v)
+			}
+		}
+	}
+
+	if f.Kind == DataModelNumber || f.Kind == DataModelFloat {
+		number := helper.ToFloat(value)
+
+		if validation.Min != nil && number < *validation.Min {
+			return ValidationMin, fmt.Sprintf("%s must be at least %v", f.Name, *validation.Min)
+		}
+		if validation.Max != nil && number > *validation.Max {
+			return ValidationMax, fmt.Sprintf("%s must be at most %v", f.Name, *validation.Max)
+		}
+	}
+
+	length := -1
+	if v, ok := value.(string); ok {
+		length = utf8.RuneCountInString(v)
+	} else if helper.IsArray(value) {
+		length = len(helper.ToList[interface{}](value))
+	}
+
+	if length >= 0 {
+		if validation.MinLength != nil && length < *validation.MinLength {
+			return ValidationMinLength, fmt.Sprintf("%s must have at least %d characters", f.Name, *validation.MinLength)
+		}
+		if validation.MaxLength != nil && length > *validation.MaxLength {
+			return ValidationMaxLength, fmt.Sprintf("%s must have at most %d characters", f.Name, *validation.MaxLength)
+		}
+	}
+
+	if v, ok := value.(string); ok {
+		if validation.Pattern != nil && !validation.Pattern.MatchString(v) {
+			return ValidationPattern, fmt.Sprintf("%s has an invalid format", f.Name)
+		}
+
+		if helper.IsNotEmpty(validation.Format) && !validationFormats[validation.Format](v) {
+			return ValidationFormat, fmt.Sprintf("%s must be a valid %s", f.Name, validation.Format)
+		}
+	}
+
+	return "", ""
+}
+
+// isUniqueValue tells whether no other record of the tenant holds the value.
+func (m *DataModelQuery) isUniqueValue(key string, value interface{}, current datatype.DataMap) bool {
+	query := m.NewInstance().SkipBeforeCommit()
+	query.RequestContext = m.RequestContext
+	query.skipTenant = m.skipTenant
+	query.Where(key, value)
+	query.addTenantId()
+	query.addTrashedScope()
+
+	existing := query.collection().findOne()
+	if existing == nil || helper.IsEmpty(*existing) {
+		return true
+	}
+
+	if current != nil {
+		return fmt.Sprint((*existing)["_id"]) == fmt.Sprint(current["_id"])
+	}
+
+	return false
+}
+
+// importUniqueness finds rows of an import repeating the value a unique field
+// has in an earlier row, which the database lookup can't see yet.
+type importUniqueness struct {
+	model *DataModel
+	seen  map[string]map[string]bool
+}
+
+func newImportUniqueness(model *DataModel) *importUniqueness {
+	return &importUniqueness{
+		model: model,
+		seen:  make(map[string]map[string]bool),
+	}
+}
+
+func (u *importUniqueness) check(row datatype.DataMap, index int) []FieldError {
+	errs := make([]FieldError, 0)
+
+	for k, field := range u.model.Fields {
+		value, ok := row[k]
+		if !field.Validation.Unique || !ok || validationIsBlank(value) {
+			continue
+		}
+
+		if u.seen[k] == nil {
+			u.seen[k] = make(map[string]bool)
+		}
+
+		key := fmt.Sprint(value)
+		if u.seen[k][key] {
+			errs = append(errs, FieldError{
+				Path:    []interface{}{index, k},
+				Field:   k,
+				Code:    ValidationUnique,
+				Message: k + " is repeated in the import",
+			})
+		}
+
+		u.seen[k][key] = true
+	}
+
+	return errs
+}
+
+func (m *DataModelQuery) validationError(errs []FieldError) error {
+	return &ValidationError{
+		Model:  m.Model.Name,
+		Errors: errs,
+	}
+}
+
+// needsValidationTarget tells whether an update is checked against the
+// record it changes, which a unique field or a cross-field rule needs.
+func (m *DataModelQuery) needsValidationTarget() bool {
+	if len(m.Model.Rules) > 0 {
+		return true
+	}
+
+	for _, field := range m.Model.Fields {
+		if field.Validation.Unique {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r DataModelRule) check(record datatype.DataMap) bool {
+	value := record[r.Field]
+	other := record[r.Other]
+
+	switch r.Operator {
+	case "requiredWith":
+		return validationIsBlank(other) || !validationIsBlank(value)
+	case "requiredWithout":
+		return !validationIsBlank(other) || !validationIsBlank(value)
+	}
+
+	if validationIsBlank(value) || validationIsBlank(other) {
+		return true
+	}
+
+	compare, ok := validationCompare(value, other)
+	if !ok {
+		return true
+	}
+
+	switch r.Operator {
+	case "equalTo":
+		return compare == 0
+	case "notEqualTo":
+		return compare != 0
+	case "lessThan":
+		return compare < 0
+	case "lessThanOrEqualTo":
+		return compare <= 0
+	case "greaterThan":
+		return compare > 0
+	case "greaterThanOrEqualTo":
+		return compare >= 0
+	}
+
+	return true
+}
+
+func validationCompare(a interface{}, b interface{}) (int, bool) {
+	ta, okA := a.(time.Time)
+	tb, okB := b.(time.Time)
+	if okA && okB {
+		return ta.Compare(tb), true
+	}
+
+	if helper.IsNumeric(a) && helper.IsNumeric(b) {
+		fa, fb := helper.ToFloat(a), helper.ToFloat(b)
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), true
+}
+
+// validationIsBlank is true for values a required field can't hold. Unlike
+// helper.IsEmpty, false and 0 are values.
+func validationIsBlank(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	if v, ok := value.(string); ok {
+		return strings.TrimSpace(v) == ""
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return val.IsNil()
+	case reflect.Slice, reflect.Map:
+		return val.Len() == 0
+	}
+
+	return false
+}
+
+// validationFormattedErrors turns a validation failure of a GraphQL resolver
+// into one error per field, its path running through the mutation input.
+func validationFormattedErrors(err gqlerrors.FormattedError) ([]gqlerrors.FormattedError, bool) {
+	original := err.OriginalError()
+	if located, ok := original.(*gqlerrors.Error); ok {
+		original = located.OriginalError
+	}
+
+	var validation *ValidationError
+	if original == nil || !errors.As(original, &validation) {
+		return nil, false
+	}
+
+	formatted := make([]gqlerrors.FormattedError, 0, len(validation.Errors))
+	for _, fe := range validation.Errors {
+		path := append(append([]interface{}{}, err.Path...), "input")
+
+		formatted = append(formatted, gqlerrors.FormattedError{
+			Message:   fe.Message,
+			Locations: err.Locations,
+			Path:      append(path, fe.Path...),
+			Extensions: map[string]interface{}{
+				"code":  string(fe.Code),
+				"field": fe.Field,
+				"model": validation.Model,
+			},
+		})
+	}
+
+	return formatted, true
+}
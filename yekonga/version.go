@@ -0,0 +1,88 @@
+package yekonga
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/robertkonga/yekonga-server-go/datatype"
+	"github.com/robertkonga/yekonga-server-go/helper"
+)
+
+// VersionKey holds the version of a record of a versioned model. It is 1
+// when the record is created and every write increments it.
+const VersionKey = "version"
+
+// errVersionConflict is returned by the backends when no record matched the
+// filter at the expected version.
+var errVersionConflict = errors.New("version conflict")
+
+// ErrRecordNotFound is returned by an Update at an expected version when the
+// record no longer exists.
+var ErrRecordNotFound = errors.New("record not found")
+
+// localWriteLock keeps the read and write of a local database update
+// together, so the version check and the increment can't interleave.
+var localWriteLock sync.Mutex
+
+// ConflictError is returned by Update when the record is no longer at the
+// expected version. Current is the record as it is now.
+type ConflictError struct {
+	Model    string
+	Expected int
+	Current  datatype.DataMap
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s was changed by someone else, expected version %d but it is at version %d",
+		e.Model, e.Expected, e.CurrentVersion())
+}
+
+func (e *ConflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":            "VERSION_CONFLICT",
+		"model":           e.Model,
+		"expectedVersion": e.Expected,
+		"currentVersion":  e.CurrentVersion(),
+		"current":         e.Current,
+	}
+}
+
+// CurrentVersion is the version of the record as it is now.
+func (e *ConflictError) CurrentVersion() int {
+	return helper.ToInt(e.Current[VersionKey])
+}
+
+// ExpectVersion makes Update fail with a ConflictError unless the record is
+// still at the version, the one the caller read before changing it. It has
+// no effect on a model without versions.
+func (m *DataModelQuery) ExpectVersion(version int) *DataModelQuery {
+	m.expectedVersion = &version
+
+	return m
+}
+
+// versionCheck returns the version the write expects, ok is false when the
+// write doesn't check it.
+func (m *DataModelQuery) versionCheck() (version int, ok bool) {
+	if !m.Model.Versioned || m.expectedVersion == nil {
+		return 0, false
+	}
+
+	return *m.expectedVersion, true
+}
+
+// conflictError loads the record an update missed at the expected version.
+// It is ErrRecordNotFound when the record doesn't exist at all.
+func (m *DataModelQuery) conflictError() interface{} {
+	records := m.snapshot()
+	if len(records) == 0 {
+		return ErrRecordNotFound
+	}
+
+	return &ConflictError{
+		Model:    m.Model.Name,
+		Expected: *m.expectedVersion,
+		Current:  records[0],
+	}
+}